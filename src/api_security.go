@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// csrfTokenTTL is how long a token handed to the UI stays valid
+	csrfTokenTTL = 12 * time.Hour
+	// maxCSRFTokens bounds the number of remembered tokens
+	maxCSRFTokens = 1024
+)
+
+// csrfTokenStore remembers the CSRF tokens Zoraxy handed to the plugin UI.
+// Zoraxy sends the token in X-Zoraxy-Csrf when it proxies a UI page and the
+// page injects it into {{.csrfToken}}, so only tokens seen that way are accepted.
+type csrfTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time // token -> expiry
+	ttl    time.Duration
+}
+
+var csrfTokens = newCSRFTokenStore(csrfTokenTTL)
+
+func newCSRFTokenStore(ttl time.Duration) *csrfTokenStore {
+	return &csrfTokenStore{tokens: make(map[string]time.Time), ttl: ttl}
+}
+
+// issue remembers a token, dropping expired and, when full, the oldest tokens
+func (s *csrfTokenStore) issue(token string) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for known, expiry := range s.tokens {
+		if now.After(expiry) {
+			delete(s.tokens, known)
+		}
+	}
+	if _, ok := s.tokens[token]; !ok && len(s.tokens) >= maxCSRFTokens {
+		var oldest string
+		for known, expiry := range s.tokens {
+			if oldest == "" || expiry.Before(s.tokens[oldest]) {
+				oldest = known
+			}
+		}
+		delete(s.tokens, oldest)
+	}
+	s.tokens[token] = now.Add(s.ttl)
+}
+
+// valid reports whether the token was issued and has not expired
+func (s *csrfTokenStore) valid(token string) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for known, expiry := range s.tokens {
+		// Compare every token in constant time so timing does not leak a match
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 && now.Before(expiry) {
+			found = true
+		}
+	}
+	return found
+}
+
+// issueCSRFTokens records the token of UI pages served through Zoraxy
+func issueCSRFTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isPage := strings.HasSuffix(r.URL.Path, "/") || strings.HasSuffix(r.URL.Path, ".html")
+		if token := r.Header.Get("X-Zoraxy-Csrf"); token != "" && r.Method == http.MethodGet && isPage {
+			csrfTokens.issue(token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireCSRFToken rejects state-changing requests without a valid CSRF token
+func requireCSRFToken(w http.ResponseWriter, r *http.Request) bool {
+	csrfToken := r.Header.Get("X-CSRF-Token")
+	if csrfToken == "" {
+		apiLog.Warn("CSRF token missing", "method", r.Method, "path", r.URL.Path)
+		http.Error(w, "Forbidden - CSRF token not found in request", http.StatusForbidden)
+		return false
+	}
+	if !csrfTokens.valid(csrfToken) {
+		apiLog.Warn("CSRF token invalid", "method", r.Method, "path", r.URL.Path)
+		http.Error(w, "Forbidden - invalid CSRF token", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// OriginsRequest replaces the origins allowed to call the API cross-origin
+type OriginsRequest struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// OriginsResponse lists the origins allowed to call the API cross-origin
+type OriginsResponse struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// normalizeOrigin validates an origin ("*" or scheme://host[:port])
+func normalizeOrigin(origin string) (string, error) {
+	origin = strings.TrimSpace(origin)
+	if origin == "*" {
+		return origin, nil
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid origin %q: expected scheme://host[:port]", origin)
+	}
+	if (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" || parsed.Fragment != "" || parsed.User != nil {
+		return "", fmt.Errorf("invalid origin %q: must not contain a path, query or credentials", origin)
+	}
+	return strings.ToLower(parsed.Scheme + "://" + parsed.Host), nil
+}
+
+// normalizeOrigins validates a list of origins and drops duplicates
+func normalizeOrigins(origins []string) ([]string, error) {
+	result := make([]string, 0, len(origins))
+	seen := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		normalized, err := normalizeOrigin(origin)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			result = append(result, normalized)
+		}
+	}
+	return result, nil
+}
+
+// isOriginAllowed checks the origin against the configured origin policy
+func isOriginAllowed(origin string) bool {
+	normalized, err := normalizeOrigin(origin)
+	if err != nil {
+		return false
+	}
+
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" || allowed == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// withOriginPolicy answers CORS requests for the allowed origins only.
+// Without configured origins the API is limited to the same origin as the UI.
+func withOriginPolicy(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && isOriginAllowed(origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				apiLog.Warn("CORS preflight from disallowed origin", "origin", origin, "path", r.URL.Path)
+				http.Error(w, "Forbidden - origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-CSRF-Token")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// handleAPIOrigins returns (GET) or replaces (POST) the allowed CORS origins
+func handleAPIOrigins(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		config.mu.RLock()
+		origins := append([]string{}, config.AllowedOrigins...)
+		config.mu.RUnlock()
+		writeJSON(w, OriginsResponse{AllowedOrigins: origins})
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var req OriginsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		origins, err := normalizeOrigins(req.AllowedOrigins)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config.mu.Lock()
+		config.AllowedOrigins = origins
+		config.mu.Unlock()
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		apiLog.Info("Allowed origins updated", "origins", strings.Join(origins, ","))
+		events.record(EventSourcePlugin, "originsUpdated", fmt.Sprintf("%d allowed origin(s)", len(origins)))
+		writeJSON(w, OriginsResponse{AllowedOrigins: origins})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issueTestCSRFToken registers a CSRF token as if Zoraxy had served the UI with it
+func issueTestCSRFToken(t *testing.T) string {
+	t.Helper()
+	token := "test-token"
+	csrfTokens.issue(token)
+	t.Cleanup(func() { forgetCSRFToken(token) })
+	return token
+}
+
+// forgetCSRFToken drops a token registered by a test from the global store
+func forgetCSRFToken(token string) {
+	csrfTokens.mu.Lock()
+	delete(csrfTokens.tokens, token)
+	csrfTokens.mu.Unlock()
+}
+
+// Test the CSRF token store
+func TestCSRFTokenStore(t *testing.T) {
+	store := newCSRFTokenStore(time.Hour)
+	store.issue("issued")
+
+	if !store.valid("issued") {
+		t.Error("Expected issued token to be valid")
+	}
+	if store.valid("other") || store.valid("") {
+		t.Error("Expected unknown tokens to be rejected")
+	}
+
+	expired := newCSRFTokenStore(-time.Second)
+	expired.issue("old")
+	if expired.valid("old") {
+		t.Error("Expected expired token to be rejected")
+	}
+
+	for i := 0; i < maxCSRFTokens+5; i++ {
+		store.issue(strings.Repeat("x", i+1))
+	}
+	if len(store.tokens) != maxCSRFTokens {
+		t.Errorf("Expected %d tokens, got %d", maxCSRFTokens, len(store.tokens))
+	}
+}
+
+// Test UI pages served through Zoraxy issue their CSRF token
+func TestIssueCSRFTokens(t *testing.T) {
+	handler := issueCSRFTokens(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer forgetCSRFToken("page-token")
+	defer forgetCSRFToken("asset-token")
+
+	req := httptest.NewRequest(http.MethodGet, UI_PATH+"/", nil)
+	req.Header.Set("X-Zoraxy-Csrf", "page-token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, UI_PATH+"/app.js", nil)
+	req.Header.Set("X-Zoraxy-Csrf", "asset-token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !csrfTokens.valid("page-token") {
+		t.Error("Expected token of the UI page to be issued")
+	}
+	if csrfTokens.valid("asset-token") {
+		t.Error("Expected assets not to issue tokens")
+	}
+}
+
+// Test every mutating endpoint rejects missing and forged tokens
+func TestCSRFRejection(t *testing.T) {
+	endpoints := []struct {
+		path    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{UI_PATH + "/api/toggle", handleAPIToggle, `{"enabled": true}`},
+		{UI_PATH + "/api/hosts", handleAPIHostRules, `{"rules": []}`},
+		{UI_PATH + "/api/logging", handleAPILogging, `{"levels": {}}`},
+		{UI_PATH + "/api/origins", handleAPIOrigins, `{"allowed_origins": []}`},
+	}
+
+	for _, endpoint := range endpoints {
+		for _, token := range []string{"", "forged-token"} {
+			t.Run(endpoint.path+" token="+token, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPost, endpoint.path, strings.NewReader(endpoint.body))
+				if token != "" {
+					req.Header.Set("X-CSRF-Token", token)
+				}
+				rr := httptest.NewRecorder()
+				endpoint.handler(rr, req)
+
+				if rr.Code != http.StatusForbidden {
+					t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+				}
+			})
+		}
+	}
+}
+
+// Test the CORS origin policy
+func TestOriginPolicy(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) { c.AllowedOrigins = []string{"https://admin.example.com"} })
+	handler := withOriginPolicy(handleAPIStatus)
+
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, UI_PATH+"/api/status", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	if rr := request(http.MethodGet, "https://admin.example.com"); rr.Header().Get("Access-Control-Allow-Origin") != "https://admin.example.com" {
+		t.Errorf("Expected allowed origin to be echoed, got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rr := request(http.MethodGet, "https://evil.example"); rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS header for disallowed origin, got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rr := request(http.MethodOptions, "https://admin.example.com"); rr.Code != http.StatusNoContent ||
+		!strings.Contains(rr.Header().Get("Access-Control-Allow-Headers"), "X-CSRF-Token") {
+		t.Errorf("Expected preflight to succeed, got %d %v", rr.Code, rr.Header())
+	}
+	if rr := request(http.MethodOptions, "https://evil.example"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected preflight from disallowed origin to be rejected, got %d", rr.Code)
+	}
+
+	setConfig(t, func(c *PluginConfig) { c.AllowedOrigins = []string{"*"} })
+	if rr := request(http.MethodGet, "https://any.example"); rr.Header().Get("Access-Control-Allow-Origin") != "https://any.example" {
+		t.Errorf("Expected wildcard policy to allow any origin, got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+// Test the origins API
+func TestOriginsAPI(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) { c.AllowedOrigins = nil })
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, UI_PATH+"/api/origins", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", issueTestCSRFToken(t))
+		rr := httptest.NewRecorder()
+		handleAPIOrigins(rr, req)
+		return rr
+	}
+
+	rr := post(`{"allowed_origins": ["HTTPS://Admin.Example.com/", "https://admin.example.com"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var response OriginsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.AllowedOrigins) != 1 || response.AllowedOrigins[0] != "https://admin.example.com" {
+		t.Errorf("Expected normalized origin, got %v", response.AllowedOrigins)
+	}
+
+	for _, invalid := range []string{"admin.example.com", "ftp://admin.example.com", "https://admin.example.com/path"} {
+		if rr := post(`{"allowed_origins": ["` + invalid + `"]}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected %q to be rejected, got %d", invalid, rr.Code)
+		}
+	}
+}
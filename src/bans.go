@@ -0,0 +1,682 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BANS_FILE is the name of the persisted ban list, stored next to the config
+const BANS_FILE = "bans.json"
+
+// bansPath is the location of the persisted ban list.
+// An empty path disables persistence (used by tests).
+var bansPath = ""
+
+const (
+	// banSweepInterval is how often expired failures and bans are dropped
+	banSweepInterval = time.Minute
+	// banMemory is how long offenses are remembered after a ban ended,
+	// repeated bans within it escalate the duration
+	banMemory = 7 * 24 * time.Hour
+	// maxBanPeers bounds the number of addresses with counted failures
+	maxBanPeers = 16384
+	// maxBans bounds the number of active and remembered bans
+	maxBans = 16384
+	// maxBanOffenders bounds the offenders listed by the API
+	maxBanOffenders = 50
+	// maxBanSeconds bounds the configurable ban durations (one year)
+	maxBanSeconds = 365 * 24 * 60 * 60
+
+	defaultBanMaxFailures   = 5
+	defaultBanWindowSeconds = 10 * 60
+	defaultBanSeconds       = 10 * 60
+	defaultMaxBanSeconds    = 24 * 60 * 60
+)
+
+// Failures counted towards a ban
+const (
+	BanReasonParse    = "parse"    // malformed PROXY header
+	BanReasonEndpoint = "endpoint" // denied by the cloud endpoint access rules
+	BanReasonAccess   = "access"   // denied by the IP access rules
+	BanReasonManual   = "manual"   // banned through the API
+)
+
+// BanSettings configures when peers are banned. An address with MaxFailures
+// failures within the window is banned for BanSeconds, doubled for every
+// repeated ban up to MaxBanSeconds.
+type BanSettings struct {
+	Enabled       bool     `json:"enabled"`
+	MaxFailures   int      `json:"max_failures"`    // default 5
+	WindowSeconds int      `json:"window_seconds"`  // default 600
+	BanSeconds    int      `json:"ban_seconds"`     // default 600
+	MaxBanSeconds int      `json:"max_ban_seconds"` // default 86400
+	Exempt        []string `json:"exempt"`          // never banned, in addition to the trusted upstreams
+
+	exempt []netip.Prefix
+}
+
+// Ban is a banned address
+type Ban struct {
+	Address  string    `json:"address"`
+	Reason   string    `json:"reason"`   // failure that triggered the ban, or manual
+	Offenses int       `json:"offenses"` // bans of the address within the memory, escalates the duration
+	BannedAt time.Time `json:"banned_at"`
+	Until    time.Time `json:"until"`
+}
+
+// BanOffender is an address with failures within the window
+type BanOffender struct {
+	Address     string    `json:"address"`
+	Failures    int       `json:"failures"`
+	LastReason  string    `json:"last_reason"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// BansResponse is returned by the ban API
+type BansResponse struct {
+	BanSettings
+	Bans       []Ban         `json:"bans"`       // active bans, most recent first
+	Remembered int           `json:"remembered"` // expired bans still escalating repeated offenses
+	Offenders  []BanOffender `json:"offenders"`  // most failures first
+}
+
+// BanListRequest bans or unbans an address. Unban "*" lifts every ban.
+type BanListRequest struct {
+	Ban             string `json:"ban,omitempty"`
+	DurationSeconds int    `json:"duration_seconds,omitempty"` // default: the escalated duration
+	Unban           string `json:"unban,omitempty"`
+}
+
+// persistedBans is the on-disk representation of the ban list
+type persistedBans struct {
+	Bans []Ban `json:"bans"`
+}
+
+func defaultBanSettings() BanSettings {
+	return BanSettings{
+		MaxFailures:   defaultBanMaxFailures,
+		WindowSeconds: defaultBanWindowSeconds,
+		BanSeconds:    defaultBanSeconds,
+		MaxBanSeconds: defaultMaxBanSeconds,
+		Exempt:        []string{},
+	}
+}
+
+// normalize validates the settings, fills in the defaults and parses the exempt ranges
+func (s *BanSettings) normalize() error {
+	defaults := defaultBanSettings()
+	if s.MaxFailures == 0 {
+		s.MaxFailures = defaults.MaxFailures
+	}
+	if s.WindowSeconds == 0 {
+		s.WindowSeconds = defaults.WindowSeconds
+	}
+	if s.BanSeconds == 0 {
+		s.BanSeconds = defaults.BanSeconds
+	}
+	if s.MaxBanSeconds == 0 {
+		s.MaxBanSeconds = max(s.BanSeconds, defaults.MaxBanSeconds)
+	}
+	if s.MaxFailures < 1 || s.MaxFailures > 1000 {
+		return fmt.Errorf("max failures %d is outside 1-1000", s.MaxFailures)
+	}
+	if s.WindowSeconds < 1 || s.WindowSeconds > 24*60*60 {
+		return fmt.Errorf("window of %d seconds is outside 1-86400", s.WindowSeconds)
+	}
+	if s.BanSeconds < 1 || s.BanSeconds > maxBanSeconds {
+		return fmt.Errorf("ban of %d seconds is outside 1-%d", s.BanSeconds, maxBanSeconds)
+	}
+	if s.MaxBanSeconds < s.BanSeconds || s.MaxBanSeconds > maxBanSeconds {
+		return fmt.Errorf("max ban of %d seconds is outside %d-%d", s.MaxBanSeconds, s.BanSeconds, maxBanSeconds)
+	}
+
+	exempt, err := parsePrefixes(s.Exempt)
+	if err != nil {
+		return fmt.Errorf("exempt: %w", err)
+	}
+	s.exempt = exempt
+	s.Exempt = formatPrefixes(exempt)
+	return nil
+}
+
+// clone returns a copy that can be used outside the config lock
+func (s BanSettings) clone() BanSettings {
+	s.Exempt = append([]string{}, s.Exempt...)
+	s.exempt = append([]netip.Prefix{}, s.exempt...)
+	return s
+}
+
+// exempts reports whether an address is never banned
+func (s *BanSettings) exempts(addr netip.Addr) bool {
+	for _, prefixes := range [][]netip.Prefix{s.exempt, trustedUpstreams()} {
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkBanUpstreams rejects enabled bans without trusted upstreams: the load
+// balancer is the peer of all its clients and must never be banned for them
+func checkBanUpstreams(settings BanSettings, upstreams []netip.Prefix) error {
+	if settings.Enabled && len(upstreams) == 0 {
+		return errors.New("bans need trusted upstreams, otherwise the load balancer could be banned for its clients")
+	}
+	return nil
+}
+
+// duration is the length of a ban for the given offense, doubling from BanSeconds
+func (s *BanSettings) duration(offenses int) time.Duration {
+	limit := time.Duration(s.MaxBanSeconds) * time.Second
+	d := time.Duration(s.BanSeconds) * time.Second
+	for i := 1; i < offenses && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		return limit
+	}
+	return d
+}
+
+// peerFailures are the recent failures of an address
+type peerFailures struct {
+	times  []time.Time // oldest first, at most MaxFailures
+	reason string      // the last failure
+}
+
+// banList holds the bans and the failures counted towards them
+type banList struct {
+	mu       sync.Mutex
+	bans     map[netip.Addr]*Ban
+	failures map[netip.Addr]*peerFailures
+	saveMu   sync.Mutex // serializes writes of the ban file
+}
+
+var bans = &banList{bans: make(map[netip.Addr]*Ban), failures: make(map[netip.Addr]*peerFailures)}
+
+// banned returns the active ban of an address
+func (l *banList) banned(addr netip.Addr, now time.Time) (Ban, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ban, ok := l.bans[addr]; ok && now.Before(ban.Until) {
+		return *ban, true
+	}
+	return Ban{}, false
+}
+
+// fail counts a failure of an address. It returns the new ban when the
+// failures within the window reached the limit.
+func (l *banList) fail(addr netip.Addr, reason string, settings *BanSettings, now time.Time) (Ban, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ban, ok := l.bans[addr]; ok && now.Before(ban.Until) {
+		return Ban{}, false
+	}
+	failures, ok := l.failures[addr]
+	if !ok {
+		if len(l.failures) >= maxBanPeers {
+			l.evictOldestPeerLocked()
+		}
+		failures = &peerFailures{}
+		l.failures[addr] = failures
+	}
+	failures.prune(now.Add(-time.Duration(settings.WindowSeconds) * time.Second))
+	failures.times = append(failures.times, now)
+	failures.reason = reason
+	if len(failures.times) > settings.MaxFailures {
+		failures.times = failures.times[len(failures.times)-settings.MaxFailures:]
+	}
+	if len(failures.times) < settings.MaxFailures {
+		return Ban{}, false
+	}
+
+	delete(l.failures, addr)
+	return l.banLocked(addr, reason, 0, settings, now), true
+}
+
+// ban bans an address, for the escalated duration when d is 0
+func (l *banList) ban(addr netip.Addr, reason string, d time.Duration, settings *BanSettings, now time.Time) Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, addr)
+	return l.banLocked(addr, reason, d, settings, now)
+}
+
+func (l *banList) banLocked(addr netip.Addr, reason string, d time.Duration, settings *BanSettings, now time.Time) Ban {
+	offenses := 1
+	if previous, ok := l.bans[addr]; ok && now.Sub(previous.Until) < banMemory {
+		offenses = previous.Offenses + 1
+	} else if !ok && len(l.bans) >= maxBans {
+		l.evictOldestBanLocked()
+	}
+	if d == 0 {
+		d = settings.duration(offenses)
+	}
+	ban := &Ban{Address: addr.String(), Reason: reason, Offenses: offenses, BannedAt: now, Until: now.Add(d)}
+	l.bans[addr] = ban
+	return *ban
+}
+
+// unban lifts the ban of an address, keeping its offenses for escalation.
+// It reports whether the address was banned.
+func (l *banList) unban(addr netip.Addr, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ban, ok := l.bans[addr]
+	if !ok || !now.Before(ban.Until) {
+		return false
+	}
+	ban.Until = now
+	return true
+}
+
+// unbanAll lifts every ban and returns how many were active
+func (l *banList) unbanAll(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lifted := 0
+	for _, ban := range l.bans {
+		if now.Before(ban.Until) {
+			ban.Until = now
+			lifted++
+		}
+	}
+	return lifted
+}
+
+// prune drops the failures before the start of the window
+func (f *peerFailures) prune(start time.Time) {
+	i := 0
+	for i < len(f.times) && !f.times[i].After(start) {
+		i++
+	}
+	f.times = f.times[i:]
+}
+
+// evictOldestPeerLocked drops the address with the oldest last failure,
+// preferring addresses whose failures were already pruned
+func (l *banList) evictOldestPeerLocked() {
+	var oldest netip.Addr
+	var oldestTime time.Time
+	for addr, failures := range l.failures {
+		if len(failures.times) == 0 {
+			delete(l.failures, addr)
+			return
+		}
+		last := failures.times[len(failures.times)-1]
+		if !oldest.IsValid() || last.Before(oldestTime) {
+			oldest, oldestTime = addr, last
+		}
+	}
+	delete(l.failures, oldest)
+}
+
+func (l *banList) evictOldestBanLocked() {
+	var oldest netip.Addr
+	var oldestUntil time.Time
+	for addr, ban := range l.bans {
+		if !oldest.IsValid() || ban.Until.Before(oldestUntil) {
+			oldest, oldestUntil = addr, ban.Until
+		}
+	}
+	delete(l.bans, oldest)
+}
+
+// sweep drops failures outside the window and forgets bans that ended
+// longer than banMemory ago. It reports whether the ban list changed.
+func (l *banList) sweep(window time.Duration, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for addr, failures := range l.failures {
+		failures.prune(now.Add(-window))
+		if len(failures.times) == 0 {
+			delete(l.failures, addr)
+		}
+	}
+	changed := false
+	for addr, ban := range l.bans {
+		if now.Sub(ban.Until) >= banMemory {
+			delete(l.bans, addr)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// reset drops all bans and failures (used by tests)
+func (l *banList) reset() {
+	l.mu.Lock()
+	l.bans = make(map[netip.Addr]*Ban)
+	l.failures = make(map[netip.Addr]*peerFailures)
+	l.mu.Unlock()
+}
+
+// startSweeper periodically drops expired failures and bans until stop is closed
+func (l *banList) startSweeper(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				settings := currentBanSettings()
+				if l.sweep(time.Duration(settings.WindowSeconds)*time.Second, now) {
+					if err := l.save(); err != nil {
+						accessLog.Error("Error saving bans", "error", err)
+					}
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// snapshot lists the active bans and the offenders with the most failures
+func (l *banList) snapshot(window time.Duration, now time.Time) ([]Ban, int, []BanOffender) {
+	l.mu.Lock()
+	active := []Ban{}
+	remembered := 0
+	for _, ban := range l.bans {
+		if now.Before(ban.Until) {
+			active = append(active, *ban)
+		} else {
+			remembered++
+		}
+	}
+	offenders := []BanOffender{}
+	for addr, failures := range l.failures {
+		failures.prune(now.Add(-window))
+		if len(failures.times) == 0 {
+			delete(l.failures, addr)
+			continue
+		}
+		offenders = append(offenders, BanOffender{
+			Address:     addr.String(),
+			Failures:    len(failures.times),
+			LastReason:  failures.reason,
+			LastFailure: failures.times[len(failures.times)-1],
+		})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(active, func(i, j int) bool {
+		if !active[i].BannedAt.Equal(active[j].BannedAt) {
+			return active[i].BannedAt.After(active[j].BannedAt)
+		}
+		return active[i].Address < active[j].Address
+	})
+	sort.Slice(offenders, func(i, j int) bool {
+		if offenders[i].Failures != offenders[j].Failures {
+			return offenders[i].Failures > offenders[j].Failures
+		}
+		return offenders[i].Address < offenders[j].Address
+	})
+	if len(offenders) > maxBanOffenders {
+		offenders = offenders[:maxBanOffenders]
+	}
+	return active, remembered, offenders
+}
+
+// load reads the ban list from bansPath, a missing file is not an error
+func (l *banList) load() error {
+	if bansPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(bansPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading bans: %w", err)
+	}
+	var stored persistedBans
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("parsing bans: %w", err)
+	}
+
+	now := time.Now()
+	loaded := make(map[netip.Addr]*Ban, len(stored.Bans))
+	for i := range stored.Bans {
+		ban := stored.Bans[i]
+		addr, err := netip.ParseAddr(ban.Address)
+		if err != nil {
+			return fmt.Errorf("ban %d: invalid address %q", i+1, ban.Address)
+		}
+		if now.Sub(ban.Until) >= banMemory {
+			continue
+		}
+		ban.Address = addr.Unmap().String()
+		loaded[addr.Unmap()] = &ban
+	}
+
+	l.mu.Lock()
+	l.bans = loaded
+	l.mu.Unlock()
+	return nil
+}
+
+// save writes the active and remembered bans to bansPath
+func (l *banList) save() error {
+	if bansPath == "" {
+		return nil
+	}
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	l.mu.Lock()
+	stored := persistedBans{Bans: make([]Ban, 0, len(l.bans))}
+	for _, ban := range l.bans {
+		stored.Bans = append(stored.Bans, *ban)
+	}
+	l.mu.Unlock()
+	sort.Slice(stored.Bans, func(i, j int) bool { return stored.Bans[i].Address < stored.Bans[j].Address })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding bans: %w", err)
+	}
+	tmpPath := bansPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("writing bans: %w", err)
+	}
+	if err := os.Rename(tmpPath, bansPath); err != nil {
+		return fmt.Errorf("replacing bans: %w", err)
+	}
+	return nil
+}
+
+// currentBanSettings returns a copy of the configured ban settings
+func currentBanSettings() BanSettings {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.Bans.clone()
+}
+
+// recordFailure counts a parse or policy failure of a peer (host:port or
+// host) and bans it once it reached the limit. Exempt peers and the trusted
+// upstreams are never counted.
+func recordFailure(peerAddr, reason string, attrs ...any) {
+	config.mu.RLock()
+	settings := config.Bans
+	config.mu.RUnlock()
+
+	if !settings.Enabled {
+		return
+	}
+	addr, ok := peerIP(peerAddr)
+	if !ok || settings.exempts(addr) {
+		return
+	}
+	ban, banned := bans.fail(addr, reason, &settings, time.Now())
+	if !banned {
+		return
+	}
+
+	metrics.bans.inc(reason)
+	accessLog.With(attrs...).Warn("Peer banned", "peer", ban.Address, "reason", reason,
+		"offenses", ban.Offenses, "until", ban.Until.Format(time.RFC3339))
+	events.record(EventSourcePlugin, "peerBanned", fmt.Sprintf("%s banned until %s after repeated %s failures (offense %d)",
+		ban.Address, ban.Until.Format(time.RFC3339), reason, ban.Offenses))
+	if err := bans.save(); err != nil {
+		accessLog.Error("Error saving bans", "error", err)
+	}
+}
+
+// peerBanned reports whether a peer (host:port or host) is banned and
+// counts the rejected connection. Nothing is banned while bans are disabled.
+func peerBanned(peerAddr, origin string) bool {
+	config.mu.RLock()
+	enabled := config.Bans.Enabled
+	config.mu.RUnlock()
+
+	if !enabled {
+		return false
+	}
+	addr, ok := peerIP(peerAddr)
+	if !ok {
+		return false
+	}
+	ban, banned := bans.banned(addr, time.Now())
+	if !banned {
+		return false
+	}
+	metrics.bannedRejected.inc(origin)
+	accessLog.Debug("Connection of banned peer rejected", "origin", origin, "peer", ban.Address,
+		"reason", ban.Reason, "until", ban.Until.Format(time.RFC3339))
+	return true
+}
+
+func bansResponse() BansResponse {
+	settings := currentBanSettings()
+	active, remembered, offenders := bans.snapshot(time.Duration(settings.WindowSeconds)*time.Second, time.Now())
+	return BansResponse{BanSettings: settings, Bans: active, Remembered: remembered, Offenders: offenders}
+}
+
+// handleAPIBans returns (GET) or replaces (POST) the ban settings. GET also
+// lists the active bans and the offenders within the window.
+func handleAPIBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, bansResponse())
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var req BanSettings
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := req.normalize(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config.mu.Lock()
+		if err := checkBanUpstreams(req, config.trustedUpstreams); err != nil {
+			config.mu.Unlock()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config.Bans = req
+		config.mu.Unlock()
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		apiLog.Info("Ban settings updated", "enabled", req.Enabled, "max_failures", req.MaxFailures,
+			"window_seconds", req.WindowSeconds, "ban_seconds", req.BanSeconds, "max_ban_seconds", req.MaxBanSeconds,
+			"exempt", len(req.Exempt))
+		state := "disabled"
+		if req.Enabled {
+			state = fmt.Sprintf("%d failures within %ds, banned for %ds up to %ds", req.MaxFailures, req.WindowSeconds, req.BanSeconds, req.MaxBanSeconds)
+		}
+		events.record(EventSourcePlugin, "bansUpdated", "Bans "+state)
+		writeJSON(w, bansResponse())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAPIBanList bans or unbans an address (POST), see BanListRequest
+func handleAPIBanList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireCSRFToken(w, r) {
+		return
+	}
+
+	var req BanListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if (req.Ban == "") == (req.Unban == "") {
+		http.Error(w, "either ban or unban is required", http.StatusBadRequest)
+		return
+	}
+	if req.DurationSeconds < 0 || req.DurationSeconds > maxBanSeconds {
+		http.Error(w, fmt.Sprintf("duration must be between 0 and %d seconds", maxBanSeconds), http.StatusBadRequest)
+		return
+	}
+
+	settings := currentBanSettings()
+	now := time.Now()
+	switch {
+	case strings.TrimSpace(req.Unban) == "*":
+		lifted := bans.unbanAll(now)
+		apiLog.Info("All bans lifted", "bans", lifted)
+		events.record(EventSourcePlugin, "peersUnbanned", fmt.Sprintf("%d ban(s) lifted", lifted))
+
+	case req.Unban != "":
+		addr, ok := peerIP(strings.TrimSpace(req.Unban))
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid address %q", req.Unban), http.StatusBadRequest)
+			return
+		}
+		if !bans.unban(addr, now) {
+			http.Error(w, fmt.Sprintf("%s is not banned", addr), http.StatusNotFound)
+			return
+		}
+		apiLog.Info("Peer unbanned", "peer", addr.String())
+		events.record(EventSourcePlugin, "peerUnbanned", addr.String()+" unbanned")
+
+	default:
+		addr, ok := peerIP(strings.TrimSpace(req.Ban))
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid address %q", req.Ban), http.StatusBadRequest)
+			return
+		}
+		if settings.exempts(addr) {
+			http.Error(w, fmt.Sprintf("%s is exempt or a trusted upstream", addr), http.StatusBadRequest)
+			return
+		}
+		ban := bans.ban(addr, BanReasonManual, time.Duration(req.DurationSeconds)*time.Second, &settings, now)
+		metrics.bans.inc(BanReasonManual)
+		apiLog.Info("Peer banned", "peer", ban.Address, "until", ban.Until.Format(time.RFC3339), "offenses", ban.Offenses)
+		events.record(EventSourcePlugin, "peerBanned", fmt.Sprintf("%s banned manually until %s", ban.Address, ban.Until.Format(time.RFC3339)))
+	}
+
+	if err := bans.save(); err != nil {
+		apiLog.Error("Error saving bans", "error", err)
+	}
+	writeJSON(w, bansResponse())
+}
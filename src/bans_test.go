@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test validation and defaults of the settings
+func TestBanSettingsNormalize(t *testing.T) {
+	settings := BanSettings{BanSeconds: 2 * 24 * 60 * 60, Exempt: []string{"10.0.0.1/8", "::ffff:192.0.2.1"}}
+	if err := settings.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if settings.MaxFailures != 5 || settings.WindowSeconds != 600 || settings.MaxBanSeconds != settings.BanSeconds {
+		t.Errorf("Expected the defaults, got %+v", settings)
+	}
+	if strings.Join(settings.Exempt, ",") != "10.0.0.0/8,192.0.2.1" {
+		t.Errorf("Expected the normalized exempt ranges, got %v", settings.Exempt)
+	}
+	if !settings.exempts(netip.MustParseAddr("10.9.9.9")) || settings.exempts(netip.MustParseAddr("192.0.2.2")) {
+		t.Error("Expected the exempt ranges to be parsed")
+	}
+
+	invalid := []BanSettings{
+		{MaxFailures: -1},
+		{MaxFailures: 1001},
+		{WindowSeconds: 86401},
+		{BanSeconds: -5},
+		{BanSeconds: 600, MaxBanSeconds: 60},
+		{Exempt: []string{"not-an-ip"}},
+	}
+	for _, settings := range invalid {
+		if err := settings.normalize(); err == nil {
+			t.Errorf("Expected %+v to be rejected", settings)
+		}
+	}
+}
+
+// Test the sliding window and escalating bans
+func TestBanEscalation(t *testing.T) {
+	list := &banList{bans: make(map[netip.Addr]*Ban), failures: make(map[netip.Addr]*peerFailures)}
+	settings := BanSettings{MaxFailures: 3, WindowSeconds: 60, BanSeconds: 100, MaxBanSeconds: 300}
+	if err := settings.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	addr := netip.MustParseAddr("203.0.113.66")
+	now := time.Unix(1760000000, 0)
+
+	// Failures that slid out of the window do not count
+	list.fail(addr, BanReasonParse, &settings, now)
+	list.fail(addr, BanReasonParse, &settings, now.Add(30*time.Second))
+	now = now.Add(61 * time.Second)
+	if _, banned := list.fail(addr, BanReasonParse, &settings, now); banned {
+		t.Fatal("Expected the first failure to have left the window")
+	}
+	_, _, offenders := list.snapshot(time.Minute, now)
+	if len(offenders) != 1 || offenders[0].Failures != 2 {
+		t.Errorf("Expected two failures in the window, got %+v", offenders)
+	}
+
+	ban, banned := list.fail(addr, BanReasonAccess, &settings, now)
+	if !banned || ban.Reason != BanReasonAccess || ban.Offenses != 1 || ban.Until.Sub(now) != 100*time.Second {
+		t.Fatalf("Expected a first ban of 100s, got %+v", ban)
+	}
+	if _, banned := list.banned(addr, now.Add(99*time.Second)); !banned {
+		t.Error("Expected the address to be banned")
+	}
+	if _, banned := list.banned(addr, now.Add(100*time.Second)); banned {
+		t.Error("Expected the ban to have ended")
+	}
+
+	// Repeated bans double up to the maximum, also after an unban
+	expected := []time.Duration{200 * time.Second, 300 * time.Second, 300 * time.Second}
+	for i, duration := range expected {
+		now = now.Add(time.Hour)
+		if i == 1 {
+			list.ban(addr, BanReasonManual, 0, &settings, now)
+			if !list.unban(addr, now) {
+				t.Fatal("Expected the manual ban to be lifted")
+			}
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			ban, banned = list.fail(addr, BanReasonParse, &settings, now)
+		}
+		if !banned || ban.Until.Sub(now) != duration {
+			t.Errorf("Expected ban %d to last %s, got %+v", i+2, duration, ban)
+		}
+	}
+	if ban.Offenses != 4 {
+		t.Errorf("Expected the fourth offense, got %d", ban.Offenses)
+	}
+
+	// Offenses are forgotten after the memory
+	now = now.Add(banMemory + 300*time.Second)
+	if !list.sweep(time.Minute, now) {
+		t.Error("Expected the sweep to forget the ban")
+	}
+	ban = list.ban(addr, BanReasonManual, time.Minute, &settings, now)
+	if ban.Offenses != 1 || ban.Until.Sub(now) != time.Minute {
+		t.Errorf("Expected a first manual ban of a minute, got %+v", ban)
+	}
+}
+
+// Test that a full failure map with pruned entries evicts without panicking
+func TestBanPeerEviction(t *testing.T) {
+	list := &banList{bans: make(map[netip.Addr]*Ban), failures: make(map[netip.Addr]*peerFailures)}
+	settings := BanSettings{MaxFailures: 3, WindowSeconds: 60}
+	if err := settings.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1760000000, 0)
+	peer := func(i int) netip.Addr {
+		return netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)})
+	}
+	for i := 0; i < maxBanPeers; i++ {
+		list.fail(peer(i), BanReasonParse, &settings, now)
+	}
+	list.fail(peer(0), BanReasonParse, &settings, now.Add(30*time.Second))
+
+	// The listing prunes the window and drops the emptied entries
+	later := now.Add(61 * time.Second)
+	if _, _, offenders := list.snapshot(time.Minute, later); len(offenders) != 1 {
+		t.Errorf("Expected one offender within the window, got %d", len(offenders))
+	}
+	if len(list.failures) != 1 {
+		t.Errorf("Expected the emptied entries to be dropped, got %d", len(list.failures))
+	}
+
+	// Entries emptied elsewhere are evicted first
+	for i := 0; i < maxBanPeers; i++ {
+		list.fail(peer(i), BanReasonParse, &settings, later)
+	}
+	list.failures[peer(7)].times = nil
+	list.fail(peer(maxBanPeers), BanReasonParse, &settings, later)
+	if len(list.failures) != maxBanPeers {
+		t.Errorf("Expected the map to stay at %d peers, got %d", maxBanPeers, len(list.failures))
+	}
+	if _, ok := list.failures[peer(7)]; ok {
+		t.Error("Expected the empty entry to be evicted")
+	}
+}
+
+// Test that the listener closes connections of banned peers and clients
+func TestListenerBans(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Bans = BanSettings{Enabled: true, MaxFailures: 2}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppListener := NewProxyProtocolListener(ln, nil, listenerLog)
+	ppListener.ReadTimeout = time.Second
+	defer ppListener.Close()
+
+	accepted := make(chan net.Conn, 8)
+	go func() {
+		for {
+			conn, err := ppListener.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	// dial sends data and reports whether the listener accepted the connection
+	dial := func(data string) bool {
+		t.Helper()
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if _, err := client.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		// A rejected connection is closed without a response
+		closed := make(chan struct{})
+		go func() {
+			client.Read(make([]byte, 1))
+			close(closed)
+		}()
+		select {
+		case conn := <-accepted:
+			conn.Close()
+			return true
+		case <-closed:
+			return false
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the listener")
+			return false
+		}
+	}
+
+	rejected := metrics.bannedRejected.get(ConnectionOriginListener)
+	for i := 0; i < 2; i++ {
+		if !dial("PROXY TCP4 invalid_format\r\n") {
+			t.Fatalf("Expected failure %d to be accepted", i+1)
+		}
+	}
+	if dial("PROXY TCP4 192.0.2.1 192.0.2.2 1000 80\r\n") {
+		t.Error("Expected the banned peer to be rejected")
+	}
+	if got := metrics.bannedRejected.get(ConnectionOriginListener); got != rejected+1 {
+		t.Errorf("Expected the rejection counter to be %d, got %d", rejected+1, got)
+	}
+
+	bans.unban(netip.MustParseAddr("127.0.0.1"), time.Now())
+	if !dial("PROXY TCP4 192.0.2.1 192.0.2.2 1001 80\r\n") {
+		t.Error("Expected the unbanned peer to be accepted")
+	}
+
+	// A banned client behind the load balancer is rejected after the header
+	settings := currentBanSettings()
+	bans.ban(netip.MustParseAddr("198.51.100.23"), BanReasonManual, time.Minute, &settings, time.Now())
+	if dial("PROXY TCP4 198.51.100.23 192.0.2.2 1002 80\r\n") {
+		t.Error("Expected the banned client to be rejected")
+	}
+}
+
+// Test that the malformed cases of the self-test never ban the loopback peer
+func TestSelfTestBans(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Bans = BanSettings{Enabled: true, MaxFailures: 2}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	for run := 1; run <= 4; run++ {
+		response := runSelfTest()
+		for _, result := range response.Results {
+			if !result.Passed {
+				t.Fatalf("Run %d: self-test case %q failed: %s", run, result.Name, result.Message)
+			}
+		}
+	}
+	if _, banned := bans.banned(netip.MustParseAddr("127.0.0.1"), time.Now()); banned {
+		t.Error("Expected the loopback peer not to be banned")
+	}
+	if _, _, offenders := bans.snapshot(time.Minute, time.Now()); len(offenders) != 0 {
+		t.Errorf("Expected no failures of the self-test, got %+v", offenders)
+	}
+}
+
+// Test bans of the capture ingress for parse and policy failures
+func TestIngressBans(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.Bans = BanSettings{Enabled: true, MaxFailures: 2, Exempt: []string{"192.0.2.0/24"}}
+		c.IPAccess = IPAccess{IPAccessList: IPAccessList{Deny: []string{"203.0.113.0/24", "192.0.2.0/24"}}}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	ingress := func(id, data string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", INGRESS_PATH+"/", strings.NewReader(data))
+		req.Header.Set("X-Zoraxy-RequestID", id)
+		return serveIngress(t, req, "www.example.com")
+	}
+	header := func(source string, port int) string {
+		data, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 1, Command: "PROXY", TransportProto: "TCP4",
+			SourceAddr: source, SourcePort: port, DestAddr: "198.51.100.50", DestPort: 443})
+		if err != nil {
+			t.Fatal(err)
+		}
+		connections.remove(ingressConnectionID(&ProxyProtocolInfo{SourceAddr: source, SourcePort: port, DestAddr: "198.51.100.50", DestPort: 443}))
+		return string(data) + "GET / HTTP/1.1\r\n\r\n"
+	}
+
+	// The trusted upstream is never banned for the parse errors of its clients
+	for i := 0; i < 3; i++ {
+		if rr := ingress("ban-parse", "PROXY TCP4 invalid_format\r\n"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected a parse error, got %d", rr.Code)
+		}
+	}
+	if _, _, offenders := bans.snapshot(time.Minute, time.Now()); len(offenders) != 0 {
+		t.Errorf("Expected no failures of the upstream, got %+v", offenders)
+	}
+
+	// Policy failures count for the client of the PROXY header, exempt clients are never banned
+	for i := 0; i < 3; i++ {
+		if rr := ingress("ban-exempt", header("192.0.2.61", 44010+i)); rr.Code != http.StatusForbidden {
+			t.Fatalf("Expected the exempt client to be denied, got %d", rr.Code)
+		}
+	}
+	if _, banned := bans.banned(netip.MustParseAddr("192.0.2.61"), time.Now()); banned {
+		t.Error("Expected the exempt client not to be banned")
+	}
+
+	bansIssued := metrics.bans.get(BanReasonAccess)
+	for i := 0; i < 2; i++ {
+		ingress("ban-access", header("203.0.113.61", 44020+i))
+	}
+	if got := metrics.bans.get(BanReasonAccess); got != bansIssued+1 {
+		t.Errorf("Expected the ban counter to be %d, got %d", bansIssued+1, got)
+	}
+	setConfig(t, func(c *PluginConfig) { c.IPAccess = IPAccess{} })
+	rejected := metrics.bannedRejected.get(ConnectionOriginIngress)
+	if rr := ingress("ban-access-3", header("203.0.113.61", 44022)); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected the banned client to be rejected, got %d", rr.Code)
+	}
+	if rr := ingress("ban-access-4", header("203.0.113.62", 44023)); rr.Code != http.StatusOK {
+		t.Errorf("Expected other clients to be allowed, got %d", rr.Code)
+	}
+	if got := metrics.bannedRejected.get(ConnectionOriginIngress); got != rejected+1 {
+		t.Errorf("Expected the rejection counter to be %d, got %d", rejected+1, got)
+	}
+
+	// Disabled bans are not enforced
+	setConfig(t, func(c *PluginConfig) { c.Bans = BanSettings{} })
+	settings := currentBanSettings()
+	bans.ban(netip.MustParseAddr("203.0.113.61"), BanReasonManual, time.Minute, &settings, time.Now())
+	if rr := ingress("ban-disabled", header("203.0.113.61", 44024)); rr.Code != http.StatusOK {
+		t.Errorf("Expected disabled bans not to be enforced, got %d", rr.Code)
+	}
+}
+
+// Test that bans cannot be enabled without trusted upstreams
+func TestBansNeedTrustedUpstreams(t *testing.T) {
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+	defer func() { configPath = oldPath }()
+	setConfig(t, func(c *PluginConfig) { c.Bans, c.trustedUpstreams = BanSettings{}, nil })
+	token := issueTestCSRFToken(t)
+
+	post := func(handler http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", token)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	if rr := post(handleAPIBans, "/ui/api/bans", `{"enabled":true}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected bans without trusted upstreams to be rejected, got %d", rr.Code)
+	}
+	if currentBanSettings().Enabled {
+		t.Error("Expected bans to stay disabled")
+	}
+
+	if rr := post(handleAPIUpstreams, "/ui/api/upstreams", `{"trusted_upstreams":["10.0.0.2"]}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rr.Code)
+	}
+	if rr := post(handleAPIBans, "/ui/api/bans", `{"enabled":true}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected bans with trusted upstreams to be enabled, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := post(handleAPIUpstreams, "/ui/api/upstreams", `{"trusted_upstreams":[]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected removing the last trusted upstream to be rejected, got %d", rr.Code)
+	}
+	if len(trustedUpstreams()) != 1 {
+		t.Errorf("Expected the trusted upstreams to be kept, got %v", trustedUpstreams())
+	}
+
+	stored := `{"enabled":true,"bans":{"enabled":true}}`
+	if err := os.WriteFile(configPath, []byte(stored), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(); err == nil {
+		t.Error("Expected a config with bans but no trusted upstreams to be rejected")
+	}
+}
+
+// Test the ban API and the persisted ban list
+func TestHandleAPIBans(t *testing.T) {
+	dir := t.TempDir()
+	oldConfigPath, oldBansPath := configPath, bansPath
+	configPath, bansPath = filepath.Join(dir, CONFIG_FILE), filepath.Join(dir, BANS_FILE)
+	defer func() { configPath, bansPath = oldConfigPath, oldBansPath }()
+	setConfig(t, func(c *PluginConfig) {
+		c.Bans = BanSettings{}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+	token := issueTestCSRFToken(t)
+
+	post := func(handler http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", token)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) BansResponse {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response BansResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if rr := post(handleAPIBans, "/ui/api/bans", `{"enabled":true,"ban_seconds":600,"max_ban_seconds":60}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, got %d", rr.Code)
+	}
+	response := decode(post(handleAPIBans, "/ui/api/bans", `{"enabled":true,"max_failures":3,"exempt":["10.0.0.0/8"]}`))
+	if !response.Enabled || response.MaxFailures != 3 || response.BanSeconds != 600 || len(response.Exempt) != 1 {
+		t.Errorf("Expected the normalized settings, got %+v", response.BanSettings)
+	}
+
+	invalid := []string{`{}`, `{"ban":"192.0.2.1","unban":"192.0.2.2"}`, `{"ban":"nope"}`, `{"ban":"10.1.2.3"}`, `{"ban":"192.0.2.1","duration_seconds":-1}`}
+	for _, body := range invalid {
+		if rr := post(handleAPIBanList, "/ui/api/banlist", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, rr.Code)
+		}
+	}
+	response = decode(post(handleAPIBanList, "/ui/api/banlist", `{"ban":"192.0.2.1","duration_seconds":3600}`))
+	decode(post(handleAPIBanList, "/ui/api/banlist", `{"ban":"2001:db8::1"}`))
+	if len(response.Bans) != 1 || response.Bans[0].Address != "192.0.2.1" || response.Bans[0].Reason != BanReasonManual {
+		t.Errorf("Expected the manual ban, got %+v", response.Bans)
+	}
+	recordFailure("198.51.100.9:4000", BanReasonParse)
+	response = decode(post(handleAPIBanList, "/ui/api/banlist", `{"unban":"2001:db8::1"}`))
+	if len(response.Bans) != 1 || response.Remembered != 1 || len(response.Offenders) != 1 || response.Offenders[0].Address != "198.51.100.9" {
+		t.Errorf("Expected one active and one remembered ban and an offender, got %+v", response)
+	}
+	if rr := post(handleAPIBanList, "/ui/api/banlist", `{"unban":"2001:db8::1"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code 404, got %d", rr.Code)
+	}
+
+	// The settings and the ban list survive a restart
+	data, err := os.ReadFile(bansPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"192.0.2.1"`)) || !bytes.Contains(data, []byte(`"2001:db8::1"`)) {
+		t.Errorf("Expected both bans to be persisted, got %s", data)
+	}
+	setConfig(t, func(c *PluginConfig) { c.Bans = BanSettings{} })
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if err := bans.load(); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	handleAPIBans(rr, httptest.NewRequest("GET", "/ui/api/bans", nil))
+	response = decode(rr)
+	if !response.Enabled || response.MaxFailures != 3 || len(response.Bans) != 1 || response.Remembered != 1 {
+		t.Errorf("Expected the persisted settings and bans to be restored, got %+v", response)
+	}
+	if restored := currentBanSettings(); !restored.exempts(netip.MustParseAddr("10.2.3.4")) {
+		t.Error("Expected the restored exempt ranges to be parsed")
+	}
+
+	response = decode(post(handleAPIBanList, "/ui/api/banlist", `{"unban":"*"}`))
+	if len(response.Bans) != 0 || response.Remembered != 2 {
+		t.Errorf("Expected all bans to be lifted, got %+v", response)
+	}
+	rr = httptest.NewRecorder()
+	handleAPIBanList(rr, httptest.NewRequest("GET", "/ui/api/banlist", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code 405, got %d", rr.Code)
+	}
+}
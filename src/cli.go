@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cliCommand is a troubleshooting subcommand of the plugin binary.
+// It returns the process exit code: 0 on success, 1 on failure, 2 on usage errors.
+type cliCommand struct {
+	usage string
+	run   func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+}
+
+// Subcommand usage lines
+const (
+	decodeUsage = "decode [-encoding raw|hex|base64] [-json] [HEX...]"
+	encodeUsage = "encode [-version 1|2] [-src IP:PORT] [-dst IP:PORT] [-tlv TYPE=VALUE] [-format raw|hex|base64]"
+	sendUsage   = "send [-version 1|2] [-src IP:PORT] [-dst IP:PORT] [-tlv TYPE=VALUE] HOST:PORT"
+)
+
+var cliCommands = map[string]cliCommand{
+	"decode": {decodeUsage, runDecodeCommand},
+	"encode": {encodeUsage, runEncodeCommand},
+	"send":   {sendUsage, runSendCommand},
+}
+
+// runCLI runs the subcommand named by args[0], reporting false if there is none
+func runCLI(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	if args[0] == "help" {
+		printCLIUsage(stdout)
+		return 0, true
+	}
+	command, ok := cliCommands[args[0]]
+	if !ok {
+		return 0, false
+	}
+	return command.run(args[1:], stdin, stdout, stderr), true
+}
+
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: proxy-protocol <command> [flags]")
+	fmt.Fprintln(w)
+	for _, name := range []string{"decode", "encode", "send"} {
+		fmt.Fprintf(w, "  proxy-protocol %s\n", cliCommands[name].usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run a command with -h for its flags. Without a command the binary runs as a Zoraxy plugin.")
+}
+
+// newCLIFlagSet creates the flag set of a subcommand, printing errors to stderr
+func newCLIFlagSet(name, usage string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: proxy-protocol %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// flagErrorCode returns the exit code for a flag parse error, 0 for -h
+func flagErrorCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
+
+// runDecodeCommand decodes a header from the hex arguments or stdin
+func runDecodeCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newCLIFlagSet("decode", decodeUsage, stderr)
+	encoding := flags.String("encoding", DecodeEncodingRaw, "encoding of stdin: raw, hex or base64")
+	asJSON := flags.Bool("json", false, "print the decoding as JSON")
+	if err := flags.Parse(args); err != nil {
+		return flagErrorCode(err)
+	}
+
+	var data []byte
+	var err error
+	if flags.NArg() > 0 {
+		data, err = parseHexInput(strings.Join(flags.Args(), " "))
+	} else {
+		var input []byte
+		if input, err = io.ReadAll(io.LimitReader(stdin, maxDecodeInputBytes)); err == nil {
+			data, err = parseDecodeInput(string(input), *encoding)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading input: %v\n", err)
+		return 2
+	}
+
+	response := decodeProxyProtocolData(data)
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(response)
+	} else {
+		printDecodeResponse(stdout, response)
+	}
+
+	if response.Outcome != InspectorOutcomeParsed {
+		return 1
+	}
+	return 0
+}
+
+// printDecodeResponse prints a decoding for humans
+func printDecodeResponse(w io.Writer, response DecodeResponse) {
+	line := func(label, format string, args ...interface{}) {
+		fmt.Fprintf(w, "%-15s %s\n", label+":", fmt.Sprintf(format, args...))
+	}
+
+	line("outcome", "%s", response.Outcome)
+	switch response.Outcome {
+	case InspectorOutcomeError:
+		line("error", "%s", response.Error)
+		line("reason", "%s", response.ErrorReason)
+		if response.ErrorOffset != nil {
+			line("error offset", "byte %d", *response.ErrorOffset)
+		}
+		return
+	case InspectorOutcomeNoHeader:
+		if response.SignatureOffset != nil {
+			line("hint", "a signature starts at byte %d", *response.SignatureOffset)
+		}
+	case InspectorOutcomeParsed:
+		line("version", "%d", response.Version)
+		line("command", "%s", response.Command)
+		line("family", "%s (%s)", response.Family, response.TransportProto)
+		if response.Command == "PROXY" && response.TransportProto != "UNKNOWN" {
+			line("source", "%s", net.JoinHostPort(response.SourceAddr, strconv.Itoa(response.SourcePort)))
+			line("destination", "%s", net.JoinHostPort(response.DestAddr, strconv.Itoa(response.DestPort)))
+		}
+		line("header length", "%d bytes", response.HeaderLength)
+		for _, tlv := range response.TLVs {
+			line("TLV "+tlv.Name, "0x%02X, %d bytes: %s", tlv.Type, tlv.Length, tlv.Value)
+		}
+	}
+	line("payload", "%d bytes, %s", response.PayloadLength, response.Payload)
+}
+
+// headerFlags are the flags describing the header to encode or send
+type headerFlags struct {
+	version *int
+	command *string
+	src     *string
+	dst     *string
+	tlvs    tlvFlag
+}
+
+func addHeaderFlags(flags *flag.FlagSet, defaultEndpoints string) *headerFlags {
+	h := &headerFlags{
+		version: flags.Int("version", 1, "Proxy Protocol version: 1 or 2"),
+		command: flags.String("command", "PROXY", "v2 command: PROXY or LOCAL"),
+		src:     flags.String("src", "", "client address IP:PORT"+defaultEndpoints),
+		dst:     flags.String("dst", "", "destination address IP:PORT"+defaultEndpoints),
+	}
+	flags.Var(&h.tlvs, "tlv", "v2 TLV as TYPE=VALUE, TYPE a name (ALPN, AUTHORITY, UNIQUE_ID...) or number, VALUE text or 0x-prefixed hex; repeatable")
+	return h
+}
+
+// info builds the header described by the flags. An empty src or dst is
+// taken from fallbackSrc and fallbackDst, and v1 without addresses is UNKNOWN.
+func (h *headerFlags) info(fallbackSrc, fallbackDst string) (*ProxyProtocolInfo, error) {
+	info := &ProxyProtocolInfo{Version: *h.version, Command: strings.ToUpper(*h.command), TLVs: h.tlvs}
+	if info.Command != "PROXY" && info.Command != "LOCAL" {
+		return nil, fmt.Errorf("unknown command %q: use PROXY or LOCAL", *h.command)
+	}
+	if len(info.TLVs) > 0 && info.Version != 2 {
+		return nil, errors.New("TLVs need -version 2")
+	}
+	if info.Command == "LOCAL" {
+		if info.Version != 2 {
+			return nil, errors.New("LOCAL needs -version 2")
+		}
+		info.TransportProto = "UNKNOWN"
+		return info, nil
+	}
+
+	src, dst := *h.src, *h.dst
+	if src == "" {
+		src = fallbackSrc
+	}
+	if dst == "" {
+		dst = fallbackDst
+	}
+	if src == "" && dst == "" && info.Version == 1 {
+		info.TransportProto = "UNKNOWN"
+		return info, nil
+	}
+
+	source, err := netip.ParseAddrPort(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -src %q: expected IP:PORT", src)
+	}
+	destination, err := netip.ParseAddrPort(dst)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -dst %q: expected IP:PORT", dst)
+	}
+
+	info.TransportProto = "TCP4"
+	if source.Addr().Unmap().Is6() {
+		info.TransportProto = "TCP6"
+	}
+	info.SourceAddr, info.SourcePort = source.Addr().Unmap().String(), int(source.Port())
+	info.DestAddr, info.DestPort = destination.Addr().Unmap().String(), int(destination.Port())
+	return info, nil
+}
+
+// tlvFlag collects repeated -tlv TYPE=VALUE flags
+type tlvFlag []ProxyProtocolTLV
+
+func (f *tlvFlag) String() string {
+	var parts []string
+	for _, tlv := range *f {
+		parts = append(parts, fmt.Sprintf("%s=%x", tlv.Name(), tlv.Value))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *tlvFlag) Set(value string) error {
+	name, data, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected TYPE=VALUE, got %q", value)
+	}
+
+	tlv := ProxyProtocolTLV{Value: []byte(data)}
+	if tlvType, err := strconv.ParseUint(name, 0, 8); err == nil {
+		tlv.Type = byte(tlvType)
+	} else if tlvType, ok := tlvTypeByName(name); ok {
+		tlv.Type = tlvType
+	} else {
+		return fmt.Errorf("unknown TLV type %q", name)
+	}
+
+	if hexValue, ok := strings.CutPrefix(data, "0x"); ok {
+		decoded, err := hex.DecodeString(hexValue)
+		if err != nil {
+			return fmt.Errorf("invalid hex value for TLV %s: %w", name, err)
+		}
+		tlv.Value = decoded
+	}
+
+	*f = append(*f, tlv)
+	return nil
+}
+
+// tlvTypeByName looks up a PP2_TYPE by name, ignoring case
+func tlvTypeByName(name string) (byte, bool) {
+	for tlvType, known := range tlvTypeNames {
+		if strings.EqualFold(known, name) {
+			return tlvType, true
+		}
+	}
+	return 0, false
+}
+
+// runEncodeCommand prints the header described by the flags
+func runEncodeCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newCLIFlagSet("encode", encodeUsage, stderr)
+	header := addHeaderFlags(flags, "")
+	format := flags.String("format", "raw", "output format: raw, hex or base64")
+	if err := flags.Parse(args); err != nil {
+		return flagErrorCode(err)
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "Unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+		return 2
+	}
+
+	info, err := header.info("", "")
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 2
+	}
+	encoded, err := encodeProxyProtocolHeader(info)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error encoding header: %v\n", err)
+		return 1
+	}
+
+	switch *format {
+	case "raw":
+		stdout.Write(encoded)
+	case "hex":
+		fmt.Fprintln(stdout, hex.EncodeToString(encoded))
+	case "base64":
+		fmt.Fprintln(stdout, base64.StdEncoding.EncodeToString(encoded))
+	default:
+		fmt.Fprintf(stderr, "Unknown format %q: use raw, hex or base64\n", *format)
+		return 2
+	}
+	return 0
+}
+
+// runSendCommand connects to HOST:PORT, sends the header followed by stdin
+// and copies the response to stdout
+func runSendCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newCLIFlagSet("send", sendUsage, stderr)
+	header := addHeaderFlags(flags, " (default: the real connection endpoints)")
+	timeout := flags.Duration("timeout", 10*time.Second, "connect timeout and idle timeout for the response")
+	if err := flags.Parse(args); err != nil {
+		return flagErrorCode(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	conn, err := net.DialTimeout("tcp", flags.Arg(0), *timeout)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error connecting: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	info, err := header.info(conn.LocalAddr().String(), conn.RemoteAddr().String())
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 2
+	}
+	encoded, err := encodeProxyProtocolHeader(info)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error encoding header: %v\n", err)
+		return 1
+	}
+	if _, err := conn.Write(encoded); err != nil {
+		fmt.Fprintf(stderr, "Error sending header: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "Sent %d byte v%d header (%s %s:%d -> %s:%d) to %s\n", len(encoded), info.Version,
+		info.Command, info.SourceAddr, info.SourcePort, info.DestAddr, info.DestPort, conn.RemoteAddr())
+
+	// Send stdin, then half-close so the server sees the end of the request
+	go func() {
+		io.Copy(conn, stdin)
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+	}()
+
+	if _, err := io.Copy(stdout, &idleTimeoutReader{conn: conn, timeout: *timeout}); err != nil {
+		fmt.Fprintf(stderr, "Error reading response: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// idleTimeoutReader fails reads once the connection is idle for the timeout
+type idleTimeoutReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *idleTimeoutReader) Read(b []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.conn.Read(b)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runTestCLI runs a subcommand and returns its exit code and output
+func runTestCLI(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code, ok := runCLI(args, strings.NewReader(stdin), &stdout, &stderr)
+	if !ok {
+		t.Fatalf("Expected %q to be a subcommand", args[0])
+	}
+	return code, stdout.String(), stderr.String()
+}
+
+// Test the plugin handshake is left alone without a subcommand
+func TestRunCLIWithoutCommand(t *testing.T) {
+	for _, args := range [][]string{nil, {"-introspect"}, {"-configure={}"}} {
+		if _, ok := runCLI(args, nil, io.Discard, io.Discard); ok {
+			t.Errorf("Expected %v to start the plugin", args)
+		}
+	}
+
+	if code, stdout, _ := runTestCLI(t, "", "help"); code != 0 || !strings.Contains(stdout, "proxy-protocol send") {
+		t.Errorf("Unexpected help output (%d): %s", code, stdout)
+	}
+	if code, _, stderr := runTestCLI(t, "", "encode", "-h"); code != 0 || !strings.Contains(stderr, "-tlv") {
+		t.Errorf("Unexpected -h output (%d): %s", code, stderr)
+	}
+}
+
+// Test the decode subcommand
+func TestDecodeCommand(t *testing.T) {
+	t.Run("hex arguments", func(t *testing.T) {
+		code, stdout, _ := runTestCLI(t, "", "decode", "0d0a0d0a000d0a515549540a", "2111000c", "c0000201c6336401010001bb")
+		if code != 0 || !strings.Contains(stdout, "192.0.2.1:256") || !strings.Contains(stdout, "198.51.100.1:443") {
+			t.Errorf("Unexpected output (%d): %s", code, stdout)
+		}
+	})
+
+	t.Run("raw stdin as JSON", func(t *testing.T) {
+		code, stdout, _ := runTestCLI(t, "PROXY TCP6 2001:db8::1 2001:db8::2 1000 443\r\nGET /", "decode", "-json")
+		var response DecodeResponse
+		if err := json.Unmarshal([]byte(stdout), &response); err != nil {
+			t.Fatalf("Failed to decode output: %v", err)
+		}
+		if code != 0 || response.TransportProto != "TCP6" || response.Payload != "http" {
+			t.Errorf("Unexpected response (%d): %+v", code, response)
+		}
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		code, stdout, _ := runTestCLI(t, "0d0a0d0a000d0a515549540a3111", "decode", "-encoding", "hex")
+		if code != 1 || !strings.Contains(stdout, "error offset:   byte 12") {
+			t.Errorf("Unexpected output (%d): %s", code, stdout)
+		}
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		if code, _, _ := runTestCLI(t, "", "decode", "xyz"); code != 2 {
+			t.Errorf("Expected exit code 2, got %d", code)
+		}
+	})
+}
+
+// Test the encode subcommand
+func TestEncodeCommand(t *testing.T) {
+	code, stdout, _ := runTestCLI(t, "", "encode", "-src", "192.0.2.1:1000", "-dst", "198.51.100.1:443")
+	if code != 0 || stdout != "PROXY TCP4 192.0.2.1 198.51.100.1 1000 443\r\n" {
+		t.Errorf("Unexpected v1 header (%d): %q", code, stdout)
+	}
+
+	code, stdout, _ = runTestCLI(t, "", "encode", "-version", "2", "-src", "[2001:db8::1]:1000", "-dst", "[2001:db8::2]:443",
+		"-tlv", "alpn=h2", "-tlv", "0xE1=0xdead", "-format", "hex")
+	if code != 0 {
+		t.Fatalf("Unexpected exit code %d", code)
+	}
+	data, _ := parseHexInput(stdout)
+	response := decodeProxyProtocolData(data)
+	if response.TransportProto != "TCP6" || len(response.TLVs) != 2 || response.TLVs[1].Value != "dead" {
+		t.Errorf("Unexpected v2 header %+v", response)
+	}
+
+	if code, stdout, _ = runTestCLI(t, "", "encode"); code != 0 || stdout != "PROXY UNKNOWN\r\n" {
+		t.Errorf("Expected UNKNOWN header, got %q", stdout)
+	}
+
+	invalid := [][]string{
+		{"-command", "LOCAL"},
+		{"-tlv", "ALPN=h2"},
+		{"-version", "2", "-tlv", "BOGUS=1"},
+		{"-src", "192.0.2.1"},
+		{"-format", "octal"},
+	}
+	for _, args := range invalid {
+		if code, _, _ := runTestCLI(t, "", append([]string{"encode"}, args...)...); code != 2 {
+			t.Errorf("Expected exit code 2 for %v, got %d", args, code)
+		}
+	}
+}
+
+// Test every header the encode subcommand writes decodes to what was asked for
+func TestEncodeRoundTrip(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		proto    string
+		command  string
+		source   string
+		tlvCount int
+	}{
+		{"v1 TCP4", []string{"-src", "192.0.2.1:1000", "-dst", "198.51.100.1:443"}, "TCP4", "PROXY", "192.0.2.1", 0},
+		{"v1 TCP6", []string{"-src", "[2001:db8::1]:1000", "-dst", "[2001:db8::2]:443"}, "TCP6", "PROXY", "2001:db8::1", 0},
+		{"v1 UNKNOWN", nil, "UNKNOWN", "PROXY", "", 0},
+		{"v2 TCP4", []string{"-version", "2", "-src", "192.0.2.1:1000", "-dst", "198.51.100.1:443"}, "TCP4", "PROXY", "192.0.2.1", 0},
+		{"v2 TCP6 with TLVs", []string{"-version", "2", "-src", "[2001:db8::1]:1000", "-dst", "[2001:db8::2]:443", "-tlv", "alpn=h2", "-tlv", "0xE1=0xdead"}, "TCP6", "PROXY", "2001:db8::1", 2},
+		{"v2 LOCAL", []string{"-version", "2", "-command", "LOCAL"}, "UNKNOWN", "LOCAL", "", 0},
+		{"v2 LOCAL with TLVs", []string{"-version", "2", "-command", "local", "-tlv", "authority=example.com"}, "UNKNOWN", "LOCAL", "", 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, stdout, stderr := runTestCLI(t, "", append([]string{"encode", "-format", "hex"}, tc.args...)...)
+			if code != 0 {
+				t.Fatalf("Unexpected exit code %d: %s", code, stderr)
+			}
+			data, err := parseHexInput(stdout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := decodeProxyProtocolData(append(data, "GET / HTTP/1.1\r\n"...))
+			if response.Error != "" {
+				t.Fatalf("Encoded header does not decode: %s", response.Error)
+			}
+			if response.TransportProto != tc.proto || response.Command != tc.command || response.SourceAddr != tc.source ||
+				len(response.TLVs) != tc.tlvCount || response.Payload != "http" {
+				t.Errorf("Unexpected round trip %+v", response)
+			}
+		})
+	}
+}
+
+// Test the send subcommand against a Proxy Protocol listener
+func TestSendCommand(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ppListener := NewProxyProtocolListener(ln, nil, listenerLog)
+	defer ppListener.Close()
+
+	received := make(chan *ProxyProtocolInfo, 1)
+	go func() {
+		conn, err := ppListener.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		if ppConn, ok := conn.(*proxyProtocolConn); ok {
+			received <- ppConn.ProxyInfo
+		} else {
+			received <- nil
+		}
+		request, _ := io.ReadAll(conn)
+		conn.Write(append([]byte("echo: "), request...))
+	}()
+
+	code, stdout, stderr := runTestCLI(t, "ping", "send", "-version", "2", "-src", "192.0.2.50:4000", "-timeout", "2s", ln.Addr().String())
+	if code != 0 || stdout != "echo: ping" {
+		t.Errorf("Unexpected output (%d): %q %s", code, stdout, stderr)
+	}
+
+	select {
+	case info := <-received:
+		if info == nil || info.SourceAddr != "192.0.2.50" || info.SourcePort != 4000 || info.DestAddr != "127.0.0.1" {
+			t.Errorf("Unexpected header %+v", info)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Listener did not receive a connection")
+	}
+
+	if code, _, _ := runTestCLI(t, "", "send"); code != 2 {
+		t.Errorf("Expected exit code 2 without address, got %d", code)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Client header modes
+const (
+	ClientHeaderReplace = "replace"
+	ClientHeaderAppend  = "append"
+	ClientHeaderKeep    = "keep" // only set when the client sent none
+)
+
+// ClientHeader is a header carrying the original client address to the backend.
+// Value is a template with {placeholder} fields from the Proxy Protocol header.
+type ClientHeader struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Mode    string `json:"mode"` // "replace" (default), "append" to or "keep" the value sent by the client
+	Enabled bool   `json:"enabled"`
+
+	parts []templatePart
+}
+
+// ClientHeadersResponse is returned by the client headers API
+type ClientHeadersResponse struct {
+	Headers        []ClientHeader    `json:"headers"`
+	Forwarded      ForwardedSettings `json:"forwarded"`
+	TrustedProxies []string          `json:"trusted_proxies"`
+	StripHeaders   []string          `json:"strip_headers"`
+	Stripped       map[string]uint64 `json:"stripped"` // headers removed from untrusted peers so far
+	Placeholders   []string          `json:"placeholders"`
+}
+
+// ClientHeadersRequest replaces the given client headers, Forwarded settings,
+// trusted proxies and strip list. Reset restores the default headers, Forwarded
+// settings and strip list and clears the trusted proxies.
+type ClientHeadersRequest struct {
+	Headers        []ClientHeader     `json:"headers"` // null keeps the current headers
+	Forwarded      *ForwardedSettings `json:"forwarded"`
+	TrustedProxies []string           `json:"trusted_proxies"` // IPs and CIDRs, null keeps the current list
+	StripHeaders   []string           `json:"strip_headers"`   // null keeps the current list
+	Reset          bool               `json:"reset"`
+}
+
+// templatePart is a literal or, when field is set, a placeholder of a header template
+type templatePart struct {
+	literal string
+	field   string
+}
+
+// headerSource is what a client header template is rendered from
+type headerSource struct {
+	info     *ProxyProtocolInfo
+	hostname string      // Host of the proxied request, if known
+	tls      bool        // the request reached the plugin over TLS
+	sent     http.Header // headers sent by the client, the base for append mode
+
+	forwarded ForwardedSettings
+	trusted   []netip.Prefix // proxies whose X-Forwarded-For entries are trusted
+
+	geo *GeoIPRecord // looked up on first use
+}
+
+// clientHeaderFields resolves the template placeholders. An empty value means
+// the field is not available, e.g. the address of a LOCAL header.
+var clientHeaderFields = map[string]func(src *headerSource) string{
+	"source_addr": func(src *headerSource) string { return src.info.SourceAddr },
+	"source_port": func(src *headerSource) string { return headerPort(src.info.SourceAddr, src.info.SourcePort) },
+	"source":      func(src *headerSource) string { return headerHostPort(src.info.SourceAddr, src.info.SourcePort) },
+	"client_addr": (*headerSource).clientAddr,
+	"dest_addr":   func(src *headerSource) string { return src.info.DestAddr },
+	"dest_port":   func(src *headerSource) string { return headerPort(src.info.DestAddr, src.info.DestPort) },
+	"destination": func(src *headerSource) string { return headerHostPort(src.info.DestAddr, src.info.DestPort) },
+	"version":     func(src *headerSource) string { return strconv.Itoa(src.info.Version) },
+	"command":     func(src *headerSource) string { return src.info.Command },
+	"transport":   func(src *headerSource) string { return src.info.TransportProto },
+	"authority": func(src *headerSource) string {
+		value, _ := src.info.TLV(PP2TypeAuthority)
+		return string(value)
+	},
+	"alpn": func(src *headerSource) string {
+		value, _ := src.info.TLV(PP2TypeALPN)
+		return string(value)
+	},
+	"unique_id":     func(src *headerSource) string { return src.info.UniqueID() },
+	"aws_vpce_id":   func(src *headerSource) string { return src.info.AWSVPCEndpointID },
+	"azure_link_id": func(src *headerSource) string { return azureLinkID(src.info) },
+	"gcp_psc_id":    func(src *headerSource) string { return gcpPSCConnectionID(src.info) },
+	"country":       func(src *headerSource) string { return src.geoIP().Country },
+	"continent":     func(src *headerSource) string { return src.geoIP().Continent },
+	"asn":           func(src *headerSource) string { return formatASN(src.geoIP().ASN) },
+	"as_org":        func(src *headerSource) string { return src.geoIP().ASOrg },
+	"host":          (*headerSource).host,
+	"proto":         (*headerSource).proto,
+	"forwarded":     func(src *headerSource) string { return forwardedElement(src) },
+}
+
+// defaultClientHeaders are the headers set before they became configurable
+func defaultClientHeaders() []ClientHeader {
+	headers := []ClientHeader{
+		{Name: "X-Original-Remote-Addr", Value: "{source_addr}", Mode: ClientHeaderReplace, Enabled: true},
+		{Name: "X-Original-Remote-Port", Value: "{source_port}", Mode: ClientHeaderReplace, Enabled: true},
+		// Keep chains from proxies in front of the load balancer
+		{Name: "X-Forwarded-For", Value: "{source_addr}", Mode: ClientHeaderAppend, Enabled: true},
+		{Name: "X-Real-IP", Value: "{client_addr}", Mode: ClientHeaderReplace, Enabled: true},
+		{Name: "X-Forwarded-Port", Value: "{source_port}", Mode: ClientHeaderReplace, Enabled: true},
+		// Tells Zoraxy to use the original client address for further processing
+		{Name: "X-Proxy-Protocol-Source", Value: "{source}", Mode: ClientHeaderReplace, Enabled: true},
+		// RFC 7239, see ForwardedSettings for the node identifiers
+		{Name: "Forwarded", Value: "{forwarded}", Mode: ClientHeaderAppend, Enabled: false},
+		// Correlates the request with the load balancer logs
+		{Name: "X-Request-ID", Value: "{unique_id}", Mode: ClientHeaderKeep, Enabled: true},
+		// Consumer endpoints of AWS PrivateLink, Azure Private Link and GCP Private Service Connect
+		{Name: "X-AWS-VPCE-ID", Value: "{aws_vpce_id}", Mode: ClientHeaderReplace, Enabled: true},
+		{Name: "X-Azure-Private-Link-ID", Value: "{azure_link_id}", Mode: ClientHeaderReplace, Enabled: true},
+		{Name: "X-GCP-PSC-Connection-ID", Value: "{gcp_psc_id}", Mode: ClientHeaderReplace, Enabled: true},
+		// Location of the client from the GeoIP databases in the plugin directory
+		{Name: "X-GeoIP-Country", Value: "{country}", Mode: ClientHeaderReplace, Enabled: true},
+		{Name: "X-GeoIP-Continent", Value: "{continent}", Mode: ClientHeaderReplace, Enabled: true},
+		{Name: "X-GeoIP-ASN", Value: "{asn}", Mode: ClientHeaderReplace, Enabled: true},
+	}
+	compiled, _ := compileClientHeaders(headers)
+	return compiled
+}
+
+// compile validates the header name and parses the value template
+func (h *ClientHeader) compile() error {
+	h.Name = strings.TrimSpace(h.Name)
+	if !isHeaderToken(h.Name) {
+		return fmt.Errorf("invalid header name %q", h.Name)
+	}
+
+	switch h.Mode {
+	case "":
+		h.Mode = ClientHeaderReplace
+	case ClientHeaderReplace, ClientHeaderAppend, ClientHeaderKeep:
+	default:
+		return fmt.Errorf("unknown mode %q for header %s", h.Mode, h.Name)
+	}
+
+	parts, err := parseHeaderTemplate(h.Value)
+	if err != nil {
+		return fmt.Errorf("header %s: %w", h.Name, err)
+	}
+	h.parts = parts
+	return nil
+}
+
+// render fills in the template, reporting false when a placeholder has no value
+func (h *ClientHeader) render(src *headerSource) (string, bool) {
+	var value strings.Builder
+	for _, part := range h.parts {
+		if part.field == "" {
+			value.WriteString(part.literal)
+			continue
+		}
+		field := clientHeaderFields[part.field](src)
+		if field == "" {
+			return "", false
+		}
+		value.WriteString(field)
+	}
+	return value.String(), value.Len() > 0
+}
+
+// compileClientHeaders validates a list of headers, rejecting duplicate names
+func compileClientHeaders(headers []ClientHeader) ([]ClientHeader, error) {
+	compiled := make([]ClientHeader, 0, len(headers))
+	seen := make(map[string]bool)
+	for i := range headers {
+		header := headers[i]
+		if err := header.compile(); err != nil {
+			return nil, fmt.Errorf("header %d: %w", i+1, err)
+		}
+		key := http.CanonicalHeaderKey(header.Name)
+		if seen[key] {
+			return nil, fmt.Errorf("header %d: %s is configured twice", i+1, header.Name)
+		}
+		seen[key] = true
+		compiled = append(compiled, header)
+	}
+	return compiled, nil
+}
+
+// parseHeaderTemplate splits a value like "{source_addr}:{source_port}" into parts
+func parseHeaderTemplate(template string) ([]templatePart, error) {
+	if strings.TrimSpace(template) == "" {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	var parts []templatePart
+	for rest := template; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			parts = append(parts, templatePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			parts = append(parts, templatePart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in %q", template)
+		}
+		field := rest[open+1 : open+end]
+		if _, ok := clientHeaderFields[field]; !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s}", field)
+		}
+		parts = append(parts, templatePart{field: field})
+		rest = rest[open+end+1:]
+	}
+
+	for _, part := range parts {
+		if strings.ContainsAny(part.literal, "\r\n") {
+			return nil, fmt.Errorf("value must not contain line breaks")
+		}
+	}
+	return parts, nil
+}
+
+// isHeaderToken reports whether name is a valid HTTP header field name (RFC 7230 token)
+func isHeaderToken(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 0x7e || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// clientHeaderPlaceholders lists the template placeholders for the UI
+func clientHeaderPlaceholders() []string {
+	names := make([]string, 0, len(clientHeaderFields))
+	for name := range clientHeaderFields {
+		names = append(names, "{"+name+"}")
+	}
+	sort.Strings(names)
+	return names
+}
+
+func headerPort(addr string, port int) string {
+	if addr == "" {
+		return ""
+	}
+	return strconv.Itoa(port)
+}
+
+func headerHostPort(addr string, port int) string {
+	if addr == "" {
+		return ""
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(port))
+}
+
+// host is the requested host: the proxied request's Host, else the AUTHORITY TLV
+func (src *headerSource) host() string {
+	if src.hostname != "" {
+		return src.hostname
+	}
+	authority, _ := src.info.TLV(PP2TypeAuthority)
+	return string(authority)
+}
+
+// geoIP is what the GeoIP databases know about the Proxy Protocol source
+func (src *headerSource) geoIP() *GeoIPRecord {
+	if src.geo == nil {
+		src.geo = &GeoIPRecord{}
+		if addr, err := netip.ParseAddr(src.info.SourceAddr); err == nil && geoip.loaded() {
+			record := geoip.lookup(addr)
+			src.geo = &record
+		}
+	}
+	return src.geo
+}
+
+// clientAddr is the real client: the X-Forwarded-For chain plus the Proxy
+// Protocol source, walked right to left over the trusted proxies
+func (src *headerSource) clientAddr() string {
+	return realClient(forwardedForChain(src.sent, src.info.SourceAddr), src.trusted)
+}
+
+// proto is the scheme the client used towards the load balancer. It is taken
+// from the SSL TLV, the plugin's own TLS state or, failing that, the well-known
+// destination ports.
+func (src *headerSource) proto() string {
+	if ssl, _ := src.info.TLV(PP2TypeSSL); len(ssl) > 0 {
+		if ssl[0]&pp2ClientSSL != 0 {
+			return "https"
+		}
+		return "http"
+	}
+	if src.tls {
+		return "https"
+	}
+	switch src.info.DestPort {
+	case 443:
+		return "https"
+	case 80:
+		return "http"
+	}
+	return ""
+}
+
+// currentClientHeaders returns a copy of the configured client headers
+func currentClientHeaders() []ClientHeader {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return append([]ClientHeader{}, config.ClientHeaders...)
+}
+
+// applyClientHeaders sets the enabled client headers on dst. Append mode adds
+// the value to what the client sent, comma separated, keep mode leaves a value
+// sent by the client alone.
+func applyClientHeaders(dst http.Header, src *headerSource) {
+	config.mu.RLock()
+	headers := config.ClientHeaders
+	src.forwarded = config.Forwarded
+	src.trusted = config.trustedProxies
+	config.mu.RUnlock()
+
+	for _, header := range headers {
+		if !header.Enabled || (header.Mode == ClientHeaderKeep && src.sent.Get(header.Name) != "") {
+			continue
+		}
+		value, ok := header.render(src)
+		if !ok {
+			continue
+		}
+		if header.Mode == ClientHeaderAppend {
+			if existing := strings.Join(src.sent.Values(header.Name), ", "); existing != "" {
+				value = existing + ", " + value
+			}
+		}
+		dst.Set(header.Name, value)
+	}
+}
+
+// clientHeadersResponse reports the current client header configuration
+func clientHeadersResponse() ClientHeadersResponse {
+	return ClientHeadersResponse{
+		Headers:        currentClientHeaders(),
+		Forwarded:      currentForwardedSettings(),
+		TrustedProxies: formatPrefixes(trustedProxies()),
+		StripHeaders:   currentStripHeaders(),
+		Stripped:       metrics.strippedHeaders.snapshot(),
+		Placeholders:   clientHeaderPlaceholders(),
+	}
+}
+
+// handleAPIClientHeaders lists (GET) or replaces (POST) the client headers
+func handleAPIClientHeaders(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, clientHeadersResponse())
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var req ClientHeadersRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		// A reset restores everything this endpoint manages, no trusted proxies included
+		headers := defaultClientHeaders()
+		forwarded := defaultForwardedSettings()
+		var proxies []netip.Prefix
+		strip := defaultStripHeaders()
+		if !req.Reset {
+			var err error
+			headers = currentClientHeaders()
+			if req.Headers != nil {
+				if headers, err = compileClientHeaders(req.Headers); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			forwarded = currentForwardedSettings()
+			if req.Forwarded != nil {
+				forwarded = *req.Forwarded
+				if err := forwarded.normalize(); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			proxies = trustedProxies()
+			if req.TrustedProxies != nil {
+				if proxies, err = parsePrefixes(req.TrustedProxies); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			strip = currentStripHeaders()
+			if req.StripHeaders != nil {
+				if strip, err = normalizeStripHeaders(req.StripHeaders); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		config.mu.Lock()
+		if err := checkCustomTLVHeaders(config.CustomTLVs, headers); err != nil {
+			config.mu.Unlock()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config.ClientHeaders = headers
+		config.Forwarded = forwarded
+		config.trustedProxies = proxies
+		config.StripHeaders = strip
+		config.mu.Unlock()
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		apiLog.Info("Client headers updated", "headers", len(headers), "forwarded_for", forwarded.For,
+			"forwarded_by", forwarded.By, "trusted_proxies", len(proxies), "strip_headers", len(strip), "reset", req.Reset)
+		events.record(EventSourcePlugin, "clientHeadersUpdated", fmt.Sprintf("Client headers updated: %d header(s)", len(headers)))
+		writeJSON(w, clientHeadersResponse())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
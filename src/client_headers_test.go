@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Test client header templates and validation
+func TestCompileClientHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  ClientHeader
+		problem string
+	}{
+		{"plain placeholder", ClientHeader{Name: "CF-Connecting-IP", Value: "{source_addr}"}, ""},
+		{"mixed template", ClientHeader{Name: "X-Client", Value: "client={source}; via={transport}"}, ""},
+		{"append mode", ClientHeader{Name: "X-Forwarded-For", Value: "{source_addr}", Mode: ClientHeaderAppend}, ""},
+		{"literal only", ClientHeader{Name: "X-Via-Proxy-Protocol", Value: "1"}, ""},
+		{"invalid name", ClientHeader{Name: "Bad Header", Value: "{source_addr}"}, "invalid header name"},
+		{"empty name", ClientHeader{Name: " ", Value: "{source_addr}"}, "invalid header name"},
+		{"unknown mode", ClientHeader{Name: "X-Client", Value: "{source_addr}", Mode: "prepend"}, "unknown mode"},
+		{"unknown placeholder", ClientHeader{Name: "X-Client", Value: "{client_ip}"}, "unknown placeholder"},
+		{"unclosed placeholder", ClientHeader{Name: "X-Client", Value: "{source_addr"}, "unclosed"},
+		{"empty value", ClientHeader{Name: "X-Client", Value: ""}, "empty value"},
+		{"line break", ClientHeader{Name: "X-Client", Value: "{source_addr}\r\nX-Injected: 1"}, "line breaks"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileClientHeaders([]ClientHeader{tt.header})
+			if tt.problem == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("Expected error containing %q, got %v", tt.problem, err)
+			}
+		})
+	}
+
+	t.Run("duplicate names", func(t *testing.T) {
+		_, err := compileClientHeaders([]ClientHeader{
+			{Name: "x-real-ip", Value: "{source_addr}"},
+			{Name: "X-Real-IP", Value: "{source_addr}"},
+		})
+		if err == nil || !strings.Contains(err.Error(), "twice") {
+			t.Errorf("Expected duplicate error, got %v", err)
+		}
+	})
+}
+
+// Test rendering and applying client headers
+func TestApplyClientHeaders(t *testing.T) {
+	info := &ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP6",
+		SourceAddr: "2001:db8::1", SourcePort: 40000, DestAddr: "2001:db8::2", DestPort: 443,
+		TLVs: []ProxyProtocolTLV{{Type: PP2TypeAuthority, Value: []byte("example.com")}}}
+
+	setConfig(t, func(c *PluginConfig) {
+		c.ClientHeaders = []ClientHeader{
+			{Name: "True-Client-IP", Value: "{source_addr}", Enabled: true},
+			{Name: "X-Forwarded-For", Value: "{source_addr}", Mode: ClientHeaderAppend, Enabled: true},
+			{Name: "X-Client", Value: "{source} -> {destination} v{version}", Enabled: true},
+			{Name: "X-Authority", Value: "{authority}", Enabled: true},
+			{Name: "X-Alpn", Value: "{alpn}", Enabled: true},
+			{Name: "X-Real-IP", Value: "{source_addr}", Enabled: false},
+		}
+	})
+
+	src := http.Header{}
+	src.Add("X-Forwarded-For", "198.51.100.7")
+	src.Add("X-Forwarded-For", "198.51.100.8")
+	dst := http.Header{}
+	applyClientHeaders(dst, &headerSource{info: info, sent: src})
+
+	expected := map[string]string{
+		"True-Client-Ip":  "2001:db8::1",
+		"X-Forwarded-For": "198.51.100.7, 198.51.100.8, 2001:db8::1",
+		"X-Client":        "[2001:db8::1]:40000 -> [2001:db8::2]:443 v2",
+		"X-Authority":     "example.com",
+		"X-Alpn":          "",
+		"X-Real-Ip":       "",
+	}
+	for name, want := range expected {
+		if got := dst.Get(name); got != want {
+			t.Errorf("Expected %s %q, got %q", name, want, got)
+		}
+	}
+
+	t.Run("append without client value", func(t *testing.T) {
+		dst := http.Header{}
+		applyClientHeaders(dst, &headerSource{info: info})
+		if got := dst.Get("X-Forwarded-For"); got != "2001:db8::1" {
+			t.Errorf("Expected X-Forwarded-For 2001:db8::1, got %q", got)
+		}
+	})
+
+	t.Run("LOCAL header has no address", func(t *testing.T) {
+		dst := http.Header{}
+		applyClientHeaders(dst, &headerSource{info: &ProxyProtocolInfo{Version: 2, Command: "LOCAL", TransportProto: "UNKNOWN"}})
+		if len(dst) != 0 {
+			t.Errorf("Expected no headers for LOCAL, got %v", dst)
+		}
+	})
+}
+
+// Test that the ingress uses the configured headers
+func TestIngressClientHeaders(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.ClientHeaders = []ClientHeader{
+			{Name: "CF-Connecting-IP", Value: "{source_addr}", Enabled: true},
+			{Name: "X-Forwarded-For", Value: "{source_addr}", Mode: ClientHeaderAppend, Enabled: true},
+		}
+	})
+	// Only trusted peers keep the X-Forwarded-For they sent
+	setConfig(t, func(c *PluginConfig) {
+		c.trustedProxies = testPrefixes(t, "192.0.2.100")
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	req := httptest.NewRequest("POST", INGRESS_PATH+"/", strings.NewReader("PROXY TCP4 192.0.2.100 198.51.100.50 45678 443\r\nGET / HTTP/1.1\r\n\r\n"))
+	req.Header.Set("X-Zoraxy-RequestID", "client-headers-1")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	rr := serveIngress(t, req, "example.com")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("CF-Connecting-IP"); got != "192.0.2.100" {
+		t.Errorf("Expected CF-Connecting-IP 192.0.2.100, got %q", got)
+	}
+	if got := rr.Header().Get("X-Forwarded-For"); got != "10.0.0.1, 192.0.2.100" {
+		t.Errorf("Expected appended X-Forwarded-For, got %q", got)
+	}
+	if got := rr.Header().Get("X-Real-IP"); got != "" {
+		t.Errorf("Expected X-Real-IP to be removed from the configuration, got %q", got)
+	}
+}
+
+// Test the client headers API and persistence
+func TestHandleAPIClientHeaders(t *testing.T) {
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+	defer func() { configPath = oldPath }()
+	setConfig(t, func(c *PluginConfig) { c.ClientHeaders = defaultClientHeaders() })
+	token := issueTestCSRFToken(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/ui/api/headers", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", token)
+		rr := httptest.NewRecorder()
+		handleAPIClientHeaders(rr, req)
+		return rr
+	}
+
+	t.Run("GET lists defaults and placeholders", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handleAPIClientHeaders(rr, httptest.NewRequest("GET", "/ui/api/headers", nil))
+		var response ClientHeadersResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Headers) != len(defaultClientHeaders()) {
+			t.Errorf("Expected %d default headers, got %d", len(defaultClientHeaders()), len(response.Headers))
+		}
+		if len(response.Placeholders) == 0 || response.Placeholders[0][0] != '{' {
+			t.Errorf("Expected placeholders, got %v", response.Placeholders)
+		}
+	})
+
+	t.Run("POST rejects invalid headers", func(t *testing.T) {
+		if rr := post(`{"headers":[{"name":"X-Client","value":"{nope}","enabled":true}]}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("POST replaces and persists", func(t *testing.T) {
+		rr := post(`{"headers":[{"name":"true-client-ip","value":"{source_addr}","enabled":true}]}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"true-client-ip"`) {
+			t.Errorf("Expected the header to be persisted, got %s", data)
+		}
+
+		config.mu.Lock()
+		config.ClientHeaders = nil
+		config.mu.Unlock()
+		if err := loadConfig(); err != nil {
+			t.Fatalf("loadConfig failed: %v", err)
+		}
+		if headers := currentClientHeaders(); len(headers) != 1 || headers[0].Mode != ClientHeaderReplace {
+			t.Errorf("Expected the persisted header to be restored, got %+v", headers)
+		}
+	})
+
+	t.Run("POST with no headers disables all", func(t *testing.T) {
+		if rr := post(`{"headers":[]}`); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", rr.Code)
+		}
+		if err := loadConfig(); err != nil {
+			t.Fatalf("loadConfig failed: %v", err)
+		}
+		if headers := currentClientHeaders(); len(headers) != 0 {
+			t.Errorf("Expected an empty list to survive a reload, got %+v", headers)
+		}
+	})
+
+	t.Run("POST without headers keeps them", func(t *testing.T) {
+		if rr := post(`{"headers":[{"name":"X-Client","value":"{source_addr}","enabled":true}]}`); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", rr.Code)
+		}
+		for _, body := range []string{`{"headers":null,"trusted_proxies":["10.0.0.0/8"]}`, `{"strip_headers":["X-Real-IP"]}`} {
+			if rr := post(body); rr.Code != http.StatusOK {
+				t.Fatalf("Expected status code 200, got %d", rr.Code)
+			}
+			if headers := currentClientHeaders(); len(headers) != 1 || headers[0].Name != "X-Client" {
+				t.Errorf("Expected %s to keep the headers, got %+v", body, headers)
+			}
+		}
+	})
+
+	t.Run("POST reset restores defaults", func(t *testing.T) {
+		if rr := post(`{"reset":true}`); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", rr.Code)
+		}
+		if headers := currentClientHeaders(); len(headers) != len(defaultClientHeaders()) {
+			t.Errorf("Expected the default headers, got %+v", headers)
+		}
+		if strip := currentStripHeaders(); !reflect.DeepEqual(strip, defaultStripHeaders()) {
+			t.Errorf("Expected the default strip list, got %v", strip)
+		}
+		if proxies := trustedProxies(); len(proxies) != 0 {
+			t.Errorf("Expected the trusted proxies to be cleared, got %v", proxies)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// Vendor TLV types of the cloud load balancers
+const (
+	PP2TypeGCP   = 0xE0 // Private Service Connect, 8 byte connection ID
+	PP2TypeAWS   = 0xEA // PrivateLink, subtype followed by the value
+	PP2TypeAzure = 0xEE // Private Link, subtype followed by the value
+)
+
+// Subtypes of the AWS and Azure TLVs
+const (
+	PP2SubtypeAWSVPCEID                = 0x01 // VPC endpoint ID, ASCII
+	PP2SubtypeAzurePrivateEndpointLink = 0x01 // LINKID, 32 bit little endian
+)
+
+// Cloud providers of a CloudEndpoint
+const (
+	CloudProviderAWS   = "aws"
+	CloudProviderAzure = "azure"
+	CloudProviderGCP   = "gcp"
+)
+
+// CloudEndpoint identifies the consumer endpoint a private connection came through
+type CloudEndpoint struct {
+	Provider string `json:"provider"`
+	ID       string `json:"id"` // VPC endpoint ID, Private Link ID or PSC connection ID
+}
+
+// String formats the endpoint as provider:id, the form used in access rules
+func (e CloudEndpoint) String() string {
+	return e.Provider + ":" + e.ID
+}
+
+// decodeCloudTLVs fills in the cloud endpoint fields from the vendor TLVs of
+// a parsed header. The 0xE0-0xEF range is shared with other applications, so
+// a TLV that does not have the vendor's layout is left alone instead of
+// failing the header, and a type in the custom TLV mappings is not decoded as
+// a vendor TLV.
+func decodeCloudTLVs(info *ProxyProtocolInfo, mappings []CustomTLV) {
+	for _, tlv := range info.TLVs {
+		if tlv.Type != PP2TypeAWS && tlv.Type != PP2TypeAzure && tlv.Type != PP2TypeGCP {
+			continue
+		}
+		if isCustomTLV(tlv.Type, mappings) {
+			continue
+		}
+		switch tlv.Type {
+		case PP2TypeAWS:
+			if info.AWSVPCEndpointID == "" && len(tlv.Value) > 1 && tlv.Value[0] == PP2SubtypeAWSVPCEID &&
+				isPrintableASCII(tlv.Value[1:]) {
+				info.AWSVPCEndpointID = string(tlv.Value[1:])
+			}
+		case PP2TypeAzure:
+			if info.AzureLinkID == nil && len(tlv.Value) == 5 && tlv.Value[0] == PP2SubtypeAzurePrivateEndpointLink {
+				linkID := binary.LittleEndian.Uint32(tlv.Value[1:])
+				info.AzureLinkID = &linkID
+			}
+		case PP2TypeGCP:
+			if info.GCPPSCConnectionID == nil && len(tlv.Value) == 8 {
+				connectionID := binary.BigEndian.Uint64(tlv.Value)
+				info.GCPPSCConnectionID = &connectionID
+			}
+		}
+	}
+}
+
+// CloudEndpoint returns the consumer endpoint from the vendor TLVs, if any
+func (info *ProxyProtocolInfo) CloudEndpoint() (CloudEndpoint, bool) {
+	switch {
+	case info.AWSVPCEndpointID != "":
+		return CloudEndpoint{Provider: CloudProviderAWS, ID: info.AWSVPCEndpointID}, true
+	case info.AzureLinkID != nil:
+		return CloudEndpoint{Provider: CloudProviderAzure, ID: azureLinkID(info)}, true
+	case info.GCPPSCConnectionID != nil:
+		return CloudEndpoint{Provider: CloudProviderGCP, ID: gcpPSCConnectionID(info)}, true
+	}
+	return CloudEndpoint{}, false
+}
+
+// azureLinkID and gcpPSCConnectionID render the numeric IDs for the header placeholders
+func azureLinkID(info *ProxyProtocolInfo) string {
+	if info.AzureLinkID == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*info.AzureLinkID), 10)
+}
+
+func gcpPSCConnectionID(info *ProxyProtocolInfo) string {
+	if info.GCPPSCConnectionID == nil {
+		return ""
+	}
+	return strconv.FormatUint(*info.GCPPSCConnectionID, 10)
+}
+
+// isPrintableASCII reports whether value is non-empty visible ASCII
+func isPrintableASCII(value []byte) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for _, b := range value {
+		if b < 0x21 || b > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// parseCloudHeader encodes a v2 header with the given TLVs, parses it back
+// and maps the vendor TLVs with the given custom TLV mappings
+func parseCloudHeader(t *testing.T, mappings []CustomTLV, tlvs ...ProxyProtocolTLV) *ProxyProtocolInfo {
+	t.Helper()
+	header, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+		SourceAddr: "10.1.2.3", SourcePort: 40000, DestAddr: "10.0.0.1", DestPort: 443, TLVs: tlvs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := parseProxyProtocolV2(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil {
+		t.Fatalf("Failed to parse header: %v", err)
+	}
+	// The parser only returns the raw TLVs
+	if _, ok := info.CloudEndpoint(); ok {
+		t.Fatalf("Expected the parser to leave the vendor TLVs to decodeCloudTLVs, got %+v", info)
+	}
+	decodeCloudTLVs(info, mappings)
+	return info
+}
+
+// Test decoding of the AWS, Azure and GCP vendor TLVs
+func TestDecodeCloudTLVs(t *testing.T) {
+	t.Run("AWS VPC endpoint", func(t *testing.T) {
+		info := parseCloudHeader(t, nil, ProxyProtocolTLV{Type: PP2TypeAWS, Value: append([]byte{PP2SubtypeAWSVPCEID}, "vpce-08d2bf15fac5001c9"...)})
+		if info.AWSVPCEndpointID != "vpce-08d2bf15fac5001c9" {
+			t.Errorf("Expected the VPC endpoint ID, got %q", info.AWSVPCEndpointID)
+		}
+		if endpoint, ok := info.CloudEndpoint(); !ok || endpoint.String() != "aws:vpce-08d2bf15fac5001c9" {
+			t.Errorf("Expected endpoint aws:vpce-08d2bf15fac5001c9, got %v", endpoint)
+		}
+		if info.TLVs[0].Name() != "AWS" {
+			t.Errorf("Expected TLV name AWS, got %s", info.TLVs[0].Name())
+		}
+	})
+
+	t.Run("Azure link ID", func(t *testing.T) {
+		info := parseCloudHeader(t, nil, ProxyProtocolTLV{Type: PP2TypeAzure, Value: []byte{PP2SubtypeAzurePrivateEndpointLink, 0x78, 0x56, 0x34, 0x12}})
+		if info.AzureLinkID == nil || *info.AzureLinkID != 0x12345678 {
+			t.Fatalf("Expected link ID 0x12345678, got %v", info.AzureLinkID)
+		}
+		if endpoint, _ := info.CloudEndpoint(); endpoint.String() != "azure:305419896" {
+			t.Errorf("Expected endpoint azure:305419896, got %v", endpoint)
+		}
+	})
+
+	t.Run("GCP PSC connection ID", func(t *testing.T) {
+		info := parseCloudHeader(t, nil, ProxyProtocolTLV{Type: PP2TypeGCP, Value: []byte{0, 0, 0, 0x01, 0, 0, 0, 0x02}})
+		if info.GCPPSCConnectionID == nil || *info.GCPPSCConnectionID != 0x100000002 {
+			t.Fatalf("Expected connection ID 0x100000002, got %v", info.GCPPSCConnectionID)
+		}
+		if endpoint, _ := info.CloudEndpoint(); endpoint.String() != "gcp:4294967298" {
+			t.Errorf("Expected endpoint gcp:4294967298, got %v", endpoint)
+		}
+	})
+
+	t.Run("foreign layouts are ignored", func(t *testing.T) {
+		info := parseCloudHeader(t, nil,
+			ProxyProtocolTLV{Type: PP2TypeAWS, Value: []byte{0x02, 'x'}},
+			ProxyProtocolTLV{Type: PP2TypeAzure, Value: []byte{PP2SubtypeAzurePrivateEndpointLink, 0x01}},
+			ProxyProtocolTLV{Type: PP2TypeGCP, Value: []byte("app")},
+		)
+		if _, ok := info.CloudEndpoint(); ok || len(info.TLVs) != 3 {
+			t.Errorf("Expected no endpoint and the raw TLVs, got %+v", info)
+		}
+	})
+
+	t.Run("custom TLV mappings take precedence", func(t *testing.T) {
+		mappings := []CustomTLV{{Type: PP2TypeGCP, Name: "shard", Decoding: TLVDecodingUint}}
+		info := parseCloudHeader(t, mappings, ProxyProtocolTLV{Type: PP2TypeGCP, Value: []byte{0, 0, 0, 0x01, 0, 0, 0, 0x02}},
+			ProxyProtocolTLV{Type: PP2TypeAzure, Value: []byte{PP2SubtypeAzurePrivateEndpointLink, 0x78, 0x56, 0x34, 0x12}})
+		if info.GCPPSCConnectionID != nil {
+			t.Errorf("Expected the mapped type not to be decoded as a PSC connection ID, got %d", *info.GCPPSCConnectionID)
+		}
+		if endpoint, _ := info.CloudEndpoint(); endpoint.String() != "azure:305419896" {
+			t.Errorf("Expected the unmapped Azure TLV to be decoded, got %v", endpoint)
+		}
+	})
+}
+
+// Test the cloud endpoint placeholders
+func TestCloudEndpointHeaders(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) { c.ClientHeaders = defaultClientHeaders() })
+	linkID := uint32(42)
+	info := &ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+		SourceAddr: "10.1.2.3", SourcePort: 40000, DestAddr: "10.0.0.1", DestPort: 443, AzureLinkID: &linkID}
+
+	dst := make(map[string][]string)
+	applyClientHeaders(dst, &headerSource{info: info})
+	if got := dst["X-Azure-Private-Link-Id"]; len(got) != 1 || got[0] != "42" {
+		t.Errorf("Expected X-Azure-Private-Link-ID 42, got %v", got)
+	}
+	if _, ok := dst["X-Aws-Vpce-Id"]; ok {
+		t.Error("Expected no X-AWS-VPCE-ID without the TLV")
+	}
+}
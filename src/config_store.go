@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// CONFIG_FILE is the name of the persisted plugin configuration,
+// stored next to the plugin executable
+const CONFIG_FILE = "config.json"
+
+// configPath is the location of the persisted configuration.
+// An empty path disables persistence (used by tests).
+var configPath = ""
+
+// pluginDir returns the directory containing the plugin executable
+func pluginDir() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return "."
+	}
+	return filepath.Dir(exe)
+}
+
+// persistedConfig is the on-disk representation of PluginConfig
+type persistedConfig struct {
+	Enabled          bool               `json:"enabled"`
+	HostRules        []HostRule         `json:"host_rules"`
+	AllowedOrigins   []string           `json:"allowed_origins,omitempty"`
+	TrustedUpstreams []string           `json:"trusted_upstreams,omitempty"`
+	ClientHeaders    []ClientHeader     `json:"client_headers"` // missing: the default headers
+	Forwarded        *ForwardedSettings `json:"forwarded,omitempty"`
+	TrustedProxies   []string           `json:"trusted_proxies,omitempty"`
+	StripHeaders     []string           `json:"strip_headers"` // missing: the default list
+	EndpointAccess   *EndpointAccess    `json:"endpoint_access,omitempty"`
+	CustomTLVs       []CustomTLV        `json:"custom_tlvs,omitempty"`
+	IPAccess         *IPAccess          `json:"ip_access,omitempty"`
+	RateLimit        *RateLimitSettings `json:"rate_limit,omitempty"`
+	Bans             *BanSettings       `json:"bans,omitempty"`
+	Logging          *LoggingSettings   `json:"logging,omitempty"`
+}
+
+// configLoadResult is the outcome of the last loadConfig call, used by the health check
+type configLoadResult struct {
+	loadedAt time.Time
+	fromDisk bool
+	err      error
+}
+
+var (
+	lastConfigLoadMu sync.Mutex
+	lastConfigLoad   configLoadResult
+)
+
+func configLoadState() configLoadResult {
+	lastConfigLoadMu.Lock()
+	defer lastConfigLoadMu.Unlock()
+	return lastConfigLoad
+}
+
+// loadConfig reads the configuration from configPath into config.
+// A missing file is not an error, the defaults are kept.
+func loadConfig() error {
+	fromDisk, err := readConfig()
+
+	lastConfigLoadMu.Lock()
+	lastConfigLoad = configLoadResult{loadedAt: time.Now(), fromDisk: fromDisk, err: err}
+	lastConfigLoadMu.Unlock()
+	return err
+}
+
+// readConfig applies the config file, reporting whether one was found
+func readConfig() (bool, error) {
+	if configPath == "" {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading config: %w", err)
+	}
+
+	var stored persistedConfig
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return true, fmt.Errorf("parsing config: %w", err)
+	}
+
+	next := PluginConfig{
+		Enabled:        stored.Enabled,
+		HostRules:      stored.HostRules,
+		AllowedOrigins: stored.AllowedOrigins,
+		ClientHeaders:  defaultClientHeaders(),
+		Forwarded:      defaultForwardedSettings(),
+		StripHeaders:   defaultStripHeaders(),
+		CustomTLVs:     stored.CustomTLVs,
+		IPAccess:       defaultIPAccess(),
+		RateLimit:      defaultRateLimitSettings(),
+		Bans:           defaultBanSettings(),
+	}
+	if stored.ClientHeaders != nil {
+		next.ClientHeaders = stored.ClientHeaders
+	}
+	if stored.Forwarded != nil {
+		next.Forwarded = *stored.Forwarded
+	}
+	if stored.StripHeaders != nil {
+		next.StripHeaders = stored.StripHeaders
+	}
+	if stored.EndpointAccess != nil {
+		next.EndpointAccess = *stored.EndpointAccess
+	}
+	if stored.IPAccess != nil {
+		next.IPAccess = *stored.IPAccess
+	}
+	if stored.RateLimit != nil {
+		next.RateLimit = *stored.RateLimit
+	}
+	if stored.Bans != nil {
+		next.Bans = *stored.Bans
+	}
+	if next.trustedUpstreams, err = parsePrefixes(stored.TrustedUpstreams); err != nil {
+		return true, fmt.Errorf("invalid trusted upstreams in config: %w", err)
+	}
+	if next.trustedProxies, err = parsePrefixes(stored.TrustedProxies); err != nil {
+		return true, fmt.Errorf("invalid trusted proxies in config: %w", err)
+	}
+	if err := next.normalize(); err != nil {
+		return true, fmt.Errorf("validating config: %w", err)
+	}
+	if stored.Logging != nil {
+		if err := stored.Logging.validate(); err != nil {
+			return true, fmt.Errorf("invalid logging settings in config: %w", err)
+		}
+	}
+
+	// Apply only a config that is valid as a whole
+	if stored.Logging != nil {
+		applyLoggingSettings(*stored.Logging)
+	}
+	applyConfig(&next)
+	return true, nil
+}
+
+// normalize validates and compiles every section of c in place
+func (c *PluginConfig) normalize() error {
+	var err error
+	if c.HostRules, err = compileHostRules(c.HostRules); err != nil {
+		return fmt.Errorf("invalid host rules: %w", err)
+	}
+	if c.AllowedOrigins, err = normalizeOrigins(c.AllowedOrigins); err != nil {
+		return fmt.Errorf("invalid allowed origins: %w", err)
+	}
+	if c.ClientHeaders, err = compileClientHeaders(c.ClientHeaders); err != nil {
+		return fmt.Errorf("invalid client headers: %w", err)
+	}
+	if err := c.Forwarded.normalize(); err != nil {
+		return fmt.Errorf("invalid forwarded settings: %w", err)
+	}
+	if c.StripHeaders, err = normalizeStripHeaders(c.StripHeaders); err != nil {
+		return fmt.Errorf("invalid strip headers: %w", err)
+	}
+	if err := c.EndpointAccess.normalize(); err != nil {
+		return fmt.Errorf("invalid endpoint access rules: %w", err)
+	}
+	c.CustomTLVs, err = normalizeCustomTLVs(c.CustomTLVs)
+	if err == nil {
+		err = checkCustomTLVHeaders(c.CustomTLVs, c.ClientHeaders)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid custom TLVs: %w", err)
+	}
+	if err := c.IPAccess.normalize(); err != nil {
+		return fmt.Errorf("invalid IP access rules: %w", err)
+	}
+	if err := c.RateLimit.normalize(); err != nil {
+		return fmt.Errorf("invalid rate limit: %w", err)
+	}
+	err = c.Bans.normalize()
+	if err == nil {
+		err = checkBanUpstreams(c.Bans, c.trustedUpstreams)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid ban settings: %w", err)
+	}
+	return nil
+}
+
+// copyConfig copies every setting except the lock
+func copyConfig(dst, src *PluginConfig) {
+	dst.Enabled = src.Enabled
+	dst.HostRules = src.HostRules
+	dst.AllowedOrigins = src.AllowedOrigins
+	dst.ClientHeaders = src.ClientHeaders
+	dst.Forwarded = src.Forwarded
+	dst.StripHeaders = src.StripHeaders
+	dst.EndpointAccess = src.EndpointAccess
+	dst.CustomTLVs = src.CustomTLVs
+	dst.IPAccess = src.IPAccess.clone()
+	dst.RateLimit = src.RateLimit.clone()
+	dst.Bans = src.Bans.clone()
+	dst.trustedUpstreams = src.trustedUpstreams
+	dst.trustedProxies = src.trustedProxies
+}
+
+// applyConfig installs the normalized c as the live configuration. The rate
+// limit buckets are dropped when their settings change.
+func applyConfig(c *PluginConfig) {
+	config.mu.Lock()
+	rateLimitChanged := !reflect.DeepEqual(config.RateLimit, c.RateLimit)
+	copyConfig(config, c)
+	config.mu.Unlock()
+
+	hostDecisions.reset()
+	if rateLimitChanged {
+		rateLimits.reset()
+	}
+}
+
+// saveConfig writes the current configuration to configPath
+func saveConfig() error {
+	if configPath == "" {
+		return nil
+	}
+
+	config.mu.RLock()
+	forwarded := config.Forwarded
+	stored := persistedConfig{
+		Enabled:          config.Enabled,
+		HostRules:        config.HostRules,
+		AllowedOrigins:   config.AllowedOrigins,
+		TrustedUpstreams: formatPrefixes(config.trustedUpstreams),
+		ClientHeaders:    append([]ClientHeader{}, config.ClientHeaders...),
+		Forwarded:        &forwarded,
+		TrustedProxies:   formatPrefixes(config.trustedProxies),
+		StripHeaders:     append([]string{}, config.StripHeaders...),
+		EndpointAccess:   &EndpointAccess{Allow: config.EndpointAccess.Allow, Deny: config.EndpointAccess.Deny},
+		CustomTLVs:       append([]CustomTLV{}, config.CustomTLVs...),
+	}
+	ipAccess := config.IPAccess.clone()
+	stored.IPAccess = &ipAccess
+	rateLimit := config.RateLimit.clone()
+	stored.RateLimit = &rateLimit
+	banSettings := config.Bans.clone()
+	stored.Bans = &banSettings
+	config.mu.RUnlock()
+	logging := currentLoggingSettings()
+	stored.Logging = &logging
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated config
+	tmpPath := configPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	if err := os.Rename(tmpPath, configPath); err != nil {
+		return fmt.Errorf("replacing config: %w", err)
+	}
+	return nil
+}
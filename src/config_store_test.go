@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setConfig applies change to a copy of the configuration for the duration of the test.
+// The copy is normalized like a loaded config file and the previous configuration is
+// restored when the test ends. Bans are dropped whenever their settings change, so a
+// test can keep its bans while it adjusts other settings.
+func setConfig(t *testing.T, change func(c *PluginConfig)) {
+	t.Helper()
+	var saved, next PluginConfig
+	config.mu.RLock()
+	copyConfig(&saved, config)
+	copyConfig(&next, config)
+	config.mu.RUnlock()
+
+	change(&next)
+	if err := next.normalize(); err != nil {
+		t.Fatalf("Invalid config: %v", err)
+	}
+	apply := func(c *PluginConfig) {
+		bansChanged := !reflect.DeepEqual(currentBanSettings(), c.Bans)
+		applyConfig(c)
+		if bansChanged {
+			bans.reset()
+		}
+	}
+	apply(&next)
+	t.Cleanup(func() { apply(&saved) })
+}
+
+// testPrefixes parses trusted proxy or upstream values for setConfig
+func testPrefixes(t *testing.T, values ...string) []netip.Prefix {
+	t.Helper()
+	prefixes, err := parsePrefixes(values)
+	if err != nil {
+		t.Fatalf("Failed to parse prefixes: %v", err)
+	}
+	return prefixes
+}
+
+// Test that a config file is applied only when all of it is valid
+func TestReadConfigValidatesFirst(t *testing.T) {
+	captureLogs(t, LogFormatText)
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+	defer func() { configPath = oldPath }()
+	setConfig(t, func(c *PluginConfig) { c.Enabled = false })
+
+	invalid := map[string]string{
+		"bans":    `{"enabled":true,"bans":{"enabled":true},"logging":{"levels":{"api":"debug"}}}`,
+		"logging": `{"enabled":true,"logging":{"format":"xml","levels":{"api":"debug"}}}`,
+	}
+	for name, content := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadConfig(); err == nil {
+				t.Fatal("Expected error")
+			}
+			if level := componentLevels[LogComponentAPI].Level(); level != slog.LevelInfo {
+				t.Errorf("Expected api level to stay info, got %s", level)
+			}
+			if _, format := logOut.current(); format != LogFormatText {
+				t.Errorf("Expected the text format to be kept, got %s", format)
+			}
+			config.mu.RLock()
+			enabled := config.Enabled
+			config.mu.RUnlock()
+			if enabled {
+				t.Error("Expected the plugin to stay disabled")
+			}
+		})
+	}
+}
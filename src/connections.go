@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultConnectionTTL is how long an idle connection stays in the registry
+	defaultConnectionTTL = 5 * time.Minute
+	// connectionEvictionInterval is how often idle connections are evicted
+	connectionEvictionInterval = 30 * time.Second
+	// maxConnectionEntries bounds the registry size
+	maxConnectionEntries = 10000
+
+	defaultConnectionsPageSize = 50
+	maxConnectionsPageSize     = 500
+)
+
+// Connection origins
+const (
+	ConnectionOriginListener = "listener" // accepted by a ProxyProtocolListener
+	ConnectionOriginIngress  = "ingress"  // seen through the Zoraxy capture ingress
+)
+
+// ConnectionEntry describes a connection that carried a Proxy Protocol header
+type ConnectionEntry struct {
+	ID             string    `json:"id"`
+	Origin         string    `json:"origin"`
+	SourceAddr     string    `json:"source_addr"`
+	SourcePort     int       `json:"source_port"`
+	DestAddr       string    `json:"dest_addr"`
+	DestPort       int       `json:"dest_port"`
+	Version        int       `json:"version"`
+	TransportProto string    `json:"transport_proto"`
+	Hostname       string    `json:"hostname,omitempty"`
+	UniqueID       string    `json:"unique_id,omitempty"` // PP2_TYPE_UNIQUE_ID of the load balancer
+	Endpoint       string    `json:"endpoint,omitempty"`  // cloud endpoint, e.g. aws:vpce-0123456789abcdef0
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	Bytes          int64     `json:"bytes"`
+	Requests       int64     `json:"requests"`
+}
+
+// ConnectionsResponse is a page of the connection registry
+type ConnectionsResponse struct {
+	Total       int               `json:"total"`
+	Page        int               `json:"page"`
+	PageSize    int               `json:"page_size"`
+	Connections []ConnectionEntry `json:"connections"`
+}
+
+// connectionFilter selects registry entries, empty fields match everything
+type connectionFilter struct {
+	Source   string
+	Hostname string
+	Origin   string
+	Version  int
+	UniqueID string
+}
+
+func (f connectionFilter) matches(entry *ConnectionEntry) bool {
+	if f.Source != "" && !strings.Contains(entry.SourceAddr, f.Source) {
+		return false
+	}
+	if f.Hostname != "" && !strings.Contains(entry.Hostname, f.Hostname) {
+		return false
+	}
+	if f.Origin != "" && entry.Origin != f.Origin {
+		return false
+	}
+	if f.Version != 0 && entry.Version != f.Version {
+		return false
+	}
+	if f.UniqueID != "" && entry.UniqueID != f.UniqueID {
+		return false
+	}
+	return true
+}
+
+// connectionRegistry tracks active Proxy Protocol connections
+type connectionRegistry struct {
+	mu         sync.RWMutex
+	entries    map[string]*ConnectionEntry
+	ttl        time.Duration
+	maxEntries int
+	nextID     uint64
+}
+
+// Plugin connection registry for active connections
+var connections = newConnectionRegistry(defaultConnectionTTL, maxConnectionEntries)
+
+func newConnectionRegistry(ttl time.Duration, maxEntries int) *connectionRegistry {
+	return &connectionRegistry{
+		entries:    make(map[string]*ConnectionEntry),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+// newID returns a unique identifier for a listener connection
+func (c *connectionRegistry) newID() string {
+	return fmt.Sprintf("conn-%d", atomic.AddUint64(&c.nextID, 1))
+}
+
+// ingressConnectionID identifies an ingress connection by its PROXY endpoints,
+// so requests sharing the load balancer connection update the same entry
+func ingressConnectionID(info *ProxyProtocolInfo) string {
+	return fmt.Sprintf("ingress-%s:%d-%s:%d", info.SourceAddr, info.SourcePort, info.DestAddr, info.DestPort)
+}
+
+// register adds the connection or refreshes it when already known
+func (c *connectionRegistry) register(id, origin string, info *ProxyProtocolInfo, hostname string, bytes int) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[id]; ok {
+		entry.LastSeen = now
+		entry.Bytes += int64(bytes)
+		entry.Requests++
+		if hostname != "" {
+			entry.Hostname = hostname
+		}
+		return
+	}
+
+	if len(c.entries) >= c.maxEntries {
+		c.evictIdleLocked(now)
+		if len(c.entries) >= c.maxEntries {
+			c.evictOldestLocked()
+		}
+	}
+
+	c.entries[id] = &ConnectionEntry{
+		ID:             id,
+		Origin:         origin,
+		SourceAddr:     info.SourceAddr,
+		SourcePort:     info.SourcePort,
+		DestAddr:       info.DestAddr,
+		DestPort:       info.DestPort,
+		Version:        info.Version,
+		TransportProto: info.TransportProto,
+		Hostname:       hostname,
+		UniqueID:       info.UniqueID(),
+		Endpoint:       endpointLabel(info),
+		FirstSeen:      now,
+		LastSeen:       now,
+		Bytes:          int64(bytes),
+		Requests:       1,
+	}
+}
+
+// touch records traffic on a known connection
+func (c *connectionRegistry) touch(id string, bytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[id]; ok {
+		entry.LastSeen = time.Now()
+		entry.Bytes += int64(bytes)
+	}
+}
+
+// remove drops a closed connection
+func (c *connectionRegistry) remove(id string) {
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.mu.Unlock()
+}
+
+// get returns a copy of the entry
+func (c *connectionRegistry) get(id string) (ConnectionEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[id]
+	if !ok {
+		return ConnectionEntry{}, false
+	}
+	return *entry, true
+}
+
+// size returns the number of tracked connections
+func (c *connectionRegistry) size() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// evictIdle removes connections idle for longer than the TTL
+func (c *connectionRegistry) evictIdle(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictIdleLocked(now)
+}
+
+func (c *connectionRegistry) evictIdleLocked(now time.Time) int {
+	evicted := 0
+	for id, entry := range c.entries {
+		if now.Sub(entry.LastSeen) > c.ttl {
+			delete(c.entries, id)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (c *connectionRegistry) evictOldestLocked() {
+	var oldestID string
+	var oldest time.Time
+	for id, entry := range c.entries {
+		if oldestID == "" || entry.LastSeen.Before(oldest) {
+			oldestID = id
+			oldest = entry.LastSeen
+		}
+	}
+	delete(c.entries, oldestID)
+}
+
+// startEviction periodically evicts idle connections until stop is closed
+func (c *connectionRegistry) startEviction(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if evicted := c.evictIdle(now); evicted > 0 {
+					registryLog.Debug("Evicted idle connections from registry", "evicted", evicted)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// list returns the matching entries, most recently active first
+func (c *connectionRegistry) list(filter connectionFilter) []ConnectionEntry {
+	c.mu.RLock()
+	result := make([]ConnectionEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		if filter.matches(entry) {
+			result = append(result, *entry)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].LastSeen.Equal(result[j].LastSeen) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].LastSeen.After(result[j].LastSeen)
+	})
+	return result
+}
+
+// handleAPIConnections returns a page of the connection registry.
+// Query parameters: page, page_size, source, host, origin, version, unique_id.
+func handleAPIConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	page, err := queryInt(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
+	pageSize, err := queryInt(query.Get("page_size"), defaultConnectionsPageSize)
+	if err != nil || pageSize < 1 {
+		http.Error(w, "Invalid page_size", http.StatusBadRequest)
+		return
+	}
+	if pageSize > maxConnectionsPageSize {
+		pageSize = maxConnectionsPageSize
+	}
+	version, err := queryInt(query.Get("version"), 0)
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+
+	all := connections.list(connectionFilter{
+		Source:   query.Get("source"),
+		Hostname: normalizeHostname(query.Get("host")),
+		Origin:   query.Get("origin"),
+		Version:  version,
+		UniqueID: query.Get("unique_id"),
+	})
+
+	// Compare the page before multiplying, a huge page would overflow the offset
+	start := len(all)
+	if page-1 <= len(all)/pageSize {
+		start = (page - 1) * pageSize
+	}
+	end := start + pageSize
+	if end > len(all) {
+		end = len(all)
+	}
+
+	writeJSON(w, ConnectionsResponse{
+		Total:       len(all),
+		Page:        page,
+		PageSize:    pageSize,
+		Connections: all[start:end],
+	})
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
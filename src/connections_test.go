@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test registry lifecycle
+func TestConnectionRegistry(t *testing.T) {
+	info := &ProxyProtocolInfo{
+		SourceAddr:     "192.0.2.10",
+		SourcePort:     40000,
+		DestAddr:       "198.51.100.1",
+		DestPort:       443,
+		Version:        2,
+		TransportProto: "TCP4",
+	}
+
+	t.Run("Register, touch and remove", func(t *testing.T) {
+		registry := newConnectionRegistry(time.Minute, 10)
+		registry.register("a", ConnectionOriginListener, info, "", 0)
+		registry.touch("a", 100)
+		registry.register("a", ConnectionOriginListener, info, "example.com", 50)
+
+		entry, ok := registry.get("a")
+		if !ok {
+			t.Fatal("Expected entry to exist")
+		}
+		if entry.Bytes != 150 || entry.Requests != 2 || entry.Hostname != "example.com" {
+			t.Errorf("Unexpected entry: %+v", entry)
+		}
+		if entry.Version != 2 || entry.SourceAddr != "192.0.2.10" {
+			t.Errorf("Unexpected entry fields: %+v", entry)
+		}
+		if entry.LastSeen.Before(entry.FirstSeen) {
+			t.Error("LastSeen should not be before FirstSeen")
+		}
+
+		registry.remove("a")
+		if registry.size() != 0 {
+			t.Errorf("Expected empty registry, got %d entries", registry.size())
+		}
+	})
+
+	t.Run("Idle TTL eviction", func(t *testing.T) {
+		registry := newConnectionRegistry(time.Minute, 10)
+		registry.register("idle", ConnectionOriginIngress, info, "", 0)
+		registry.register("active", ConnectionOriginIngress, info, "", 0)
+
+		registry.mu.Lock()
+		registry.entries["idle"].LastSeen = time.Now().Add(-2 * time.Minute)
+		registry.mu.Unlock()
+
+		if evicted := registry.evictIdle(time.Now()); evicted != 1 {
+			t.Errorf("Expected 1 eviction, got %d", evicted)
+		}
+		if _, ok := registry.get("idle"); ok {
+			t.Error("Expected idle entry to be evicted")
+		}
+		if _, ok := registry.get("active"); !ok {
+			t.Error("Expected active entry to remain")
+		}
+	})
+
+	t.Run("Size limit evicts oldest", func(t *testing.T) {
+		registry := newConnectionRegistry(time.Hour, 2)
+		registry.register("first", ConnectionOriginIngress, info, "", 0)
+		registry.register("second", ConnectionOriginIngress, info, "", 0)
+		registry.mu.Lock()
+		registry.entries["first"].LastSeen = time.Now().Add(-time.Minute)
+		registry.mu.Unlock()
+		registry.register("third", ConnectionOriginIngress, info, "", 0)
+
+		if registry.size() != 2 {
+			t.Errorf("Expected 2 entries, got %d", registry.size())
+		}
+		if _, ok := registry.get("first"); ok {
+			t.Error("Expected oldest entry to be evicted")
+		}
+	})
+}
+
+// Test listener connections are tracked until closed
+func TestListenerConnectionTracking(t *testing.T) {
+	header := "PROXY TCP4 192.0.2.55 198.51.100.1 41000 80\r\n"
+	mockConn := &mockConn{data: []byte(header + "GET / HTTP/1.1\r\n\r\n")}
+	ppListener := NewProxyProtocolListener(&singleConnListener{conn: mockConn}, nil, listenerLog)
+
+	before := connections.size()
+	conn, err := ppListener.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+
+	ppConn, ok := conn.(*proxyProtocolConn)
+	if !ok {
+		t.Fatalf("Expected *proxyProtocolConn, got %T", conn)
+	}
+	if connections.size() != before+1 {
+		t.Fatalf("Expected connection to be registered")
+	}
+
+	buf := make([]byte, 64)
+	n, _ := ppConn.Read(buf)
+
+	entry, ok := connections.get(ppConn.registryID)
+	if !ok || entry.Bytes != int64(n) || entry.SourceAddr != "192.0.2.55" || entry.Origin != ConnectionOriginListener {
+		t.Errorf("Unexpected registry entry: %+v", entry)
+	}
+
+	ppConn.Close()
+	if _, ok := connections.get(ppConn.registryID); ok {
+		t.Error("Expected connection to be removed on close")
+	}
+}
+
+// Test ingress registers the parsed connection
+func TestIngressConnectionTracking(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	proxyData := "PROXY TCP4 192.0.2.77 198.51.100.50 45000 443\r\nGET / HTTP/1.1\r\n\r\n"
+	req := httptest.NewRequest("POST", INGRESS_PATH+"/", strings.NewReader(proxyData))
+	req.Header.Set("X-Zoraxy-RequestID", "track-1")
+	serveIngress(t, req, "example.com")
+
+	id := ingressConnectionID(&ProxyProtocolInfo{SourceAddr: "192.0.2.77", SourcePort: 45000, DestAddr: "198.51.100.50", DestPort: 443})
+	entry, ok := connections.get(id)
+	if !ok {
+		t.Fatal("Expected ingress connection to be registered")
+	}
+	if entry.Origin != ConnectionOriginIngress || entry.Bytes != int64(len(proxyData)) || entry.Version != 1 {
+		t.Errorf("Unexpected registry entry: %+v", entry)
+	}
+	connections.remove(id)
+}
+
+// Test the paginated connections API
+func TestAPIConnections(t *testing.T) {
+	oldConnections := connections
+	connections = newConnectionRegistry(time.Minute, 100)
+	defer func() { connections = oldConnections }()
+
+	for i := 0; i < 5; i++ {
+		connections.register(fmt.Sprintf("v1-%d", i), ConnectionOriginIngress, &ProxyProtocolInfo{
+			SourceAddr: fmt.Sprintf("192.0.2.%d", i), Version: 1,
+		}, "a.example.com", 10)
+	}
+	connections.register("v2", ConnectionOriginListener, &ProxyProtocolInfo{SourceAddr: "203.0.113.9", Version: 2}, "", 0)
+
+	get := func(query string) ConnectionsResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/ui/api/connections"+query, nil)
+		rr := httptest.NewRecorder()
+		handleAPIConnections(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		var response ConnectionsResponse
+		if err := json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&response); err != nil {
+			t.Fatalf("Failed to parse JSON response: %v", err)
+		}
+		return response
+	}
+
+	if response := get(""); response.Total != 6 || len(response.Connections) != 6 {
+		t.Errorf("Expected all 6 connections, got %d/%d", len(response.Connections), response.Total)
+	}
+	if response := get("?page=2&page_size=4"); response.Total != 6 || len(response.Connections) != 2 {
+		t.Errorf("Expected 2 connections on page 2, got %d", len(response.Connections))
+	}
+	if response := get("?page=5&page_size=4"); len(response.Connections) != 0 {
+		t.Errorf("Expected empty page, got %d", len(response.Connections))
+	}
+	if response := get("?page=4611686018427387904&page_size=4"); len(response.Connections) != 0 {
+		t.Errorf("Expected empty page for a huge page number, got %d", len(response.Connections))
+	}
+	if response := get("?version=2"); response.Total != 1 || response.Connections[0].ID != "v2" {
+		t.Errorf("Unexpected version filter result: %+v", response)
+	}
+	if response := get("?source=192.0.2.3"); response.Total != 1 {
+		t.Errorf("Expected 1 connection for source filter, got %d", response.Total)
+	}
+	if response := get("?host=A.example.com&origin=ingress"); response.Total != 5 {
+		t.Errorf("Expected 5 connections for host filter, got %d", response.Total)
+	}
+
+	for _, query := range []string{"?page=0", "?page_size=x", "?version=v2"} {
+		req := httptest.NewRequest(http.MethodGet, "/ui/api/connections"+query, nil)
+		rr := httptest.NewRecorder()
+		handleAPIConnections(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, got %d", http.StatusBadRequest, query, rr.Code)
+		}
+	}
+}
+
+// singleConnListener returns one connection and fails afterwards
+type singleConnListener struct {
+	mockListener
+	conn *mockConn
+	used bool
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	if l.used {
+		return nil, fmt.Errorf("listener exhausted")
+	}
+	l.used = true
+	return l.conn, nil
+}
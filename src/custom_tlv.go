@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TLV types available for application specific use (PP2_TYPE_MIN_CUSTOM - PP2_TYPE_MAX_CUSTOM)
+const (
+	PP2TypeMinCustom = 0xE0
+	PP2TypeMaxCustom = 0xEF
+)
+
+// Custom TLV decodings
+const (
+	TLVDecodingString = "string" // UTF-8 text
+	TLVDecodingUint   = "uint"   // big endian unsigned integer of 1 to 8 bytes
+	TLVDecodingHex    = "hex"
+	TLVDecodingBase64 = "base64"
+)
+
+// CustomTLV maps an application TLV to a name, a decoding and optionally a request header
+type CustomTLV struct {
+	Type     int    `json:"type"`             // 0xE0 - 0xEF
+	Name     string `json:"name"`             // e.g. tenant_id
+	Decoding string `json:"decoding"`         // string (default), uint, hex or base64
+	Header   string `json:"header,omitempty"` // request header set to the decoded value
+}
+
+// CustomTLVsResponse is returned by the custom TLV API
+type CustomTLVsResponse struct {
+	TLVs      []CustomTLV `json:"tlvs"`
+	Decodings []string    `json:"decodings"`
+}
+
+// CustomTLVsRequest replaces the custom TLV mappings
+type CustomTLVsRequest struct {
+	TLVs []CustomTLV `json:"tlvs"`
+}
+
+// DecodedTLV is a custom TLV of a connection with its decoded value
+type DecodedTLV struct {
+	CustomTLV
+	Value string
+}
+
+// customTLVName matches the names of custom TLVs
+var customTLVName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// normalize fills in defaults and validates the mapping
+func (c *CustomTLV) normalize() error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Header = strings.TrimSpace(c.Header)
+
+	if c.Type < PP2TypeMinCustom || c.Type > PP2TypeMaxCustom {
+		return fmt.Errorf("type 0x%02X is outside the custom range 0xE0-0xEF", c.Type)
+	}
+	if !customTLVName.MatchString(c.Name) {
+		return fmt.Errorf("invalid name %q for TLV 0x%02X: use lowercase letters, digits and _", c.Name, c.Type)
+	}
+	switch c.Decoding {
+	case "":
+		c.Decoding = TLVDecodingString
+	case TLVDecodingString, TLVDecodingUint, TLVDecodingHex, TLVDecodingBase64:
+	default:
+		return fmt.Errorf("unknown decoding %q for TLV %s: use string, uint, hex or base64", c.Decoding, c.Name)
+	}
+	if c.Header != "" && !isHeaderToken(c.Header) {
+		return fmt.Errorf("invalid header name %q for TLV %s", c.Header, c.Name)
+	}
+	return nil
+}
+
+// decode renders a TLV value, reporting false when it does not fit the decoding
+func (c *CustomTLV) decode(value []byte) (string, bool) {
+	switch c.Decoding {
+	case TLVDecodingUint:
+		if len(value) == 0 || len(value) > 8 {
+			return "", false
+		}
+		var n uint64
+		for _, b := range value {
+			n = n<<8 | uint64(b)
+		}
+		return strconv.FormatUint(n, 10), true
+	case TLVDecodingHex:
+		return hex.EncodeToString(value), len(value) > 0
+	case TLVDecodingBase64:
+		return base64.StdEncoding.EncodeToString(value), len(value) > 0
+	default:
+		// Header values must not carry control characters
+		text := string(value)
+		if text == "" || !utf8.ValidString(text) || strings.IndexFunc(text, unicode.IsControl) >= 0 {
+			return "", false
+		}
+		return text, true
+	}
+}
+
+// normalizeCustomTLVs validates a list of mappings, rejecting duplicate types, names and headers
+func normalizeCustomTLVs(tlvs []CustomTLV) ([]CustomTLV, error) {
+	result := make([]CustomTLV, 0, len(tlvs))
+	types := make(map[int]bool)
+	names := make(map[string]bool)
+	headers := make(map[string]bool)
+	for i := range tlvs {
+		tlv := tlvs[i]
+		if err := tlv.normalize(); err != nil {
+			return nil, fmt.Errorf("TLV %d: %w", i+1, err)
+		}
+		if types[tlv.Type] {
+			return nil, fmt.Errorf("TLV %d: type 0x%02X is mapped twice", i+1, tlv.Type)
+		}
+		if names[tlv.Name] {
+			return nil, fmt.Errorf("TLV %d: name %s is used twice", i+1, tlv.Name)
+		}
+		if key := http.CanonicalHeaderKey(tlv.Header); tlv.Header != "" {
+			if headers[key] {
+				return nil, fmt.Errorf("TLV %d: header %s is used twice", i+1, tlv.Header)
+			}
+			headers[key] = true
+		}
+		types[tlv.Type] = true
+		names[tlv.Name] = true
+		result = append(result, tlv)
+	}
+	return result, nil
+}
+
+// checkCustomTLVHeaders rejects custom TLV headers that are also client
+// headers, the TLV value would overwrite the client header
+func checkCustomTLVHeaders(tlvs []CustomTLV, headers []ClientHeader) error {
+	clientHeaders := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		clientHeaders[http.CanonicalHeaderKey(header.Name)] = true
+	}
+	for _, tlv := range tlvs {
+		if tlv.Header != "" && clientHeaders[http.CanonicalHeaderKey(tlv.Header)] {
+			return fmt.Errorf("header %s of TLV %s is already a client header", tlv.Header, tlv.Name)
+		}
+	}
+	return nil
+}
+
+// isCustomTLV reports whether a TLV type is in the mappings
+func isCustomTLV(tlvType byte, mappings []CustomTLV) bool {
+	for _, mapping := range mappings {
+		if mapping.Type == int(tlvType) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentCustomTLVs returns a copy of the configured mappings
+func currentCustomTLVs() []CustomTLV {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return append([]CustomTLV{}, config.CustomTLVs...)
+}
+
+// decodeCustomTLVs decodes the mapped TLVs of a connection. Only the first
+// TLV of each type is used, values that do not fit the decoding are skipped.
+func decodeCustomTLVs(info *ProxyProtocolInfo, mappings []CustomTLV) []DecodedTLV {
+	var decoded []DecodedTLV
+	for _, mapping := range mappings {
+		raw, ok := info.TLV(byte(mapping.Type))
+		if !ok {
+			continue
+		}
+		if value, ok := mapping.decode(raw); ok {
+			decoded = append(decoded, DecodedTLV{CustomTLV: mapping, Value: value})
+		}
+	}
+	return decoded
+}
+
+// applyCustomTLVHeaders sets the request headers of the mapped TLVs and
+// returns the decoded values
+func applyCustomTLVHeaders(dst http.Header, info *ProxyProtocolInfo, mappings []CustomTLV) []DecodedTLV {
+	decoded := decodeCustomTLVs(info, mappings)
+	for _, tlv := range decoded {
+		if tlv.Header != "" {
+			dst.Set(tlv.Header, tlv.Value)
+		}
+	}
+	return decoded
+}
+
+// handleAPICustomTLVs lists (GET) or replaces (POST) the custom TLV mappings
+func handleAPICustomTLVs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, customTLVsResponse())
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var req CustomTLVsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		tlvs, err := normalizeCustomTLVs(req.TLVs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config.mu.Lock()
+		if err := checkCustomTLVHeaders(tlvs, config.ClientHeaders); err != nil {
+			config.mu.Unlock()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config.CustomTLVs = tlvs
+		config.mu.Unlock()
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		apiLog.Info("Custom TLVs updated", "tlvs", len(tlvs))
+		events.record(EventSourcePlugin, "customTLVsUpdated", fmt.Sprintf("Custom TLVs updated: %d mapping(s)", len(tlvs)))
+		writeJSON(w, customTLVsResponse())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func customTLVsResponse() CustomTLVsResponse {
+	return CustomTLVsResponse{
+		TLVs:      currentCustomTLVs(),
+		Decodings: []string{TLVDecodingString, TLVDecodingUint, TLVDecodingHex, TLVDecodingBase64},
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test validation of custom TLV mappings
+func TestNormalizeCustomTLVs(t *testing.T) {
+	tlvs, err := normalizeCustomTLVs([]CustomTLV{{Type: 0xE1, Name: "tenant_id", Header: " X-Tenant-ID "}})
+	if err != nil {
+		t.Fatalf("Expected a valid mapping, got %v", err)
+	}
+	if tlvs[0].Decoding != TLVDecodingString || tlvs[0].Header != "X-Tenant-ID" {
+		t.Errorf("Expected defaults to be filled in, got %+v", tlvs[0])
+	}
+
+	invalid := map[string][]CustomTLV{
+		"type below range": {{Type: 0x05, Name: "unique"}},
+		"type above range": {{Type: 0xF0, Name: "other"}},
+		"invalid name":     {{Type: 0xE1, Name: "Tenant ID"}},
+		"unknown decoding": {{Type: 0xE1, Name: "tenant", Decoding: "json"}},
+		"invalid header":   {{Type: 0xE1, Name: "tenant", Header: "X Tenant"}},
+		"duplicate type":   {{Type: 0xE1, Name: "a"}, {Type: 0xE1, Name: "b"}},
+		"duplicate name":   {{Type: 0xE1, Name: "a"}, {Type: 0xE2, Name: "a"}},
+		"duplicate header": {{Type: 0xE1, Name: "a", Header: "X-A"}, {Type: 0xE2, Name: "b", Header: "x-a"}},
+	}
+	for name, tlvs := range invalid {
+		if _, err := normalizeCustomTLVs(tlvs); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+// Test the custom TLV decodings
+func TestCustomTLVDecode(t *testing.T) {
+	tests := []struct {
+		decoding string
+		value    []byte
+		expected string
+		ok       bool
+	}{
+		{TLVDecodingString, []byte("eu-central-1"), "eu-central-1", true},
+		{TLVDecodingString, []byte("a\r\nb"), "", false},
+		{TLVDecodingString, []byte{0xff, 0xfe}, "", false},
+		{TLVDecodingString, nil, "", false},
+		{TLVDecodingUint, []byte{0x01, 0x00}, "256", true},
+		{TLVDecodingUint, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "18446744073709551615", true},
+		{TLVDecodingUint, make([]byte, 9), "", false},
+		{TLVDecodingHex, []byte{0xde, 0xad}, "dead", true},
+		{TLVDecodingBase64, []byte("hi"), "aGk=", true},
+	}
+	for _, tt := range tests {
+		tlv := CustomTLV{Type: 0xE1, Name: "test", Decoding: tt.decoding}
+		got, ok := tlv.decode(tt.value)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("Expected %s of %x to be %q (%v), got %q (%v)", tt.decoding, tt.value, tt.expected, tt.ok, got, ok)
+		}
+	}
+}
+
+// Test that the ingress sets the headers of mapped TLVs and strips spoofed ones
+func TestIngressCustomTLVs(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.CustomTLVs = []CustomTLV{
+			{Type: 0xE1, Name: "tenant_id", Decoding: TLVDecodingUint, Header: "X-Tenant-ID"},
+			{Type: 0xE2, Name: "region", Header: "X-Region"},
+			{Type: 0xE3, Name: "unused"},
+		}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	header, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+		SourceAddr: "192.0.2.90", SourcePort: 42000, DestAddr: "198.51.100.90", DestPort: 443,
+		TLVs: []ProxyProtocolTLV{
+			{Type: 0xE1, Value: []byte{0x00, 0x2a}},
+			{Type: 0xE3, Value: []byte("x")},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", INGRESS_PATH+"/", bytes.NewReader(append(header, "GET / HTTP/1.1\r\n\r\n"...)))
+	req.Header.Set("X-Zoraxy-RequestID", "custom-tlv-1")
+	req.Header.Set("X-Region", "spoofed")
+	rr := serveIngress(t, req, "example.com")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("X-Tenant-ID"); got != "42" {
+		t.Errorf("Expected X-Tenant-ID 42, got %q", got)
+	}
+	if _, stripped := sanitizeClientHeaders(req.Header, &ProxyProtocolInfo{SourceAddr: "192.0.2.90"}); len(stripped) != 1 || stripped[0] != "X-Region" {
+		t.Errorf("Expected X-Region of an untrusted peer to be stripped, got %v", stripped)
+	}
+
+	entries := inspector.list("")
+	if len(entries) == 0 || len(entries[0].TLVs) != 2 {
+		t.Fatalf("Expected the header in the inspector, got %+v", entries)
+	}
+	if tlv := entries[0].TLVs[0]; tlv.Name != "tenant_id" || tlv.Decoded != "42" {
+		t.Errorf("Expected the inspector to name and decode the TLV, got %+v", tlv)
+	}
+	connections.remove(ingressConnectionID(&ProxyProtocolInfo{SourceAddr: "192.0.2.90", SourcePort: 42000, DestAddr: "198.51.100.90", DestPort: 443}))
+}
+
+// Test the custom TLV API
+func TestHandleAPICustomTLVs(t *testing.T) {
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+	defer func() { configPath = oldPath }()
+	setConfig(t, func(c *PluginConfig) { c.CustomTLVs = nil })
+	token := issueTestCSRFToken(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/ui/api/tlvs", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", token)
+		rr := httptest.NewRecorder()
+		handleAPICustomTLVs(rr, req)
+		return rr
+	}
+
+	if rr := post(`{"tlvs":[{"type":1,"name":"alpn"}]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for a standard type, got %d", rr.Code)
+	}
+	setConfig(t, func(c *PluginConfig) { c.ClientHeaders = []ClientHeader{{Name: "X-Real-IP", Value: "{client_addr}"}} })
+	if rr := post(`{"tlvs":[{"type":225,"name":"tenant_id","header":"x-real-ip"}]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for a client header, got %d", rr.Code)
+	}
+
+	rr := post(`{"tlvs":[{"type":225,"name":"tenant_id","decoding":"uint","header":"X-Tenant-ID"}]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response CustomTLVsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.TLVs) != 1 || len(response.Decodings) != 4 {
+		t.Errorf("Expected the mapping and decodings, got %+v", response)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"tenant_id"`) {
+		t.Errorf("Expected the mapping to be persisted, got %s", data)
+	}
+	setConfig(t, func(c *PluginConfig) { c.CustomTLVs = nil })
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if tlvs := currentCustomTLVs(); len(tlvs) != 1 || tlvs[0].Header != "X-Tenant-ID" {
+		t.Errorf("Expected the persisted mapping to be restored, got %+v", tlvs)
+	}
+
+	// Client headers must not take over a TLV header either
+	req := httptest.NewRequest("POST", "/ui/api/headers", strings.NewReader(`{"headers":[{"name":"X-Tenant-ID","value":"{client_addr}","enabled":true}]}`))
+	req.Header.Set("X-CSRF-Token", token)
+	rr = httptest.NewRecorder()
+	handleAPIClientHeaders(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for a TLV header, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxDecodeInputBytes limits the request body of the decode API
+	maxDecodeInputBytes = 64 * 1024
+	// maxDecodePreviewBytes limits the payload preview in decode responses
+	maxDecodePreviewBytes = 64
+)
+
+// Input encodings accepted by the decode API
+const (
+	DecodeEncodingHex    = "hex"
+	DecodeEncodingBase64 = "base64"
+	DecodeEncodingRaw    = "raw"
+)
+
+// DecodeRequest holds captured bytes to decode
+type DecodeRequest struct {
+	Data     string `json:"data"`
+	Encoding string `json:"encoding"` // hex (default), base64 or raw
+}
+
+// DecodeResponse is the full decoding of the submitted bytes
+type DecodeResponse struct {
+	InputLength     int            `json:"input_length"`
+	Outcome         string         `json:"outcome"`
+	Error           string         `json:"error,omitempty"`
+	ErrorReason     string         `json:"error_reason,omitempty"`
+	ErrorOffset     *int           `json:"error_offset,omitempty"`
+	SignatureOffset *int           `json:"signature_offset,omitempty"`
+	HeaderLength    int            `json:"header_length,omitempty"`
+	HeaderHex       string         `json:"header_hex,omitempty"`
+	Version         int            `json:"version,omitempty"`
+	Command         string         `json:"command,omitempty"`
+	Family          string         `json:"family,omitempty"`
+	TransportProto  string         `json:"transport_proto,omitempty"`
+	SourceAddr      string         `json:"source_addr,omitempty"`
+	SourcePort      int            `json:"source_port,omitempty"`
+	DestAddr        string         `json:"dest_addr,omitempty"`
+	DestPort        int            `json:"dest_port,omitempty"`
+	TLVs            []InspectedTLV `json:"tlvs,omitempty"`
+	PayloadLength   int            `json:"payload_length"`
+	Payload         string         `json:"payload"`
+	PayloadHex      string         `json:"payload_hex,omitempty"`
+}
+
+// hexDumpOffset matches the offset column of tcpdump -x/-X and xxd output
+var hexDumpOffset = regexp.MustCompile(`^(0x)?[0-9a-fA-F]+:\s`)
+
+// parseDecodeInput converts the submitted data to bytes
+func parseDecodeInput(data, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", DecodeEncodingHex:
+		return parseHexInput(data)
+	case DecodeEncodingBase64:
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %w", err)
+		}
+		return decoded, nil
+	case DecodeEncodingRaw:
+		return []byte(data), nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q: use hex, base64 or raw", encoding)
+	}
+}
+
+// parseHexInput accepts plain hex ("0d0a 0d0a", "0d:0a", "\x0d\x0a") as well as
+// tcpdump -x/-X, xxd and hexdump -C output, ignoring offsets and the ASCII column
+func parseHexInput(data string) ([]byte, error) {
+	var digits strings.Builder
+	cleaner := strings.NewReplacer("\\x", "", "0x", "", ":", "", " ", "", "\t", "")
+	hexdump := false
+	for number, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if bar := strings.Index(line, "|"); bar >= 0 && strings.HasSuffix(line, "|") {
+			// hexdump -C: offset, hex bytes, |ASCII|
+			hexdump = true
+			if fields := strings.Fields(line[:bar]); len(fields) > 0 {
+				line = strings.Join(fields[1:], "")
+			}
+		} else if hexdump && !strings.Contains(line, " ") {
+			// hexdump -C ends with the total length
+			continue
+		} else if offset := hexDumpOffset.FindString(line); offset != "" {
+			// tcpdump and xxd: offset, hex groups and the ASCII column after two spaces
+			line = strings.TrimSpace(line[len(offset):])
+			if cut := strings.Index(line, "  "); cut >= 0 {
+				line = line[:cut]
+			}
+		}
+
+		cleaned := cleaner.Replace(line)
+		for i, r := range cleaned {
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return nil, fmt.Errorf("invalid hex character %q on line %d, position %d", r, number+1, i+1)
+			}
+		}
+		digits.WriteString(cleaned)
+	}
+
+	if digits.Len()%2 != 0 {
+		return nil, fmt.Errorf("odd number of hex digits (%d)", digits.Len())
+	}
+	return hex.DecodeString(digits.String())
+}
+
+// decodeProxyProtocolData decodes data the way the capture ingress would
+func decodeProxyProtocolData(data []byte) DecodeResponse {
+	response := DecodeResponse{InputLength: len(data), Outcome: InspectorOutcomeNoHeader}
+
+	remaining, info, err := processProxyProtocolData(data)
+	switch {
+	case err != nil:
+		response.Outcome = InspectorOutcomeError
+		response.Error = err.Error()
+		response.ErrorReason = parseErrorReason(err)
+		offset, ok := errorOffset(err)
+		if !ok && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+			// The data ended inside the header
+			offset, ok = len(data), true
+		}
+		if ok {
+			response.ErrorOffset = &offset
+		}
+		return response
+
+	case info == nil:
+		// Point at a header further in, e.g. after IP/TCP headers of a packet capture
+		if offset := signatureOffset(data); offset > 0 {
+			response.SignatureOffset = &offset
+		}
+
+	default:
+		response.Outcome = InspectorOutcomeParsed
+		response.HeaderLength = info.HeaderLength
+		response.HeaderHex = hex.EncodeToString(data[:min(info.HeaderLength, len(data))])
+		response.Version = info.Version
+		response.Command = info.Command
+		response.Family = addressFamily(info.TransportProto)
+		response.TransportProto = info.TransportProto
+		response.SourceAddr = info.SourceAddr
+		response.SourcePort = info.SourcePort
+		response.DestAddr = info.DestAddr
+		response.DestPort = info.DestPort
+		response.TLVs = inspectedTLVs(info.TLVs)
+	}
+
+	response.PayloadLength = len(remaining)
+	response.Payload = classifyPayload(remaining)
+	response.PayloadHex = hex.EncodeToString(remaining[:min(len(remaining), maxDecodePreviewBytes)])
+	return response
+}
+
+// signatureOffset returns the offset of the first v1 or v2 signature, -1 if there is none
+func signatureOffset(data []byte) int {
+	offset := -1
+	for _, signature := range []string{ProxyProtocolV1Prefix, ProxyProtocolV2Prefix} {
+		if index := bytes.Index(data, []byte(signature)); index >= 0 && (offset < 0 || index < offset) {
+			offset = index
+		}
+	}
+	return offset
+}
+
+// handleAPIDecode decodes submitted bytes without touching live traffic.
+// The body is a DecodeRequest, or the raw bytes with Content-Type application/octet-stream.
+func handleAPIDecode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireCSRFToken(w, r) {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDecodeInputBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Request body too large (max %d bytes)", maxDecodeInputBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	data := body
+	if r.Header.Get("Content-Type") != "application/octet-stream" {
+		var req DecodeRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if data, err = parseDecodeInput(req.Data, req.Encoding); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	response := decodeProxyProtocolData(data)
+	apiLog.Debug("Decoded submitted header", "bytes", len(data), "outcome", response.Outcome, "error", response.Error)
+	writeJSON(w, response)
+}
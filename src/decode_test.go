@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const decodeTestV1Header = "PROXY TCP4 192.0.2.1 198.51.100.1 1000 443\r\n"
+
+// Test the hex formats accepted by the decoder
+func TestParseHexInput(t *testing.T) {
+	formats := map[string]string{
+		"plain":  "50524f5859205443503420313932 2e302e322e3120 3139382e35312e3130302e3120313030302034343 30d0a",
+		"escape": `\x50\x52\x4f\x58\x59\x20\x54\x43\x50\x34\x20\x31\x39\x32\x2e\x30\x2e\x32\x2e\x31\x20\x31\x39\x38\x2e\x35\x31\x2e\x31\x30\x30\x2e\x31\x20\x31\x30\x30\x30\x20\x34\x34\x33\x0d\x0a`,
+		"tcpdump": "\t0x0000:  5052 4f58 5920 5443 5034 2031 3932 2e30  PROXY TCP4 192.0\n" +
+			"\t0x0010:  2e32 2e31 2031 3938 2e35 312e 3130 302e  .2.1 198.51.100.\n" +
+			"\t0x0020:  3120 3130 3030 2034 3433 0d0a            1 1000 443..\n",
+		"hexdump": "00000000  50 52 4f 58 59 20 54 43  50 34 20 31 39 32 2e 30  |PROXY TCP4 192.0|\n" +
+			"00000010  2e 32 2e 31 20 31 39 38  2e 35 31 2e 31 30 30 2e  |.2.1 198.51.100.|\n" +
+			"00000020  31 20 31 30 30 30 20 34  34 33 0d 0a              |1 1000 443..|\n" +
+			"0000002c\n",
+	}
+	for name, input := range formats {
+		t.Run(name, func(t *testing.T) {
+			data, err := parseHexInput(input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(data) != decodeTestV1Header {
+				t.Errorf("Expected %q, got %q", decodeTestV1Header, data)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"0d0a0", "0d0g"} {
+		if _, err := parseHexInput(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+// Test decoding reports header fields, payload and error offsets
+func TestDecodeProxyProtocolData(t *testing.T) {
+	v2 := append([]byte(ProxyProtocolV2Prefix), 0x21, 0x11, 0x00, 0x11,
+		192, 0, 2, 100, 198, 51, 100, 50, 0xB2, 0x6E, 0x01, 0xBB,
+		0x01, 0x00, 0x02, 'h', '2')
+
+	t.Run("v2 with TLVs", func(t *testing.T) {
+		response := decodeProxyProtocolData(append(append([]byte{}, v2...), "GET / HTTP/1.1\r\n"...))
+		if response.Outcome != InspectorOutcomeParsed || response.Version != 2 || response.Family != "inet" {
+			t.Errorf("Unexpected response %+v", response)
+		}
+		if response.SourceAddr != "192.0.2.100" || response.SourcePort != 45678 || response.DestPort != 443 {
+			t.Errorf("Unexpected addresses %+v", response)
+		}
+		if response.HeaderLength != len(v2) || len(response.TLVs) != 1 || response.TLVs[0].Name != "ALPN" {
+			t.Errorf("Unexpected header %+v", response)
+		}
+		if response.Payload != "http" || response.PayloadLength != 16 {
+			t.Errorf("Expected 16 bytes of http payload, got %d bytes of %s", response.PayloadLength, response.Payload)
+		}
+	})
+
+	errorCases := []struct {
+		name   string
+		data   []byte
+		reason string
+		offset int
+	}{
+		{"invalid version", append([]byte(ProxyProtocolV2Prefix), 0x31, 0x11, 0x00, 0x00), ParseErrorInvalidVersion, 12},
+		{"unsupported family", append([]byte(ProxyProtocolV2Prefix), 0x21, 0x31, 0x00, 0x00), ParseErrorUnsupportedFamily, 13},
+		{"address too short", append([]byte(ProxyProtocolV2Prefix), 0x21, 0x11, 0x00, 0x04, 1, 2, 3, 4), ParseErrorAddressTooShort, 14},
+		{"truncated", v2[:20], ParseErrorTruncated, 20},
+		{"truncated TLV value", append(append([]byte{}, v2[:len(v2)-2]...), 'h'), ParseErrorTruncated, len(v2) - 1},
+		{"TLV past header end", func() []byte {
+			data := append([]byte{}, v2...)
+			data[len(data)-3] = 0x05
+			return data
+		}(), ParseErrorMalformedTLV, 28},
+		{"short v1", []byte("PROXY TCP4 192.0.2.1\r\n"), ParseErrorInvalidV1Header, 20},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := decodeProxyProtocolData(tc.data)
+			if response.Outcome != InspectorOutcomeError || response.ErrorReason != tc.reason {
+				t.Errorf("Expected %s error, got %+v", tc.reason, response)
+			}
+			if response.ErrorOffset == nil || *response.ErrorOffset != tc.offset {
+				t.Errorf("Expected error offset %d, got %v (%s)", tc.offset, response.ErrorOffset, response.Error)
+			}
+		})
+	}
+
+	t.Run("signature further in", func(t *testing.T) {
+		response := decodeProxyProtocolData(append([]byte{0x45, 0x00, 0x00, 0x34}, decodeTestV1Header...))
+		if response.Outcome != InspectorOutcomeNoHeader || response.SignatureOffset == nil || *response.SignatureOffset != 4 {
+			t.Errorf("Expected signature at offset 4, got %+v", response)
+		}
+		if response.Payload != "http" || response.PayloadLength != 4+len(decodeTestV1Header) {
+			t.Errorf("Expected the whole input as payload, got %+v", response)
+		}
+	})
+}
+
+// Test the decode API
+func TestDecodeAPI(t *testing.T) {
+	post := func(contentType, body string, token bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, UI_PATH+"/api/decode", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if token {
+			req.Header.Set("X-CSRF-Token", issueTestCSRFToken(t))
+		}
+		rr := httptest.NewRecorder()
+		handleAPIDecode(rr, req)
+		return rr
+	}
+	decode := func(t *testing.T, rr *httptest.ResponseRecorder) DecodeResponse {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var response DecodeResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return response
+	}
+
+	t.Run("Missing CSRF Token", func(t *testing.T) {
+		if rr := post("application/json", `{}`, false); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("base64", func(t *testing.T) {
+		body := `{"encoding": "base64", "data": "` + base64.StdEncoding.EncodeToString([]byte(decodeTestV1Header)) + `"}`
+		if response := decode(t, post("application/json", body, true)); response.Outcome != InspectorOutcomeParsed || response.SourceAddr != "192.0.2.1" {
+			t.Errorf("Unexpected response %+v", response)
+		}
+	})
+
+	t.Run("raw body", func(t *testing.T) {
+		if response := decode(t, post("application/octet-stream", decodeTestV1Header+"\x16\x03\x01", true)); response.Payload != "tls" {
+			t.Errorf("Expected tls payload, got %+v", response)
+		}
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		for _, body := range []string{`{"data": "zz"}`, `{"encoding": "rot13", "data": ""}`, `not json`} {
+			if rr := post("application/json", body, true); rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %d for %s, got %d", http.StatusBadRequest, body, rr.Code)
+			}
+		}
+	})
+}
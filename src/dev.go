@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// defaultDevStepInterval is the delay between scenario steps
+const defaultDevStepInterval = time.Second
+
+// devWebRoots are tried in order when -www is not given
+var devWebRoots = []string{"www", filepath.Join("src", "www")}
+
+// DevScenario is a list of requests the mock Zoraxy host sends to the plugin
+type DevScenario struct {
+	Interval string            `json:"interval"` // delay between steps, e.g. "500ms" (default 1s)
+	Repeat   bool              `json:"repeat"`   // replay the steps until the plugin stops
+	Steps    []DevScenarioStep `json:"steps"`
+
+	// Load balancers whose requests are captured, used with the in-memory settings
+	TrustedUpstreams []string `json:"trusted_upstreams"`
+
+	interval         time.Duration
+	trustedUpstreams []netip.Prefix
+}
+
+// DevScenarioStep is a single request, sniffed and, when captured, sent to the ingress
+type DevScenarioStep struct {
+	Name       string `json:"name"`
+	Hostname   string `json:"hostname"`
+	Method     string `json:"method"`      // default GET
+	URI        string `json:"uri"`         // default /
+	RemoteAddr string `json:"remote_addr"` // peer as seen by Zoraxy, default 127.0.0.1:40000
+	Data       string `json:"data"`        // connection bytes, including any PROXY header
+	DataHex    string `json:"data_hex"`    // connection bytes as hex, instead of data
+	Expect     int    `json:"expect"`      // expected sniff status (280, 284 or 580), optional
+
+	data []byte
+}
+
+// DevStepResult is what the plugin answered to a scenario step
+type DevStepResult struct {
+	Step          string
+	Sniff         plugin.ControlStatusCode
+	IngressStatus int    // 0 when the request was not captured
+	ClientAddr    string // X-Proxy-Protocol-Source of the ingress response
+	Body          []byte // ingress response body
+}
+
+// loadDevScenario reads and validates a scenario file
+func loadDevScenario(path string) (*DevScenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenario DevScenario
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+
+	scenario.interval = defaultDevStepInterval
+	if scenario.Interval != "" {
+		if scenario.interval, err = time.ParseDuration(scenario.Interval); err != nil || scenario.interval < 0 {
+			return nil, fmt.Errorf("invalid scenario interval %q", scenario.Interval)
+		}
+	}
+	if len(scenario.Steps) == 0 {
+		return nil, fmt.Errorf("scenario %s has no steps", path)
+	}
+	if scenario.trustedUpstreams, err = parsePrefixes(scenario.TrustedUpstreams); err != nil {
+		return nil, fmt.Errorf("invalid scenario trusted_upstreams: %w", err)
+	}
+
+	for i := range scenario.Steps {
+		step := &scenario.Steps[i]
+		if step.Name == "" {
+			step.Name = "step " + strconv.Itoa(i+1)
+		}
+		if step.Method == "" {
+			step.Method = http.MethodGet
+		}
+		if step.URI == "" {
+			step.URI = "/"
+		}
+		if step.RemoteAddr == "" {
+			step.RemoteAddr = "127.0.0.1:40000"
+		}
+		step.data = []byte(step.Data)
+		if step.DataHex != "" {
+			if step.data, err = parseHexInput(step.DataHex); err != nil {
+				return nil, fmt.Errorf("%s: invalid data_hex: %w", step.Name, err)
+			}
+		}
+	}
+	return &scenario, nil
+}
+
+// mockZoraxy sends requests to the plugin the way Zoraxy's dynamic router does.
+// It has its own client, plugintest is only for tests and would link the testing package.
+type mockZoraxy struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newMockZoraxy(baseURL string) *mockZoraxy {
+	return &mockZoraxy{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		// Zoraxy does not follow redirects of the plugin either
+		client: &http.Client{
+			Timeout:       10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// send sniffs the step and, when the plugin captures it, forwards the data to the ingress
+func (z *mockZoraxy) send(step DevScenarioStep) (DevStepResult, error) {
+	result := DevStepResult{Step: step.Name}
+	requestID := newDevRequestID()
+
+	// Zoraxy hands the plugin the request as received, with an origin-form URI
+	req, err := http.NewRequest(step.Method, step.URI, nil)
+	if err != nil {
+		return result, err
+	}
+	req.Host = step.Hostname
+	req.RequestURI = step.URI
+	req.RemoteAddr = step.RemoteAddr
+
+	payload, err := json.Marshal(plugin.EncodeForwardRequestPayload(req))
+	if err != nil {
+		return result, err
+	}
+	sniff, err := z.do(http.MethodPost, SNIFF_PATH, requestID, payload)
+	if err != nil {
+		return result, fmt.Errorf("sniff: %w", err)
+	}
+	result.Sniff = plugin.ControlStatusCode(sniff.StatusCode)
+	switch result.Sniff {
+	case plugin.ControlStatusCode_CAPTURED:
+	case plugin.ControlStatusCode_UNHANDLED, plugin.ControlStatusCode_ERROR:
+		return result, nil
+	default:
+		return result, fmt.Errorf("sniff: unexpected status %d: %s", sniff.StatusCode, bytes.TrimSpace(sniff.body))
+	}
+
+	ingress, err := z.do(step.Method, strings.TrimSuffix(INGRESS_PATH, "/")+req.URL.RequestURI(), requestID, step.data)
+	if err != nil {
+		return result, fmt.Errorf("ingress: %w", err)
+	}
+	result.IngressStatus = ingress.StatusCode
+	result.ClientAddr = ingress.Header.Get("X-Proxy-Protocol-Source")
+	result.Body = ingress.body
+	return result, nil
+}
+
+// mockResponse is a plugin response with its body read
+type mockResponse struct {
+	*http.Response
+	body []byte
+}
+
+func (z *mockZoraxy) do(method, path, requestID string, body []byte) (*mockResponse, error) {
+	req, err := http.NewRequest(method, z.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Zoraxy-RequestID", requestID)
+
+	resp, err := z.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return &mockResponse{Response: resp, body: data}, err
+}
+
+// replay sends the scenario steps until it is done or stop is closed
+func (z *mockZoraxy) replay(scenario *DevScenario, stop <-chan struct{}) {
+	for {
+		for _, step := range scenario.Steps {
+			select {
+			case <-stop:
+				return
+			case <-time.After(scenario.interval):
+			}
+			logDevStep(step, z.send)
+		}
+		if !scenario.Repeat {
+			devLog.Info("Scenario finished")
+			return
+		}
+	}
+}
+
+// logDevStep sends a step and logs the outcome
+func logDevStep(step DevScenarioStep, send func(DevScenarioStep) (DevStepResult, error)) {
+	result, err := send(step)
+	if err != nil {
+		devLog.Error("Scenario step failed", "step", step.Name, "error", err)
+		return
+	}
+
+	attrs := []interface{}{"step", step.Name, "host", step.Hostname, "sniff", controlStatusName(result.Sniff)}
+	if result.IngressStatus != 0 {
+		attrs = append(attrs, "ingress_status", result.IngressStatus, "client", result.ClientAddr,
+			"bytes", len(result.Body), "payload", classifyPayload(result.Body))
+	}
+	if step.Expect != 0 && plugin.ControlStatusCode(step.Expect) != result.Sniff {
+		devLog.Warn("Scenario step did not match the expected sniff status",
+			append(attrs, "expected", controlStatusName(plugin.ControlStatusCode(step.Expect)))...)
+		return
+	}
+	devLog.Info("Scenario step", attrs...)
+}
+
+// newDevRequestID returns a random UUID like the ones Zoraxy assigns to requests
+func newDevRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// newDevCSRFToken returns a random token for the development UI
+func newDevCSRFToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withDevCSRFToken hands a fixed CSRF token to the UI, as Zoraxy would
+func withDevCSRFToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Zoraxy-Csrf") == "" {
+			r.Header.Set("X-Zoraxy-Csrf", token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// findDevWebRoot returns the first directory holding the UI. Absolute directories
+// are made relative, the SDK's debug router reads HTML files relative to the working directory.
+func findDevWebRoot(candidates []string) (string, error) {
+	for _, dir := range candidates {
+		if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+			continue
+		}
+		if filepath.IsAbs(dir) {
+			wd, err := os.Getwd()
+			if err != nil {
+				return "", err
+			}
+			rel, err := filepath.Rel(wd, dir)
+			if err != nil {
+				return "", fmt.Errorf("cannot serve %s from %s: %w", dir, wd, err)
+			}
+			return rel, nil
+		}
+		return dir, nil
+	}
+	return "", fmt.Errorf("no index.html found in %s, use -www", strings.Join(candidates, ", "))
+}
+
+// runDevMode serves the plugin without Zoraxy: the UI is read from disk on
+// every request and an optional scenario is replayed by a mock Zoraxy host
+func runDevMode(args []string) int {
+	flags := flag.NewFlagSet("-dev", flag.ContinueOnError)
+	port := flags.Int("port", 0, "port to listen on, 0 picks a free port")
+	webRoot := flags.String("www", "", "directory with the UI files (default: ./www or ./src/www)")
+	scenarioPath := flags.String("scenario", "", "scenario file replayed by the mock Zoraxy host")
+	configFile := flags.String("config", "", "config file to load and save (default: in-memory settings, plugin enabled, scenario upstreams trusted)")
+	geoipDir := flags.String("geoip", "", "directory with GeoIP databases (default: the directory of -config, otherwise the working directory)")
+	if err := flags.Parse(args); err != nil {
+		return flagErrorCode(err)
+	}
+
+	var err error
+	if *webRoot == "" {
+		*webRoot, err = findDevWebRoot(devWebRoots)
+	} else {
+		_, err = findDevWebRoot([]string{*webRoot})
+	}
+	if err != nil {
+		devLog.Error("Cannot serve the UI", "error", err)
+		return 1
+	}
+
+	var scenario *DevScenario
+	if *scenarioPath != "" {
+		if scenario, err = loadDevScenario(*scenarioPath); err != nil {
+			devLog.Error("Cannot load scenario", "error", err)
+			return 1
+		}
+	}
+
+	configPath = *configFile
+	if err := loadConfig(); err != nil {
+		devLog.Error("Error loading config, using defaults", "path", configPath, "error", err)
+	}
+	if configPath != "" {
+		bansPath = filepath.Join(filepath.Dir(configPath), BANS_FILE)
+		if err := bans.load(); err != nil {
+			devLog.Error("Error loading bans", "path", bansPath, "error", err)
+		}
+	}
+	if configPath == "" {
+		config.mu.Lock()
+		config.Enabled = true
+		if scenario != nil {
+			config.trustedUpstreams = scenario.trustedUpstreams
+		}
+		config.mu.Unlock()
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(*port)))
+	if err != nil {
+		devLog.Error("Cannot listen", "error", err)
+		return 1
+	}
+	baseURL := "http://" + ln.Addr().String()
+
+	registerRoutes(http.DefaultServeMux)
+	stop := make(chan struct{})
+	connections.startEviction(connectionEvictionInterval, stop)
+	rateLimits.startSweeper(rateLimitSweepInterval, stop)
+	bans.startSweeper(banSweepInterval, stop)
+
+	// Load the GeoIP databases like the plugin does and reload them when they change
+	if *geoipDir == "" {
+		*geoipDir = "."
+		if configPath != "" {
+			*geoipDir = filepath.Dir(configPath)
+		}
+	}
+	geoip.setDir(*geoipDir)
+	geoip.startWatcher(geoipReloadInterval, stop)
+
+	debugRouter := plugin.NewPluginFileSystemUIRouter(PLUGIN_ID, *webRoot, UI_PATH)
+	debugRouter.RegisterTerminateHandler(func() {
+		close(stop)
+		devLog.Info("Development mode terminated")
+	}, nil)
+	http.Handle(UI_PATH+"/", withDevCSRFToken(newDevCSRFToken(), issueCSRFTokens(debugRouter.Handler())))
+
+	devLog.Info("Development mode started", "ui", baseURL+UI_PATH+"/", "www", *webRoot, "config", configPath, "geoip", *geoipDir)
+	if scenario != nil {
+		devLog.Info("Replaying scenario", "path", *scenarioPath, "steps", len(scenario.Steps), "repeat", scenario.Repeat)
+		go newMockZoraxy(baseURL).replay(scenario, stop)
+	}
+
+	if err := servePlugin(ln); err != nil {
+		devLog.Error("Server stopped", "error", err)
+		return 1
+	}
+	return 0
+}
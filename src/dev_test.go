@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// Test the example scenario loads with defaults applied
+func TestLoadDevScenario(t *testing.T) {
+	scenario, err := loadDevScenario("dev-scenario.json")
+	if err != nil {
+		t.Fatalf("Failed to load example scenario: %v", err)
+	}
+	if len(scenario.Steps) != 4 || scenario.interval.String() != "2s" || !scenario.Repeat {
+		t.Errorf("Unexpected scenario %+v", scenario)
+	}
+	if len(scenario.trustedUpstreams) != 1 || scenario.trustedUpstreams[0].String() != "192.0.2.10/32" {
+		t.Errorf("Expected the scenario upstream to be parsed, got %v", scenario.trustedUpstreams)
+	}
+	for _, step := range scenario.Steps {
+		if step.Method != http.MethodGet || len(step.data) == 0 {
+			t.Errorf("Unexpected step %+v", step)
+		}
+	}
+	if !strings.HasSuffix(string(scenario.Steps[1].data), "GET /login HTTP/1.1\r\n\r\n") {
+		t.Errorf("Expected data_hex to be decoded, got %q", scenario.Steps[1].data)
+	}
+
+	invalid := map[string]string{
+		"no steps":     `{"steps": []}`,
+		"bad interval": `{"interval": "soon", "steps": [{"data": "x"}]}`,
+		"bad hex":      `{"steps": [{"data_hex": "0g"}]}`,
+		"bad upstream": `{"trusted_upstreams": ["lb"], "steps": [{"data": "x"}]}`,
+		"bad json":     `{"steps": `,
+	}
+	for name, content := range invalid {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenario.json")
+			os.WriteFile(path, []byte(content), 0o600)
+			if _, err := loadDevScenario(path); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+// Test the mock Zoraxy host drives the sniff and ingress handlers
+func TestMockZoraxy(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	zoraxy := newMockZoraxy(server.URL)
+
+	step := DevScenarioStep{Name: "v1", Hostname: "app.example.com", Method: http.MethodGet, URI: "/",
+		RemoteAddr: "192.0.2.10:41000", data: []byte("PROXY TCP4 203.0.113.7 192.0.2.10 51234 443\r\nGET / HTTP/1.1\r\n\r\n")}
+
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.trustedUpstreams = testPrefixes(t, "192.0.2.10")
+	})
+	result, err := zoraxy.send(step)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Sniff != plugin.ControlStatusCode_CAPTURED || result.IngressStatus != http.StatusOK {
+		t.Errorf("Expected captured request, got %+v", result)
+	}
+	if result.ClientAddr != "203.0.113.7:51234" || string(result.Body) != "GET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("Unexpected ingress result %q %q", result.ClientAddr, result.Body)
+	}
+
+	setConfig(t, func(c *PluginConfig) { c.Enabled, c.HostRules = false, nil })
+	result, err = zoraxy.send(step)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Sniff != plugin.ControlStatusCode_UNHANDLED || result.IngressStatus != 0 {
+		t.Errorf("Expected unhandled request, got %+v", result)
+	}
+}
+
+// Test the development UI hands out a CSRF token the API accepts
+func TestDevUI(t *testing.T) {
+	root, err := findDevWebRoot([]string{"missing", "www"})
+	if err != nil || root != "www" {
+		t.Fatalf("Expected www as web root, got %q (%v)", root, err)
+	}
+	if _, err := findDevWebRoot([]string{"missing"}); err == nil {
+		t.Error("Expected error without index.html")
+	}
+	abs, _ := filepath.Abs("www")
+	if root, err := findDevWebRoot([]string{abs}); err != nil || root != "www" {
+		t.Errorf("Expected %s to be served as www, got %q (%v)", abs, root, err)
+	}
+
+	token := newDevCSRFToken()
+	defer forgetCSRFToken(token)
+	router := plugin.NewPluginFileSystemUIRouter(PLUGIN_ID, root, UI_PATH)
+	handler := withDevCSRFToken(token, issueCSRFTokens(router.Handler()))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, UI_PATH+"/", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), token) {
+		t.Fatalf("Expected the UI with the token, got %d", rr.Code)
+	}
+	if !csrfTokens.valid(token) {
+		t.Error("Expected the development token to be accepted")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// EndpointAccess allows or denies connections by the cloud endpoint they came
+// through. Entries are provider:id (aws:vpce-0123456789abcdef0, azure:305419896,
+// gcp:1234567890), provider:* for every endpoint of a provider, or a bare ID.
+type EndpointAccess struct {
+	Allow []string `json:"allow"` // when set, only these endpoints are accepted
+	Deny  []string `json:"deny"`  // always rejected, checked first
+}
+
+// EndpointAccessResponse is returned by the endpoint access API
+type EndpointAccessResponse struct {
+	EndpointAccess
+	Denied map[string]uint64 `json:"denied"` // denied connections by provider so far
+}
+
+// normalizeEndpointEntry validates an access entry and lowercases the provider
+func normalizeEndpointEntry(entry string) (string, error) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || strings.ContainsAny(entry, " \t,") {
+		return "", fmt.Errorf("invalid endpoint %q", entry)
+	}
+
+	provider, id, found := strings.Cut(entry, ":")
+	if !found {
+		return entry, nil
+	}
+	provider = strings.ToLower(provider)
+	var err error
+	switch {
+	case provider != CloudProviderAWS && provider != CloudProviderAzure && provider != CloudProviderGCP:
+		return "", fmt.Errorf("invalid endpoint %q: unknown provider %q, use aws, azure or gcp", entry, provider)
+	case id == "":
+		return "", fmt.Errorf("invalid endpoint %q: missing ID", entry)
+	case id == "*":
+	case provider == CloudProviderAzure:
+		_, err = strconv.ParseUint(id, 10, 32)
+	case provider == CloudProviderGCP:
+		_, err = strconv.ParseUint(id, 10, 64)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %s IDs are decimal numbers", entry, provider)
+	}
+	return provider + ":" + id, nil
+}
+
+// normalizeEndpointEntries validates a list of entries and drops duplicates
+func normalizeEndpointEntries(entries []string) ([]string, error) {
+	result := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		normalized, err := normalizeEndpointEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			result = append(result, normalized)
+		}
+	}
+	return result, nil
+}
+
+// normalize validates both lists
+func (a *EndpointAccess) normalize() error {
+	var err error
+	if a.Allow, err = normalizeEndpointEntries(a.Allow); err != nil {
+		return err
+	}
+	a.Deny, err = normalizeEndpointEntries(a.Deny)
+	return err
+}
+
+// endpointMatches reports whether the endpoint matches one of the entries
+func endpointMatches(entries []string, endpoint CloudEndpoint) bool {
+	for _, entry := range entries {
+		provider, id, found := strings.Cut(entry, ":")
+		if !found {
+			if entry == endpoint.ID {
+				return true
+			}
+			continue
+		}
+		if provider == endpoint.Provider && (id == "*" || id == endpoint.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows checks a connection against the rules. Connections without a cloud
+// endpoint only pass when no allow list is configured.
+func (a EndpointAccess) allows(info *ProxyProtocolInfo) bool {
+	endpoint, ok := info.CloudEndpoint()
+	if ok && endpointMatches(a.Deny, endpoint) {
+		return false
+	}
+	if len(a.Allow) == 0 {
+		return true
+	}
+	return ok && endpointMatches(a.Allow, endpoint)
+}
+
+// checkEndpointAccess applies the configured rules to a connection and counts denials,
+// also towards a ban of the client
+func checkEndpointAccess(info *ProxyProtocolInfo) bool {
+	config.mu.RLock()
+	access := config.EndpointAccess
+	config.mu.RUnlock()
+
+	if access.allows(info) {
+		return true
+	}
+	provider := "none"
+	if endpoint, ok := info.CloudEndpoint(); ok {
+		provider = endpoint.Provider
+	}
+	metrics.endpointDenied.inc(provider)
+	recordFailure(info.SourceAddr, BanReasonEndpoint)
+	return false
+}
+
+// endpointLabel describes the cloud endpoint of a connection for logs and the registry
+func endpointLabel(info *ProxyProtocolInfo) string {
+	if endpoint, ok := info.CloudEndpoint(); ok {
+		return endpoint.String()
+	}
+	return ""
+}
+
+// currentEndpointAccess returns a copy of the configured rules
+func currentEndpointAccess() EndpointAccess {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return EndpointAccess{
+		Allow: append([]string{}, config.EndpointAccess.Allow...),
+		Deny:  append([]string{}, config.EndpointAccess.Deny...),
+	}
+}
+
+// handleAPIEndpoints returns (GET) or replaces (POST) the endpoint access rules
+func handleAPIEndpoints(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, EndpointAccessResponse{EndpointAccess: currentEndpointAccess(), Denied: metrics.endpointDenied.snapshot()})
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var req EndpointAccess
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := req.normalize(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config.mu.Lock()
+		config.EndpointAccess = req
+		config.mu.Unlock()
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		apiLog.Info("Endpoint access rules updated", "allow", strings.Join(req.Allow, ","), "deny", strings.Join(req.Deny, ","))
+		events.record(EventSourcePlugin, "endpointAccessUpdated",
+			fmt.Sprintf("Endpoint access: %d allowed, %d denied", len(req.Allow), len(req.Deny)))
+		writeJSON(w, EndpointAccessResponse{EndpointAccess: currentEndpointAccess(), Denied: metrics.endpointDenied.snapshot()})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test validation of endpoint access entries
+func TestNormalizeEndpointEntry(t *testing.T) {
+	valid := map[string]string{
+		"AWS:vpce-0abc":  "aws:vpce-0abc",
+		" azure:42 ":     "azure:42",
+		"gcp:*":          "gcp:*",
+		"vpce-0abc":      "vpce-0abc",
+		"gcp:4294967298": "gcp:4294967298",
+	}
+	for entry, expected := range valid {
+		if got, err := normalizeEndpointEntry(entry); err != nil || got != expected {
+			t.Errorf("Expected %q for %q, got %q (%v)", expected, entry, got, err)
+		}
+	}
+
+	for _, entry := range []string{"", "aws:", "oracle:1", "azure:4294967296", "gcp:abc", "vpce-1 vpce-2", "*:1"} {
+		if _, err := normalizeEndpointEntry(entry); err == nil {
+			t.Errorf("Expected an error for %q", entry)
+		}
+	}
+}
+
+// Test allow and deny decisions
+func TestEndpointAccessAllows(t *testing.T) {
+	linkID := uint32(42)
+	aws := &ProxyProtocolInfo{AWSVPCEndpointID: "vpce-0abc"}
+	azure := &ProxyProtocolInfo{AzureLinkID: &linkID}
+	plain := &ProxyProtocolInfo{}
+
+	tests := []struct {
+		name     string
+		access   EndpointAccess
+		info     *ProxyProtocolInfo
+		expected bool
+	}{
+		{"no rules", EndpointAccess{}, aws, true},
+		{"no rules without endpoint", EndpointAccess{}, plain, true},
+		{"denied ID", EndpointAccess{Deny: []string{"aws:vpce-0abc"}}, aws, false},
+		{"denied bare ID", EndpointAccess{Deny: []string{"42"}}, azure, false},
+		{"deny other provider", EndpointAccess{Deny: []string{"azure:*"}}, aws, true},
+		{"deny without endpoint", EndpointAccess{Deny: []string{"aws:*"}}, plain, true},
+		{"allowed ID", EndpointAccess{Allow: []string{"azure:42"}}, azure, true},
+		{"not in allow list", EndpointAccess{Allow: []string{"azure:43"}}, azure, false},
+		{"allow list without endpoint", EndpointAccess{Allow: []string{"aws:*"}}, plain, false},
+		{"deny wins", EndpointAccess{Allow: []string{"aws:*"}, Deny: []string{"vpce-0abc"}}, aws, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.access.normalize(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.access.allows(tt.info); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// Test that the ingress rejects denied endpoints
+func TestIngressEndpointAccess(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.EndpointAccess = EndpointAccess{Deny: []string{"aws:vpce-0bad"}}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	ingress := func(id string, port int, vpce string) *httptest.ResponseRecorder {
+		header, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+			SourceAddr: "10.1.2.3", SourcePort: port, DestAddr: "10.0.0.1", DestPort: 443,
+			TLVs: []ProxyProtocolTLV{{Type: PP2TypeAWS, Value: append([]byte{PP2SubtypeAWSVPCEID}, vpce...)}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", INGRESS_PATH+"/", bytes.NewReader(append(header, "GET / HTTP/1.1\r\n\r\n"...)))
+		req.Header.Set("X-Zoraxy-RequestID", id)
+		return serveIngress(t, req, "example.com")
+	}
+
+	before := metrics.endpointDenied.get(CloudProviderAWS)
+	if rr := ingress("endpoint-1", 40001, "vpce-0bad"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code 403, got %d", rr.Code)
+	}
+	if got := metrics.endpointDenied.get(CloudProviderAWS); got != before+1 {
+		t.Errorf("Expected the denied counter to be %d, got %d", before+1, got)
+	}
+
+	rr := ingress("endpoint-2", 40002, "vpce-0good")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("X-AWS-VPCE-ID"); got != "vpce-0good" {
+		t.Errorf("Expected X-AWS-VPCE-ID vpce-0good, got %q", got)
+	}
+	entries := connections.list(connectionFilter{Source: "10.1.2.3"})
+	if len(entries) != 2 || entries[0].Endpoint != "aws:vpce-0good" {
+		t.Errorf("Expected the endpoints in the registry, got %+v", entries)
+	}
+	for _, entry := range entries {
+		connections.remove(entry.ID)
+	}
+}
+
+// Test the endpoint access API
+func TestHandleAPIEndpoints(t *testing.T) {
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+	defer func() { configPath = oldPath }()
+	setConfig(t, func(c *PluginConfig) { c.EndpointAccess = EndpointAccess{} })
+	token := issueTestCSRFToken(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/ui/api/endpoints", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", token)
+		rr := httptest.NewRecorder()
+		handleAPIEndpoints(rr, req)
+		return rr
+	}
+
+	if rr := post(`{"allow":["azure:not-a-number"]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, got %d", rr.Code)
+	}
+
+	rr := post(`{"allow":["AWS:*","aws:*"],"deny":["vpce-0bad"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response EndpointAccessResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Allow) != 1 || response.Allow[0] != "aws:*" || len(response.Deny) != 1 {
+		t.Errorf("Expected the normalized rules, got %+v", response.EndpointAccess)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"vpce-0bad"`) {
+		t.Errorf("Expected the rules to be persisted, got %s", data)
+	}
+	config.mu.Lock()
+	config.EndpointAccess = EndpointAccess{}
+	config.mu.Unlock()
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if access := currentEndpointAccess(); len(access.Allow) != 1 || len(access.Deny) != 1 {
+		t.Errorf("Expected the persisted rules to be restored, got %+v", access)
+	}
+
+	req := httptest.NewRequest("PUT", "/ui/api/endpoints", nil)
+	rr = httptest.NewRecorder()
+	handleAPIEndpoints(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code 405, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxEventLogEntries bounds the number of events kept in memory
+const maxEventLogEntries = 200
+
+// Event sources
+const (
+	EventSourcePlugin = "plugin"
+	EventSourceZoraxy = "zoraxy"
+)
+
+// PluginEvent is a single entry of the plugin event log
+type PluginEvent struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Name    string    `json:"name"`
+	Message string    `json:"message"`
+}
+
+// EventsResponse is returned by the events API, newest event first
+type EventsResponse struct {
+	Events []PluginEvent `json:"events"`
+}
+
+// eventLog is a bounded in-memory log of notable plugin events
+type eventLog struct {
+	mu     sync.RWMutex
+	events []PluginEvent
+}
+
+var events = &eventLog{}
+
+// record appends an event, dropping the oldest when the log is full
+func (l *eventLog) record(source, name, message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, PluginEvent{
+		Time:    time.Now(),
+		Source:  source,
+		Name:    name,
+		Message: message,
+	})
+	if len(l.events) > maxEventLogEntries {
+		l.events = l.events[len(l.events)-maxEventLogEntries:]
+	}
+}
+
+// list returns the events, newest first
+func (l *eventLog) list() []PluginEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	result := make([]PluginEvent, 0, len(l.events))
+	for i := len(l.events) - 1; i >= 0; i-- {
+		result = append(result, l.events[i])
+	}
+	return result
+}
+
+func handleAPIEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, EventsResponse{Events: events.list()})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Node identifier formats of the RFC 7239 for= and by= parameters
+const (
+	ForwardedNodeNone       = "none"       // leave the parameter out
+	ForwardedNodeIP         = "ip"         // for=192.0.2.1, for="[2001:db8::1]"
+	ForwardedNodeIPPort     = "ip_port"    // for="192.0.2.1:4711", for="[2001:db8::1]:4711"
+	ForwardedNodeObfuscated = "obfuscated" // for=_3f2a9c1b7d04, by=_zoraxy
+)
+
+// defaultForwardedByIdentifier is the obfuscated by= identifier when none is configured
+const defaultForwardedByIdentifier = "_zoraxy"
+
+// ForwardedSettings controls the node identifiers of the generated Forwarded header
+type ForwardedSettings struct {
+	For          string `json:"for"`           // ip (default), ip_port or obfuscated
+	By           string `json:"by"`            // none (default), ip, ip_port or obfuscated
+	ByIdentifier string `json:"by_identifier"` // identifier for by=obfuscated, default _zoraxy
+}
+
+// obfuscatedIdentifier matches the obfnode / obfport syntax of RFC 7239 section 6.3
+var obfuscatedIdentifier = regexp.MustCompile(`^_[A-Za-z0-9._-]+$`)
+
+// forwardedKey keys the obfuscated for= identifiers. It is random per process,
+// so a client keeps its identifier until the plugin restarts.
+var forwardedKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// defaultForwardedSettings sends the client address and leaves out by=
+func defaultForwardedSettings() ForwardedSettings {
+	return ForwardedSettings{For: ForwardedNodeIP, By: ForwardedNodeNone}
+}
+
+// normalize fills in defaults and validates the settings
+func (s *ForwardedSettings) normalize() error {
+	if s.For == "" {
+		s.For = ForwardedNodeIP
+	}
+	if s.By == "" {
+		s.By = ForwardedNodeNone
+	}
+	switch s.For {
+	case ForwardedNodeIP, ForwardedNodeIPPort, ForwardedNodeObfuscated:
+	default:
+		return fmt.Errorf("unknown for= format %q: use ip, ip_port or obfuscated", s.For)
+	}
+	switch s.By {
+	case ForwardedNodeNone, ForwardedNodeIP, ForwardedNodeIPPort, ForwardedNodeObfuscated:
+	default:
+		return fmt.Errorf("unknown by= format %q: use none, ip, ip_port or obfuscated", s.By)
+	}
+	if s.By == ForwardedNodeObfuscated && s.ByIdentifier == "" {
+		s.ByIdentifier = defaultForwardedByIdentifier
+	}
+	if s.ByIdentifier != "" && !obfuscatedIdentifier.MatchString(s.ByIdentifier) {
+		return fmt.Errorf("invalid obfuscated identifier %q: must start with _ followed by letters, digits, '.', '_' or '-'", s.ByIdentifier)
+	}
+	return nil
+}
+
+// forwardedElement builds a single Forwarded element (for=..;by=..;proto=..;host=..)
+// for the connection. It is empty when the client address is unknown.
+func forwardedElement(src *headerSource) string {
+	settings := src.forwarded
+	if settings.For == "" {
+		settings = defaultForwardedSettings()
+	}
+
+	forNode := forwardedNode(settings.For, src.info.SourceAddr, src.info.SourcePort, "")
+	if forNode == "" {
+		return ""
+	}
+	pairs := []string{"for=" + forNode}
+	if byNode := forwardedNode(settings.By, src.info.DestAddr, src.info.DestPort, settings.ByIdentifier); byNode != "" {
+		pairs = append(pairs, "by="+byNode)
+	}
+	if proto := src.proto(); proto != "" {
+		pairs = append(pairs, "proto="+proto)
+	}
+	if host := src.host(); host != "" {
+		pairs = append(pairs, "host="+forwardedValue(host))
+	}
+	return strings.Join(pairs, ";")
+}
+
+// forwardedNode formats a node identifier, quoted where RFC 7239 requires it
+func forwardedNode(format, addr string, port int, identifier string) string {
+	switch format {
+	case ForwardedNodeObfuscated:
+		if identifier != "" {
+			return identifier
+		}
+		if addr == "" {
+			return ""
+		}
+		return obfuscateNode(addr)
+	case ForwardedNodeIP, ForwardedNodeIPPort:
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return ""
+		}
+		node := ip.String()
+		if ip.To4() == nil {
+			node = "[" + node + "]"
+		}
+		if format == ForwardedNodeIPPort {
+			node += ":" + strconv.Itoa(port)
+		}
+		return forwardedValue(node)
+	default:
+		return ""
+	}
+}
+
+// obfuscateNode derives a stable obfuscated identifier from an address
+func obfuscateNode(addr string) string {
+	mac := hmac.New(sha256.New, forwardedKey)
+	mac.Write([]byte(addr))
+	return "_" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// forwardedValue returns value as a token, or as a quoted-string when it holds
+// characters outside the token set (':' of ports, '[' of IPv6 addresses)
+func forwardedValue(value string) string {
+	if isHeaderToken(value) {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// currentForwardedSettings returns the configured Forwarded settings
+func currentForwardedSettings() ForwardedSettings {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.Forwarded
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// forwardedForChain returns the X-Forwarded-For entries sent by the client,
+// followed by the address from the Proxy Protocol header
+func forwardedForChain(sent http.Header, source string) []string {
+	var chain []string
+	for _, value := range sent.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				chain = append(chain, entry)
+			}
+		}
+	}
+	if source != "" {
+		chain = append(chain, source)
+	}
+	return chain
+}
+
+// realClient walks the chain right to left, skipping trusted proxies, and
+// returns the first address that is not one. When every entry is trusted the
+// leftmost one is the client. An entry that is not an IP address ends the walk
+// at the last valid address, as nothing left of it can be verified.
+func realClient(chain []string, trusted []netip.Prefix) string {
+	client := ""
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := forwardedForAddr(chain[i])
+		if !ok {
+			break
+		}
+		client = addr.String()
+		if !prefixesContain(trusted, addr) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedForAddr parses an X-Forwarded-For entry: an IP, optionally with
+// a port, IPv6 optionally in brackets
+func forwardedForAddr(entry string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(strings.Trim(entry, "[]")); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(entry); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// prefixesContain reports whether addr is in one of the prefixes
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxies returns the proxies whose X-Forwarded-For entries are trusted
+func trustedProxies() []netip.Prefix {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.trustedProxies
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test picking the real client from the X-Forwarded-For chain
+func TestRealClient(t *testing.T) {
+	trusted, err := parsePrefixes([]string{"203.0.113.0/24", "2001:db8:cd::/48", "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		xff      []string
+		source   string
+		trusted  bool
+		expected string
+	}{
+		{"no chain", nil, "198.51.100.7", true, "198.51.100.7"},
+		{"untrusted source ignores the chain", []string{"192.0.2.1"}, "198.51.100.7", true, "198.51.100.7"},
+		{"trusted CDN", []string{"192.0.2.1"}, "203.0.113.5", true, "192.0.2.1"},
+		{"spoofed entry left of the client", []string{"6.6.6.6, 192.0.2.1"}, "203.0.113.5", true, "192.0.2.1"},
+		{"several trusted hops", []string{"192.0.2.1, 10.0.0.1", "203.0.113.9"}, "203.0.113.5", true, "192.0.2.1"},
+		{"all trusted picks the leftmost", []string{"10.0.0.1"}, "203.0.113.5", true, "10.0.0.1"},
+		{"IPv6 with port and brackets", []string{"[2001:db8::7]:4711"}, "2001:db8:cd::1", true, "2001:db8::7"},
+		{"invalid entry stops the walk", []string{"192.0.2.1, unknown"}, "203.0.113.5", true, "203.0.113.5"},
+		{"without trusted proxies", []string{"192.0.2.1"}, "203.0.113.5", false, "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := http.Header{}
+			for _, value := range tt.xff {
+				sent.Add("X-Forwarded-For", value)
+			}
+			prefixes := trusted
+			if !tt.trusted {
+				prefixes = nil
+			}
+			if got := realClient(forwardedForChain(sent, tt.source), prefixes); got != tt.expected {
+				t.Errorf("Expected client %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// Test that the ingress keeps the chain and resolves the client
+func TestIngressForwardedForChain(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.trustedProxies = testPrefixes(t, "203.0.113.0/24")
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	req := httptest.NewRequest("POST", INGRESS_PATH+"/", strings.NewReader("PROXY TCP4 203.0.113.5 198.51.100.1 51000 443\r\nGET / HTTP/1.1\r\n\r\n"))
+	req.Header.Set("X-Zoraxy-RequestID", "xff-chain-1")
+	req.Header.Set("X-Forwarded-For", "192.0.2.44")
+	rr := serveIngress(t, req, "example.com")
+
+	if got := rr.Header().Get("X-Forwarded-For"); got != "192.0.2.44, 203.0.113.5" {
+		t.Errorf("Expected the chain to be appended to, got %q", got)
+	}
+	if got := rr.Header().Get("X-Real-IP"); got != "192.0.2.44" {
+		t.Errorf("Expected X-Real-IP to be the client behind the CDN, got %q", got)
+	}
+	if got := rr.Header().Get("X-Proxy-Protocol-Source"); got != "203.0.113.5:51000" {
+		t.Errorf("Expected X-Proxy-Protocol-Source to stay the Proxy Protocol source, got %q", got)
+	}
+}
+
+// Test that the listener middleware builds the same chain as the ingress
+func TestProxyProtocolMiddlewareChain(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) { c.trustedProxies = testPrefixes(t, "203.0.113.0/24") })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(chan http.Header, 1)
+	server := &http.Server{
+		Handler: ProxyProtocolMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen <- r.Header.Clone()
+		})),
+		ConnContext: ProxyProtocolConnContext,
+	}
+	go server.Serve(NewProxyProtocolListener(ln, nil, listenerLog))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "PROXY TCP4 203.0.113.5 198.51.100.1 51000 80\r\n"+
+		"GET / HTTP/1.1\r\nHost: example.com\r\nX-Forwarded-For: 192.0.2.44\r\nConnection: close\r\n\r\n")
+	http.ReadResponse(bufio.NewReader(conn), nil)
+
+	select {
+	case header := <-seen:
+		if got := header.Get("X-Forwarded-For"); got != "192.0.2.44, 203.0.113.5" {
+			t.Errorf("Expected the chain to be appended to, got %q", got)
+		}
+		if got := header.Get("X-Real-IP"); got != "192.0.2.44" {
+			t.Errorf("Expected X-Real-IP 192.0.2.44, got %q", got)
+		}
+		if got := header.Get("X-Forwarded-Proto"); got != "http" {
+			t.Errorf("Expected X-Forwarded-Proto http, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Request did not reach the handler")
+	}
+}
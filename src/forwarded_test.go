@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test RFC 7239 element generation
+func TestForwardedElement(t *testing.T) {
+	v4 := &ProxyProtocolInfo{Version: 1, Command: "PROXY", TransportProto: "TCP4",
+		SourceAddr: "192.0.2.60", SourcePort: 4711, DestAddr: "198.51.100.17", DestPort: 443}
+	v6 := &ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP6",
+		SourceAddr: "2001:db8:cafe::17", SourcePort: 4711, DestAddr: "2001:db8::1", DestPort: 8080}
+
+	tests := []struct {
+		name     string
+		src      headerSource
+		expected string
+	}{
+		{"IPv4 with host", headerSource{info: v4, hostname: "example.com",
+			forwarded: ForwardedSettings{For: ForwardedNodeIP, By: ForwardedNodeNone}},
+			"for=192.0.2.60;proto=https;host=example.com"},
+		{"IPv6 is quoted and bracketed", headerSource{info: v6,
+			forwarded: ForwardedSettings{For: ForwardedNodeIP, By: ForwardedNodeNone}},
+			`for="[2001:db8:cafe::17]"`},
+		{"IPv4 with port is quoted", headerSource{info: v4,
+			forwarded: ForwardedSettings{For: ForwardedNodeIPPort, By: ForwardedNodeIP}},
+			`for="192.0.2.60:4711";by=198.51.100.17;proto=https`},
+		{"IPv6 with ports", headerSource{info: v6,
+			forwarded: ForwardedSettings{For: ForwardedNodeIPPort, By: ForwardedNodeIPPort}},
+			`for="[2001:db8:cafe::17]:4711";by="[2001:db8::1]:8080"`},
+		{"host with port is quoted", headerSource{info: v6, hostname: "example.com:8080",
+			forwarded: ForwardedSettings{For: ForwardedNodeIP, By: ForwardedNodeObfuscated, ByIdentifier: "_edge-1"}},
+			`for="[2001:db8:cafe::17]";by=_edge-1;host="example.com:8080"`},
+		{"defaults", headerSource{info: v4}, "for=192.0.2.60;proto=https"},
+		{"TLS from the plugin listener", headerSource{info: v6, tls: true}, `for="[2001:db8:cafe::17]";proto=https`},
+		{"LOCAL has no element", headerSource{info: &ProxyProtocolInfo{Version: 2, Command: "LOCAL"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwardedElement(&tt.src); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("proto and host from TLVs", func(t *testing.T) {
+		info := *v6
+		info.TLVs = []ProxyProtocolTLV{
+			{Type: PP2TypeAuthority, Value: []byte("api.example.com")},
+			{Type: PP2TypeSSL, Value: []byte{pp2ClientSSL, 0, 0, 0, 0}},
+		}
+		expected := `for="[2001:db8:cafe::17]";proto=https;host=api.example.com`
+		if got := forwardedElement(&headerSource{info: &info}); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+
+		info.TLVs[1].Value = []byte{0, 0, 0, 0, 0}
+		info.DestPort = 443
+		if got := forwardedElement(&headerSource{info: &info}); !strings.Contains(got, "proto=http;") {
+			t.Errorf("Expected the SSL TLV to win over the destination port, got %s", got)
+		}
+	})
+
+	t.Run("obfuscated client", func(t *testing.T) {
+		src := headerSource{info: v4, forwarded: ForwardedSettings{For: ForwardedNodeObfuscated, By: ForwardedNodeNone}}
+		first := forwardedElement(&src)
+		if !strings.HasPrefix(first, "for=_") || strings.Contains(first, "192.0.2.60") {
+			t.Errorf("Expected an obfuscated identifier, got %s", first)
+		}
+		if second := forwardedElement(&src); second != first {
+			t.Errorf("Expected a stable identifier, got %s and %s", first, second)
+		}
+		other := *v4
+		other.SourceAddr = "192.0.2.61"
+		if got := forwardedElement(&headerSource{info: &other, forwarded: src.forwarded}); got == first {
+			t.Errorf("Expected different clients to get different identifiers, got %s", got)
+		}
+	})
+}
+
+// Test Forwarded settings validation
+func TestForwardedSettingsNormalize(t *testing.T) {
+	settings := ForwardedSettings{By: ForwardedNodeObfuscated}
+	if err := settings.normalize(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if settings.For != ForwardedNodeIP || settings.ByIdentifier != defaultForwardedByIdentifier {
+		t.Errorf("Expected defaults to be filled in, got %+v", settings)
+	}
+
+	for _, invalid := range []ForwardedSettings{
+		{For: "hostname"},
+		{By: "proxy"},
+		{By: ForwardedNodeObfuscated, ByIdentifier: "zoraxy"},
+		{By: ForwardedNodeObfuscated, ByIdentifier: "_zor axy"},
+	} {
+		if err := invalid.normalize(); err == nil {
+			t.Errorf("Expected an error for %+v", invalid)
+		}
+	}
+}
+
+// Test that the ingress appends to the Forwarded header sent by a trusted peer
+func TestIngressForwardedHeader(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.trustedProxies = testPrefixes(t, "2001:db8::/64")
+		c.ClientHeaders = []ClientHeader{{Name: "Forwarded", Value: "{forwarded}", Mode: ClientHeaderAppend, Enabled: true}}
+		c.Forwarded = ForwardedSettings{For: ForwardedNodeIPPort, By: ForwardedNodeObfuscated}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	req := httptest.NewRequest("POST", INGRESS_PATH+"/", strings.NewReader("PROXY TCP6 2001:db8::7 2001:db8::1 51000 443\r\nGET / HTTP/1.1\r\n\r\n"))
+	req.Header.Set("X-Zoraxy-RequestID", "forwarded-1")
+	req.Header.Set("Forwarded", "for=198.51.100.9")
+	rr := serveIngress(t, req, "shop.example.com")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rr.Code)
+	}
+	expected := `for=198.51.100.9, for="[2001:db8::7]:51000";by=_zoraxy;proto=https;host=shop.example.com`
+	if got := rr.Header().Get("Forwarded"); got != expected {
+		t.Errorf("Expected Forwarded %s, got %s", expected, got)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GEOIP_FILE_PATTERN matches the MaxMind DB files loaded from the plugin directory
+const GEOIP_FILE_PATTERN = "*.mmdb"
+
+// geoipReloadInterval is how often the plugin directory is checked for changed databases
+const geoipReloadInterval = 30 * time.Second
+
+// GeoIPRecord is what the loaded databases know about an address
+type GeoIPRecord struct {
+	Address   string `json:"address"`
+	Network   string `json:"network,omitempty"`
+	Country   string `json:"country,omitempty"`   // ISO 3166-1 alpha-2 code
+	Continent string `json:"continent,omitempty"` // e.g. EU, NA
+	ASN       uint64 `json:"asn,omitempty"`
+	ASOrg     string `json:"as_org,omitempty"`
+}
+
+// GeoIPDatabase describes a loaded database file
+type GeoIPDatabase struct {
+	File      string    `json:"file"`
+	Type      string    `json:"type"` // e.g. GeoLite2-Country, GeoLite2-ASN
+	IPVersion int       `json:"ip_version"`
+	BuildTime time.Time `json:"build_time"`
+	LoadedAt  time.Time `json:"loaded_at"`
+
+	reader  *maxminddb.Reader
+	size    int64
+	modTime time.Time
+}
+
+// GeoIPResponse is returned by the GeoIP API
+type GeoIPResponse struct {
+	Directory string          `json:"directory"`
+	Databases []GeoIPDatabase `json:"databases"`
+	Errors    []string        `json:"errors"` // files that failed to load
+}
+
+// geoipFileStamp identifies a version of a database file
+type geoipFileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// geoIPStore holds the databases found in the plugin directory. Lookups
+// merge them in file name order, so a country and an ASN database can be
+// combined.
+type geoIPStore struct {
+	mu        sync.RWMutex
+	dir       string // empty disables GeoIP (used by tests)
+	databases []*GeoIPDatabase
+	failed    map[string]geoipFileStamp // files that failed to load, retried once they change
+	errors    map[string]string
+}
+
+var geoip = &geoIPStore{}
+
+// setDir points the store at a directory and loads its databases
+func (s *geoIPStore) setDir(dir string) {
+	s.mu.Lock()
+	s.dir = dir
+	s.databases = nil
+	s.failed = nil
+	s.errors = nil
+	s.mu.Unlock()
+	s.reload()
+}
+
+// reload loads new and changed database files and drops removed ones.
+// A file that fails to load keeps its previous version.
+func (s *geoIPStore) reload() {
+	s.mu.RLock()
+	dir := s.dir
+	current := make(map[string]*GeoIPDatabase, len(s.databases))
+	for _, db := range s.databases {
+		current[db.File] = db
+	}
+	failed := make(map[string]geoipFileStamp, len(s.failed))
+	for file, stamp := range s.failed {
+		failed[file] = stamp
+	}
+	errors := make(map[string]string, len(s.errors))
+	for file, err := range s.errors {
+		errors[file] = err
+	}
+	s.mu.RUnlock()
+
+	if dir == "" {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(dir, GEOIP_FILE_PATTERN))
+	if err != nil {
+		logger.Error("Error listing GeoIP databases", "dir", dir, "error", err)
+		return
+	}
+	sort.Strings(files)
+
+	var databases []*GeoIPDatabase
+	seen := make(map[string]bool, len(files))
+	changed := false
+	for _, path := range files {
+		file := filepath.Base(path)
+		seen[file] = true
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		stamp := geoipFileStamp{size: info.Size(), modTime: info.ModTime()}
+		previous := current[file]
+		if previous != nil && previous.size == stamp.size && previous.modTime.Equal(stamp.modTime) {
+			databases = append(databases, previous)
+			continue
+		}
+		if failedStamp, ok := failed[file]; ok && failedStamp == stamp {
+			if previous != nil {
+				databases = append(databases, previous)
+			}
+			continue
+		}
+
+		db, err := loadGeoIPDatabase(path, stamp)
+		changed = true
+		if err != nil {
+			failed[file] = stamp
+			errors[file] = err.Error()
+			logger.Error("Error loading GeoIP database", "file", file, "error", err)
+			events.record(EventSourcePlugin, "geoipError", fmt.Sprintf("GeoIP database %s failed to load: %v", file, err))
+			if previous != nil {
+				databases = append(databases, previous)
+			}
+			continue
+		}
+		delete(failed, file)
+		delete(errors, file)
+		databases = append(databases, db)
+
+		action := "loaded"
+		if previous != nil {
+			action = "reloaded"
+		}
+		logger.Info("GeoIP database "+action, "file", file, "type", db.Type, "build_time", db.BuildTime.Format(time.RFC3339))
+		events.record(EventSourcePlugin, "geoipLoaded", fmt.Sprintf("GeoIP database %s %s (%s)", file, action, db.Type))
+	}
+
+	for file := range current {
+		if !seen[file] {
+			changed = true
+			logger.Info("GeoIP database removed", "file", file)
+			events.record(EventSourcePlugin, "geoipRemoved", "GeoIP database "+file+" removed")
+		}
+	}
+	for file := range failed {
+		if !seen[file] {
+			delete(failed, file)
+			delete(errors, file)
+		}
+	}
+	if !changed && len(databases) == len(current) {
+		return
+	}
+
+	s.mu.Lock()
+	if s.dir == dir {
+		s.databases = databases
+		s.failed = failed
+		s.errors = errors
+	}
+	s.mu.Unlock()
+}
+
+// loadGeoIPDatabase reads and validates a database file
+func loadGeoIPDatabase(path string, stamp geoipFileStamp) (*GeoIPDatabase, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIPDatabase{
+		File:      filepath.Base(path),
+		Type:      reader.Metadata.DatabaseType,
+		IPVersion: int(reader.Metadata.IPVersion),
+		BuildTime: time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC(),
+		LoadedAt:  time.Now(),
+		reader:    reader,
+		size:      stamp.size,
+		modTime:   stamp.modTime,
+	}, nil
+}
+
+// startWatcher periodically reloads changed databases until stop is closed
+func (s *geoIPStore) startWatcher(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.reload()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// loaded reports whether any database is available
+func (s *geoIPStore) loaded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.databases) > 0
+}
+
+// lookup merges what the databases know about an address, the first
+// database with a value wins for every field
+func (s *geoIPStore) lookup(addr netip.Addr) GeoIPRecord {
+	addr = addr.Unmap()
+	record := GeoIPRecord{Address: addr.String()}
+
+	s.mu.RLock()
+	databases := s.databases
+	s.mu.RUnlock()
+
+	for _, db := range databases {
+		fields, network, found, err := lookupGeoIPFields(db.reader, addr)
+		if err != nil {
+			logger.Debug("GeoIP lookup failed", "file", db.File, "address", record.Address, "error", err)
+			continue
+		}
+		if !found {
+			continue
+		}
+		if record.Network == "" && network.IsValid() {
+			record.Network = network.String()
+		}
+
+		if record.Country == "" {
+			record.Country = fields.Country.ISOCode
+		}
+		if record.Country == "" {
+			record.Country = fields.RegisteredCountry.ISOCode
+		}
+		if record.Continent == "" {
+			record.Continent = fields.Continent.Code
+		}
+		if record.ASN == 0 {
+			record.ASN = fields.ASN
+		}
+		if record.ASOrg == "" {
+			record.ASOrg = fields.ASOrg
+		}
+	}
+	return record
+}
+
+// formatASN renders an AS number for headers, empty when unknown
+func formatASN(asn uint64) string {
+	if asn == 0 {
+		return ""
+	}
+	return strconv.FormatUint(asn, 10)
+}
+
+// snapshot describes the loaded databases for the API
+func (s *geoIPStore) snapshot() GeoIPResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	response := GeoIPResponse{Directory: s.dir, Databases: make([]GeoIPDatabase, 0, len(s.databases)), Errors: []string{}}
+	for _, db := range s.databases {
+		response.Databases = append(response.Databases, *db)
+	}
+	for file, err := range s.errors {
+		response.Errors = append(response.Errors, file+": "+err)
+	}
+	sort.Strings(response.Errors)
+	return response
+}
+
+// handleAPIGeoIP lists the loaded databases. GET with ?lookup=<address>
+// returns what they know about that address.
+func handleAPIGeoIP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if address := strings.TrimSpace(r.URL.Query().Get("lookup")); address != "" {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid address %q", address), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, geoip.lookup(addr))
+		return
+	}
+	writeJSON(w, geoip.snapshot())
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setGeoIPDir loads the databases of dir for the duration of the test
+func setGeoIPDir(t *testing.T, dir string) {
+	t.Helper()
+	geoip.setDir(dir)
+	t.Cleanup(func() { geoip.setDir("") })
+}
+
+// writeGeoIPFile writes a database file with a distinct modification time
+func writeGeoIPFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testASNMMDB is an ASN database covering the test networks
+func testASNMMDB(t *testing.T) []byte {
+	return buildTestMMDB(t, "GeoLite2-ASN", 6, 24, nil,
+		mmdbTestNetwork{"192.0.2.0/25", map[string]any{
+			"autonomous_system_number":       uint32(64496),
+			"autonomous_system_organization": "Example Networks",
+		}},
+	)
+}
+
+// Test loading, merging and reloading the databases of a directory
+func TestGeoIPReload(t *testing.T) {
+	dir := t.TempDir()
+	country := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	started := time.Now().Add(-time.Hour)
+	writeGeoIPFile(t, country, testCountryMMDB(t, 24, "DE"), started)
+	writeGeoIPFile(t, filepath.Join(dir, "GeoLite2-ASN.mmdb"), testASNMMDB(t), started)
+	setGeoIPDir(t, dir)
+
+	record := geoip.lookup(netip.MustParseAddr("192.0.2.10"))
+	expected := GeoIPRecord{Address: "192.0.2.10", Network: "192.0.2.0/25", Country: "DE", Continent: "EU", ASN: 64496, ASOrg: "Example Networks"}
+	if record != expected {
+		t.Errorf("Expected %+v, got %+v", expected, record)
+	}
+	if record := geoip.lookup(netip.MustParseAddr("2001:db8::1")); record.Country != "US" || record.ASN != 0 {
+		t.Errorf("Expected the registered country without ASN, got %+v", record)
+	}
+
+	// A changed file is reloaded
+	writeGeoIPFile(t, country, testCountryMMDB(t, 28, "AT"), started.Add(time.Minute))
+	geoip.reload()
+	if record := geoip.lookup(netip.MustParseAddr("192.0.2.10")); record.Country != "AT" {
+		t.Errorf("Expected the reloaded country AT, got %q", record.Country)
+	}
+
+	// A broken file keeps the previous version
+	writeGeoIPFile(t, country, []byte("truncated"), started.Add(2*time.Minute))
+	geoip.reload()
+	snapshot := geoip.snapshot()
+	if len(snapshot.Databases) != 2 || len(snapshot.Errors) != 1 {
+		t.Errorf("Expected both databases and one error, got %+v", snapshot)
+	}
+	if record := geoip.lookup(netip.MustParseAddr("192.0.2.10")); record.Country != "AT" {
+		t.Errorf("Expected the previous version to be kept, got %q", record.Country)
+	}
+
+	// A removed file is dropped
+	if err := os.Remove(country); err != nil {
+		t.Fatal(err)
+	}
+	geoip.reload()
+	if snapshot := geoip.snapshot(); len(snapshot.Databases) != 1 || len(snapshot.Errors) != 0 {
+		t.Errorf("Expected only the ASN database, got %+v", snapshot)
+	}
+	if record := geoip.lookup(netip.MustParseAddr("192.0.2.10")); record.Country != "" || record.ASN != 64496 {
+		t.Errorf("Expected only the ASN, got %+v", record)
+	}
+}
+
+// Test the GeoIP headers and country rules of the ingress
+func TestIngressGeoIP(t *testing.T) {
+	dir := t.TempDir()
+	writeGeoIPFile(t, filepath.Join(dir, "country.mmdb"), testCountryMMDB(t, 24, "DE"), time.Now())
+	writeGeoIPFile(t, filepath.Join(dir, "asn.mmdb"), testASNMMDB(t), time.Now())
+	setGeoIPDir(t, dir)
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.ClientHeaders = defaultClientHeaders()
+		c.IPAccess = IPAccess{Hosts: []HostIPAccess{
+			{Pattern: "shop.example.com", IPAccessList: IPAccessList{Deny: []string{"country:de"}}},
+		}}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	ingress := func(id, hostname, source string, port int) *httptest.ResponseRecorder {
+		header, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+			SourceAddr: source, SourcePort: port, DestAddr: "198.51.100.48", DestPort: 443})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", INGRESS_PATH+"/", bytes.NewReader(append(header, "GET / HTTP/1.1\r\n\r\n"...)))
+		req.Header.Set("X-Zoraxy-RequestID", id)
+		rr := serveIngress(t, req, hostname)
+		connections.remove(ingressConnectionID(&ProxyProtocolInfo{SourceAddr: source, SourcePort: port, DestAddr: "198.51.100.48", DestPort: 443}))
+		return rr
+	}
+
+	rr := ingress("geoip-1", "", "192.0.2.20", 42001)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rr.Code)
+	}
+	for name, expected := range map[string]string{"X-GeoIP-Country": "DE", "X-GeoIP-Continent": "EU", "X-GeoIP-ASN": "64496"} {
+		if got := rr.Header().Get(name); got != expected {
+			t.Errorf("Expected %s %s, got %q", name, expected, got)
+		}
+	}
+	if rr := ingress("geoip-2", "", "203.0.113.20", 42002); rr.Header().Get("X-GeoIP-Country") != "" {
+		t.Error("Expected no country header for an unknown address")
+	}
+	if rr := ingress("geoip-3", "shop.example.com", "192.0.2.20", 42003); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected the country to be denied on the host, got %d", rr.Code)
+	}
+}
+
+// Test that the access rules and client headers of a request share one lookup
+func TestGeoIPSharedLookup(t *testing.T) {
+	dir := t.TempDir()
+	writeGeoIPFile(t, filepath.Join(dir, "country.mmdb"), testCountryMMDB(t, 24, "DE"), time.Now())
+	setGeoIPDir(t, dir)
+	setConfig(t, func(c *PluginConfig) {
+		c.ClientHeaders = []ClientHeader{{Name: "X-GeoIP-Country", Value: "{country}", Enabled: true}}
+		c.IPAccess = IPAccess{}
+	})
+
+	src := &headerSource{info: &ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4", SourceAddr: "192.0.2.20"}}
+	if allowed, _ := checkIPAccess(src); !allowed {
+		t.Fatal("Expected the client to be allowed")
+	}
+
+	// Without the databases only the record of the access check can set the header
+	geoip.setDir("")
+	dst := http.Header{}
+	applyClientHeaders(dst, src)
+	if got := dst.Get("X-GeoIP-Country"); got != "DE" {
+		t.Errorf("Expected the country of the access check, got %q", got)
+	}
+}
+
+// Test the GeoIP API
+func TestHandleAPIGeoIP(t *testing.T) {
+	dir := t.TempDir()
+	writeGeoIPFile(t, filepath.Join(dir, "country.mmdb"), testCountryMMDB(t, 32, "DE"), time.Now())
+	setGeoIPDir(t, dir)
+
+	rr := httptest.NewRecorder()
+	handleAPIGeoIP(rr, httptest.NewRequest("GET", "/ui/api/geoip", nil))
+	var response GeoIPResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Databases) != 1 || response.Databases[0].Type != "GeoLite2-Country" || response.Databases[0].BuildTime.Unix() != 1760000000 {
+		t.Errorf("Expected the loaded database, got %+v", response)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAPIGeoIP(rr, httptest.NewRequest("GET", "/ui/api/geoip?lookup=192.0.2.1", nil))
+	var record GeoIPRecord
+	if err := json.NewDecoder(rr.Body).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Country != "DE" || record.Network != "192.0.2.0/24" {
+		t.Errorf("Expected the lookup result, got %+v", record)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAPIGeoIP(rr, httptest.NewRequest("GET", "/ui/api/geoip?lookup=nope", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handleAPIGeoIP(rr, httptest.NewRequest("POST", "/ui/api/geoip", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code 405, got %d", rr.Code)
+	}
+}
@@ -1,3 +1,7 @@
 module go.codexo.de/exoridus/zoraxy-proxy-protocol
 
-go 1.20
+go 1.21
+
+require github.com/oschwald/maxminddb-golang v1.12.0
+
+require golang.org/x/sys v0.10.0 // indirect
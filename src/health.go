@@ -0,0 +1,400 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// healthWindow is the period the parse error rate is computed over
+	healthWindow = 5 * time.Minute
+	// healthMinParseAttempts avoids flagging the error rate on a handful of requests
+	healthMinParseAttempts = 20
+	// healthMaxParseErrorRate is the highest tolerated share of failed parses
+	healthMaxParseErrorRate = 0.5
+	// registryHighWatermark is the registry fill level reported as unhealthy
+	registryHighWatermark = 0.9
+	// upstreamStaleAfter is how long a trusted upstream may go without PROXY traffic
+	upstreamStaleAfter = 15 * time.Minute
+	// maxTrackedUpstreams bounds the number of peers with a last-seen time
+	maxTrackedUpstreams = 1024
+)
+
+// Health check names
+const (
+	HealthCheckListeners   = "listeners"
+	HealthCheckParseErrors = "parse_errors"
+	HealthCheckConfig      = "config"
+	HealthCheckRegistry    = "registry"
+	HealthCheckUpstreams   = "upstream_traffic"
+)
+
+// startedAt is used to report the plugin uptime
+var startedAt = time.Now()
+
+// HealthCheck is the result of a single health check
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message"`
+}
+
+// ListenerStatus describes a listener the plugin is serving on
+type ListenerStatus struct {
+	Name    string    `json:"name"`
+	Address string    `json:"address"`
+	Bound   bool      `json:"bound"`
+	Error   string    `json:"error,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+// UpstreamStatus reports the PROXY traffic of a trusted upstream
+type UpstreamStatus struct {
+	Upstream string     `json:"upstream"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	LastPeer string     `json:"last_peer,omitempty"`
+	Healthy  bool       `json:"healthy"`
+}
+
+// HealthResponse is returned by the health API
+type HealthResponse struct {
+	Healthy       bool             `json:"healthy"`
+	Uptime        string           `json:"uptime"`
+	UptimeSeconds int64            `json:"uptime_seconds"`
+	Checks        []HealthCheck    `json:"checks"`
+	Listeners     []ListenerStatus `json:"listeners"`
+	Upstreams     []UpstreamStatus `json:"upstreams"`
+}
+
+// UpstreamsRequest replaces the trusted upstreams
+type UpstreamsRequest struct {
+	TrustedUpstreams []string `json:"trusted_upstreams"`
+}
+
+// UpstreamsResponse lists the trusted upstreams with their last PROXY traffic
+type UpstreamsResponse struct {
+	TrustedUpstreams []string         `json:"trusted_upstreams"`
+	Upstreams        []UpstreamStatus `json:"upstreams"`
+}
+
+// listenerTracker records the listeners the plugin is serving on
+type listenerTracker struct {
+	mu      sync.RWMutex
+	entries map[string]*ListenerStatus
+}
+
+var listeners = &listenerTracker{entries: make(map[string]*ListenerStatus)}
+
+// bound records a listener that is accepting connections
+func (t *listenerTracker) bound(name, address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries[name] = &ListenerStatus{Name: name, Address: address, Bound: true, Since: time.Now()}
+}
+
+// failed records a listener that stopped accepting connections unexpectedly
+func (t *listenerTracker) failed(name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.entries[name]
+	if !ok {
+		status = &ListenerStatus{Name: name}
+		t.entries[name] = status
+	}
+	status.Bound = false
+	status.Error = err.Error()
+	status.Since = time.Now()
+}
+
+// accepted clears the failure of a listener that accepts connections again
+func (t *listenerTracker) accepted(name string) {
+	t.mu.RLock()
+	status, ok := t.entries[name]
+	recovered := ok && !status.Bound
+	t.mu.RUnlock()
+	if !recovered {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if status, ok := t.entries[name]; ok && !status.Bound {
+		status.Bound = true
+		status.Error = ""
+		status.Since = time.Now()
+	}
+}
+
+// closed forgets a listener that was closed on purpose
+func (t *listenerTracker) closed(name string) {
+	t.mu.Lock()
+	delete(t.entries, name)
+	t.mu.Unlock()
+}
+
+// list returns the listeners sorted by name
+func (t *listenerTracker) list() []ListenerStatus {
+	t.mu.RLock()
+	result := make([]ListenerStatus, 0, len(t.entries))
+	for _, status := range t.entries {
+		result = append(result, *status)
+	}
+	t.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// parseWindow counts parse attempts and failures in one minute buckets
+type parseWindow struct {
+	mu      sync.Mutex
+	buckets [int(healthWindow / time.Minute)]struct {
+		minute   int64
+		attempts uint64
+		errors   uint64
+	}
+}
+
+func (p *parseWindow) observe(now time.Time, failed bool) {
+	minute := now.Unix() / 60
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	bucket := &p.buckets[minute%int64(len(p.buckets))]
+	if bucket.minute != minute {
+		bucket.minute = minute
+		bucket.attempts = 0
+		bucket.errors = 0
+	}
+	bucket.attempts++
+	if failed {
+		bucket.errors++
+	}
+}
+
+// totals returns the attempts and failures within the window
+func (p *parseWindow) totals(now time.Time) (attempts, failures uint64) {
+	minute := now.Unix() / 60
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, bucket := range p.buckets {
+		if minute-bucket.minute < int64(len(p.buckets)) {
+			attempts += bucket.attempts
+			failures += bucket.errors
+		}
+	}
+	return attempts, failures
+}
+
+// upstreamTracker remembers when each peer last sent a PROXY header
+type upstreamTracker struct {
+	mu       sync.RWMutex
+	lastSeen map[netip.Addr]time.Time
+}
+
+var upstreams = &upstreamTracker{lastSeen: make(map[netip.Addr]time.Time)}
+
+// seen records PROXY traffic from the peer address (host:port or host)
+func (u *upstreamTracker) seen(peerAddr string) {
+	addr, ok := peerIP(peerAddr)
+	if !ok {
+		return
+	}
+	now := time.Now()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, known := u.lastSeen[addr]; !known && len(u.lastSeen) >= maxTrackedUpstreams {
+		var oldest netip.Addr
+		for peer, seen := range u.lastSeen {
+			if !oldest.IsValid() || seen.Before(u.lastSeen[oldest]) {
+				oldest = peer
+			}
+		}
+		delete(u.lastSeen, oldest)
+	}
+	u.lastSeen[addr] = now
+}
+
+// status returns the most recent traffic of every trusted upstream
+func (u *upstreamTracker) status(trusted []netip.Prefix, now time.Time) []UpstreamStatus {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	result := make([]UpstreamStatus, 0, len(trusted))
+	for _, prefix := range trusted {
+		status := UpstreamStatus{Upstream: formatPrefix(prefix)}
+		for peer, seen := range u.lastSeen {
+			if prefix.Contains(peer) && (status.LastSeen == nil || seen.After(*status.LastSeen)) {
+				seen := seen
+				status.LastSeen = &seen
+				status.LastPeer = peer.String()
+			}
+		}
+		if status.LastSeen != nil {
+			status.Healthy = now.Sub(*status.LastSeen) <= upstreamStaleAfter
+		} else {
+			// Give upstreams time to send traffic after a restart
+			status.Healthy = now.Sub(startedAt) <= upstreamStaleAfter
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// runHealthChecks evaluates every health check
+func runHealthChecks() []HealthCheck {
+	now := time.Now()
+	return []HealthCheck{
+		checkListeners(),
+		checkParseErrors(now),
+		checkConfig(),
+		checkRegistry(),
+		checkUpstreams(now),
+	}
+}
+
+func checkListeners() HealthCheck {
+	check := HealthCheck{Name: HealthCheckListeners, Healthy: true}
+	all := listeners.list()
+	var down []string
+	for _, status := range all {
+		if !status.Bound {
+			down = append(down, fmt.Sprintf("%s (%s)", status.Name, status.Error))
+		}
+	}
+	switch {
+	case len(down) > 0:
+		check.Healthy = false
+		check.Message = "Not bound: " + strings.Join(down, ", ")
+	case len(all) == 0:
+		check.Message = "No listeners registered"
+	default:
+		check.Message = fmt.Sprintf("%d listener(s) bound", len(all))
+	}
+	return check
+}
+
+func checkParseErrors(now time.Time) HealthCheck {
+	attempts, failures := metrics.recentParses.totals(now)
+	check := HealthCheck{Name: HealthCheckParseErrors, Healthy: true}
+	check.Message = fmt.Sprintf("%d of %d header(s) failed to parse in the last %s", failures, attempts, healthWindow)
+	if attempts >= healthMinParseAttempts && float64(failures)/float64(attempts) > healthMaxParseErrorRate {
+		check.Healthy = false
+	}
+	return check
+}
+
+func checkConfig() HealthCheck {
+	check := HealthCheck{Name: HealthCheckConfig, Healthy: true}
+	state := configLoadState()
+	switch {
+	case configPath == "":
+		check.Message = "Persistence disabled"
+	case state.err != nil:
+		check.Healthy = false
+		check.Message = state.err.Error()
+	case state.loadedAt.IsZero():
+		check.Healthy = false
+		check.Message = "Config not loaded"
+	case !state.fromDisk:
+		check.Message = "No config file, using defaults"
+	default:
+		check.Message = "Loaded from " + configPath + " at " + state.loadedAt.Format(time.RFC3339)
+	}
+	return check
+}
+
+func checkRegistry() HealthCheck {
+	size := connections.size()
+	check := HealthCheck{Name: HealthCheckRegistry, Healthy: true}
+	check.Message = fmt.Sprintf("%d of %d connection(s) tracked", size, connections.maxEntries)
+	if float64(size) >= float64(connections.maxEntries)*registryHighWatermark {
+		check.Healthy = false
+	}
+	return check
+}
+
+func checkUpstreams(now time.Time) HealthCheck {
+	check := HealthCheck{Name: HealthCheckUpstreams, Healthy: true}
+	statuses := upstreams.status(trustedUpstreams(), now)
+	if len(statuses) == 0 {
+		// Without trusted upstreams no request is captured
+		check.Healthy = false
+		check.Message = "No trusted upstreams configured, no request is captured"
+		return check
+	}
+
+	var stale []string
+	for _, status := range statuses {
+		if !status.Healthy {
+			stale = append(stale, status.Upstream)
+		}
+	}
+	if len(stale) > 0 {
+		check.Healthy = false
+		check.Message = fmt.Sprintf("No PROXY traffic for %s from %s", upstreamStaleAfter, strings.Join(stale, ", "))
+		return check
+	}
+	check.Message = fmt.Sprintf("%d trusted upstream(s) sending PROXY traffic", len(statuses))
+	return check
+}
+
+// failingChecks returns the checks that did not pass
+func failingChecks(checks []HealthCheck) []HealthCheck {
+	var failing []HealthCheck
+	for _, check := range checks {
+		if !check.Healthy {
+			failing = append(failing, check)
+		}
+	}
+	return failing
+}
+
+// uptime returns how long the plugin has been running
+func uptime() time.Duration {
+	return time.Since(startedAt).Round(time.Second)
+}
+
+// handleAPIHealth runs the health checks, answering 503 when one fails
+func handleAPIHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	checks := runHealthChecks()
+	response := HealthResponse{
+		Healthy:       len(failingChecks(checks)) == 0,
+		Uptime:        uptime().String(),
+		UptimeSeconds: int64(uptime().Seconds()),
+		Checks:        checks,
+		Listeners:     listeners.list(),
+		Upstreams:     upstreams.status(trustedUpstreams(), time.Now()),
+	}
+	if !response.Healthy {
+		// Headers are sent with the status, set the content type first
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, response)
+}
+
+// isListenerFailure reports whether an Accept or Serve error means the
+// listener failed. Timeouts are temporary and do not count.
+func isListenerFailure(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed)
+}
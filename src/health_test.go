@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// findCheck returns the named check
+func findCheck(t *testing.T, checks []HealthCheck, name string) HealthCheck {
+	t.Helper()
+	for _, check := range checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	t.Fatalf("Check %s not found in %+v", name, checks)
+	return HealthCheck{}
+}
+
+// Test the parse window only counts recent minutes
+func TestParseWindow(t *testing.T) {
+	var window parseWindow
+	now := time.Now()
+
+	window.observe(now.Add(-10*time.Minute), true)
+	window.observe(now.Add(-2*time.Minute), true)
+	window.observe(now, false)
+
+	attempts, failures := window.totals(now)
+	if attempts != 2 || failures != 1 {
+		t.Errorf("Expected 2 attempts and 1 failure, got %d and %d", attempts, failures)
+	}
+}
+
+// Test each health check reports failures
+func TestHealthChecks(t *testing.T) {
+	t.Run("listeners", func(t *testing.T) {
+		listeners.bound("test-listener", "127.0.0.1:0")
+		defer listeners.closed("test-listener")
+
+		if check := checkListeners(); !check.Healthy {
+			t.Errorf("Expected bound listener to be healthy: %+v", check)
+		}
+		listeners.failed("test-listener", errors.New("accept: too many open files"))
+		if check := checkListeners(); check.Healthy || !strings.Contains(check.Message, "too many open files") {
+			t.Errorf("Expected failed listener to be reported: %+v", check)
+		}
+		listeners.accepted("test-listener")
+		if check := checkListeners(); !check.Healthy {
+			t.Errorf("Expected the listener to recover on the next accepted connection: %+v", check)
+		}
+
+		if isListenerFailure(fmt.Errorf("accept: %w", os.ErrDeadlineExceeded)) {
+			t.Error("Expected a timeout not to be a listener failure")
+		}
+		if isListenerFailure(net.ErrClosed) || !isListenerFailure(errors.New("accept: too many open files")) {
+			t.Error("Expected only unexpected errors to be listener failures")
+		}
+	})
+
+	t.Run("parse errors", func(t *testing.T) {
+		oldMetrics := metrics
+		metrics = newPluginMetrics()
+		defer func() { metrics = oldMetrics }()
+
+		for i := 0; i < healthMinParseAttempts-1; i++ {
+			metrics.observeParse(time.Now(), nil, errInvalidV1Header)
+		}
+		if check := checkParseErrors(time.Now()); !check.Healthy {
+			t.Errorf("Expected too few attempts to stay healthy: %+v", check)
+		}
+		metrics.observeParse(time.Now(), nil, errInvalidV1Header)
+		if check := checkParseErrors(time.Now()); check.Healthy {
+			t.Errorf("Expected high error rate to be reported: %+v", check)
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		oldPath := configPath
+		configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+		defer func() {
+			configPath = oldPath
+			loadConfig()
+		}()
+
+		loadConfig()
+		if check := checkConfig(); !check.Healthy || !strings.Contains(check.Message, "defaults") {
+			t.Errorf("Expected missing config to use defaults: %+v", check)
+		}
+
+		os.WriteFile(configPath, []byte("{not json"), 0o600)
+		loadConfig()
+		if check := checkConfig(); check.Healthy {
+			t.Errorf("Expected broken config to be reported: %+v", check)
+		}
+	})
+
+	t.Run("registry", func(t *testing.T) {
+		oldConnections := connections
+		connections = newConnectionRegistry(time.Minute, 2)
+		defer func() { connections = oldConnections }()
+
+		if check := checkRegistry(); !check.Healthy {
+			t.Errorf("Expected empty registry to be healthy: %+v", check)
+		}
+		connections.register("a", ConnectionOriginIngress, &ProxyProtocolInfo{}, "", 0)
+		connections.register("b", ConnectionOriginIngress, &ProxyProtocolInfo{}, "", 0)
+		if check := checkRegistry(); check.Healthy {
+			t.Errorf("Expected full registry to be reported: %+v", check)
+		}
+	})
+
+	t.Run("upstream traffic", func(t *testing.T) {
+		oldUpstreams, oldStartedAt := upstreams, startedAt
+		upstreams = &upstreamTracker{lastSeen: make(map[netip.Addr]time.Time)}
+		defer func() { upstreams, startedAt = oldUpstreams, oldStartedAt }()
+
+		setConfig(t, func(c *PluginConfig) { c.trustedUpstreams = nil })
+		if check := checkUpstreams(time.Now()); check.Healthy {
+			t.Errorf("Expected missing trusted upstreams to be reported: %+v", check)
+		}
+
+		setConfig(t, func(c *PluginConfig) { c.trustedUpstreams = testPrefixes(t, "192.0.2.0/24") })
+		if check := checkUpstreams(time.Now()); !check.Healthy {
+			t.Errorf("Expected grace period after start: %+v", check)
+		}
+
+		startedAt = time.Now().Add(-time.Hour)
+		if check := checkUpstreams(time.Now()); check.Healthy {
+			t.Errorf("Expected silent upstream to be reported: %+v", check)
+		}
+
+		upstreams.seen("192.0.2.7:41000")
+		statuses := upstreams.status(trustedUpstreams(), time.Now())
+		if len(statuses) != 1 || !statuses[0].Healthy || statuses[0].LastPeer != "192.0.2.7" {
+			t.Errorf("Unexpected upstream status %+v", statuses)
+		}
+		if check := checkUpstreams(time.Now().Add(upstreamStaleAfter + time.Minute)); check.Healthy {
+			t.Errorf("Expected stale upstream to be reported: %+v", check)
+		}
+	})
+}
+
+// Test the health API and the failing checks in the status API
+func TestHealthAPI(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) { c.trustedUpstreams = testPrefixes(t, testUpstream) })
+
+	rr := httptest.NewRecorder()
+	handleAPIHealth(rr, httptest.NewRequest(http.MethodGet, UI_PATH+"/api/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var health HealthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !health.Healthy || len(health.Checks) != 5 || health.Uptime == "" {
+		t.Errorf("Unexpected health response %+v", health)
+	}
+	findCheck(t, health.Checks, HealthCheckUpstreams)
+
+	listeners.failed("broken-listener", errors.New("bind: address already in use"))
+	defer listeners.closed("broken-listener")
+	setConfig(t, func(c *PluginConfig) { c.Enabled, c.HostRules = true, nil })
+
+	rr = httptest.NewRecorder()
+	handleAPIHealth(rr, httptest.NewRequest(http.MethodGet, UI_PATH+"/api/health", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Result().Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Expected the unhealthy response to be JSON, got %q", got)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAPIStatus(rr, httptest.NewRequest(http.MethodGet, UI_PATH+"/api/status", nil))
+	var status StatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.Status != "Error" || len(status.FailingChecks) != 1 || status.FailingChecks[0].Name != HealthCheckListeners {
+		t.Errorf("Expected Error status with the failing listener check, got %+v", status)
+	}
+	if !status.Enabled {
+		t.Error("Expected the status to report the plugin as enabled despite the failing check")
+	}
+	if status.Uptime == "" {
+		t.Error("Expected uptime in status response")
+	}
+}
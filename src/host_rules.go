@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Host rule match types
+const (
+	HostMatchExact    = "exact"
+	HostMatchWildcard = "wildcard"
+	HostMatchRegex    = "regex"
+)
+
+// maxHostDecisionCacheSize bounds the number of cached hostname decisions
+const maxHostDecisionCacheSize = 4096
+
+// HostRule enables or disables Proxy Protocol handling for matching hostnames
+type HostRule struct {
+	Pattern string `json:"pattern"`
+	Match   string `json:"match"` // "exact", "wildcard" or "regex"
+	Enabled bool   `json:"enabled"`
+
+	compiled *regexp.Regexp
+}
+
+// HostRulesResponse is returned by the host rules API
+type HostRulesResponse struct {
+	Rules []HostRule `json:"rules"`
+}
+
+// HostRulesRequest replaces the configured host rules
+type HostRulesRequest struct {
+	Rules []HostRule `json:"rules"`
+}
+
+// HostRuleTestResponse reports the decision for a single hostname
+type HostRuleTestResponse struct {
+	Hostname string `json:"hostname"`
+	Enabled  bool   `json:"enabled"`
+	Rule     string `json:"rule,omitempty"`
+}
+
+// hostDecisionCache memoizes per-hostname decisions so regex rules are not
+// evaluated for every sniffed request. Every reset starts a new generation,
+// so a decision made from the rules before a reset is never cached after it.
+type hostDecisionCache struct {
+	mu         sync.RWMutex
+	decisions  map[string]bool
+	generation uint64
+}
+
+var hostDecisions = &hostDecisionCache{decisions: make(map[string]bool)}
+
+// get returns the cached decision, or the generation to pass to put once the
+// decision is made
+func (c *hostDecisionCache) get(hostname string) (bool, bool, uint64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	enabled, ok := c.decisions[hostname]
+	return enabled, ok, c.generation
+}
+
+// put caches a decision unless the cache was reset since get
+func (c *hostDecisionCache) put(hostname string, enabled bool, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if len(c.decisions) >= maxHostDecisionCacheSize {
+		c.decisions = make(map[string]bool)
+	}
+	c.decisions[hostname] = enabled
+}
+
+func (c *hostDecisionCache) reset() {
+	c.mu.Lock()
+	c.decisions = make(map[string]bool)
+	c.generation++
+	c.mu.Unlock()
+}
+
+// normalizeHostname lowercases the hostname and strips any port
+func normalizeHostname(hostname string) string {
+	hostname = strings.TrimSpace(hostname)
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	hostname = strings.TrimSuffix(hostname, ".")
+	return strings.ToLower(hostname)
+}
+
+// compile validates the rule and prepares its matcher
+func (r *HostRule) compile() error {
+	r.Pattern = strings.TrimSpace(r.Pattern)
+	if r.Pattern == "" {
+		return fmt.Errorf("empty host pattern")
+	}
+	if r.Match == "" {
+		r.Match = HostMatchExact
+	}
+
+	var expr string
+	switch r.Match {
+	case HostMatchExact:
+		r.Pattern = normalizeHostname(r.Pattern)
+		r.compiled = nil
+		return nil
+	case HostMatchWildcard:
+		// '*' matches any run of characters within a single label
+		r.Pattern = strings.ToLower(r.Pattern)
+		expr = "^" + strings.ReplaceAll(regexp.QuoteMeta(r.Pattern), `\*`, `[^.]*`) + "$"
+	case HostMatchRegex:
+		expr = "(?i)" + r.Pattern
+	default:
+		return fmt.Errorf("unknown match type %q for pattern %q", r.Match, r.Pattern)
+	}
+
+	compiled, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", r.Pattern, err)
+	}
+	r.compiled = compiled
+	return nil
+}
+
+// matches reports whether the normalized hostname matches the rule
+func (r *HostRule) matches(hostname string) bool {
+	if r.Match == HostMatchExact {
+		return hostname == r.Pattern
+	}
+	return r.compiled != nil && r.compiled.MatchString(hostname)
+}
+
+// compileHostRules validates and compiles a list of rules
+func compileHostRules(rules []HostRule) ([]HostRule, error) {
+	compiled := make([]HostRule, 0, len(rules))
+	for i := range rules {
+		rule := rules[i]
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+// matchHostRule returns the first rule matching the hostname, if any
+func matchHostRule(rules []HostRule, hostname string) *HostRule {
+	for i := range rules {
+		if rules[i].matches(hostname) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// isHostEnabled decides whether Proxy Protocol handling applies to hostname.
+// Without host rules the global toggle applies to every host. Once rules are
+// configured, only hosts matched by an enabled rule are handled.
+func isHostEnabled(hostname string) bool {
+	config.mu.RLock()
+	enabled := config.Enabled
+	hasRules := len(config.HostRules) > 0
+	config.mu.RUnlock()
+
+	if !enabled {
+		return false
+	}
+	if !hasRules {
+		return true
+	}
+
+	// The generation is taken before the rules are read, rules changed after
+	// that are followed by a reset that keeps this decision out of the cache
+	hostname = normalizeHostname(hostname)
+	decision, ok, generation := hostDecisions.get(hostname)
+	if ok {
+		return decision
+	}
+
+	config.mu.RLock()
+	rule := matchHostRule(config.HostRules, hostname)
+	decision = rule != nil && rule.Enabled
+	config.mu.RUnlock()
+
+	hostDecisions.put(hostname, decision, generation)
+	return decision
+}
+
+// handleAPIHostRules lists (GET) or replaces (POST) the host rules.
+// GET with ?test=<hostname> reports the decision for that hostname.
+func handleAPIHostRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if hostname := r.URL.Query().Get("test"); hostname != "" {
+			normalized := normalizeHostname(hostname)
+			response := HostRuleTestResponse{
+				Hostname: normalized,
+				Enabled:  isHostEnabled(normalized),
+			}
+			config.mu.RLock()
+			if rule := matchHostRule(config.HostRules, normalized); rule != nil {
+				response.Rule = rule.Pattern
+			}
+			config.mu.RUnlock()
+			writeJSON(w, response)
+			return
+		}
+
+		config.mu.RLock()
+		rules := append([]HostRule{}, config.HostRules...)
+		config.mu.RUnlock()
+		writeJSON(w, HostRulesResponse{Rules: rules})
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var req HostRulesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		rules, err := compileHostRules(req.Rules)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config.mu.Lock()
+		config.HostRules = rules
+		config.mu.Unlock()
+		hostDecisions.reset()
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		apiLog.Info("Host rules updated", "rules", len(rules))
+		events.record(EventSourcePlugin, "hostRulesUpdated", fmt.Sprintf("Host rules updated: %d rule(s)", len(rules)))
+		writeJSON(w, HostRulesResponse{Rules: rules})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
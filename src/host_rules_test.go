@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// Test host rule matching
+func TestHostRuleMatching(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     HostRule
+		hostname string
+		expected bool
+	}{
+		{"exact match", HostRule{Pattern: "example.com", Match: HostMatchExact}, "example.com", true},
+		{"exact match is case insensitive", HostRule{Pattern: "Example.COM", Match: HostMatchExact}, "example.com", true},
+		{"exact mismatch", HostRule{Pattern: "example.com", Match: HostMatchExact}, "www.example.com", false},
+		{"default match type is exact", HostRule{Pattern: "example.com"}, "example.com", true},
+		{"wildcard subdomain", HostRule{Pattern: "*.example.com", Match: HostMatchWildcard}, "api.example.com", true},
+		{"wildcard does not match apex", HostRule{Pattern: "*.example.com", Match: HostMatchWildcard}, "example.com", false},
+		{"wildcard stays within a label", HostRule{Pattern: "*.example.com", Match: HostMatchWildcard}, "a.b.example.com", false},
+		{"wildcard partial label", HostRule{Pattern: "lb-*.example.com", Match: HostMatchWildcard}, "lb-eu1.example.com", true},
+		{"regex match", HostRule{Pattern: `^(a|b)\.example\.org$`, Match: HostMatchRegex}, "b.example.org", true},
+		{"regex mismatch", HostRule{Pattern: `^(a|b)\.example\.org$`, Match: HostMatchRegex}, "c.example.org", false},
+		{"regex is case insensitive", HostRule{Pattern: `^api\.`, Match: HostMatchRegex}, "api.example.org", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			if err := rule.compile(); err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if got := rule.matches(normalizeHostname(tt.hostname)); got != tt.expected {
+				t.Errorf("matches(%q) = %t, expected %t", tt.hostname, got, tt.expected)
+			}
+		})
+	}
+}
+
+// Test host rule validation
+func TestHostRuleValidation(t *testing.T) {
+	invalid := []HostRule{
+		{Pattern: "", Match: HostMatchExact},
+		{Pattern: "example.com", Match: "prefix"},
+		{Pattern: "([", Match: HostMatchRegex},
+	}
+	for _, rule := range invalid {
+		if _, err := compileHostRules([]HostRule{rule}); err == nil {
+			t.Errorf("Expected error for rule %+v", rule)
+		}
+	}
+}
+
+// Test hostname normalization
+func TestNormalizeHostname(t *testing.T) {
+	tests := map[string]string{
+		"Example.com":       "example.com",
+		"example.com:8443":  "example.com",
+		"example.com.":      "example.com",
+		"[2001:db8::1]:443": "2001:db8::1",
+		" example.com ":     "example.com",
+	}
+	for input, expected := range tests {
+		if got := normalizeHostname(input); got != expected {
+			t.Errorf("normalizeHostname(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+// Test per-host enablement decisions
+func TestIsHostEnabled(t *testing.T) {
+	t.Run("No rules uses global toggle", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) { c.Enabled, c.HostRules = true, nil })
+		if !isHostEnabled("anything.example.com") {
+			t.Error("Expected host to be enabled without rules")
+		}
+	})
+
+	t.Run("Global toggle disabled overrides rules", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = false, []HostRule{{Pattern: "example.com", Enabled: true}}
+		})
+		if isHostEnabled("example.com") {
+			t.Error("Expected host to be disabled when plugin is disabled")
+		}
+	})
+
+	t.Run("First matching rule wins", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, []HostRule{
+				{Pattern: "internal.example.com", Match: HostMatchExact, Enabled: false},
+				{Pattern: "*.example.com", Match: HostMatchWildcard, Enabled: true},
+			}
+		})
+		if isHostEnabled("internal.example.com") {
+			t.Error("Expected internal.example.com to be disabled")
+		}
+		if !isHostEnabled("lb.example.com:443") {
+			t.Error("Expected lb.example.com to be enabled")
+		}
+	})
+
+	t.Run("Unmatched hosts are disabled once rules exist", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, []HostRule{{Pattern: "example.com", Enabled: true}}
+		})
+		if isHostEnabled("other.org") {
+			t.Error("Expected unmatched host to be disabled")
+		}
+	})
+
+	t.Run("Decisions made before a reset are not cached", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, []HostRule{{Pattern: "example.com", Enabled: true}}
+		})
+		_, _, generation := hostDecisions.get("example.com")
+		hostDecisions.reset()
+		hostDecisions.put("example.com", false, generation)
+		if _, ok, _ := hostDecisions.get("example.com"); ok {
+			t.Error("Expected the stale decision to be dropped")
+		}
+		if !isHostEnabled("example.com") {
+			t.Error("Expected example.com to be enabled")
+		}
+	})
+}
+
+// Test host rules API
+func TestAPIHostRules(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) { c.Enabled, c.HostRules = true, nil })
+
+	t.Run("POST replaces rules", func(t *testing.T) {
+		body := `{"rules":[{"pattern":"*.lb.example.com","match":"wildcard","enabled":true}]}`
+		req := httptest.NewRequest(http.MethodPost, "/ui/api/hosts", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", issueTestCSRFToken(t))
+		rr := httptest.NewRecorder()
+		handleAPIHostRules(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if !isHostEnabled("a.lb.example.com") {
+			t.Error("Expected rule to be applied")
+		}
+	})
+
+	t.Run("GET lists rules", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ui/api/hosts", nil)
+		rr := httptest.NewRecorder()
+		handleAPIHostRules(rr, req)
+
+		var response HostRulesResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse JSON response: %v", err)
+		}
+		if len(response.Rules) != 1 || response.Rules[0].Pattern != "*.lb.example.com" {
+			t.Errorf("Unexpected rules: %+v", response.Rules)
+		}
+	})
+
+	t.Run("GET test reports decision", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ui/api/hosts?test=A.lb.example.com", nil)
+		rr := httptest.NewRecorder()
+		handleAPIHostRules(rr, req)
+
+		var response HostRuleTestResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse JSON response: %v", err)
+		}
+		if !response.Enabled || response.Rule != "*.lb.example.com" {
+			t.Errorf("Unexpected decision: %+v", response)
+		}
+	})
+
+	t.Run("POST rejects invalid rules", func(t *testing.T) {
+		body := `{"rules":[{"pattern":"([","match":"regex","enabled":true}]}`
+		req := httptest.NewRequest(http.MethodPost, "/ui/api/hosts", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", issueTestCSRFToken(t))
+		rr := httptest.NewRecorder()
+		handleAPIHostRules(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("POST without CSRF token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/ui/api/hosts", strings.NewReader(`{"rules":[]}`))
+		rr := httptest.NewRecorder()
+		handleAPIHostRules(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+}
+
+// Test sniff handler honours host rules
+func TestSniffHostRules(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, []HostRule{{Pattern: "lb.example.com", Enabled: true}}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+	peer := net.JoinHostPort(testUpstream, "5000")
+
+	if rr := serveSniff(t, plugin.DynamicSniffForwardRequest{Method: "GET", Hostname: "other.example.com", RemoteAddr: peer}, "host-1"); rr.Code != 284 {
+		t.Errorf("Expected status code 284 (UNHANDLED) for unmatched host, got %d", rr.Code)
+	}
+	if rr := serveSniff(t, plugin.DynamicSniffForwardRequest{Method: "GET", Hostname: "LB.example.com:443", RemoteAddr: peer}, "host-2"); rr.Code != 280 {
+		t.Errorf("Expected status code 280 (CAPTURED) for matched host, got %d", rr.Code)
+	}
+	pendingRequests.take("host-2")
+}
+
+// Test config persistence round trip
+func TestConfigPersistence(t *testing.T) {
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+	defer func() { configPath = oldPath }()
+
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, []HostRule{{Pattern: "*.example.com", Match: HostMatchWildcard, Enabled: true}}
+	})
+	if err := saveConfig(); err != nil {
+		t.Fatalf("saveConfig failed: %v", err)
+	}
+
+	config.mu.Lock()
+	config.Enabled = false
+	config.HostRules = nil
+	config.mu.Unlock()
+
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if !isHostEnabled("api.example.com") {
+		t.Error("Expected persisted rule to be restored")
+	}
+
+	if err := os.WriteFile(configPath, []byte("{invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(); err == nil {
+		t.Error("Expected error for invalid config file")
+	}
+}
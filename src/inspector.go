@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxInspectorEntries bounds the number of headers kept by the inspector
+	maxInspectorEntries = 100
+	// maxInspectorRawBytes limits the raw bytes stored per header
+	maxInspectorRawBytes = 512
+)
+
+// Inspector outcomes
+const (
+	InspectorOutcomeParsed   = "parsed"    // a Proxy Protocol header was decoded
+	InspectorOutcomeError    = "error"     // the header could not be read or parsed
+	InspectorOutcomeNoHeader = "no_header" // the data did not start with a header
+)
+
+// InspectedTLV is a decoded v2 TLV
+type InspectedTLV struct {
+	Type   int    `json:"type"`
+	Name   string `json:"name"`
+	Length int    `json:"length"`
+	Value  string `json:"value_hex"`
+	// Decoded is set for TLVs mapped in the custom TLV registry
+	Decoded string `json:"decoded,omitempty"`
+}
+
+// InspectorEntry is a recently received header with its decoded fields
+type InspectorEntry struct {
+	ID             uint64         `json:"id"`
+	Time           time.Time      `json:"time"`
+	Origin         string         `json:"origin"`
+	PeerAddr       string         `json:"peer_addr"`
+	Hostname       string         `json:"hostname,omitempty"`
+	Outcome        string         `json:"outcome"`
+	Error          string         `json:"error,omitempty"`
+	Raw            string         `json:"raw_hex"`
+	RawTruncated   bool           `json:"raw_truncated,omitempty"`
+	HeaderLength   int            `json:"header_length,omitempty"`
+	Version        int            `json:"version,omitempty"`
+	Command        string         `json:"command,omitempty"`
+	TransportProto string         `json:"transport_proto,omitempty"`
+	SourceAddr     string         `json:"source_addr,omitempty"`
+	SourcePort     int            `json:"source_port,omitempty"`
+	DestAddr       string         `json:"dest_addr,omitempty"`
+	DestPort       int            `json:"dest_port,omitempty"`
+	TLVs           []InspectedTLV `json:"tlvs,omitempty"`
+}
+
+// InspectorResponse is returned by the inspector API, newest header first
+type InspectorResponse struct {
+	Total   int              `json:"total"`
+	Entries []InspectorEntry `json:"entries"`
+}
+
+// headerInspector is a ring buffer of the most recently received headers
+type headerInspector struct {
+	mu      sync.RWMutex
+	entries []InspectorEntry
+	next    int // slot written next
+	count   int
+	lastID  uint64
+}
+
+var inspector = newHeaderInspector(maxInspectorEntries)
+
+func newHeaderInspector(size int) *headerInspector {
+	return &headerInspector{entries: make([]InspectorEntry, size)}
+}
+
+// record stores a header, overwriting the oldest when the buffer is full.
+// raw holds the received bytes; when a header was parsed it is cut to the header length.
+func (h *headerInspector) record(origin, peerAddr, hostname string, raw []byte, info *ProxyProtocolInfo, err error) {
+	entry := InspectorEntry{
+		Time:     time.Now(),
+		Origin:   origin,
+		PeerAddr: peerAddr,
+		Hostname: hostname,
+		Outcome:  InspectorOutcomeNoHeader,
+	}
+
+	switch {
+	case err != nil:
+		entry.Outcome = InspectorOutcomeError
+		entry.Error = err.Error()
+	case info != nil:
+		entry.Outcome = InspectorOutcomeParsed
+		entry.HeaderLength = info.HeaderLength
+		entry.Version = info.Version
+		entry.Command = info.Command
+		entry.TransportProto = info.TransportProto
+		entry.SourceAddr = info.SourceAddr
+		entry.SourcePort = info.SourcePort
+		entry.DestAddr = info.DestAddr
+		entry.DestPort = info.DestPort
+		entry.TLVs = inspectedTLVs(info.TLVs)
+		if info.HeaderLength > 0 && info.HeaderLength < len(raw) {
+			raw = raw[:info.HeaderLength]
+		}
+	}
+
+	if len(raw) > maxInspectorRawBytes {
+		raw = raw[:maxInspectorRawBytes]
+		entry.RawTruncated = true
+	}
+	entry.Raw = hex.EncodeToString(raw)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	entry.ID = h.lastID
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.count < len(h.entries) {
+		h.count++
+	}
+}
+
+// inspectedTLVs converts TLVs for the API, named and decoded by the custom TLV registry
+func inspectedTLVs(tlvs []ProxyProtocolTLV) []InspectedTLV {
+	mappings := make(map[byte]CustomTLV)
+	for _, mapping := range currentCustomTLVs() {
+		mappings[byte(mapping.Type)] = mapping
+	}
+
+	var result []InspectedTLV
+	for _, tlv := range tlvs {
+		inspected := InspectedTLV{
+			Type:   int(tlv.Type),
+			Name:   tlv.Name(),
+			Length: len(tlv.Value),
+			Value:  hex.EncodeToString(tlv.Value),
+		}
+		if mapping, ok := mappings[tlv.Type]; ok {
+			inspected.Name = mapping.Name
+			inspected.Decoded, _ = mapping.decode(tlv.Value)
+		}
+		result = append(result, inspected)
+	}
+	return result
+}
+
+// list returns the entries with the given outcome (all when empty), newest first
+func (h *headerInspector) list(outcome string) []InspectorEntry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	result := make([]InspectorEntry, 0, h.count)
+	for i := 1; i <= h.count; i++ {
+		entry := h.entries[(h.next-i+len(h.entries))%len(h.entries)]
+		if outcome == "" || entry.Outcome == outcome {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// handleAPIInspector returns the recently received headers.
+// Query parameters: outcome (parsed, error, no_header), download.
+func handleAPIInspector(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	outcome := r.URL.Query().Get("outcome")
+	switch outcome {
+	case "", InspectorOutcomeParsed, InspectorOutcomeError, InspectorOutcomeNoHeader:
+	default:
+		http.Error(w, "Invalid outcome", http.StatusBadRequest)
+		return
+	}
+
+	entries := inspector.list(outcome)
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="proxy-protocol-headers.json"`)
+	}
+	writeJSON(w, InspectorResponse{Total: len(entries), Entries: entries})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// useInspector replaces the global inspector for the duration of the test
+func useInspector(t *testing.T, size int) *headerInspector {
+	t.Helper()
+	old := inspector
+	inspector = newHeaderInspector(size)
+	t.Cleanup(func() { inspector = old })
+	return inspector
+}
+
+// Test the ring buffer keeps the newest entries
+func TestHeaderInspectorRing(t *testing.T) {
+	h := newHeaderInspector(3)
+	for i := 0; i < 5; i++ {
+		h.record(ConnectionOriginIngress, "192.0.2.1:1000", "", []byte("GET /"), nil, nil)
+	}
+	h.record(ConnectionOriginIngress, "192.0.2.1:1000", "", []byte("PROXY bad\r\n"), nil, errors.New("bad header"))
+
+	entries := h.list("")
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].ID != 6 || entries[2].ID != 4 {
+		t.Errorf("Expected IDs 6..4 newest first, got %d..%d", entries[0].ID, entries[2].ID)
+	}
+	if entries[0].Outcome != InspectorOutcomeError || entries[0].Error != "bad header" {
+		t.Errorf("Unexpected newest entry %+v", entries[0])
+	}
+	if got := len(h.list(InspectorOutcomeNoHeader)); got != 2 {
+		t.Errorf("Expected 2 no_header entries, got %d", got)
+	}
+}
+
+// Test decoded fields and raw bytes of a parsed header
+func TestHeaderInspectorParsedEntry(t *testing.T) {
+	h := newHeaderInspector(10)
+
+	header := ProxyProtocolV2Prefix + "\x21\x11\x00\x11" +
+		"\xc0\x00\x02\x64\xc6\x33\x64\x32\xb2\x6e\x01\xbb" +
+		"\x01\x00\x02h2"
+	data := []byte(header + "GET / HTTP/1.1\r\n")
+	_, info, err := processProxyProtocolData(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h.record(ConnectionOriginIngress, "203.0.113.9:5000", "app.example.com", data, info, nil)
+
+	entry := h.list(InspectorOutcomeParsed)[0]
+	if entry.HeaderLength != len(header) || len(entry.Raw) != 2*len(header) {
+		t.Errorf("Expected raw bytes cut to the %d byte header, got %d hex chars", len(header), len(entry.Raw))
+	}
+	if entry.SourceAddr != "192.0.2.100" || entry.SourcePort != 45678 || entry.DestPort != 443 {
+		t.Errorf("Unexpected decoded addresses %+v", entry)
+	}
+	if len(entry.TLVs) != 1 || entry.TLVs[0].Name != "ALPN" || entry.TLVs[0].Value != "6832" {
+		t.Errorf("Unexpected TLVs %+v", entry.TLVs)
+	}
+
+	h.record(ConnectionOriginIngress, "203.0.113.9:5000", "", make([]byte, maxInspectorRawBytes+10), nil, nil)
+	if entry := h.list("")[0]; !entry.RawTruncated || len(entry.Raw) != 2*maxInspectorRawBytes {
+		t.Errorf("Expected raw bytes truncated to %d, got %d hex chars", maxInspectorRawBytes, len(entry.Raw))
+	}
+}
+
+// Test listener and ingress headers reach the inspector
+func TestInspectorRecording(t *testing.T) {
+	h := useInspector(t, 10)
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.trustedUpstreams = testPrefixes(t, "203.0.113.7")
+	})
+
+	ppListener := NewProxyProtocolListener(&singleConnListener{conn: &mockConn{data: []byte("PROXY TCP4 192.0.2.55 198.51.100.1 41000 80\r\nGET / HTTP/1.1\r\n\r\n")}}, nil, listenerLog)
+	conn, err := ppListener.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	conn.Close()
+
+	serveSniff(t, plugin.DynamicSniffForwardRequest{Hostname: "app.example.com", RemoteAddr: "203.0.113.7:6000"}, "insp-1")
+	req := httptest.NewRequest(http.MethodPost, INGRESS_PATH, strings.NewReader("PROXY TCP4 invalid_format\r\n"))
+	req.Header.Set("X-Zoraxy-RequestID", "insp-1")
+	handleProxyProtocolIngress(httptest.NewRecorder(), req)
+
+	entries := h.list("")
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Origin != ConnectionOriginIngress || entries[0].Outcome != InspectorOutcomeError ||
+		entries[0].PeerAddr != "203.0.113.7:6000" || entries[0].Hostname != "app.example.com" {
+		t.Errorf("Unexpected ingress entry %+v", entries[0])
+	}
+	if entries[1].Origin != ConnectionOriginListener || entries[1].Outcome != InspectorOutcomeParsed || entries[1].SourceAddr != "192.0.2.55" {
+		t.Errorf("Unexpected listener entry %+v", entries[1])
+	}
+}
+
+// Test the inspector API
+func TestInspectorAPI(t *testing.T) {
+	h := useInspector(t, 10)
+	h.record(ConnectionOriginIngress, "192.0.2.1:1000", "", []byte("GET /"), nil, nil)
+	h.record(ConnectionOriginIngress, "192.0.2.1:1000", "", []byte("PROXY x"), nil, errors.New("bad"))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleAPIInspector(rr, httptest.NewRequest(http.MethodGet, UI_PATH+"/api/inspector"+query, nil))
+		return rr
+	}
+
+	rr := get("?outcome=error")
+	var response InspectorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Total != 1 || response.Entries[0].Error != "bad" {
+		t.Errorf("Expected the error entry only, got %+v", response)
+	}
+
+	if rr := get("?download=1"); !strings.Contains(rr.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("Expected attachment download, got %q", rr.Header().Get("Content-Disposition"))
+	}
+	if rr := get("?outcome=bogus"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handleAPIInspector(rr, httptest.NewRequest(http.MethodPost, UI_PATH+"/api/inspector", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
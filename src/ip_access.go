@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IP access decisions and scopes
+const (
+	IPAccessAllow = "allow"
+	IPAccessDeny  = "deny"
+
+	IPAccessScopeGlobal = "global"
+	IPAccessScopeHost   = "host"
+)
+
+// maxIPAccessDenyBody limits the configurable body of denied requests
+const maxIPAccessDenyBody = 4096
+
+// accessEntry is an inclusive address range or a country parsed from an access entry
+type accessEntry struct {
+	from, to netip.Addr
+	country  string // ISO 3166-1 alpha-2 code, matched against the GeoIP databases
+	entry    string
+}
+
+// matches reports whether the client lies within the range or country
+func (e accessEntry) matches(addr netip.Addr, country string) bool {
+	if e.country != "" {
+		return e.country == country
+	}
+	return addr.BitLen() == e.from.BitLen() && e.from.Compare(addr) <= 0 && addr.Compare(e.to) <= 0
+}
+
+// lastAddr returns the highest address of a prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// parseAccessEntry parses a single address (192.0.2.1), a CIDR (192.0.2.0/24),
+// an inclusive range (192.0.2.10-192.0.2.20) or a country (country:DE) and
+// returns its normalized form
+func parseAccessEntry(entry string) (accessEntry, error) {
+	entry = strings.TrimSpace(entry)
+	if prefix, code, found := strings.Cut(entry, ":"); found && strings.EqualFold(prefix, "country") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return accessEntry{}, fmt.Errorf("invalid country %q: use a two letter ISO code like country:DE", entry)
+		}
+		return accessEntry{country: code, entry: "country:" + code}, nil
+	}
+	if from, to, found := strings.Cut(entry, "-"); found {
+		start, err := netip.ParseAddr(strings.TrimSpace(from))
+		if err != nil {
+			return accessEntry{}, fmt.Errorf("invalid range %q: %w", entry, err)
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(to))
+		if err != nil {
+			return accessEntry{}, fmt.Errorf("invalid range %q: %w", entry, err)
+		}
+		start, end = start.Unmap(), end.Unmap()
+		if start.BitLen() != end.BitLen() {
+			return accessEntry{}, fmt.Errorf("invalid range %q: mixed address families", entry)
+		}
+		if end.Less(start) {
+			return accessEntry{}, fmt.Errorf("invalid range %q: start is after end", entry)
+		}
+		return accessEntry{from: start, to: end, entry: start.String() + "-" + end.String()}, nil
+	}
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return accessEntry{}, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		prefix = prefix.Masked()
+		return accessEntry{from: prefix.Addr(), to: lastAddr(prefix), entry: prefix.String()}, nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return accessEntry{}, fmt.Errorf("invalid address %q: %w", entry, err)
+	}
+	addr = addr.Unmap()
+	return accessEntry{from: addr, to: addr, entry: addr.String()}, nil
+}
+
+// parseAccessEntries parses a list of entries, dropping duplicates
+func parseAccessEntries(entries []string) ([]accessEntry, error) {
+	parsed := make([]accessEntry, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		e, err := parseAccessEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[e.entry] {
+			seen[e.entry] = true
+			parsed = append(parsed, e)
+		}
+	}
+	return parsed, nil
+}
+
+// matchAccessEntry returns the first entry matching the client
+func matchAccessEntry(entries []accessEntry, addr netip.Addr, country string) (string, bool) {
+	for _, e := range entries {
+		if e.matches(addr, country) {
+			return e.entry, true
+		}
+	}
+	return "", false
+}
+
+// IPAccessList allows or denies client addresses. Country entries need a
+// GeoIP database; without one, clients have no country.
+type IPAccessList struct {
+	Allow []string `json:"allow"` // when set, only these clients are accepted
+	Deny  []string `json:"deny"`  // always rejected, checked first
+
+	allow, deny []accessEntry
+}
+
+// normalize parses both lists and rewrites the entries in their normalized form
+func (l *IPAccessList) normalize() error {
+	var err error
+	if l.allow, err = parseAccessEntries(l.Allow); err != nil {
+		return err
+	}
+	if l.deny, err = parseAccessEntries(l.Deny); err != nil {
+		return err
+	}
+	l.Allow = accessEntryStrings(l.allow)
+	l.Deny = accessEntryStrings(l.deny)
+	return nil
+}
+
+func accessEntryStrings(entries []accessEntry) []string {
+	result := make([]string, len(entries))
+	for i, e := range entries {
+		result[i] = e.entry
+	}
+	return result
+}
+
+// HostIPAccess applies additional lists to the hostnames matched by a host pattern
+type HostIPAccess struct {
+	Pattern string `json:"pattern"`
+	Match   string `json:"match"` // "exact", "wildcard" or "regex"
+	IPAccessList
+
+	rule HostRule
+}
+
+// IPAccess restricts requests by the client address of the PROXY header.
+// The global lists apply to every host, the lists of the first matching host
+// scope are checked in addition: a deny entry in either rejects the client,
+// and every non-empty allow list must contain it.
+type IPAccess struct {
+	IPAccessList
+	Hosts      []HostIPAccess `json:"hosts"`
+	DenyStatus int            `json:"deny_status"` // status code of denied requests, default 403
+	DenyBody   string         `json:"deny_body"`   // body of denied requests
+}
+
+// IPAccessResponse is returned by the IP access API
+type IPAccessResponse struct {
+	IPAccess
+	Decisions map[string]uint64 `json:"decisions"` // decisions by scope and outcome so far
+}
+
+// IPAccessTestResponse reports the decision for an address and hostname
+type IPAccessTestResponse struct {
+	Address string `json:"address"`
+	Host    string `json:"host,omitempty"`
+	Country string `json:"country,omitempty"`
+	ipAccessDecision
+}
+
+// ipAccessDecision is the outcome of an access check
+type ipAccessDecision struct {
+	Allowed bool   `json:"allowed"`
+	Scope   string `json:"scope"`          // global or the pattern of the host scope
+	Rule    string `json:"rule,omitempty"` // the matching entry, empty when no list matched
+}
+
+func defaultIPAccess() IPAccess {
+	return IPAccess{DenyStatus: http.StatusForbidden, DenyBody: "Forbidden"}
+}
+
+// normalize validates the lists, host scopes and deny response
+func (a *IPAccess) normalize() error {
+	if err := a.IPAccessList.normalize(); err != nil {
+		return err
+	}
+	hosts := make([]HostIPAccess, 0, len(a.Hosts))
+	for i := range a.Hosts {
+		host := a.Hosts[i]
+		host.rule = HostRule{Pattern: host.Pattern, Match: host.Match, Enabled: true}
+		if err := host.rule.compile(); err != nil {
+			return fmt.Errorf("host scope %d: %w", i+1, err)
+		}
+		host.Pattern, host.Match = host.rule.Pattern, host.rule.Match
+		if err := host.IPAccessList.normalize(); err != nil {
+			return fmt.Errorf("host scope %s: %w", host.Pattern, err)
+		}
+		hosts = append(hosts, host)
+	}
+	a.Hosts = hosts
+
+	if a.DenyStatus == 0 {
+		a.DenyStatus = http.StatusForbidden
+	}
+	if a.DenyStatus < 400 || a.DenyStatus > 599 {
+		return fmt.Errorf("deny status %d is not an error status (400-599)", a.DenyStatus)
+	}
+	if len(a.DenyBody) > maxIPAccessDenyBody {
+		return fmt.Errorf("deny body exceeds %d bytes", maxIPAccessDenyBody)
+	}
+	return nil
+}
+
+// clone returns a deep copy, so the lists can be used outside the config lock
+func (a IPAccess) clone() IPAccess {
+	a.Allow = append([]string{}, a.Allow...)
+	a.Deny = append([]string{}, a.Deny...)
+	a.Hosts = append([]HostIPAccess{}, a.Hosts...)
+	return a
+}
+
+// hostScope returns the first host scope matching the hostname, if any
+func (a *IPAccess) hostScope(hostname string) *HostIPAccess {
+	if hostname == "" {
+		return nil
+	}
+	hostname = normalizeHostname(hostname)
+	for i := range a.Hosts {
+		if a.Hosts[i].rule.matches(hostname) {
+			return &a.Hosts[i]
+		}
+	}
+	return nil
+}
+
+// decide checks a client address and its country (empty when unknown)
+// against the global lists and the host scope
+func (a *IPAccess) decide(addr netip.Addr, country, hostname string) ipAccessDecision {
+	addr = addr.Unmap()
+	host := a.hostScope(hostname)
+
+	if entry, ok := matchAccessEntry(a.deny, addr, country); ok {
+		return ipAccessDecision{Scope: IPAccessScopeGlobal, Rule: entry}
+	}
+	if host != nil {
+		if entry, ok := matchAccessEntry(host.deny, addr, country); ok {
+			return ipAccessDecision{Scope: host.Pattern, Rule: entry}
+		}
+	}
+
+	decision := ipAccessDecision{Allowed: true, Scope: IPAccessScopeGlobal}
+	if len(a.allow) > 0 {
+		entry, ok := matchAccessEntry(a.allow, addr, country)
+		if !ok {
+			return ipAccessDecision{Scope: IPAccessScopeGlobal}
+		}
+		decision.Rule = entry
+	}
+	if host != nil && len(host.allow) > 0 {
+		entry, ok := matchAccessEntry(host.allow, addr, country)
+		if !ok {
+			return ipAccessDecision{Scope: host.Pattern}
+		}
+		decision = ipAccessDecision{Allowed: true, Scope: host.Pattern, Rule: entry}
+	}
+	return decision
+}
+
+// accessScopeLabel reduces a scope, global or a host pattern, to the metric label
+func accessScopeLabel(scope string) string {
+	if scope == IPAccessScopeGlobal {
+		return IPAccessScopeGlobal
+	}
+	return IPAccessScopeHost
+}
+
+// checkIPAccess applies the access rules to the client address of a request.
+// Every decision is counted, denials and allows by a matching entry are
+// logged at warn and info, other allows at debug; connections without a client address
+// (LOCAL, UNKNOWN) are not subject to the rules. The GeoIP record is kept in
+// src for the client headers. The returned settings carry the response for
+// denied requests.
+func checkIPAccess(src *headerSource, attrs ...any) (bool, IPAccess) {
+	config.mu.RLock()
+	access := config.IPAccess
+	config.mu.RUnlock()
+
+	info, hostname := src.info, src.hostname
+	log := accessLog.With(attrs...).With("source", info.SourceAddr, "host", hostname)
+	addr, err := netip.ParseAddr(info.SourceAddr)
+	if err != nil {
+		log.Debug("Access check skipped, no client address", "command", info.Command)
+		return true, access
+	}
+
+	country := ""
+	if geoip.loaded() {
+		country = src.geoIP().Country
+		log = log.With("country", country)
+	}
+
+	decision := access.decide(addr, country, hostname)
+	if decision.Allowed {
+		metrics.ipAccess.inc(accessScopeLabel(decision.Scope), IPAccessAllow)
+		// Requests no entry matched are most of the traffic, they are only counted
+		if decision.Rule != "" {
+			log.Info("Access allowed", "scope", decision.Scope, "rule", decision.Rule)
+		} else {
+			log.Debug("Access allowed", "scope", decision.Scope)
+		}
+		return true, access
+	}
+	metrics.ipAccess.inc(accessScopeLabel(decision.Scope), IPAccessDeny)
+	rule := decision.Rule
+	if rule == "" {
+		rule = "not in allow list"
+	}
+	log.Warn("Access denied", "scope", decision.Scope, "rule", rule, "status", access.DenyStatus)
+	recordFailure(info.SourceAddr, BanReasonAccess, attrs...)
+	return false, access
+}
+
+// writeIPAccessDenied answers a denied request with the configured status and body
+func writeIPAccessDenied(w http.ResponseWriter, access IPAccess) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(access.DenyStatus)
+	w.Write([]byte(access.DenyBody))
+}
+
+// currentIPAccess returns a copy of the configured rules
+func currentIPAccess() IPAccess {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.IPAccess.clone()
+}
+
+// handleAPIIPAccess returns (GET) or replaces (POST) the IP access rules.
+// GET with ?test=<address>[&host=<hostname>] reports the decision for that client.
+func handleAPIIPAccess(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if address := r.URL.Query().Get("test"); address != "" {
+			addr, err := netip.ParseAddr(strings.TrimSpace(address))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid address %q", address), http.StatusBadRequest)
+				return
+			}
+			host := normalizeHostname(r.URL.Query().Get("host"))
+			country := geoip.lookup(addr).Country
+			access := currentIPAccess()
+			writeJSON(w, IPAccessTestResponse{Address: addr.Unmap().String(), Host: host, Country: country,
+				ipAccessDecision: access.decide(addr, country, host)})
+			return
+		}
+		writeJSON(w, IPAccessResponse{IPAccess: currentIPAccess(), Decisions: metrics.ipAccess.snapshot()})
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var req IPAccess
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := req.normalize(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config.mu.Lock()
+		config.IPAccess = req
+		config.mu.Unlock()
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		apiLog.Info("IP access rules updated", "allow", strings.Join(req.Allow, ","), "deny", strings.Join(req.Deny, ","),
+			"hosts", len(req.Hosts), "deny_status", req.DenyStatus)
+		events.record(EventSourcePlugin, "ipAccessUpdated",
+			fmt.Sprintf("IP access: %d allowed, %d denied, %d host scope(s)", len(req.Allow), len(req.Deny), len(req.Hosts)))
+		writeJSON(w, IPAccessResponse{IPAccess: currentIPAccess(), Decisions: metrics.ipAccess.snapshot()})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test parsing of addresses, CIDRs, ranges and countries
+func TestParseIPRange(t *testing.T) {
+	valid := map[string]string{
+		"192.0.2.1":                  "192.0.2.1",
+		"::ffff:192.0.2.1":           "192.0.2.1",
+		"192.0.2.77/24":              "192.0.2.0/24",
+		"2001:db8::1/64":             "2001:db8::/64",
+		" 192.0.2.10 - 192.0.2.20 ":  "192.0.2.10-192.0.2.20",
+		"2001:db8::1-2001:db8::ffff": "2001:db8::1-2001:db8::ffff",
+		"Country:de":                 "country:DE",
+	}
+	for entry, expected := range valid {
+		if r, err := parseAccessEntry(entry); err != nil || r.entry != expected {
+			t.Errorf("Expected %q for %q, got %q (%v)", expected, entry, r.entry, err)
+		}
+	}
+
+	for _, entry := range []string{"", "192.0.2", "192.0.2.0/33", "192.0.2.20-192.0.2.10", "192.0.2.1-2001:db8::1", "example.com", "country:DEU", "country:1A"} {
+		if _, err := parseAccessEntry(entry); err == nil {
+			t.Errorf("Expected an error for %q", entry)
+		}
+	}
+
+	r, _ := parseAccessEntry("10.0.0.0/30")
+	for addr, expected := range map[string]bool{"9.255.255.255": false, "10.0.0.0": true, "10.0.0.3": true, "10.0.0.4": false, "::a00:1": false} {
+		if got := r.matches(netip.MustParseAddr(addr), ""); got != expected {
+			t.Errorf("Expected contains(%s) to be %v, got %v", addr, expected, got)
+		}
+	}
+}
+
+// Test decisions over the global lists and host scopes
+func TestIPAccessDecide(t *testing.T) {
+	access := IPAccess{
+		IPAccessList: IPAccessList{Allow: []string{"192.0.2.0/24", "2001:db8::/32"}, Deny: []string{"192.0.2.66"}},
+		Hosts: []HostIPAccess{
+			{Pattern: "admin.example.com", IPAccessList: IPAccessList{Allow: []string{"192.0.2.10-192.0.2.20"}}},
+			{Pattern: "*.example.com", Match: HostMatchWildcard, IPAccessList: IPAccessList{Deny: []string{"192.0.2.128/25"}}},
+		},
+	}
+	if err := access.normalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr, host string
+		allowed    bool
+		scope      string
+		rule       string
+	}{
+		{"192.0.2.1", "", true, IPAccessScopeGlobal, "192.0.2.0/24"},
+		{"::ffff:192.0.2.1", "", true, IPAccessScopeGlobal, "192.0.2.0/24"},
+		{"198.51.100.1", "", false, IPAccessScopeGlobal, ""},
+		{"192.0.2.66", "www.example.com", false, IPAccessScopeGlobal, "192.0.2.66"},
+		{"192.0.2.15", "ADMIN.example.com:443", true, "admin.example.com", "192.0.2.10-192.0.2.20"},
+		{"192.0.2.30", "admin.example.com", false, "admin.example.com", ""},
+		{"192.0.2.200", "www.example.com", false, "*.example.com", "192.0.2.128/25"},
+		{"192.0.2.30", "www.example.com", true, IPAccessScopeGlobal, "192.0.2.0/24"},
+		{"192.0.2.200", "other.test", true, IPAccessScopeGlobal, "192.0.2.0/24"},
+	}
+	for _, tt := range tests {
+		got := access.decide(netip.MustParseAddr(tt.addr), "", tt.host)
+		if got.Allowed != tt.allowed || got.Scope != tt.scope || got.Rule != tt.rule {
+			t.Errorf("Expected %s on %q to be %v in %s by %q, got %+v", tt.addr, tt.host, tt.allowed, tt.scope, tt.rule, got)
+		}
+	}
+}
+
+// Test validation of the deny response and host scopes
+func TestIPAccessNormalize(t *testing.T) {
+	access := IPAccess{}
+	if err := access.normalize(); err != nil || access.DenyStatus != http.StatusForbidden {
+		t.Errorf("Expected the default deny status, got %d (%v)", access.DenyStatus, err)
+	}
+
+	invalid := map[string]IPAccess{
+		"success status": {DenyStatus: 200},
+		"status too big": {DenyStatus: 600},
+		"long body":      {DenyBody: strings.Repeat("x", maxIPAccessDenyBody+1)},
+		"empty pattern":  {Hosts: []HostIPAccess{{Pattern: " "}}},
+		"bad host entry": {Hosts: []HostIPAccess{{Pattern: "example.com", IPAccessList: IPAccessList{Deny: []string{"nope"}}}}},
+		"bad entry":      {IPAccessList: IPAccessList{Allow: []string{"10.0.0.0/8", "10.0.0.1-10.0.0.0"}}},
+	}
+	for name, access := range invalid {
+		if err := access.normalize(); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+// Test that the ingress answers denied clients with the configured response
+func TestIngressIPAccess(t *testing.T) {
+	logs := captureLogs(t, LogFormatText)
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.IPAccess = IPAccess{IPAccessList: IPAccessList{Deny: []string{"203.0.113.0/24"}}, DenyStatus: 451, DenyBody: "Not here"}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	ingress := func(id, source string, port int) *httptest.ResponseRecorder {
+		header, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+			SourceAddr: source, SourcePort: port, DestAddr: "198.51.100.47", DestPort: 443})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", INGRESS_PATH+"/", bytes.NewReader(append(header, "GET / HTTP/1.1\r\n\r\n"...)))
+		req.Header.Set("X-Zoraxy-RequestID", id)
+		rr := serveIngress(t, req, "example.com")
+		connections.remove(ingressConnectionID(&ProxyProtocolInfo{SourceAddr: source, SourcePort: port, DestAddr: "198.51.100.47", DestPort: 443}))
+		return rr
+	}
+
+	denied := metrics.ipAccess.get(IPAccessScopeGlobal, IPAccessDeny)
+	rr := ingress("ip-access-1", "203.0.113.9", 41001)
+	if rr.Code != 451 || rr.Body.String() != "Not here" {
+		t.Errorf("Expected 451 Not here, got %d %q", rr.Code, rr.Body.String())
+	}
+	if got := metrics.ipAccess.get(IPAccessScopeGlobal, IPAccessDeny); got != denied+1 {
+		t.Errorf("Expected the deny counter to be %d, got %d", denied+1, got)
+	}
+
+	allowed := metrics.ipAccess.get(IPAccessScopeGlobal, IPAccessAllow)
+	if rr := ingress("ip-access-2", "192.0.2.47", 41002); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", rr.Code)
+	}
+	if got := metrics.ipAccess.get(IPAccessScopeGlobal, IPAccessAllow); got != allowed+1 {
+		t.Errorf("Expected the allow counter to be %d, got %d", allowed+1, got)
+	}
+
+	// Denials are warnings, allows without a matching entry are only logged at debug
+	output := logs.String()
+	if !strings.Contains(output, "level=WARN msg=\"Access denied\"") {
+		t.Errorf("Expected the denial to be logged at warn, got:\n%s", output)
+	}
+	if strings.Contains(output, "Access allowed") {
+		t.Errorf("Expected the unmatched allow not to be logged at info, got:\n%s", output)
+	}
+
+	// Allows by a matching entry are logged at info
+	setConfig(t, func(c *PluginConfig) { c.IPAccess.Allow = []string{"192.0.2.0/24"} })
+	logs.Reset()
+	if rr := ingress("ip-access-3", "192.0.2.48", 41003); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", rr.Code)
+	}
+	if output := logs.String(); !strings.Contains(output, "level=INFO msg=\"Access allowed\"") || !strings.Contains(output, "rule=192.0.2.0/24") {
+		t.Errorf("Expected the matched allow to be logged at info, got:\n%s", output)
+	}
+}
+
+// Test the IP access API
+func TestHandleAPIIPAccess(t *testing.T) {
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+	defer func() { configPath = oldPath }()
+	setConfig(t, func(c *PluginConfig) { c.IPAccess = IPAccess{} })
+	token := issueTestCSRFToken(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/ui/api/access", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", token)
+		rr := httptest.NewRecorder()
+		handleAPIIPAccess(rr, req)
+		return rr
+	}
+
+	if rr := post(`{"deny":["10.0.0.0/33"]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, got %d", rr.Code)
+	}
+
+	rr := post(`{"allow":["10.1.2.3/8","10.0.0.0/8"],"hosts":[{"pattern":"Admin.Example.com","deny":["10.9.0.0/16"]}],"deny_body":"Go away"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response IPAccessResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Allow) != 1 || response.Allow[0] != "10.0.0.0/8" || response.Hosts[0].Pattern != "admin.example.com" || response.DenyStatus != http.StatusForbidden {
+		t.Errorf("Expected the normalized rules, got %+v", response.IPAccess)
+	}
+
+	req := httptest.NewRequest("GET", "/ui/api/access?test=10.9.1.1&host=admin.example.com", nil)
+	rr = httptest.NewRecorder()
+	handleAPIIPAccess(rr, req)
+	var test IPAccessTestResponse
+	if err := json.NewDecoder(rr.Body).Decode(&test); err != nil {
+		t.Fatal(err)
+	}
+	if test.Allowed || test.Scope != "admin.example.com" || test.Rule != "10.9.0.0/16" {
+		t.Errorf("Expected the host scope to deny the address, got %+v", test)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Go away"`) {
+		t.Errorf("Expected the rules to be persisted, got %s", data)
+	}
+	setConfig(t, func(c *PluginConfig) { c.IPAccess = IPAccess{} })
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if access := currentIPAccess(); len(access.Allow) != 1 || len(access.Hosts) != 1 || access.DenyBody != "Go away" {
+		t.Errorf("Expected the persisted rules to be restored, got %+v", access)
+	}
+	restored := currentIPAccess()
+	if decision := restored.decide(netip.MustParseAddr("10.9.1.1"), "", "admin.example.com"); decision.Allowed {
+		t.Error("Expected the restored host scope to be compiled")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Log output formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Log components, each with its own level
+const (
+	LogComponentPlugin   = "plugin"
+	LogComponentAPI      = "api"
+	LogComponentSniff    = "sniff"
+	LogComponentIngress  = "ingress"
+	LogComponentListener = "listener"
+	LogComponentRegistry = "registry"
+	LogComponentAccess   = "access"
+	LogComponentDev      = "dev"
+)
+
+// maxHexDumpBytes limits the payload bytes included in debug hex dumps
+const maxHexDumpBytes = 64
+
+var logComponents = []string{
+	LogComponentPlugin,
+	LogComponentAPI,
+	LogComponentSniff,
+	LogComponentIngress,
+	LogComponentListener,
+	LogComponentRegistry,
+	LogComponentAccess,
+	LogComponentDev,
+}
+
+// LoggingSettings is the persisted and API representation of the log configuration
+type LoggingSettings struct {
+	Format string            `json:"format"`
+	Levels map[string]string `json:"levels"`
+}
+
+// logOutput holds the shared output handler, swapped when the format changes
+type logOutput struct {
+	mu      sync.RWMutex
+	writer  io.Writer
+	format  string
+	handler slog.Handler
+}
+
+func newLogOutput(w io.Writer, format string) *logOutput {
+	output := &logOutput{writer: w}
+	output.setFormat(format)
+	return output
+}
+
+// setFormat switches between text and JSON output
+func (o *logOutput) setFormat(format string) error {
+	// Levels are filtered per component, the output accepts everything
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var handler slog.Handler
+	switch format {
+	case LogFormatText:
+		handler = slog.NewTextHandler(o.writer, options)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(o.writer, options)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	o.mu.Lock()
+	o.format = format
+	o.handler = handler
+	o.mu.Unlock()
+	return nil
+}
+
+func (o *logOutput) current() (slog.Handler, string) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.handler, o.format
+}
+
+var logOut = newLogOutput(os.Stdout, LogFormatText)
+
+// componentLevels holds the runtime adjustable level of every component
+var componentLevels = func() map[string]*slog.LevelVar {
+	levels := make(map[string]*slog.LevelVar, len(logComponents))
+	for _, component := range logComponents {
+		levels[component] = new(slog.LevelVar)
+	}
+	return levels
+}()
+
+// componentHandler filters records by the component level and tags them
+// with the component before passing them to the current output handler
+type componentHandler struct {
+	component string
+	level     *slog.LevelVar
+	ops       []func(slog.Handler) slog.Handler
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	handler, _ := logOut.current()
+	handler = handler.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	for _, op := range h.ops {
+		handler = op(handler)
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (h *componentHandler) with(op func(slog.Handler) slog.Handler) *componentHandler {
+	ops := append(append([]func(slog.Handler) slog.Handler{}, h.ops...), op)
+	return &componentHandler{component: h.component, level: h.level, ops: ops}
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+// newComponentLogger returns a logger filtered by the component level
+func newComponentLogger(component string) *slog.Logger {
+	return slog.New(&componentHandler{component: component, level: componentLevels[component]})
+}
+
+// Loggers for the plugin components
+var (
+	logger      = newComponentLogger(LogComponentPlugin)
+	apiLog      = newComponentLogger(LogComponentAPI)
+	sniffLog    = newComponentLogger(LogComponentSniff)
+	ingressLog  = newComponentLogger(LogComponentIngress)
+	listenerLog = newComponentLogger(LogComponentListener)
+	registryLog = newComponentLogger(LogComponentRegistry)
+	accessLog   = newComponentLogger(LogComponentAccess)
+	devLog      = newComponentLogger(LogComponentDev)
+)
+
+// parseLogLevel accepts debug, info, warn and error
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", value)
+	}
+	return level, nil
+}
+
+// currentLoggingSettings returns the active log configuration
+func currentLoggingSettings() LoggingSettings {
+	_, format := logOut.current()
+	levels := make(map[string]string, len(componentLevels))
+	for component, level := range componentLevels {
+		levels[component] = strings.ToLower(level.Level().String())
+	}
+	return LoggingSettings{Format: format, Levels: levels}
+}
+
+// validate checks the format and levels without applying them
+func (s LoggingSettings) validate() error {
+	if s.Format != "" && s.Format != LogFormatText && s.Format != LogFormatJSON {
+		return fmt.Errorf("unknown log format %q", s.Format)
+	}
+	for component, value := range s.Levels {
+		if _, ok := componentLevels[component]; !ok {
+			return fmt.Errorf("unknown log component %q", component)
+		}
+		if _, err := parseLogLevel(value); err != nil {
+			return fmt.Errorf("component %s: %w", component, err)
+		}
+	}
+	return nil
+}
+
+// applyLoggingSettings validates and applies a log configuration.
+// Empty fields and components not listed are left unchanged.
+func applyLoggingSettings(settings LoggingSettings) error {
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	if settings.Format != "" {
+		if err := logOut.setFormat(settings.Format); err != nil {
+			return err
+		}
+	}
+	for component, value := range settings.Levels {
+		level, _ := parseLogLevel(value)
+		componentLevels[component].Set(level)
+	}
+	return nil
+}
+
+// hexDump renders the first bytes of data for debug logging
+func hexDump(data []byte) string {
+	if len(data) > maxHexDumpBytes {
+		data = data[:maxHexDumpBytes]
+	}
+	return hex.EncodeToString(data)
+}
+
+// handleAPILogging returns (GET) or updates (POST) the log configuration
+func handleAPILogging(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, currentLoggingSettings())
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var settings LoggingSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := applyLoggingSettings(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		current := currentLoggingSettings()
+		components := make([]string, 0, len(current.Levels))
+		for component, level := range current.Levels {
+			components = append(components, component+"="+level)
+		}
+		sort.Strings(components)
+		apiLog.Info("Logging settings updated", "format", current.Format, "levels", strings.Join(components, ","))
+		events.record(EventSourcePlugin, "loggingUpdated", "Logging set to "+current.Format+" ("+strings.Join(components, ", ")+")")
+		writeJSON(w, current)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
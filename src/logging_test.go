@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureLogs redirects the log output to a buffer and restores the
+// previous output and component levels when the test ends
+func captureLogs(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+
+	oldOut := logOut
+	oldLevels := make(map[string]slog.Level, len(componentLevels))
+	for component, level := range componentLevels {
+		oldLevels[component] = level.Level()
+	}
+	t.Cleanup(func() {
+		logOut = oldOut
+		for component, level := range oldLevels {
+			componentLevels[component].Set(level)
+		}
+	})
+
+	var buf bytes.Buffer
+	logOut = newLogOutput(&buf, format)
+	return &buf
+}
+
+// Test per-component level filtering
+func TestComponentLevels(t *testing.T) {
+	buf := captureLogs(t, LogFormatText)
+
+	if err := applyLoggingSettings(LoggingSettings{Levels: map[string]string{
+		LogComponentIngress: "debug",
+		LogComponentSniff:   "warn",
+	}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ingressLog.Debug("ingress debug")
+	sniffLog.Info("sniff info")
+	sniffLog.Warn("sniff warn")
+	logger.Debug("plugin debug")
+
+	output := buf.String()
+	if !strings.Contains(output, "ingress debug") || !strings.Contains(output, "component=ingress") {
+		t.Errorf("Expected ingress debug record, got %q", output)
+	}
+	if strings.Contains(output, "sniff info") {
+		t.Errorf("Expected sniff info to be filtered, got %q", output)
+	}
+	if !strings.Contains(output, "sniff warn") {
+		t.Errorf("Expected sniff warn record, got %q", output)
+	}
+	if strings.Contains(output, "plugin debug") {
+		t.Errorf("Expected plugin debug to be filtered at the default level, got %q", output)
+	}
+}
+
+// Test switching the output format at runtime
+func TestLogFormat(t *testing.T) {
+	buf := captureLogs(t, LogFormatText)
+
+	if err := applyLoggingSettings(LoggingSettings{Format: LogFormatJSON}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Loggers created before the switch follow the new format
+	apiLog.With("request_id", "r-1").Info("json record")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected JSON log line, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "json record" || record["component"] != LogComponentAPI || record["request_id"] != "r-1" {
+		t.Errorf("Unexpected JSON record %v", record)
+	}
+
+	if err := applyLoggingSettings(LoggingSettings{Format: "xml"}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+	if _, format := logOut.current(); format != LogFormatJSON {
+		t.Errorf("Expected format to stay %s, got %s", LogFormatJSON, format)
+	}
+}
+
+// Test invalid settings are rejected without partial changes
+func TestApplyLoggingSettingsValidation(t *testing.T) {
+	captureLogs(t, LogFormatText)
+
+	tests := []struct {
+		name     string
+		settings LoggingSettings
+	}{
+		{"unknown component", LoggingSettings{Levels: map[string]string{"database": "debug"}}},
+		{"invalid level", LoggingSettings{Levels: map[string]string{LogComponentAPI: "verbose"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := applyLoggingSettings(tt.settings); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+
+	if level := componentLevels[LogComponentAPI].Level(); level != slog.LevelInfo {
+		t.Errorf("Expected api level to stay info, got %s", level)
+	}
+}
+
+// Test hex dumps are truncated
+func TestHexDump(t *testing.T) {
+	if got := hexDump([]byte("PROXY")); got != "50524f5859" {
+		t.Errorf("Expected 50524f5859, got %s", got)
+	}
+	if got := hexDump(bytes.Repeat([]byte{0xff}, maxHexDumpBytes*2)); len(got) != maxHexDumpBytes*2 {
+		t.Errorf("Expected %d hex characters, got %d", maxHexDumpBytes*2, len(got))
+	}
+}
+
+// Test the logging API
+func TestLoggingAPI(t *testing.T) {
+	captureLogs(t, LogFormatText)
+
+	t.Run("GET returns current settings", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handleAPILogging(rr, httptest.NewRequest(http.MethodGet, UI_PATH+"/api/logging", nil))
+
+		var response LoggingSettings
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.Format != LogFormatText {
+			t.Errorf("Expected format %s, got %s", LogFormatText, response.Format)
+		}
+		if len(response.Levels) != len(logComponents) {
+			t.Errorf("Expected %d components, got %d", len(logComponents), len(response.Levels))
+		}
+	})
+
+	post := func(body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, UI_PATH+"/api/logging", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("X-CSRF-Token", token)
+		}
+		rr := httptest.NewRecorder()
+		handleAPILogging(rr, req)
+		return rr
+	}
+
+	t.Run("POST without CSRF token", func(t *testing.T) {
+		if rr := post(`{"levels":{"sniff":"debug"}}`, ""); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("POST invalid level", func(t *testing.T) {
+		if rr := post(`{"levels":{"sniff":"loud"}}`, issueTestCSRFToken(t)); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("POST updates and persists levels", func(t *testing.T) {
+		oldPath := configPath
+		configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+		defer func() { configPath = oldPath }()
+
+		rr := post(`{"levels":{"sniff":"debug"}}`, issueTestCSRFToken(t))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if !sniffLog.Enabled(context.Background(), slog.LevelDebug) {
+			t.Error("Expected sniff debug logging to be enabled")
+		}
+
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatalf("Expected config to be written: %v", err)
+		}
+		if !strings.Contains(string(data), `"sniff": "debug"`) {
+			t.Errorf("Expected persisted sniff level, got %s", data)
+		}
+
+		componentLevels[LogComponentSniff].Set(slog.LevelInfo)
+		if err := loadConfig(); err != nil {
+			t.Fatalf("Unexpected error loading config: %v", err)
+		}
+		if level := componentLevels[LogComponentSniff].Level(); level != slog.LevelDebug {
+			t.Errorf("Expected sniff level debug after reload, got %s", level)
+		}
+	})
+}
@@ -7,12 +7,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
 )
@@ -21,6 +24,9 @@ const (
 	PLUGIN_ID = "de.codexo.proxyprotocol"
 	UI_PATH   = "/ui"
 	WEB_ROOT  = "/www"
+
+	SNIFF_PATH   = "/proxy_protocol_sniff"
+	INGRESS_PATH = "/proxy_protocol_handler"
 )
 
 // Version information - set via ldflags during build
@@ -33,26 +39,40 @@ var content embed.FS
 
 // Plugin configuration
 type PluginConfig struct {
-	Enabled bool `json:"enabled"`
-	mu      sync.RWMutex
+	Enabled        bool              `json:"enabled"`
+	HostRules      []HostRule        `json:"host_rules"`
+	AllowedOrigins []string          `json:"allowed_origins"` // CORS origins, empty for same-origin only
+	ClientHeaders  []ClientHeader    `json:"client_headers"`  // headers carrying the client address to the backend
+	Forwarded      ForwardedSettings `json:"forwarded"`       // node identifiers of the {forwarded} placeholder
+	StripHeaders   []string          `json:"strip_headers"`   // client-identity headers removed from untrusted peers
+	EndpointAccess EndpointAccess    `json:"endpoint_access"` // allow / deny by cloud endpoint
+	CustomTLVs     []CustomTLV       `json:"custom_tlvs"`     // names, decodings and headers of application TLVs
+	IPAccess       IPAccess          `json:"ip_access"`       // allow / deny by client address
+	RateLimit      RateLimitSettings `json:"rate_limit"`      // token buckets per client subnet
+	Bans           BanSettings       `json:"bans"`            // bans of peers with repeated failures
+	mu             sync.RWMutex
+
+	trustedUpstreams []netip.Prefix // load balancers expected to send PROXY headers
+	trustedProxies   []netip.Prefix // proxies in front of them whose X-Forwarded-For entries are trusted
 }
 
 var config = &PluginConfig{
-	Enabled: false,
+	Enabled:       false,
+	ClientHeaders: defaultClientHeaders(),
+	Forwarded:     defaultForwardedSettings(),
+	StripHeaders:  defaultStripHeaders(),
+	IPAccess:      defaultIPAccess(),
+	RateLimit:     defaultRateLimitSettings(),
+	Bans:          defaultBanSettings(),
 }
 
-// Logger for the plugin
-var logger *log.Logger
-
-// Plugin connection registry for active connections
-var activeConnections = make(map[string]*proxyProtocolConn)
-var connectionsMutex sync.RWMutex
-
 // API response structures
 type StatusResponse struct {
-	Status  string `json:"status"`
-	Enabled bool   `json:"enabled"`
-	Version string `json:"version"`
+	Status        string        `json:"status"`
+	Enabled       bool          `json:"enabled"`
+	Version       string        `json:"version"`
+	Uptime        string        `json:"uptime"`
+	FailingChecks []HealthCheck `json:"failing_checks,omitempty"`
 }
 
 type ToggleRequest struct {
@@ -64,31 +84,6 @@ type ToggleResponse struct {
 	Enabled bool   `json:"enabled"`
 }
 
-func init() {
-	logger = log.New(os.Stdout, "[ProxyProtocol] ", log.LstdFlags)
-}
-
-// isPluginHealthy checks if the plugin is functioning correctly
-func isPluginHealthy() bool {
-	// Basic health checks for the plugin
-
-	// Check if logger is available
-	if logger == nil {
-		return false
-	}
-
-	// Check if config is accessible
-	config.mu.RLock()
-	defer config.mu.RUnlock()
-
-	// Check if activeConnections map is accessible
-	connectionsMutex.RLock()
-	defer connectionsMutex.RUnlock()
-
-	// If we reach here, basic components are working
-	return true
-}
-
 func main() {
 	// Check for version flag before doing anything else
 	if len(os.Args) > 1 {
@@ -99,12 +94,73 @@ func main() {
 		}
 	}
 
+	// Troubleshooting subcommands (decode, encode, send) run without Zoraxy
+	if code, ok := runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); ok {
+		os.Exit(code)
+	}
+
+	// Standalone development mode with a mock Zoraxy host
+	if len(os.Args) > 1 && (os.Args[1] == "-dev" || os.Args[1] == "--dev") {
+		os.Exit(runDevMode(os.Args[2:]))
+	}
+
+	runtimeCfg, err := plugin.ServeAndRecvSpec(pluginSpec())
+	if err != nil {
+		fmt.Println("This is a plugin for Zoraxy and should not be run standalone")
+		fmt.Println("For installation instructions, see: https://github.com/Exoridus/zoraxy-proxy-protocol")
+		panic(err)
+	}
+
+	configPath = filepath.Join(pluginDir(), CONFIG_FILE)
+	if err := loadConfig(); err != nil {
+		logger.Error("Error loading config, using defaults", "path", configPath, "error", err)
+	}
+	bansPath = filepath.Join(pluginDir(), BANS_FILE)
+	if err := bans.load(); err != nil {
+		logger.Error("Error loading bans", "path", bansPath, "error", err)
+	}
+
+	registerRoutes(http.DefaultServeMux)
+
+	// Evict idle connections from the registry
+	stopBackground := make(chan struct{})
+	connections.startEviction(connectionEvictionInterval, stopBackground)
+	rateLimits.startSweeper(rateLimitSweepInterval, stopBackground)
+	bans.startSweeper(banSweepInterval, stopBackground)
+
+	// Load the GeoIP databases next to the plugin and reload them when they change
+	geoip.setDir(pluginDir())
+	geoip.startWatcher(geoipReloadInterval, stopBackground)
+
+	// Create embedded web router for UI (this registers /ui/ pattern which is less specific)
+	embedWebRouter := plugin.NewPluginEmbedUIRouter(PLUGIN_ID, &content, WEB_ROOT, UI_PATH)
+	embedWebRouter.RegisterTerminateHandler(func() {
+		close(stopBackground)
+		logger.Info("Proxy Protocol Plugin terminated")
+	}, nil)
+	// Remember the CSRF tokens Zoraxy injects into the UI pages
+	http.Handle(UI_PATH+"/", issueCSRFTokens(embedWebRouter.Handler()))
+
+	listenAddr := "127.0.0.1:" + strconv.Itoa(runtimeCfg.Port)
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		panic(err)
+	}
+
+	logger.Info("Proxy Protocol Plugin started", "address", "http://"+listenAddr)
+	if err := servePlugin(ln); err != nil {
+		panic(err)
+	}
+}
+
+// pluginSpec describes the plugin to Zoraxy
+func pluginSpec() *plugin.IntroSpect {
 	// Convert string flags to integers for the plugin spec
 	major, _ := strconv.Atoi(versionMajor)
 	minor, _ := strconv.Atoi(versionMinor)
 	patch, _ := strconv.Atoi(versionPatch)
 
-	runtimeCfg, err := plugin.ServeAndRecvSpec(&plugin.IntroSpect{
+	return &plugin.IntroSpect{
 		ID:            PLUGIN_ID,
 		Name:          "Proxy Protocol",
 		Author:        "Exoridus",
@@ -121,47 +177,72 @@ func main() {
 		StaticCaptureIngress: "",
 
 		// Dynamic capturing for Proxy Protocol header detection
-		DynamicCaptureSniff:   "/proxy_protocol_sniff",
-		DynamicCaptureIngress: "/proxy_protocol_handler",
+		DynamicCaptureSniff:   SNIFF_PATH,
+		DynamicCaptureIngress: INGRESS_PATH,
 
 		// UI path for configuration
 		UIPath: UI_PATH,
 
-		// No subscription events needed
-		SubscriptionPath:    "",
-		SubscriptionsEvents: map[string]string{},
-	})
-	if err != nil {
-		fmt.Println("This is a plugin for Zoraxy and should not be run standalone")
-		fmt.Println("For installation instructions, see: https://github.com/Exoridus/zoraxy-proxy-protocol")
-		panic(err)
+		// Subscribe to Zoraxy events for the plugin event log
+		SubscriptionPath:    SUBSCRIPTION_PATH,
+		SubscriptionsEvents: subscriptionEvents,
 	}
+}
 
+// registerRoutes registers the Zoraxy endpoints and the API, everything but the UI
+func registerRoutes(mux *http.ServeMux) {
 	// Register core plugin endpoints (required by Zoraxy)
-	http.HandleFunc("/proxy_protocol_sniff", handleProxyProtocolSniff)
-	http.HandleFunc("/proxy_protocol_handler", handleProxyProtocolIngress)
+	registerCaptureHandlers(mux)
+	mux.HandleFunc(SUBSCRIPTION_PATH, handleSubscriptionEvent)
+	mux.HandleFunc(METRICS_PATH, handleMetrics)
 
 	// Register API endpoints BEFORE the embedded router for precedence
-	http.HandleFunc(UI_PATH+"/api/status", handleAPIStatus)
-	http.HandleFunc(UI_PATH+"/api/toggle", handleAPIToggle)
+	mux.HandleFunc(UI_PATH+"/api/status", withOriginPolicy(handleAPIStatus))
+	mux.HandleFunc(UI_PATH+"/api/toggle", withOriginPolicy(handleAPIToggle))
+	mux.HandleFunc(UI_PATH+"/api/hosts", withOriginPolicy(handleAPIHostRules))
+	mux.HandleFunc(UI_PATH+"/api/headers", withOriginPolicy(handleAPIClientHeaders))
+	mux.HandleFunc(UI_PATH+"/api/endpoints", withOriginPolicy(handleAPIEndpoints))
+	mux.HandleFunc(UI_PATH+"/api/tlvs", withOriginPolicy(handleAPICustomTLVs))
+	mux.HandleFunc(UI_PATH+"/api/access", withOriginPolicy(handleAPIIPAccess))
+	mux.HandleFunc(UI_PATH+"/api/geoip", withOriginPolicy(handleAPIGeoIP))
+	mux.HandleFunc(UI_PATH+"/api/ratelimit", withOriginPolicy(handleAPIRateLimit))
+	mux.HandleFunc(UI_PATH+"/api/bans", withOriginPolicy(handleAPIBans))
+	mux.HandleFunc(UI_PATH+"/api/banlist", withOriginPolicy(handleAPIBanList))
+	mux.HandleFunc(UI_PATH+"/api/events", withOriginPolicy(handleAPIEvents))
+	mux.HandleFunc(UI_PATH+"/api/connections", withOriginPolicy(handleAPIConnections))
+	mux.HandleFunc(UI_PATH+"/api/logging", withOriginPolicy(handleAPILogging))
+	mux.HandleFunc(UI_PATH+"/api/inspector", withOriginPolicy(handleAPIInspector))
+	mux.HandleFunc(UI_PATH+"/api/origins", withOriginPolicy(handleAPIOrigins))
+	mux.HandleFunc(UI_PATH+"/api/health", withOriginPolicy(handleAPIHealth))
+	mux.HandleFunc(UI_PATH+"/api/upstreams", withOriginPolicy(handleAPIUpstreams))
+	mux.HandleFunc(UI_PATH+"/api/selftest", withOriginPolicy(handleAPISelfTest))
+	mux.HandleFunc(UI_PATH+"/api/decode", withOriginPolicy(handleAPIDecode))
+}
 
-	// Create embedded web router for UI (this registers /ui/ pattern which is less specific)
-	embedWebRouter := plugin.NewPluginEmbedUIRouter(PLUGIN_ID, &content, WEB_ROOT, UI_PATH)
-	embedWebRouter.RegisterTerminateHandler(func() {
-		fmt.Println("Proxy Protocol Plugin terminated")
-	}, nil)
-	embedWebRouter.AttachHandlerToMux(nil)
+// servePlugin serves http.DefaultServeMux on the plugin listener
+func servePlugin(ln net.Listener) error {
+	listeners.bound("plugin", ln.Addr().String())
+	err := http.Serve(ln, nil)
+	if isListenerFailure(err) {
+		listeners.failed("plugin", err)
+		return err
+	}
+	return nil
+}
 
-	fmt.Println("Proxy Protocol Plugin started at http://127.0.0.1:" + strconv.Itoa(runtimeCfg.Port))
-	err = http.ListenAndServe("127.0.0.1:"+strconv.Itoa(runtimeCfg.Port), nil)
-	if err != nil {
-		panic(err)
+// writeJSON encodes the response as JSON with the common API headers
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		apiLog.Error("Error encoding JSON response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
 // API Handlers
 func handleAPIStatus(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("API Status request: %s %s\n", r.Method, r.URL.Path)
+	apiLog.Debug("API status request", "method", r.Method, "path", r.URL.Path)
 
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -172,15 +253,13 @@ func handleAPIStatus(w http.ResponseWriter, r *http.Request) {
 	enabled := config.Enabled
 	config.mu.RUnlock()
 
-	// Determine status based on enabled state and potential errors
+	// Enabled reports the setting, failing checks only change the status
+	failing := failingChecks(runHealthChecks())
 	status := "Disabled"
 	if enabled {
-		// Check if plugin is functioning correctly
-		if isPluginHealthy() {
-			status = "Enabled"
-		} else {
+		status = "Enabled"
+		if len(failing) > 0 {
 			status = "Error"
-			enabled = false // Override enabled to false if there's an error
 		}
 	}
 
@@ -188,215 +267,249 @@ func handleAPIStatus(w http.ResponseWriter, r *http.Request) {
 	versionString := fmt.Sprintf("%s.%s.%s", versionMajor, versionMinor, versionPatch)
 
 	response := StatusResponse{
-		Status:  status,
-		Enabled: enabled,
-		Version: versionString,
+		Status:        status,
+		Enabled:       enabled,
+		Version:       versionString,
+		Uptime:        uptime().String(),
+		FailingChecks: failing,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		fmt.Printf("Error encoding JSON response: %v\n", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	fmt.Printf("Status response sent: %+v\n", response)
+	writeJSON(w, response)
+	apiLog.Debug("Status response sent", "status", response.Status, "enabled", response.Enabled)
 }
 
 func handleAPIToggle(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("API Toggle request: %s %s\n", r.Method, r.URL.Path)
+	apiLog.Debug("API toggle request", "method", r.Method, "path", r.URL.Path)
 
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Check for CSRF token
-	csrfToken := r.Header.Get("X-CSRF-Token")
-	if csrfToken == "" {
-		fmt.Printf("CSRF token missing or invalid: %s\n", csrfToken)
-		http.Error(w, "Forbidden - CSRF token not found in request", http.StatusForbidden)
+	if !requireCSRFToken(w, r) {
 		return
 	}
 
 	var req ToggleRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Printf("Error decoding JSON: %v\n", err)
+		apiLog.Warn("Error decoding toggle request", "error", err)
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	fmt.Printf("Toggle request received: enabled=%t\n", req.Enabled)
-
 	config.mu.Lock()
 	config.Enabled = req.Enabled
 	config.mu.Unlock()
+	hostDecisions.reset()
 
-	fmt.Printf("Proxy Protocol support %s\n", map[bool]string{true: "enabled", false: "disabled"}[req.Enabled])
+	if err := saveConfig(); err != nil {
+		apiLog.Error("Error saving config", "error", err)
+	}
+
+	state := map[bool]string{true: "enabled", false: "disabled"}[req.Enabled]
+	apiLog.Info("Proxy Protocol support "+state, "enabled", req.Enabled)
+	events.record(EventSourcePlugin, "toggle", "Proxy Protocol support "+state)
 
 	response := ToggleResponse{
 		Result:  "success",
 		Enabled: req.Enabled,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, response)
+}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		fmt.Printf("Error encoding JSON response: %v\n", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
+// registerCaptureHandlers registers the dynamic sniff and capture endpoints called by Zoraxy
+func registerCaptureHandlers(mux *http.ServeMux) {
+	pathRouter := plugin.NewPathRouter()
 
-	fmt.Printf("Toggle response sent: %+v\n", response)
+	// Count sniff outcomes, including payload errors
+	sniff := countSniffOutcomes(handleSniff(sniffProxyProtocol))
+	mux.Handle(SNIFF_PATH, sniff)
+	mux.Handle(SNIFF_PATH+"/", sniff)
+
+	pathRouter.RegisterDynamicCaptureHandle(INGRESS_PATH, mux, handleProxyProtocolIngress)
 }
 
-// Core plugin functionality - these are the endpoints that Zoraxy calls
-func handleProxyProtocolSniff(w http.ResponseWriter, r *http.Request) {
-	logger.Printf("=== SNIFF REQUEST RECEIVED ===")
-	logger.Printf("Method: %s, URL: %s", r.Method, r.URL.Path)
-	logger.Printf("Headers: %+v", r.Header)
-	logger.Printf("Remote Addr: %s", r.RemoteAddr)
+// Core plugin functionality - decides whether Zoraxy should forward the request to the ingress
+func sniffProxyProtocol(requestID string, req *plugin.DynamicSniffForwardRequest) plugin.ControlStatusCode {
+	log := sniffLog.With("request_id", requestID, "host", req.Hostname)
+	log.Debug("Sniff request received", "method", req.Method, "uri", req.RequestURI, "remote_addr", req.RemoteAddr)
 
 	config.mu.RLock()
 	enabled := config.Enabled
 	config.mu.RUnlock()
 
-	logger.Printf("Plugin enabled status: %t", enabled)
-
 	if !enabled {
 		// Plugin disabled - let Zoraxy handle normally
-		logger.Printf("Plugin disabled, returning UNHANDLED")
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(284) // ControlStatusCode_UNHANDLED
-		w.Write([]byte("UNHANDLED"))
-		return
+		log.Debug("Plugin disabled, returning UNHANDLED")
+		return plugin.ControlStatusCode_UNHANDLED
 	}
 
-	// Get the raw connection data from the request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		logger.Printf("Error reading request body for sniffing: %v", err)
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(580) // ControlStatusCode_ERROR
-		w.Write([]byte("ERROR"))
-		return
+	hostname := req.Hostname
+	if hostname == "" {
+		hostname = req.Host
+	}
+	if !isHostEnabled(hostname) {
+		log.Debug("Proxy Protocol disabled for host, returning UNHANDLED")
+		return plugin.ControlStatusCode_UNHANDLED
 	}
 
-	logger.Printf("Received %d bytes of data for sniffing", len(body))
-	if len(body) > 0 {
-		// Log first few bytes in hex for debugging
-		hexStr := ""
-		for i := 0; i < min(32, len(body)); i++ {
-			hexStr += fmt.Sprintf("%02x ", body[i])
-		}
-		logger.Printf("First bytes (hex): %s", hexStr)
-
-		// Also show as string if printable
-		printable := ""
-		for i := 0; i < min(64, len(body)); i++ {
-			if body[i] >= 32 && body[i] <= 126 {
-				printable += string(body[i])
-			} else {
-				printable += fmt.Sprintf("\\x%02x", body[i])
-			}
-		}
-		logger.Printf("First bytes (mixed): %s", printable)
+	// Only the load balancers prepend PROXY headers, the data of any other peer is client-controlled
+	if !isTrustedUpstream(req.RemoteAddr) {
+		log.Debug("Peer is not a trusted upstream, returning UNHANDLED", "remote_addr", req.RemoteAddr)
+		return plugin.ControlStatusCode_UNHANDLED
 	}
 
-	// Check if this looks like proxy protocol data
-	if detectProxyProtocol(body) {
-		logger.Printf("✅ Proxy Protocol detected in connection")
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(280) // ControlStatusCode_CAPTURED - Tell Zoraxy we'll handle this
-		w.Write([]byte("CAPTURED"))
-		return
+	// Remember the sniffed request so the ingress can correlate it,
+	// a request the ingress could not match is left to Zoraxy
+	if err := pendingRequests.add(requestID, req); err != nil {
+		log.Warn("Cannot capture the sniffed request, returning UNHANDLED", "error", err)
+		return plugin.ControlStatusCode_UNHANDLED
 	}
 
-	// Not proxy protocol data - let Zoraxy handle normally
-	logger.Printf("❌ No Proxy Protocol detected, returning UNHANDLED")
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(284) // ControlStatusCode_UNHANDLED
-	w.Write([]byte("UNHANDLED"))
+	log.Debug("Proxy Protocol enabled for host, returning CAPTURED")
+	return plugin.ControlStatusCode_CAPTURED
 }
 
 func handleProxyProtocolIngress(w http.ResponseWriter, r *http.Request) {
-	logger.Printf("=== INGRESS REQUEST RECEIVED ===")
-	logger.Printf("Method: %s, URL: %s", r.Method, r.URL.Path)
-	logger.Printf("Headers: %+v", r.Header)
+	ingressLog.Debug("Ingress request received", "method", r.Method, "uri", r.RequestURI)
 
 	config.mu.RLock()
 	enabled := config.Enabled
 	config.mu.RUnlock()
 
 	if !enabled {
-		logger.Printf("Plugin disabled in ingress handler")
+		ingressLog.Warn("Ingress request while plugin is disabled")
 		w.WriteHeader(http.StatusServiceUnavailable)
 		w.Write([]byte("Proxy Protocol Handler Disabled"))
 		return
 	}
 
-	// Get connection identifier from headers
-	connID := r.Header.Get("X-Connection-ID")
+	// Get the request identifier assigned by Zoraxy, falling back to the legacy connection header
+	connID := r.Header.Get("X-Zoraxy-RequestID")
 	if connID == "" {
-		logger.Printf("No connection ID provided in proxy protocol ingress")
+		connID = r.Header.Get("X-Connection-ID")
+	}
+	if connID == "" {
+		ingressLog.Warn("No request ID provided in proxy protocol ingress")
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("No Connection ID"))
 		return
 	}
 
-	logger.Printf("Processing connection ID: %s", connID)
+	log := ingressLog.With("request_id", connID)
+
+	// Only requests captured by the sniff are parsed, their peer decides whether the data is trusted
+	sniffed, ok := pendingRequests.take(connID)
+	if !ok {
+		log.Warn("Ingress request without a captured sniff request")
+		http.Error(w, "Unknown Request", http.StatusBadRequest)
+		return
+	}
+	hostname := sniffed.Hostname
+	peerAddr := sniffed.RemoteAddr
+	log = log.With("host", hostname)
+	log.Debug("Ingress matches sniffed request", "remote_addr", peerAddr)
+
+	if !isTrustedUpstream(peerAddr) {
+		log.Warn("Ingress request from a peer that is not a trusted upstream", "remote_addr", peerAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	// Read the raw connection data
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		logger.Printf("Error reading proxy protocol data: %v", err)
+		log.Error("Error reading proxy protocol data", "error", err)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Read Error"))
 		return
 	}
 
-	logger.Printf("Received %d bytes of data for processing", len(body))
+	log.Debug("Received data for processing", "bytes", len(body), "hex", hexDump(body))
 
 	// Process the proxy protocol data
+	parseStarted := time.Now()
 	processedData, proxyInfo, err := processProxyProtocolData(body)
+	if err != nil || proxyInfo != nil {
+		metrics.observeParse(parseStarted, proxyInfo, err)
+	}
+	inspector.record(ConnectionOriginIngress, peerAddr, hostname, body, proxyInfo, err)
 	if err != nil {
-		logger.Printf("Error processing proxy protocol: %v", err)
+		// The data comes from a client of the load balancer, so nobody is counted
+		log.Warn("Error processing proxy protocol", "error", err, "reason", parseErrorReason(err))
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Parse Error"))
 		return
 	}
 
-	if proxyInfo != nil {
-		logger.Printf("✅ Proxy Protocol parsed: %s:%d -> %s:%d (v%d)",
-			proxyInfo.SourceAddr, proxyInfo.SourcePort,
-			proxyInfo.DestAddr, proxyInfo.DestPort, proxyInfo.Version)
+	// Never pass client data on as if it had been sent with a PROXY header
+	if proxyInfo == nil {
+		log.Warn("No Proxy Protocol header in captured request", "bytes", len(body))
+		http.Error(w, "Missing Proxy Protocol Header", http.StatusBadRequest)
+		return
+	}
 
-		// Store the proxy info for later use
-		connectionsMutex.Lock()
-		if conn, exists := activeConnections[connID]; exists {
-			conn.ProxyInfo = proxyInfo
-		}
-		connectionsMutex.Unlock()
+	// Vendor and custom TLVs are mapped with one copy of the mappings
+	customTLVs := currentCustomTLVs()
+	decodeCloudTLVs(proxyInfo, customTLVs)
 
-		// Set headers for the processed request with original client IP
-		w.Header().Set("X-Original-Remote-Addr", proxyInfo.SourceAddr)
-		w.Header().Set("X-Original-Remote-Port", strconv.Itoa(proxyInfo.SourcePort))
-		w.Header().Set("X-Forwarded-For", proxyInfo.SourceAddr)
-		w.Header().Set("X-Real-IP", proxyInfo.SourceAddr)
-		w.Header().Set("X-Forwarded-Port", strconv.Itoa(proxyInfo.SourcePort))
+	// Correlates the following lines with the load balancer logs and cloud endpoints
+	if uniqueID := proxyInfo.UniqueID(); uniqueID != "" {
+		log = log.With("unique_id", uniqueID)
+	}
+	if endpoint := endpointLabel(proxyInfo); endpoint != "" {
+		log = log.With("endpoint", endpoint)
+	}
+	log.Info("Proxy Protocol parsed",
+		"source", fmt.Sprintf("%s:%d", proxyInfo.SourceAddr, proxyInfo.SourcePort),
+		"destination", fmt.Sprintf("%s:%d", proxyInfo.DestAddr, proxyInfo.DestPort),
+		"version", proxyInfo.Version)
 
-		// Indicate to Zoraxy that it should use the original client IP for further processing
-		w.Header().Set("X-Proxy-Protocol-Source", fmt.Sprintf("%s:%d", proxyInfo.SourceAddr, proxyInfo.SourcePort))
-	} else {
-		logger.Printf("No proxy protocol info found, passing through data unchanged")
+	if peerBanned(proxyInfo.SourceAddr, ConnectionOriginIngress) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
 	}
 
+	// Track the connection in the registry
+	upstreams.seen(peerAddr)
+	connections.register(ingressConnectionID(proxyInfo), ConnectionOriginIngress, proxyInfo, hostname, len(body))
+
+	if !checkEndpointAccess(proxyInfo) {
+		log.Warn("Connection denied by endpoint access rules", "endpoint", endpointLabel(proxyInfo))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	// Shared by the access rules and the client headers, e.g. for one GeoIP lookup
+	src := &headerSource{info: proxyInfo, hostname: hostname}
+	if allowed, access := checkIPAccess(src, "origin", ConnectionOriginIngress, "request_id", connID); !allowed {
+		writeIPAccessDenied(w, access)
+		return
+	}
+	if allowed, wait := checkRateLimit(proxyInfo, hostname, "origin", ConnectionOriginIngress, "request_id", connID); !allowed {
+		writeRateLimited(w, wait)
+		return
+	}
+
+	// Set the configured headers carrying the original client address,
+	// ignoring client-identity headers of untrusted peers
+	var stripped []string
+	src.sent, stripped = sanitizeClientHeaders(r.Header, proxyInfo)
+	applyClientHeaders(w.Header(), src)
+
+	// Headers of the mapped custom TLVs
+	for _, tlv := range applyCustomTLVHeaders(w.Header(), proxyInfo, customTLVs) {
+		log.Debug("Custom TLV decoded", "type", fmt.Sprintf("0x%02X", tlv.Type), "name", tlv.Name, "value", tlv.Value)
+	}
+
+	// Zoraxy only applies the headers returned here, the stripped ones the
+	// plugin did not set are overridden with an empty value
+	overrideStripped(w.Header(), stripped)
+	countStripped(stripped)
+
 	// Return the processed data (without proxy protocol headers)
 	// This should be the actual HTTP/HTTPS request that Zoraxy can process
-	logger.Printf("Returning %d bytes of processed data", len(processedData))
+	log.Debug("Returning processed data", "bytes", len(processedData), "payload", classifyPayload(processedData))
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.WriteHeader(http.StatusOK)
 	w.Write(processedData)
@@ -416,6 +529,10 @@ func detectProxyProtocol(data []byte) bool {
 		if idx := bytes.Index(data, []byte("\r\n")); idx != -1 {
 			line := string(data[:idx])
 			parts := strings.Fields(line)
+			// "PROXY UNKNOWN" may stand alone without the address placeholders
+			if len(parts) == 2 && parts[0] == "PROXY" && parts[1] == "UNKNOWN" {
+				return true
+			}
 			// Valid proxy protocol v1 should have exactly 6 parts and valid protocol
 			if len(parts) == 6 && parts[0] == "PROXY" {
 				// Validate protocol type (TCP4, TCP6, or UNKNOWN)
@@ -466,23 +583,14 @@ func processProxyProtocolData(data []byte) ([]byte, *ProxyProtocolInfo, error) {
 		// Find where the proxy protocol header ends
 		headerEnd := bytes.Index(data, []byte("\r\n"))
 		if headerEnd == -1 {
-			return nil, nil, fmt.Errorf("malformed proxy protocol v1 header: missing CRLF")
+			return nil, nil, fmt.Errorf("%w: missing CRLF", errInvalidV1Header)
 		}
 
 		// Return remaining data after the header
 		remainingData := data[headerEnd+2:]
 
-		// Log what we're returning for debugging
-		logger.Printf("Proxy Protocol v1 processed, returning %d bytes of data", len(remainingData))
-		if len(remainingData) > 0 {
-			// Check if remaining data looks like TLS handshake
-			if remainingData[0] == 0x16 { // TLS handshake record type
-				logger.Printf("Remaining data appears to be TLS handshake")
-			} else if remainingData[0] >= 0x20 && remainingData[0] <= 0x7E {
-				// Looks like printable ASCII (HTTP)
-				logger.Printf("Remaining data appears to be HTTP: %s", string(remainingData[:min(50, len(remainingData))]))
-			}
-		}
+		logger.Debug("Proxy Protocol v1 processed", "remaining_bytes", len(remainingData),
+			"payload", classifyPayload(remainingData), "hex", hexDump(remainingData))
 
 		return remainingData, proxyInfo, nil
 
@@ -504,23 +612,14 @@ func processProxyProtocolData(data []byte) ([]byte, *ProxyProtocolInfo, error) {
 		headerLen := 16 + addrLen
 
 		if len(data) < headerLen {
-			return nil, nil, fmt.Errorf("proxy protocol v2 header incomplete: expected %d bytes, got %d", headerLen, len(data))
+			return nil, nil, errorAt(len(data), fmt.Errorf("%w: expected %d bytes, got %d", errIncompleteV2Header, headerLen, len(data)))
 		}
 
 		// Return remaining data after the header
 		remainingData := data[headerLen:]
 
-		// Log what we're returning for debugging
-		logger.Printf("Proxy Protocol v2 processed, returning %d bytes of data", len(remainingData))
-		if len(remainingData) > 0 {
-			// Check if remaining data looks like TLS handshake
-			if remainingData[0] == 0x16 { // TLS handshake record type
-				logger.Printf("Remaining data appears to be TLS handshake")
-			} else if remainingData[0] >= 0x20 && remainingData[0] <= 0x7E {
-				// Looks like printable ASCII (HTTP)
-				logger.Printf("Remaining data appears to be HTTP: %s", string(remainingData[:min(50, len(remainingData))]))
-			}
-		}
+		logger.Debug("Proxy Protocol v2 processed", "remaining_bytes", len(remainingData),
+			"payload", classifyPayload(remainingData), "hex", hexDump(remainingData))
 
 		return remainingData, proxyInfo, nil
 	}
@@ -529,6 +628,20 @@ func processProxyProtocolData(data []byte) ([]byte, *ProxyProtocolInfo, error) {
 	return data, nil, nil
 }
 
+// classifyPayload describes the data following the Proxy Protocol header
+func classifyPayload(data []byte) string {
+	switch {
+	case len(data) == 0:
+		return "empty"
+	case data[0] == 0x16: // TLS handshake record type
+		return "tls"
+	case data[0] >= 0x20 && data[0] <= 0x7E: // printable ASCII, likely HTTP
+		return "http"
+	default:
+		return "binary"
+	}
+}
+
 // Helper function for min
 func min(a, b int) int {
 	if a < b {
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+	"go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin/plugintest"
+)
+
+// Test that the introspect Zoraxy receives is valid
+func TestPluginSpec(t *testing.T) {
+	data, err := json.Marshal(pluginSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := plugintest.ParseIntroSpect(data)
+	if err != nil {
+		t.Fatalf("Expected a valid introspect, got %v", err)
+	}
+	if spec.DynamicCaptureSniff != SNIFF_PATH || spec.DynamicCaptureIngress != INGRESS_PATH {
+		t.Errorf("Expected dynamic capture on %s and %s, got %s and %s",
+			SNIFF_PATH, INGRESS_PATH, spec.DynamicCaptureSniff, spec.DynamicCaptureIngress)
+	}
+}
+
+// Test the capture decisions through a simulated Zoraxy host
+func TestPluginCaptureDecisions(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	host := plugintest.StartHandler(t, pluginSpec(), mux)
+	request := func(hostname string) *http.Request {
+		req := httptest.NewRequest("GET", "http://"+hostname+"/", nil)
+		req.RemoteAddr = "192.0.2.1:40000"
+		return req
+	}
+
+	t.Run("disabled plugin is unhandled", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) { c.Enabled, c.HostRules = false, nil })
+		host.ExpectSniff(t, request("example.com"), plugin.ControlStatusCode_UNHANDLED)
+	})
+
+	t.Run("enabled plugin captures trusted upstreams", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, nil
+			c.trustedUpstreams = testPrefixes(t, "192.0.2.1")
+		})
+		host.ExpectSniff(t, request("example.com"), plugin.ControlStatusCode_CAPTURED)
+	})
+
+	t.Run("other peers are unhandled", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, nil
+			c.trustedUpstreams = testPrefixes(t, "192.0.2.2")
+		})
+		host.ExpectSniff(t, request("example.com"), plugin.ControlStatusCode_UNHANDLED)
+	})
+
+	t.Run("nothing is captured without trusted upstreams", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) { c.Enabled, c.HostRules, c.trustedUpstreams = true, nil, nil })
+		host.ExpectSniff(t, request("example.com"), plugin.ControlStatusCode_UNHANDLED)
+	})
+
+	t.Run("disabled host rule is unhandled", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, []HostRule{{Pattern: "example.com", Match: HostMatchExact, Enabled: false}}
+		})
+		host.ExpectSniff(t, request("example.com"), plugin.ControlStatusCode_UNHANDLED)
+	})
+
+	t.Run("malformed payload is an error", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) { c.Enabled, c.HostRules = true, nil })
+		code, err := host.SniffRaw([]byte("{"), plugintest.NewRequestID())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if code != plugin.ControlStatusCode_ERROR {
+			t.Errorf("Expected %d, got %d", plugin.ControlStatusCode_ERROR, code)
+		}
+	})
+
+	t.Run("captured request reaches the ingress", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, nil
+			c.trustedUpstreams = testPrefixes(t, "192.0.2.1")
+		})
+		header := "PROXY TCP4 203.0.113.7 198.51.100.1 51000 443\r\n"
+		result, err := host.Forward(request("example.com"), []byte(header+"GET / HTTP/1.1\r\n\r\n"))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if result.Ingress == nil {
+			t.Fatalf("Expected CAPTURED with an ingress response, got %d", result.Sniff)
+		}
+		if result.Ingress.StatusCode != http.StatusOK {
+			t.Errorf("Expected ingress status 200, got %d", result.Ingress.StatusCode)
+		}
+		if got := result.Ingress.Header.Get("X-Proxy-Protocol-Source"); got != "203.0.113.7:51000" {
+			t.Errorf("Expected client 203.0.113.7:51000, got %q", got)
+		}
+	})
+	t.Run("captured request without a header is not echoed", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, nil
+			c.trustedUpstreams = testPrefixes(t, "192.0.2.1")
+		})
+		result, err := host.Forward(request("example.com"), []byte("GET /secret HTTP/1.1\r\n\r\n"))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if result.Ingress == nil {
+			t.Fatalf("Expected CAPTURED with an ingress response, got %d", result.Sniff)
+		}
+		if result.Ingress.StatusCode != http.StatusBadRequest || strings.Contains(string(result.Ingress.Body), "secret") {
+			t.Errorf("Expected 400 without the request, got %d %q", result.Ingress.StatusCode, result.Ingress.Body)
+		}
+	})
+
+	t.Run("ingress without a captured sniff is rejected", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) { c.Enabled, c.HostRules = true, nil })
+		header := "PROXY TCP4 203.0.113.7 198.51.100.1 51000 443\r\n"
+		resp, err := host.Ingress(http.MethodGet, "/", plugintest.NewRequestID(), nil, []byte(header+"GET / HTTP/1.1\r\n\r\n"))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("X-Proxy-Protocol-Source") != "" {
+			t.Errorf("Expected the client-supplied header to be ignored, got %d %v", resp.StatusCode, resp.Header)
+		}
+	})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// METRICS_PATH serves the Prometheus text exposition
+const METRICS_PATH = "/metrics"
+
+// Parse error reasons used as metric labels
+const (
+	ParseErrorTruncated          = "truncated"
+	ParseErrorInvalidV1Header    = "invalid_v1_header"
+	ParseErrorInvalidVersion     = "invalid_version"
+	ParseErrorAddressTooShort    = "address_too_short"
+	ParseErrorUnsupportedFamily  = "unsupported_family"
+	ParseErrorIncompleteV2Header = "incomplete_v2_header"
+	ParseErrorMalformedTLV       = "malformed_tlv"
+	ParseErrorOther              = "other"
+)
+
+// parseDurationBuckets are the upper bounds of the parse latency histogram in seconds
+var parseDurationBuckets = []float64{0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01}
+
+// counterVec is a counter partitioned by a fixed set of labels
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]uint64)}
+}
+
+// inc increments the counter for the label values, given in label order
+func (c *counterVec) inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+// get returns the counter value for the label values
+func (c *counterVec) get(labelValues ...string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+// snapshot returns the counter values keyed by the label values, joined with ","
+func (c *counterVec) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make(map[string]uint64, len(c.values))
+	for key, value := range c.values {
+		values[strings.ReplaceAll(key, "\xff", ",")] = value
+	}
+	return values
+}
+
+func (c *counterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, formatLabels(c.labels, strings.Split(key, "\xff")), c.values[key])
+	}
+	c.mu.Unlock()
+}
+
+// histogram is a cumulative histogram without labels
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if value <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, strconv.FormatFloat(upper, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// labelValueEscaper escapes label values as the Prometheus text format requires,
+// other characters including non-ASCII ones are written as is
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders a Prometheus label set
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + labelValueEscaper.Replace(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// pluginMetrics holds all metrics exposed by the plugin
+type pluginMetrics struct {
+	headersParsed   *counterVec
+	parseErrors     *counterVec
+	sniffOutcomes   *counterVec
+	strippedHeaders *counterVec
+	endpointDenied  *counterVec
+	ipAccess        *counterVec
+	rateLimited     *counterVec
+	bans            *counterVec
+	bannedRejected  *counterVec
+	parseDuration   *histogram
+	recentParses    *parseWindow // feeds the parse error health check
+}
+
+var metrics = newPluginMetrics()
+
+func newPluginMetrics() *pluginMetrics {
+	return &pluginMetrics{
+		headersParsed: newCounterVec("proxy_protocol_headers_parsed_total",
+			"Proxy Protocol headers parsed successfully.", "version", "family", "command"),
+		parseErrors: newCounterVec("proxy_protocol_parse_errors_total",
+			"Proxy Protocol headers that failed to parse.", "reason"),
+		sniffOutcomes: newCounterVec("proxy_protocol_sniff_requests_total",
+			"Dynamic sniff requests by outcome.", "outcome"),
+		strippedHeaders: newCounterVec("proxy_protocol_stripped_headers_total",
+			"Client-supplied forwarding headers removed from untrusted peers.", "header"),
+		endpointDenied: newCounterVec("proxy_protocol_endpoint_denied_total",
+			"Connections rejected by the cloud endpoint access rules.", "provider"),
+		ipAccess: newCounterVec("proxy_protocol_ip_access_decisions_total",
+			"Client address access decisions by scope and outcome.", "scope", "decision"),
+		rateLimited: newCounterVec("proxy_protocol_rate_limited_total",
+			"Requests rejected by the client rate limit.", "scope"),
+		bans: newCounterVec("proxy_protocol_bans_total",
+			"Peers banned after repeated failures or through the API.", "reason"),
+		bannedRejected: newCounterVec("proxy_protocol_banned_rejections_total",
+			"Connections and requests of banned peers rejected.", "origin"),
+		parseDuration: newHistogram("proxy_protocol_header_parse_duration_seconds",
+			"Time spent parsing Proxy Protocol headers.", parseDurationBuckets),
+		recentParses: &parseWindow{},
+	}
+}
+
+// addressFamily maps the transport protocol to the address family label
+func addressFamily(transportProto string) string {
+	switch transportProto {
+	case "TCP4":
+		return "inet"
+	case "TCP6":
+		return "inet6"
+	default:
+		return "unspec"
+	}
+}
+
+// parseErrorReason classifies a parse error for the error counter
+func parseErrorReason(err error) string {
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ParseErrorTruncated
+	case errors.Is(err, errInvalidV1Header):
+		return ParseErrorInvalidV1Header
+	case errors.Is(err, errInvalidVersion):
+		return ParseErrorInvalidVersion
+	case errors.Is(err, errAddressTooShort):
+		return ParseErrorAddressTooShort
+	case errors.Is(err, errUnsupportedFamily):
+		return ParseErrorUnsupportedFamily
+	case errors.Is(err, errIncompleteV2Header):
+		return ParseErrorIncompleteV2Header
+	case errors.Is(err, errMalformedTLV):
+		return ParseErrorMalformedTLV
+	default:
+		return ParseErrorOther
+	}
+}
+
+// observeParse records the result of a header parse attempt
+func (m *pluginMetrics) observeParse(started time.Time, info *ProxyProtocolInfo, err error) {
+	m.parseDuration.observe(time.Since(started).Seconds())
+	m.recentParses.observe(time.Now(), err != nil)
+	if err != nil {
+		m.parseErrors.inc(parseErrorReason(err))
+		return
+	}
+	if info != nil {
+		m.headersParsed.inc(strconv.Itoa(info.Version), addressFamily(info.TransportProto), strings.ToLower(info.Command))
+	}
+}
+
+// write renders all metrics in the Prometheus text exposition format
+func (m *pluginMetrics) write(w io.Writer) {
+	m.headersParsed.write(w)
+	m.parseErrors.write(w)
+	m.sniffOutcomes.write(w)
+	m.strippedHeaders.write(w)
+	m.endpointDenied.write(w)
+	m.ipAccess.write(w)
+	m.rateLimited.write(w)
+	m.bans.write(w)
+	m.bannedRejected.write(w)
+	m.parseDuration.write(w)
+
+	byOrigin := map[string]int{ConnectionOriginListener: 0, ConnectionOriginIngress: 0}
+	for _, entry := range connections.list(connectionFilter{}) {
+		byOrigin[entry.Origin]++
+	}
+	fmt.Fprintf(w, "# HELP proxy_protocol_active_connections Connections currently tracked in the registry.\n")
+	fmt.Fprintf(w, "# TYPE proxy_protocol_active_connections gauge\n")
+	for _, origin := range []string{ConnectionOriginIngress, ConnectionOriginListener} {
+		fmt.Fprintf(w, "proxy_protocol_active_connections{origin=%q} %d\n", origin, byOrigin[origin])
+	}
+
+	enabled := 0
+	config.mu.RLock()
+	if config.Enabled {
+		enabled = 1
+	}
+	config.mu.RUnlock()
+	fmt.Fprintf(w, "# HELP proxy_protocol_enabled Whether Proxy Protocol handling is enabled.\n")
+	fmt.Fprintf(w, "# TYPE proxy_protocol_enabled gauge\n")
+	fmt.Fprintf(w, "proxy_protocol_enabled %d\n", enabled)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w)
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// countSniffOutcomes counts the control status codes answered by the sniff handler
+func countSniffOutcomes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		metrics.sniffOutcomes.inc(controlStatusName(plugin.ControlStatusCode(recorder.status)))
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// Test parse error classification
+func TestParseErrorReason(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"PROXY TCP4 invalid_format\r\n", ParseErrorInvalidV1Header},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 1 2", ParseErrorTruncated},
+		{ProxyProtocolV2Prefix + "\x31\x11\x00\x0c", ParseErrorInvalidVersion},
+		{ProxyProtocolV2Prefix + "\x21\x11\x00\x04\x01\x02\x03\x04", ParseErrorAddressTooShort},
+		{ProxyProtocolV2Prefix + "\x21\x31\x00\x04\x01\x02\x03\x04", ParseErrorUnsupportedFamily},
+	}
+
+	for _, tt := range tests {
+		_, _, err := processProxyProtocolData([]byte(tt.data))
+		if err == nil {
+			t.Errorf("Expected error for %q", tt.data)
+			continue
+		}
+		if reason := parseErrorReason(err); reason != tt.expected {
+			t.Errorf("parseErrorReason(%v) = %s, expected %s", err, reason, tt.expected)
+		}
+	}
+
+	if reason := parseErrorReason(errors.New("boom")); reason != ParseErrorOther {
+		t.Errorf("Expected %s for unknown error, got %s", ParseErrorOther, reason)
+	}
+	if reason := parseErrorReason(fmt.Errorf("wrapped: %w", io.ErrUnexpectedEOF)); reason != ParseErrorTruncated {
+		t.Errorf("Expected %s for wrapped EOF, got %s", ParseErrorTruncated, reason)
+	}
+}
+
+// Test counters, histogram and exposition format
+func TestMetricsExposition(t *testing.T) {
+	oldMetrics := metrics
+	metrics = newPluginMetrics()
+	defer func() { metrics = oldMetrics }()
+
+	started := time.Now()
+	metrics.observeParse(started, &ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP6"}, nil)
+	metrics.observeParse(started, &ProxyProtocolInfo{Version: 1, Command: "PROXY", TransportProto: "TCP4"}, nil)
+	metrics.observeParse(started, nil, errInvalidVersion)
+
+	if got := metrics.headersParsed.get("2", "inet6", "proxy"); got != 1 {
+		t.Errorf("Expected 1 v2 inet6 header, got %d", got)
+	}
+	if got := metrics.parseErrors.get(ParseErrorInvalidVersion); got != 1 {
+		t.Errorf("Expected 1 invalid version error, got %d", got)
+	}
+
+	rr := httptest.NewRecorder()
+	handleMetrics(rr, httptest.NewRequest(http.MethodGet, METRICS_PATH, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected Content-Type %q", contentType)
+	}
+
+	body := rr.Body.String()
+	expected := []string{
+		"# TYPE proxy_protocol_headers_parsed_total counter",
+		`proxy_protocol_headers_parsed_total{version="1",family="inet",command="proxy"} 1`,
+		`proxy_protocol_parse_errors_total{reason="invalid_version"} 1`,
+		"# TYPE proxy_protocol_header_parse_duration_seconds histogram",
+		`proxy_protocol_header_parse_duration_seconds_bucket{le="+Inf"} 3`,
+		"proxy_protocol_header_parse_duration_seconds_count 3",
+		"# TYPE proxy_protocol_active_connections gauge",
+		`proxy_protocol_active_connections{origin="ingress"}`,
+		"# TYPE proxy_protocol_enabled gauge",
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected metrics output to contain %q\n%s", line, body)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	handleMetrics(rr, httptest.NewRequest(http.MethodPost, METRICS_PATH, nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+// Test histogram buckets are cumulative
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogram("test_seconds", "Test.", []float64{0.1, 1})
+	h.observe(0.05)
+	h.observe(0.5)
+	h.observe(5)
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	for _, line := range []string{`test_seconds_bucket{le="0.1"} 1`, `test_seconds_bucket{le="1"} 2`, `test_seconds_bucket{le="+Inf"} 3`, "test_seconds_sum 5.55"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected histogram output to contain %q\n%s", line, buf.String())
+		}
+	}
+}
+
+// Test label values are escaped per the Prometheus text format only
+func TestFormatLabels(t *testing.T) {
+	got := formatLabels([]string{"host", "reason"}, []string{"bücher.example", "a\\b \"c\"\n\td"})
+	want := `{host="bücher.example",reason="a\\b \"c\"\n` + "\td\"}"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+// Test sniff outcomes and ingress parses are counted
+func TestHandlerMetrics(t *testing.T) {
+	oldMetrics := metrics
+	metrics = newPluginMetrics()
+	defer func() { metrics = oldMetrics }()
+
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, []HostRule{{Pattern: "lb.example.com", Enabled: true}}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+	peer := net.JoinHostPort(testUpstream, "5000")
+
+	serveSniff(t, plugin.DynamicSniffForwardRequest{Hostname: "lb.example.com", RemoteAddr: peer}, "m-1")
+	serveSniff(t, plugin.DynamicSniffForwardRequest{Hostname: "other.example.com", RemoteAddr: peer}, "m-2")
+	rr := httptest.NewRecorder()
+	newCaptureMux().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, SNIFF_PATH, strings.NewReader("not json")))
+
+	for _, outcome := range []string{"CAPTURED", "UNHANDLED", "ERROR"} {
+		if got := metrics.sniffOutcomes.get(outcome); got != 1 {
+			t.Errorf("Expected 1 %s sniff, got %d", outcome, got)
+		}
+	}
+
+	pendingRequests.take("m-1")
+	for _, data := range []string{"PROXY TCP4 192.0.2.1 198.51.100.1 1000 443\r\nGET / HTTP/1.1\r\n\r\n", "PROXY TCP4 invalid_format\r\n", "GET / HTTP/1.1\r\n\r\n"} {
+		req := httptest.NewRequest(http.MethodPost, INGRESS_PATH+"/", strings.NewReader(data))
+		req.Header.Set("X-Zoraxy-RequestID", "m-3")
+		serveIngress(t, req, "lb.example.com")
+	}
+	if got := metrics.headersParsed.get("1", "inet", "proxy"); got != 1 {
+		t.Errorf("Expected 1 parsed header, got %d", got)
+	}
+	if got := metrics.parseErrors.get(ParseErrorInvalidV1Header); got != 1 {
+		t.Errorf("Expected 1 parse error, got %d", got)
+	}
+	if metrics.parseDuration.count != 2 {
+		t.Errorf("Expected 2 parse observations, got %d", metrics.parseDuration.count)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoIPFields are the values read from a database record. The decoder skips
+// everything else, e.g. the names in every language.
+type geoIPFields struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	ASN   uint64 `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// lookupGeoIPFields returns the fields of an address and the network of its
+// record, found is false for addresses that are not in the database
+func lookupGeoIPFields(reader *maxminddb.Reader, addr netip.Addr) (fields geoIPFields, network netip.Prefix, found bool, err error) {
+	addr = addr.Unmap()
+	// IPv4-only databases do not know IPv6 addresses, the reader reports them as an error
+	if addr.Is6() && reader.Metadata.IPVersion == 4 {
+		return geoIPFields{}, netip.Prefix{}, false, nil
+	}
+	ipNet, found, err := reader.LookupNetwork(addr.AsSlice(), &fields)
+	if err != nil || !found {
+		return geoIPFields{}, netip.Prefix{}, found, err
+	}
+	// An IPv4 address reached through a short IPv6 prefix has no IPv4 network
+	ones, _ := ipNet.Mask.Size()
+	network, _ = addr.Prefix(ones)
+	return fields, network, true, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/netip"
+	"sort"
+	"testing"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// mmdbMetadataMarker precedes the metadata map at the end of a MaxMind DB file
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbDataSeparatorSize is the gap of zero bytes between the search tree and the data section
+const mmdbDataSeparatorSize = 16
+
+// MaxMind DB data types written by the test databases
+// (https://maxmind.github.io/MaxMind-DB/)
+const (
+	mmdbTypePointer = 1
+	mmdbTypeString  = 2
+	mmdbTypeDouble  = 3
+	mmdbTypeUint32  = 6
+	mmdbTypeMap     = 7
+	mmdbTypeUint64  = 9
+	mmdbTypeArray   = 11
+	mmdbTypeBool    = 14
+)
+
+// mmdbTestPointer refers to a value at an offset of the data section
+type mmdbTestPointer int
+
+// mmdbTestNetwork is a network and its record in a test database
+type mmdbTestNetwork struct {
+	prefix string
+	data   any
+}
+
+// writeMMDBControl writes the control byte, extended type and size of a value
+func writeMMDBControl(buf *bytes.Buffer, kind, size int) {
+	ctrl := byte(kind << 5)
+	if kind > 7 {
+		ctrl = 0
+	}
+	switch {
+	case size < 29:
+		buf.WriteByte(ctrl | byte(size))
+	case size < 285:
+		buf.WriteByte(ctrl | 29)
+	default:
+		buf.WriteByte(ctrl | 30)
+	}
+	if kind > 7 {
+		buf.WriteByte(byte(kind - 7))
+	}
+	switch {
+	case size >= 285:
+		buf.Write([]byte{byte((size - 285) >> 8), byte(size - 285)})
+	case size >= 29:
+		buf.WriteByte(byte(size - 29))
+	}
+}
+
+// encodeMMDBValue writes a value in the data section format
+func encodeMMDBValue(t *testing.T, buf *bytes.Buffer, value any) {
+	t.Helper()
+	switch v := value.(type) {
+	case string:
+		writeMMDBControl(buf, mmdbTypeString, len(v))
+		buf.WriteString(v)
+	case uint32:
+		b := binary.BigEndian.AppendUint32(nil, v)
+		b = bytes.TrimLeft(b, "\x00")
+		writeMMDBControl(buf, mmdbTypeUint32, len(b))
+		buf.Write(b)
+	case uint64:
+		b := binary.BigEndian.AppendUint64(nil, v)
+		b = bytes.TrimLeft(b, "\x00")
+		writeMMDBControl(buf, mmdbTypeUint64, len(b))
+		buf.Write(b)
+	case float64:
+		writeMMDBControl(buf, mmdbTypeDouble, 8)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		writeMMDBControl(buf, mmdbTypeBool, size)
+	case []any:
+		writeMMDBControl(buf, mmdbTypeArray, len(v))
+		for _, item := range v {
+			encodeMMDBValue(t, buf, item)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeMMDBControl(buf, mmdbTypeMap, len(v))
+		for _, key := range keys {
+			encodeMMDBValue(t, buf, key)
+			encodeMMDBValue(t, buf, v[key])
+		}
+	case mmdbTestPointer:
+		if v >= 2048 {
+			t.Fatalf("Test pointer %d too large", v)
+		}
+		buf.Write([]byte{byte(mmdbTypePointer<<5) | byte(v>>8), byte(v)})
+	default:
+		t.Fatalf("Cannot encode %T", value)
+	}
+}
+
+// buildTestMMDB writes a database with the given networks. The shared values
+// are written to the start of the data section, for mmdbTestPointer.
+func buildTestMMDB(t *testing.T, databaseType string, ipVersion, recordSize int, shared []any, networks ...mmdbTestNetwork) []byte {
+	t.Helper()
+	var data bytes.Buffer
+	for _, value := range shared {
+		encodeMMDBValue(t, &data, value)
+	}
+
+	// Records: -1 empty, >= 0 a node, < -1 data at offset -(value+2)
+	nodes := [][2]int{{-1, -1}}
+	for _, network := range networks {
+		prefix := netip.MustParsePrefix(network.prefix)
+		offset := data.Len()
+		encodeMMDBValue(t, &data, network.data)
+
+		ip := prefix.Addr().AsSlice()
+		bits := prefix.Bits()
+		if prefix.Addr().Is4() && ipVersion == 6 {
+			ip = append(make([]byte, 12), ip...)
+			bits += 96
+		}
+		node := 0
+		for i := 0; i < bits; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if i == bits-1 {
+				nodes[node][bit] = -(offset + 2)
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var file bytes.Buffer
+	nodeCount := len(nodes)
+	value := func(record int) uint32 {
+		switch {
+		case record == -1:
+			return uint32(nodeCount)
+		case record < -1:
+			return uint32(nodeCount + mmdbDataSeparatorSize - record - 2)
+		default:
+			return uint32(record)
+		}
+	}
+	for _, node := range nodes {
+		left, right := value(node[0]), value(node[1])
+		switch recordSize {
+		case 24:
+			file.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			file.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(left>>20&0xF0 | right>>24&0x0F), byte(right >> 16), byte(right >> 8), byte(right)})
+		default:
+			file.Write(binary.BigEndian.AppendUint32(nil, left))
+			file.Write(binary.BigEndian.AppendUint32(nil, right))
+		}
+	}
+	file.Write(make([]byte, mmdbDataSeparatorSize))
+	file.Write(data.Bytes())
+	file.Write(mmdbMetadataMarker)
+	encodeMMDBValue(t, &file, map[string]any{
+		"binary_format_major_version": uint32(2),
+		"binary_format_minor_version": uint32(0),
+		"build_epoch":                 uint64(1760000000),
+		"database_type":               databaseType,
+		"ip_version":                  uint32(ipVersion),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint32(recordSize),
+	})
+	return file.Bytes()
+}
+
+// testCountryMMDB is a country database with an IPv4 and an IPv6 network
+func testCountryMMDB(t *testing.T, recordSize int, country string) []byte {
+	t.Helper()
+	europe := map[string]any{"code": "EU", "geoname_id": uint32(6255148)}
+	return buildTestMMDB(t, "GeoLite2-Country", 6, recordSize, []any{europe},
+		mmdbTestNetwork{"192.0.2.0/24", map[string]any{
+			"continent": mmdbTestPointer(0),
+			"country":   map[string]any{"iso_code": country, "names": map[string]any{"en": "Germany"}},
+		}},
+		mmdbTestNetwork{"2001:db8::/32", map[string]any{
+			"continent":          map[string]any{"code": "NA"},
+			"registered_country": map[string]any{"iso_code": "US", "is_in_european_union": false},
+			"location":           map[string]any{"latitude": 37.751},
+		}},
+	)
+}
+
+// openTestMMDB opens a test database
+func openTestMMDB(t *testing.T, buf []byte) *maxminddb.Reader {
+	t.Helper()
+	reader, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	return reader
+}
+
+// Test lookups for all record sizes
+func TestMMDBLookup(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		reader := openTestMMDB(t, testCountryMMDB(t, recordSize, "DE"))
+		if reader.Metadata.DatabaseType != "GeoLite2-Country" || reader.Metadata.IPVersion != 6 {
+			t.Errorf("Expected the metadata, got %+v", reader.Metadata)
+		}
+
+		tests := []struct {
+			addr      string
+			country   string
+			continent string
+			network   string
+		}{
+			{"192.0.2.55", "DE", "EU", "192.0.2.0/24"},
+			{"::ffff:192.0.2.55", "DE", "EU", "192.0.2.0/24"},
+			{"2001:db8:1::1", "", "NA", "2001:db8::/32"},
+			{"198.51.100.1", "", "", ""},
+			{"2001:db9::1", "", "", ""},
+		}
+		for _, tt := range tests {
+			fields, network, found, err := lookupGeoIPFields(reader, netip.MustParseAddr(tt.addr))
+			if err != nil {
+				t.Fatalf("record size %d, %s: %v", recordSize, tt.addr, err)
+			}
+			if tt.network == "" {
+				if found {
+					t.Errorf("record size %d: expected %s not to be found, got %+v", recordSize, tt.addr, fields)
+				}
+				continue
+			}
+			if fields.Country.ISOCode != tt.country || fields.Continent.Code != tt.continent {
+				t.Errorf("record size %d: expected %q/%q for %s, got %+v", recordSize, tt.country, tt.continent, tt.addr, fields)
+			}
+			if network.String() != tt.network {
+				t.Errorf("record size %d: expected network %s for %s, got %s", recordSize, tt.network, tt.addr, network)
+			}
+		}
+		if fields, _, _, _ := lookupGeoIPFields(reader, netip.MustParseAddr("2001:db8::1")); fields.RegisteredCountry.ISOCode != "US" {
+			t.Errorf("record size %d: expected the registered country, got %+v", recordSize, fields)
+		}
+	}
+}
+
+// Test an IPv4 ASN database whose records hold other value types too
+func TestMMDBIPv4Database(t *testing.T) {
+	reader := openTestMMDB(t, buildTestMMDB(t, "Test-IPv4", 4, 24, nil,
+		mmdbTestNetwork{"10.0.0.0/8", map[string]any{
+			"autonomous_system_number":       uint32(64500),
+			"autonomous_system_organization": "a string longer than twenty-nine bytes to use the extended size",
+			"big":                            uint64(1) << 40,
+			"flag":                           true,
+			"ratio":                          0.5,
+			"values":                         []any{uint32(1), "two"},
+		}},
+	))
+
+	fields, network, found, err := lookupGeoIPFields(reader, netip.MustParseAddr("10.1.2.3"))
+	if err != nil || !found || network.String() != "10.0.0.0/8" {
+		t.Fatalf("Expected a 10.0.0.0/8 record, got %s (%v)", network, err)
+	}
+	if fields.ASN != 64500 || len(fields.ASOrg) < 29 {
+		t.Errorf("Expected the AS fields, got %+v", fields)
+	}
+	if _, _, found, err := lookupGeoIPFields(reader, netip.MustParseAddr("2001:db8::1")); found || err != nil {
+		t.Errorf("Expected IPv6 addresses not to be found (%v)", err)
+	}
+}
+
+// Test that damaged files are rejected without panicking
+func TestMMDBCorrupt(t *testing.T) {
+	if _, err := maxminddb.FromBytes([]byte("not a database")); err == nil {
+		t.Error("Expected an error without metadata")
+	}
+
+	file := testCountryMMDB(t, 24, "DE")
+	marker := bytes.LastIndex(file, mmdbMetadataMarker)
+	for cut := 0; cut < len(file); cut++ {
+		// Flip a byte of the tree or data section, or drop one of the metadata
+		damaged := append(append([]byte{}, file[:cut]...), file[min(cut+1, len(file)):]...)
+		if cut < marker {
+			damaged = append([]byte{}, file...)
+			damaged[cut] ^= 0xFF
+		}
+		reader, err := maxminddb.FromBytes(damaged)
+		if err != nil {
+			continue
+		}
+		for _, addr := range []string{"192.0.2.1", "2001:db8::1", "203.0.113.1"} {
+			lookupGeoIPFields(reader, netip.MustParseAddr(addr))
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package plugintest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+var (
+	// StartTimeout bounds the wait for a launched plugin to accept connections
+	StartTimeout = 10 * time.Second
+	// StopTimeout bounds the wait for a plugin to exit after the terminate request
+	StopTimeout = 5 * time.Second
+)
+
+// DefaultRuntimeConst is passed to launched plugins in the -configure payload
+var DefaultRuntimeConst = plugin.RuntimeConstantValue{
+	ZoraxyVersion:    "plugintest",
+	ZoraxyUUID:       "00000000-0000-4000-8000-000000000000",
+	DevelopmentBuild: true,
+}
+
+// ErrUnexpectedStatus is returned when a sniff is not answered with 280, 284 or 580
+var ErrUnexpectedStatus = errors.New("unexpected sniff status")
+
+// Host plays Zoraxy for a single plugin
+type Host struct {
+	Spec    *plugin.IntroSpect
+	BaseURL string
+	Client  *http.Client
+
+	cmd    *exec.Cmd
+	output *lockedBuffer
+	exited chan struct{}
+}
+
+// Response is a plugin response with its body read
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// ForwardResult is the outcome of a request routed through the dynamic capture
+type ForwardResult struct {
+	RequestID string
+	Sniff     plugin.ControlStatusCode
+	Ingress   *Response // nil unless the sniff answered CAPTURED
+}
+
+// StartHandler serves handler as a plugin described by spec, without a separate process
+func StartHandler(t testing.TB, spec *plugin.IntroSpect, handler http.Handler) *Host {
+	t.Helper()
+	if err := ValidateIntroSpect(spec); err != nil {
+		t.Fatalf("Plugin introspect is invalid: %v", err)
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := server.Client()
+	client.CheckRedirect = keepRedirect
+	return &Host{Spec: spec, BaseURL: server.URL, Client: client}
+}
+
+// StartBinary launches a plugin binary the way Zoraxy does: -introspect first,
+// then -configure with a free port, from the directory of the binary.
+// The plugin is terminated through its UI terminate endpoint when the test ends.
+func StartBinary(t testing.TB, binary string) *Host {
+	t.Helper()
+	binary, err := filepath.Abs(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := Introspect(binary)
+	if err != nil {
+		t.Fatalf("Plugin introspect failed: %v", err)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("Cannot find a free port: %v", err)
+	}
+	configure, err := json.Marshal(plugin.ConfigureSpec{Port: port, RuntimeConst: DefaultRuntimeConst})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := &Host{
+		Spec:    spec,
+		BaseURL: "http://127.0.0.1:" + strconv.Itoa(port),
+		Client:  &http.Client{Timeout: 10 * time.Second, CheckRedirect: keepRedirect},
+		output:  &lockedBuffer{},
+		exited:  make(chan struct{}),
+	}
+	host.cmd = exec.Command(binary, "-configure="+string(configure))
+	host.cmd.Dir = filepath.Dir(binary)
+	host.cmd.Stdout = host.output
+	host.cmd.Stderr = host.output
+	if err := host.cmd.Start(); err != nil {
+		t.Fatalf("Cannot start plugin: %v", err)
+	}
+	go func() {
+		host.cmd.Wait()
+		close(host.exited)
+	}()
+	t.Cleanup(func() {
+		if err := host.Terminate(); err != nil {
+			t.Errorf("%v", err)
+		}
+		if t.Failed() {
+			t.Logf("Plugin output:\n%s", host.Output())
+		}
+	})
+
+	if err := host.waitReady(port); err != nil {
+		t.Fatalf("Plugin did not start: %v\n%s", err, host.Output())
+	}
+	return host
+}
+
+// Output returns what a launched plugin wrote to stdout and stderr so far
+func (h *Host) Output() string {
+	if h.output == nil {
+		return ""
+	}
+	return h.output.String()
+}
+
+// Terminate asks a launched plugin to exit via UIPath/term and kills it if it does not
+func (h *Host) Terminate() error {
+	if h.cmd == nil {
+		return nil
+	}
+	select {
+	case <-h.exited:
+		return nil
+	default:
+	}
+
+	if h.Spec.UIPath != "" {
+		if resp, err := h.Post(strings.TrimSuffix(h.Spec.UIPath, "/")+"/term", "", nil, nil); err == nil && resp.StatusCode == http.StatusOK {
+			select {
+			case <-h.exited:
+				return nil
+			case <-time.After(StopTimeout):
+			}
+		}
+	}
+
+	h.cmd.Process.Kill()
+	<-h.exited
+	return fmt.Errorf("plugin %s did not exit after the terminate request and was killed", h.Spec.ID)
+}
+
+func (h *Host) waitReady(port int) error {
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	deadline := time.Now().Add(StartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-h.exited:
+			return fmt.Errorf("plugin exited: %v", h.cmd.ProcessState)
+		default:
+		}
+		if conn, err := net.DialTimeout("tcp", address, 100*time.Millisecond); err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return fmt.Errorf("no listener on %s after %s", address, StartTimeout)
+}
+
+// Post sends a request to the plugin with the Zoraxy request ID header
+func (h *Host) Post(path, requestID string, header http.Header, body []byte) (*Response, error) {
+	return h.Do(http.MethodPost, path, requestID, header, body)
+}
+
+// Do sends a request to the plugin and reads the response
+func (h *Host) Do(method, path, requestID string, header http.Header, body []byte) (*Response, error) {
+	req, err := http.NewRequest(method, h.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if requestID != "" {
+		req.Header.Set("X-Zoraxy-RequestID", requestID)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}, nil
+}
+
+// Sniff sends a forwarded request payload to the dynamic capture sniff path
+func (h *Host) Sniff(payload *plugin.DynamicSniffForwardRequest, requestID string) (plugin.ControlStatusCode, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	return h.SniffRaw(body, requestID)
+}
+
+// SniffRaw sends body as is to the sniff path, e.g. to check how malformed payloads are answered
+func (h *Host) SniffRaw(body []byte, requestID string) (plugin.ControlStatusCode, error) {
+	if h.Spec.DynamicCaptureSniff == "" {
+		return 0, fmt.Errorf("plugin %s has no dynamic capture sniff path", h.Spec.ID)
+	}
+
+	resp, err := h.Post(h.Spec.DynamicCaptureSniff, requestID, nil, body)
+	if err != nil {
+		return 0, err
+	}
+	code := plugin.ControlStatusCode(resp.StatusCode)
+	switch code {
+	case plugin.ControlStatusCode_CAPTURED, plugin.ControlStatusCode_UNHANDLED, plugin.ControlStatusCode_ERROR:
+		return code, nil
+	default:
+		return code, fmt.Errorf("%w %d: %s", ErrUnexpectedStatus, resp.StatusCode, bytes.TrimSpace(resp.Body))
+	}
+}
+
+// Ingress sends a captured request to the dynamic capture ingress, with the original URI appended
+func (h *Host) Ingress(method, uri, requestID string, header http.Header, body []byte) (*Response, error) {
+	if h.Spec.DynamicCaptureIngress == "" {
+		return nil, fmt.Errorf("plugin %s has no dynamic capture ingress path", h.Spec.ID)
+	}
+	if !strings.HasPrefix(uri, "/") {
+		uri = "/" + uri
+	}
+	return h.Do(method, strings.TrimSuffix(h.Spec.DynamicCaptureIngress, "/")+uri, requestID, header, body)
+}
+
+// Forward routes r like Zoraxy's dynamic router: sniff with a fresh request ID and,
+// when the plugin answers CAPTURED, send the request with body to the ingress
+func (h *Host) Forward(r *http.Request, body []byte) (*ForwardResult, error) {
+	result := &ForwardResult{RequestID: NewRequestID()}
+	payload := plugin.EncodeForwardRequestPayload(r)
+
+	var err error
+	if result.Sniff, err = h.Sniff(&payload, result.RequestID); err != nil {
+		return result, fmt.Errorf("sniff: %w", err)
+	}
+	if result.Sniff != plugin.ControlStatusCode_CAPTURED {
+		return result, nil
+	}
+
+	// Zoraxy appends the origin-form URI, also for requests built with an absolute URL
+	if result.Ingress, err = h.Ingress(r.Method, r.URL.RequestURI(), result.RequestID, r.Header.Clone(), body); err != nil {
+		return result, fmt.Errorf("ingress: %w", err)
+	}
+	return result, nil
+}
+
+// Notify delivers a subscription event to the plugin
+func (h *Host) Notify(event plugin.SubscriptionEvent) (*Response, error) {
+	if h.Spec.SubscriptionPath == "" {
+		return nil, fmt.Errorf("plugin %s has no subscription path", h.Spec.ID)
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return h.Post(h.Spec.SubscriptionPath, "", http.Header{"Content-Type": {"application/json"}}, body)
+}
+
+// ExpectSniff fails the test unless the plugin answers the sniff of r with want
+func (h *Host) ExpectSniff(t testing.TB, r *http.Request, want plugin.ControlStatusCode) plugin.ControlStatusCode {
+	t.Helper()
+	payload := plugin.EncodeForwardRequestPayload(r)
+	got, err := h.Sniff(&payload, NewRequestID())
+	if err != nil {
+		t.Fatalf("Sniff of %s %s failed: %v", r.Host, r.RequestURI, err)
+	}
+	if got != want {
+		t.Errorf("Expected sniff of %s %s to be %d, got %d", r.Host, r.RequestURI, want, got)
+	}
+	return got
+}
+
+// NewRequestID returns a random UUID like the ones Zoraxy assigns to requests
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// keepRedirect hands redirects to the caller, Zoraxy does not follow them either
+func keepRedirect(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// freePort asks the kernel for a currently unused loopback port
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// lockedBuffer collects process output written from several goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
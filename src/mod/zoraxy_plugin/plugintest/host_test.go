@@ -0,0 +1,153 @@
+package plugintest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// newSampleHandler mirrors testdata/sampleplugin as an in-process handler set
+func newSampleHandler() http.Handler {
+	mux := http.NewServeMux()
+	pathRouter := plugin.NewPathRouter()
+	// Zoraxy expects the control status codes, the SDK's sniff helper answers 200 or 501
+	mux.HandleFunc("/d_sniff", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := plugin.DecodeForwardRequestPayload(body)
+		switch {
+		case err != nil:
+			w.WriteHeader(int(plugin.ControlStatusCode_ERROR))
+		case req.Hostname == "capture.example":
+			w.WriteHeader(int(plugin.ControlStatusCode_CAPTURED))
+		default:
+			w.WriteHeader(int(plugin.ControlStatusCode_UNHANDLED))
+		}
+	})
+	pathRouter.RegisterDynamicCaptureHandle("/d_capture", mux, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Request-ID", r.Header.Get("X-Zoraxy-RequestID"))
+		w.Write([]byte(r.Method + " " + r.RequestURI + " " + string(body)))
+	})
+	mux.HandleFunc("/notify", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+	return mux
+}
+
+// checkSampleHost runs the capture decisions shared by the handler and binary tests
+func checkSampleHost(t *testing.T, host *Host) {
+	t.Run("captured", func(t *testing.T) {
+		host.ExpectSniff(t, httptest.NewRequest("GET", "http://capture.example/path?q=1", nil), plugin.ControlStatusCode_CAPTURED)
+	})
+
+	t.Run("unhandled", func(t *testing.T) {
+		host.ExpectSniff(t, httptest.NewRequest("GET", "http://other.example/", nil), plugin.ControlStatusCode_UNHANDLED)
+	})
+
+	t.Run("malformed payload", func(t *testing.T) {
+		code, err := host.SniffRaw([]byte("{not json"), NewRequestID())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if code != plugin.ControlStatusCode_ERROR {
+			t.Errorf("Expected %d, got %d", plugin.ControlStatusCode_ERROR, code)
+		}
+	})
+
+	t.Run("forward captured", func(t *testing.T) {
+		result, err := host.Forward(httptest.NewRequest("POST", "http://capture.example/upload?id=7", nil), []byte("data"))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if result.Ingress == nil {
+			t.Fatal("Expected the request to reach the ingress")
+		}
+		if got := string(result.Ingress.Body); got != "POST /upload?id=7 data" {
+			t.Errorf("Expected ingress to see the rewritten URI, got %q", got)
+		}
+		if got := result.Ingress.Header.Get("X-Request-ID"); got != result.RequestID {
+			t.Errorf("Expected request ID %s, got %s", result.RequestID, got)
+		}
+	})
+
+	t.Run("forward unhandled", func(t *testing.T) {
+		result, err := host.Forward(httptest.NewRequest("GET", "http://other.example/", nil), nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if result.Sniff != plugin.ControlStatusCode_UNHANDLED || result.Ingress != nil {
+			t.Errorf("Expected UNHANDLED without ingress, got %d with %v", result.Sniff, result.Ingress)
+		}
+	})
+}
+
+// Test a handler set served with an introspect
+func TestStartHandler(t *testing.T) {
+	spec := validSpec()
+	spec.SubscriptionPath = "/notify"
+	host := StartHandler(t, spec, newSampleHandler())
+	checkSampleHost(t, host)
+
+	t.Run("notify", func(t *testing.T) {
+		resp, err := host.Notify(plugin.SubscriptionEvent{EventName: "hostAccessRuleChanged", EventSource: "test"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !strings.Contains(string(resp.Body), `"event_name":"hostAccessRuleChanged"`) {
+			t.Errorf("Expected the event JSON to be delivered, got %s", resp.Body)
+		}
+	})
+}
+
+// Test that sniff answers other than 280/284/580 are reported
+func TestSniffUnexpectedStatus(t *testing.T) {
+	host := StartHandler(t, validSpec(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	code, err := host.Sniff(&plugin.DynamicSniffForwardRequest{Hostname: "example.com"}, NewRequestID())
+	if !errors.Is(err, ErrUnexpectedStatus) {
+		t.Errorf("Expected ErrUnexpectedStatus, got %v", err)
+	}
+	if code != http.StatusOK {
+		t.Errorf("Expected status 200 to be reported, got %d", code)
+	}
+}
+
+// Test the full -introspect / -configure handshake with a real plugin binary
+func TestStartBinary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping plugin build in short mode")
+	}
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	binary := filepath.Join(t.TempDir(), "sampleplugin")
+	build := exec.Command(goBinary, "build", "-o", binary, "./testdata/sampleplugin")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("Building sample plugin failed: %v\n%s", err, output)
+	}
+
+	host := StartBinary(t, binary)
+	if host.Spec.ID != "example.plugintest.sample" {
+		t.Errorf("Expected introspect ID example.plugintest.sample, got %q", host.Spec.ID)
+	}
+	checkSampleHost(t, host)
+
+	if err := host.Terminate(); err != nil {
+		t.Errorf("Expected clean termination, got %v", err)
+	}
+	if !strings.Contains(host.Output(), "Sample plugin started with Zoraxy plugintest") {
+		t.Errorf("Expected the runtime constants to reach the plugin, got output %q", host.Output())
+	}
+}
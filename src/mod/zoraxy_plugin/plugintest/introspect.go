@@ -0,0 +1,121 @@
+/*
+Package plugintest simulates the Zoraxy side of the plugin protocol for tests.
+
+It launches a plugin binary with the -introspect / -configure handshake, or serves
+a handler set with a given IntroSpect, and then talks to it the way Zoraxy's
+dynamic router does: sniff requests answered with 280 / 284 / 580, ingress
+requests for captured traffic and subscription events.
+*/
+package plugintest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// IntrospectTimeout bounds the -introspect run of a plugin binary
+var IntrospectTimeout = 10 * time.Second
+
+// pluginIDPattern matches reverse domain IDs like com.example.myplugin
+var pluginIDPattern = regexp.MustCompile(`^[a-zA-Z0-9-]+(\.[a-zA-Z0-9_-]+)+$`)
+
+// ParseIntroSpect decodes the -introspect output strictly and validates it
+func ParseIntroSpect(data []byte) (*plugin.IntroSpect, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var spec plugin.IntroSpect
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid introspect JSON: %w", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("invalid introspect JSON: trailing data after the object")
+	}
+	if err := ValidateIntroSpect(&spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// ValidateIntroSpect checks the fields Zoraxy relies on when loading a plugin.
+// All problems are reported together.
+func ValidateIntroSpect(spec *plugin.IntroSpect) error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !pluginIDPattern.MatchString(spec.ID) {
+		add("id %q must be a reverse domain name like com.example.plugin", spec.ID)
+	}
+	if strings.TrimSpace(spec.Name) == "" {
+		add("name is required")
+	}
+	if spec.Type != plugin.PluginType_Router && spec.Type != plugin.PluginType_Utilities {
+		add("type %d must be 0 (router) or 1 (utilities)", spec.Type)
+	}
+	if spec.VersionMajor < 0 || spec.VersionMinor < 0 || spec.VersionPatch < 0 {
+		add("version %d.%d.%d must not be negative", spec.VersionMajor, spec.VersionMinor, spec.VersionPatch)
+	}
+
+	checkPath := func(field, path string) {
+		if path != "" && (!strings.HasPrefix(path, "/") || (len(path) > 1 && strings.HasSuffix(path, "/"))) {
+			add("%s %q must start with / and not end with /", field, path)
+		}
+	}
+	checkPath("static_capture_ingress", spec.StaticCaptureIngress)
+	checkPath("dynamic_capture_sniff", spec.DynamicCaptureSniff)
+	checkPath("dynamic_capture_ingress", spec.DynamicCaptureIngress)
+	checkPath("ui_path", spec.UIPath)
+	checkPath("subscription_path", spec.SubscriptionPath)
+	for _, rule := range spec.StaticCapturePaths {
+		if !strings.HasPrefix(rule.CapturePath, "/") {
+			add("static capture path %q must start with /", rule.CapturePath)
+		}
+	}
+
+	if len(spec.StaticCapturePaths) > 0 && spec.StaticCaptureIngress == "" {
+		add("static_capture_paths need a static_capture_ingress")
+	}
+	if (spec.DynamicCaptureSniff == "") != (spec.DynamicCaptureIngress == "") {
+		add("dynamic_capture_sniff and dynamic_capture_ingress must be set together")
+	}
+	if spec.DynamicCaptureSniff != "" && spec.DynamicCaptureSniff == spec.DynamicCaptureIngress {
+		add("dynamic_capture_sniff and dynamic_capture_ingress must differ")
+	}
+	if len(spec.SubscriptionsEvents) > 0 && spec.SubscriptionPath == "" {
+		add("subscriptions_events need a subscription_path")
+	}
+	if spec.Type == plugin.PluginType_Router && spec.DynamicCaptureSniff == "" && len(spec.StaticCapturePaths) == 0 {
+		add("router plugins must capture traffic with static or dynamic capture")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid introspect: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Introspect runs the plugin binary with -introspect and validates its output
+func Introspect(binary string) (*plugin.IntroSpect, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), IntrospectTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, "-introspect")
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s -introspect: %w: %s", binary, err, strings.TrimSpace(stderr.String()))
+	}
+	return ParseIntroSpect(output)
+}
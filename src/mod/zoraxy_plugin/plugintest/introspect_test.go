@@ -0,0 +1,97 @@
+package plugintest
+
+import (
+	"strings"
+	"testing"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+func validSpec() *plugin.IntroSpect {
+	return &plugin.IntroSpect{
+		ID:                    "com.example.plugin",
+		Name:                  "Example",
+		Type:                  plugin.PluginType_Router,
+		DynamicCaptureSniff:   "/d_sniff",
+		DynamicCaptureIngress: "/d_capture",
+		UIPath:                "/ui",
+	}
+}
+
+// Test introspect validation
+func TestValidateIntroSpect(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*plugin.IntroSpect)
+		problem string
+	}{
+		{"valid", func(*plugin.IntroSpect) {}, ""},
+		{"utilities without capture", func(s *plugin.IntroSpect) {
+			s.Type = plugin.PluginType_Utilities
+			s.DynamicCaptureSniff, s.DynamicCaptureIngress = "", ""
+		}, ""},
+		{"missing id", func(s *plugin.IntroSpect) { s.ID = "" }, "reverse domain"},
+		{"id without dots", func(s *plugin.IntroSpect) { s.ID = "plugin" }, "reverse domain"},
+		{"missing name", func(s *plugin.IntroSpect) { s.Name = " " }, "name is required"},
+		{"unknown type", func(s *plugin.IntroSpect) { s.Type = 2 }, "type 2"},
+		{"negative version", func(s *plugin.IntroSpect) { s.VersionMinor = -1 }, "must not be negative"},
+		{"relative path", func(s *plugin.IntroSpect) { s.UIPath = "ui" }, "ui_path"},
+		{"trailing slash", func(s *plugin.IntroSpect) { s.DynamicCaptureSniff = "/d_sniff/" }, "dynamic_capture_sniff"},
+		{"sniff without ingress", func(s *plugin.IntroSpect) { s.DynamicCaptureIngress = "" }, "set together"},
+		{"same sniff and ingress", func(s *plugin.IntroSpect) { s.DynamicCaptureIngress = "/d_sniff" }, "must differ"},
+		{"static paths without ingress", func(s *plugin.IntroSpect) {
+			s.StaticCapturePaths = []plugin.StaticCaptureRule{{CapturePath: "/static"}}
+		}, "static_capture_ingress"},
+		{"events without path", func(s *plugin.IntroSpect) {
+			s.SubscriptionsEvents = map[string]string{"hostAccessRuleChanged": "refresh"}
+		}, "subscription_path"},
+		{"router without capture", func(s *plugin.IntroSpect) {
+			s.DynamicCaptureSniff, s.DynamicCaptureIngress = "", ""
+		}, "router plugins"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := validSpec()
+			tt.modify(spec)
+			err := ValidateIntroSpect(spec)
+			if tt.problem == "" {
+				if err != nil {
+					t.Errorf("Expected valid introspect, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("Expected error containing %q, got %v", tt.problem, err)
+			}
+		})
+	}
+}
+
+// Test strict parsing of the -introspect output
+func TestParseIntroSpect(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		spec, err := ParseIntroSpect([]byte(`{"id":"com.example.plugin","name":"Example","type":0,
+			"dynamic_capture_sniff":"/d_sniff","dynamic_capture_ingress":"/d_capture"}`))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if spec.DynamicCaptureSniff != "/d_sniff" {
+			t.Errorf("Expected sniff path /d_sniff, got %q", spec.DynamicCaptureSniff)
+		}
+	})
+
+	for name, data := range map[string]string{
+		"not JSON":      "Plugin started",
+		"unknown field": `{"id":"com.example.plugin","name":"Example","type":1,"sniff":"/x"}`,
+		"trailing data": `{"id":"com.example.plugin","name":"Example","type":1} {}`,
+		"wrong type":    `{"id":"com.example.plugin","name":"Example","type":"router"}`,
+		"invalid spec":  `{"id":"","name":"Example","type":1}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseIntroSpect([]byte(data)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
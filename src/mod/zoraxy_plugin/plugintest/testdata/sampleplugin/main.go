@@ -0,0 +1,60 @@
+// Command sampleplugin is a minimal dynamic capture plugin used by the plugintest tests.
+// It captures requests for capture.example and echoes the ingress request back.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+func main() {
+	runtimeCfg, err := plugin.ServeAndRecvSpec(&plugin.IntroSpect{
+		ID:                    "example.plugintest.sample",
+		Name:                  "Sample",
+		Type:                  plugin.PluginType_Router,
+		VersionMajor:          1,
+		DynamicCaptureSniff:   "/d_sniff",
+		DynamicCaptureIngress: "/d_capture",
+		UIPath:                "/ui",
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	pathRouter := plugin.NewPathRouter()
+	// Zoraxy expects the control status codes, the SDK's sniff helper answers 200 or 501
+	http.HandleFunc("/d_sniff", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := plugin.DecodeForwardRequestPayload(body)
+		switch {
+		case err != nil:
+			w.WriteHeader(int(plugin.ControlStatusCode_ERROR))
+		case req.Hostname == "capture.example":
+			w.WriteHeader(int(plugin.ControlStatusCode_CAPTURED))
+		default:
+			w.WriteHeader(int(plugin.ControlStatusCode_UNHANDLED))
+		}
+	})
+	pathRouter.RegisterDynamicCaptureHandle("/d_capture", http.DefaultServeMux, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Request-ID", r.Header.Get("X-Zoraxy-RequestID"))
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.RequestURI, body)
+	})
+	http.HandleFunc("/ui/term", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			os.Exit(0)
+		}()
+	})
+
+	fmt.Println("Sample plugin started with Zoraxy", runtimeCfg.RuntimeConst.ZoraxyVersion)
+	http.ListenAndServe("127.0.0.1:"+strconv.Itoa(runtimeCfg.Port), nil)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+const (
+	// pendingRequestTTL is how long a captured sniff waits for its ingress request
+	pendingRequestTTL = 30 * time.Second
+	// maxPendingRequests bounds the number of captured sniffs kept in memory
+	maxPendingRequests = 10000
+)
+
+// pendingRequest is a sniffed request that was captured and is awaiting ingress
+type pendingRequest struct {
+	Hostname   string
+	RemoteAddr string
+	CapturedAt time.Time
+}
+
+// pendingRequestStore correlates sniff decisions with the following ingress
+// request through the X-Zoraxy-RequestID header
+type pendingRequestStore struct {
+	mu       sync.Mutex
+	requests map[string]pendingRequest
+}
+
+var pendingRequests = &pendingRequestStore{requests: make(map[string]pendingRequest)}
+
+// Reasons a sniffed request cannot be captured
+var (
+	errMissingRequestID    = errors.New("missing request ID")
+	errPendingRequestsFull = errors.New("too many pending requests")
+)
+
+// add records a captured sniff request. A request that is not recorded
+// cannot be matched by the ingress and must not be captured.
+func (s *pendingRequestStore) add(requestID string, req *plugin.DynamicSniffForwardRequest) error {
+	if requestID == "" {
+		return errMissingRequestID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.requests) >= maxPendingRequests {
+		s.evictExpiredLocked(time.Now())
+		if len(s.requests) >= maxPendingRequests {
+			return errPendingRequestsFull
+		}
+	}
+
+	hostname := req.Hostname
+	if hostname == "" {
+		hostname = req.Host
+	}
+	s.requests[requestID] = pendingRequest{
+		Hostname:   normalizeHostname(hostname),
+		RemoteAddr: req.RemoteAddr,
+		CapturedAt: time.Now(),
+	}
+	return nil
+}
+
+// take returns and removes the captured sniff for the request ID
+func (s *pendingRequestStore) take(requestID string) (pendingRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, ok := s.requests[requestID]
+	if !ok {
+		return pendingRequest{}, false
+	}
+	delete(s.requests, requestID)
+	if time.Since(pending.CapturedAt) > pendingRequestTTL {
+		return pendingRequest{}, false
+	}
+	return pending, true
+}
+
+// evictExpiredLocked removes stale entries, the caller must hold s.mu
+func (s *pendingRequestStore) evictExpiredLocked(now time.Time) {
+	for id, pending := range s.requests {
+		if now.Sub(pending.CapturedAt) > pendingRequestTTL {
+			delete(s.requests, id)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -13,6 +14,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
 )
 
 // Test Proxy Protocol v2 detection and parsing with HAProxy format
@@ -179,6 +182,18 @@ func TestProxyProtocolEdgeCases(t *testing.T) {
 		}
 	})
 
+	t.Run("v1 UNKNOWN without addresses", func(t *testing.T) {
+		testData := []byte("PROXY UNKNOWN\r\nGET / HTTP/1.1\r\n")
+
+		if !detectProxyProtocol(testData) {
+			t.Error("v1 UNKNOWN without addresses should be detected")
+		}
+		remaining, info, err := processProxyProtocolData(testData)
+		if err != nil || info == nil || info.TransportProto != "UNKNOWN" || string(remaining) != "GET / HTTP/1.1\r\n" {
+			t.Errorf("Unexpected result %q %+v (%v)", remaining, info, err)
+		}
+	})
+
 	t.Run("Insufficient v2 data", func(t *testing.T) {
 		// Only signature, no version/command
 		testData := []byte(ProxyProtocolV2Prefix)
@@ -336,7 +351,7 @@ func TestAPIHandlers(t *testing.T) {
 			t.Fatal(err)
 		}
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-CSRF-Token", "test-token") // Add required CSRF token
+		req.Header.Set("X-CSRF-Token", issueTestCSRFToken(t)) // Add required CSRF token
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(handleAPIToggle)
@@ -368,7 +383,7 @@ func TestAPIHandlers(t *testing.T) {
 			t.Fatal(err)
 		}
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-CSRF-Token", "test-token") // Add required CSRF token
+		req.Header.Set("X-CSRF-Token", issueTestCSRFToken(t)) // Add required CSRF token
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(handleAPIToggle)
@@ -411,7 +426,7 @@ func TestAPIHandlers(t *testing.T) {
 			t.Fatal(err)
 		}
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-CSRF-Token", "test-token") // Add required CSRF token
+		req.Header.Set("X-CSRF-Token", issueTestCSRFToken(t)) // Add required CSRF token
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(handleAPIToggle)
@@ -439,6 +454,22 @@ func TestAPIHandlers(t *testing.T) {
 			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, status)
 		}
 	})
+
+	t.Run("Toggle API POST - Unknown CSRF Token", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/ui/api/toggle", strings.NewReader(`{"enabled": true}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-CSRF-Token", "forged-token")
+
+		rr := httptest.NewRecorder()
+		handleAPIToggle(rr, req)
+
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, status)
+		}
+		if !strings.Contains(rr.Body.String(), "invalid CSRF token") {
+			t.Errorf("Expected invalid token message, got %q", rr.Body.String())
+		}
+	})
 }
 
 // Test utility functions
@@ -454,13 +485,6 @@ func TestUtilityFunctions(t *testing.T) {
 			t.Error("min(4, 4) should return 4")
 		}
 	})
-
-	t.Run("isPluginHealthy function", func(t *testing.T) {
-		healthy := isPluginHealthy()
-		if !healthy {
-			t.Error("Plugin should be healthy in test environment")
-		}
-	})
 }
 
 // Test proxy protocol parsing error cases
@@ -552,7 +576,7 @@ func TestProxyProtocolListener(t *testing.T) {
 			w.WriteHeader(http.StatusOK)
 		})
 
-		ppListener := NewProxyProtocolListener(listener, handler, logger)
+		ppListener := NewProxyProtocolListener(listener, handler, listenerLog)
 
 		if ppListener.Listener != listener {
 			t.Error("Listener should be set correctly")
@@ -560,7 +584,7 @@ func TestProxyProtocolListener(t *testing.T) {
 		if ppListener.OriginalHandler == nil {
 			t.Error("Handler should be set")
 		}
-		if ppListener.Logger != logger {
+		if ppListener.Logger != listenerLog {
 			t.Error("Logger should be set correctly")
 		}
 		if ppListener.ReadTimeout != 5*time.Second {
@@ -744,19 +768,20 @@ func TestConfigConcurrency(t *testing.T) {
 
 // Test CORS headers
 func TestCORSHeaders(t *testing.T) {
-	t.Run("Status API has CORS headers", func(t *testing.T) {
+	t.Run("Status API has no wildcard CORS header", func(t *testing.T) {
 		req, err := http.NewRequest("GET", "/ui/api/status", nil)
 		if err != nil {
 			t.Fatal(err)
 		}
+		req.Header.Set("Origin", "https://evil.example")
 
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handleAPIStatus)
+		handler := withOriginPolicy(handleAPIStatus)
 		handler.ServeHTTP(rr, req)
 
 		corsHeader := rr.Header().Get("Access-Control-Allow-Origin")
-		if corsHeader != "*" {
-			t.Errorf("Expected CORS header '*', got '%s'", corsHeader)
+		if corsHeader != "" {
+			t.Errorf("Expected no CORS header for an unknown origin, got '%s'", corsHeader)
 		}
 
 		contentType := rr.Header().Get("Content-Type")
@@ -768,20 +793,37 @@ func TestCORSHeaders(t *testing.T) {
 
 // Test core plugin handlers
 func TestProxyProtocolHandlers(t *testing.T) {
-	t.Run("handleProxyProtocolSniff - Plugin Disabled", func(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) { c.trustedUpstreams = testPrefixes(t, testUpstream, "198.51.100.10") })
+	sniff := func(requestID string) {
+		t.Helper()
+		if rr := serveSniff(t, plugin.DynamicSniffForwardRequest{Hostname: "example.com", RemoteAddr: net.JoinHostPort(testUpstream, "5000")}, requestID); rr.Code != 280 {
+			t.Fatalf("Expected status code 280 (CAPTURED), got %d", rr.Code)
+		}
+	}
+
+	t.Run("sniff - Plugin Disabled", func(t *testing.T) {
 		// Ensure plugin is disabled
 		config.mu.Lock()
 		config.Enabled = false
 		config.mu.Unlock()
 
-		req, err := http.NewRequest("POST", "/proxy_protocol_sniff", strings.NewReader("test data"))
-		if err != nil {
-			t.Fatal(err)
+		rr := serveSniff(t, plugin.DynamicSniffForwardRequest{Method: "GET", Hostname: "example.com"}, "req-1")
+
+		if status := rr.Code; status != 284 {
+			t.Errorf("Expected status code 284 (UNHANDLED), got %d", status)
 		}
 
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handleProxyProtocolSniff)
-		handler.ServeHTTP(rr, req)
+		if body := rr.Body.String(); body != "UNHANDLED" {
+			t.Errorf("Expected body 'UNHANDLED', got '%s'", body)
+		}
+	})
+
+	t.Run("sniff - Plugin Enabled, Host Not Enabled", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, []HostRule{{Pattern: "lb.example.com", Enabled: true}}
+		})
+
+		rr := serveSniff(t, plugin.DynamicSniffForwardRequest{Method: "GET", Hostname: "example.com"}, "req-2")
 
 		if status := rr.Code; status != 284 {
 			t.Errorf("Expected status code 284 (UNHANDLED), got %d", status)
@@ -792,71 +834,95 @@ func TestProxyProtocolHandlers(t *testing.T) {
 		}
 	})
 
-	t.Run("handleProxyProtocolSniff - Plugin Enabled, No Proxy Protocol", func(t *testing.T) {
+	t.Run("sniff - Plugin Enabled, Host Enabled", func(t *testing.T) {
 		// Enable plugin
 		config.mu.Lock()
 		config.Enabled = true
 		config.mu.Unlock()
 
-		httpData := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
-		req, err := http.NewRequest("POST", "/proxy_protocol_sniff", strings.NewReader(httpData))
-		if err != nil {
-			t.Fatal(err)
+		rr := serveSniff(t, plugin.DynamicSniffForwardRequest{
+			Method:     "GET",
+			Hostname:   "example.com",
+			RemoteAddr: "198.51.100.10:50000",
+		}, "req-3")
+
+		if status := rr.Code; status != 280 {
+			t.Errorf("Expected status code 280 (CAPTURED), got %d", status)
 		}
 
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handleProxyProtocolSniff)
-		handler.ServeHTTP(rr, req)
+		if body := rr.Body.String(); body != "CAPTURED" {
+			t.Errorf("Expected body 'CAPTURED', got '%s'", body)
+		}
 
-		if status := rr.Code; status != 284 {
-			t.Errorf("Expected status code 284 (UNHANDLED), got %d", status)
+		pending, ok := pendingRequests.take("req-3")
+		if !ok {
+			t.Fatal("Expected captured request to be pending for ingress")
 		}
+		if pending.Hostname != "example.com" || pending.RemoteAddr != "198.51.100.10:50000" {
+			t.Errorf("Unexpected pending request: %+v", pending)
+		}
+	})
 
-		if body := rr.Body.String(); body != "UNHANDLED" {
-			t.Errorf("Expected body 'UNHANDLED', got '%s'", body)
+	t.Run("sniff - Pending Requests Full", func(t *testing.T) {
+		config.mu.Lock()
+		config.Enabled = true
+		config.mu.Unlock()
+
+		pendingRequests.mu.Lock()
+		for i := 0; i < maxPendingRequests; i++ {
+			pendingRequests.requests[fmt.Sprintf("full-%d", i)] = pendingRequest{CapturedAt: time.Now()}
+		}
+		pendingRequests.mu.Unlock()
+		defer func() {
+			pendingRequests.mu.Lock()
+			pendingRequests.requests = make(map[string]pendingRequest)
+			pendingRequests.mu.Unlock()
+		}()
+
+		rr := serveSniff(t, plugin.DynamicSniffForwardRequest{
+			Method:     "GET",
+			Hostname:   "example.com",
+			RemoteAddr: "198.51.100.10:50000",
+		}, "req-full")
+		if status := rr.Code; status != 284 {
+			t.Errorf("Expected status code 284 (UNHANDLED) with a full store, got %d", status)
+		}
+		if _, ok := pendingRequests.take("req-full"); ok {
+			t.Error("Expected the request not to be pending")
 		}
 	})
 
-	t.Run("handleProxyProtocolSniff - Plugin Enabled, With Proxy Protocol", func(t *testing.T) {
+	t.Run("sniff - Invalid Payload", func(t *testing.T) {
 		// Enable plugin
 		config.mu.Lock()
 		config.Enabled = true
 		config.mu.Unlock()
 
+		// Raw bytes are not a valid forwarded request payload
 		proxyData := "PROXY TCP4 192.0.2.100 198.51.100.50 45678 443\r\nGET / HTTP/1.1\r\n\r\n"
-		req, err := http.NewRequest("POST", "/proxy_protocol_sniff", strings.NewReader(proxyData))
-		if err != nil {
-			t.Fatal(err)
-		}
-
+		req := httptest.NewRequest("POST", SNIFF_PATH, strings.NewReader(proxyData))
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handleProxyProtocolSniff)
-		handler.ServeHTTP(rr, req)
+		newCaptureMux().ServeHTTP(rr, req)
 
-		if status := rr.Code; status != 280 {
-			t.Errorf("Expected status code 280 (CAPTURED), got %d", status)
+		if status := rr.Code; status != 580 {
+			t.Errorf("Expected status code 580 (ERROR), got %d", status)
 		}
 
-		if body := rr.Body.String(); body != "CAPTURED" {
-			t.Errorf("Expected body 'CAPTURED', got '%s'", body)
+		if body := rr.Body.String(); body != "ERROR" {
+			t.Errorf("Expected body 'ERROR', got '%s'", body)
 		}
 	})
 
-	t.Run("handleProxyProtocolSniff - Read Error", func(t *testing.T) {
+	t.Run("sniff - Read Error", func(t *testing.T) {
 		// Enable plugin
 		config.mu.Lock()
 		config.Enabled = true
 		config.mu.Unlock()
 
 		// Create a request with a body that will cause read error
-		req, err := http.NewRequest("POST", "/proxy_protocol_sniff", &errorReader{})
-		if err != nil {
-			t.Fatal(err)
-		}
-
+		req := httptest.NewRequest("POST", SNIFF_PATH, &errorReader{})
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handleProxyProtocolSniff)
-		handler.ServeHTTP(rr, req)
+		newCaptureMux().ServeHTTP(rr, req)
 
 		if status := rr.Code; status != 580 {
 			t.Errorf("Expected status code 580 (ERROR), got %d", status)
@@ -867,6 +933,26 @@ func TestProxyProtocolHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("ingress - Routed Through Capture Path", func(t *testing.T) {
+		// Enable plugin
+		config.mu.Lock()
+		config.Enabled = true
+		config.mu.Unlock()
+
+		proxyData := "PROXY TCP4 192.0.2.100 198.51.100.50 45678 443\r\nGET / HTTP/1.1\r\n\r\n"
+		req := httptest.NewRequest("POST", INGRESS_PATH+"/index.html", strings.NewReader(proxyData))
+		req.Header.Set("X-Zoraxy-RequestID", "req-4")
+		rr := serveIngress(t, req, "example.com")
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("Expected status code %d, got %d", http.StatusOK, status)
+		}
+
+		if header := rr.Header().Get("X-Real-IP"); header != "192.0.2.100" {
+			t.Errorf("Expected X-Real-IP '192.0.2.100', got '%s'", header)
+		}
+	})
+
 	t.Run("handleProxyProtocolIngress - Plugin Disabled", func(t *testing.T) {
 		// Disable plugin
 		config.mu.Lock()
@@ -920,6 +1006,7 @@ func TestProxyProtocolHandlers(t *testing.T) {
 			t.Fatal(err)
 		}
 		req.Header.Set("X-Connection-ID", "test-conn-123")
+		sniff("test-conn-123")
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(handleProxyProtocolIngress)
@@ -950,6 +1037,7 @@ func TestProxyProtocolHandlers(t *testing.T) {
 			t.Fatal(err)
 		}
 		req.Header.Set("X-Connection-ID", "test-conn-123")
+		sniff("test-conn-123")
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(handleProxyProtocolIngress)
@@ -973,6 +1061,7 @@ func TestProxyProtocolHandlers(t *testing.T) {
 			t.Fatal(err)
 		}
 		req.Header.Set("X-Connection-ID", "test-conn-123")
+		sniff("test-conn-123")
 
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(handleProxyProtocolIngress)
@@ -989,7 +1078,7 @@ func TestProxyProtocolListenerMethods(t *testing.T) {
 	t.Run("ProxyProtocolListener Accept", func(t *testing.T) {
 		mockListener := &mockListener{}
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
-		ppListener := NewProxyProtocolListener(mockListener, handler, logger)
+		ppListener := NewProxyProtocolListener(mockListener, handler, listenerLog)
 
 		conn, err := ppListener.Accept()
 		if err != nil {
@@ -1003,7 +1092,7 @@ func TestProxyProtocolListenerMethods(t *testing.T) {
 	t.Run("ProxyProtocolListener Close", func(t *testing.T) {
 		mockListener := &mockListener{}
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
-		ppListener := NewProxyProtocolListener(mockListener, handler, logger)
+		ppListener := NewProxyProtocolListener(mockListener, handler, listenerLog)
 
 		err := ppListener.Close()
 		if err != nil {
@@ -1018,7 +1107,7 @@ func TestProxyProtocolListenerMethods(t *testing.T) {
 	t.Run("ProxyProtocolListener Addr", func(t *testing.T) {
 		mockListener := &mockListener{}
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
-		ppListener := NewProxyProtocolListener(mockListener, handler, logger)
+		ppListener := NewProxyProtocolListener(mockListener, handler, listenerLog)
 
 		addr := ppListener.Addr()
 		if addr == nil {
@@ -1122,6 +1211,41 @@ func TestProxyProtocolMiddleware(t *testing.T) {
 	})
 }
 
+// newCaptureMux returns a mux with the Zoraxy facing endpoints registered
+func newCaptureMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	registerCaptureHandlers(mux)
+	return mux
+}
+
+// serveSniff sends a forwarded request payload to the sniff endpoint
+func serveSniff(t *testing.T, payload plugin.DynamicSniffForwardRequest, requestID string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", SNIFF_PATH, bytes.NewReader(body))
+	req.Header.Set("X-Zoraxy-RequestID", requestID)
+	rr := httptest.NewRecorder()
+	newCaptureMux().ServeHTTP(rr, req)
+	return rr
+}
+
+// testUpstream is the trusted load balancer the ingress tests send from
+const testUpstream = "10.0.0.2"
+
+// serveIngress sniffs a request of the test upstream for hostname and, like Zoraxy,
+// sends req to the ingress with the same request ID
+func serveIngress(t *testing.T, req *http.Request, hostname string) *httptest.ResponseRecorder {
+	t.Helper()
+	serveSniff(t, plugin.DynamicSniffForwardRequest{Hostname: hostname, RemoteAddr: net.JoinHostPort(testUpstream, "5000")},
+		req.Header.Get("X-Zoraxy-RequestID"))
+	rr := httptest.NewRecorder()
+	newCaptureMux().ServeHTTP(rr, req)
+	return rr
+}
+
 // Helper type for testing read errors
 type errorReader struct{}
 
@@ -1170,6 +1294,18 @@ func TestParseProxyProtocolV1EdgeCases(t *testing.T) {
 			t.Errorf("Expected source port 0, got %d", info.SourcePort)
 		}
 	})
+
+	t.Run("parseProxyProtocolV1 with short UNKNOWN", func(t *testing.T) {
+		reader := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\nGET /"))
+
+		info, err := parseProxyProtocolV1(reader)
+		if err != nil {
+			t.Fatalf("Short UNKNOWN parsing should not error: %v", err)
+		}
+		if info.TransportProto != "UNKNOWN" || info.SourceAddr != "" || info.HeaderLength != 15 {
+			t.Errorf("Unexpected info %+v", info)
+		}
+	})
 }
 
 // Test additional parsing v2 edge cases
@@ -1225,3 +1361,163 @@ func TestParseProxyProtocolV2EdgeCases(t *testing.T) {
 		}
 	})
 }
+
+// Test v2 TLV parsing
+func TestParseProxyProtocolV2TLVs(t *testing.T) {
+	header := func(command byte, af byte, addrBlock []byte, tlvs []byte) []byte {
+		var buffer bytes.Buffer
+		buffer.Write([]byte(ProxyProtocolV2Prefix))
+		buffer.WriteByte(command)
+		buffer.WriteByte(af)
+		length := len(addrBlock) + len(tlvs)
+		buffer.WriteByte(byte(length >> 8))
+		buffer.WriteByte(byte(length))
+		buffer.Write(addrBlock)
+		buffer.Write(tlvs)
+		return buffer.Bytes()
+	}
+	ipv4Block := []byte{192, 0, 2, 100, 198, 51, 100, 50, 0xB2, 0x6E, 0x01, 0xBB}
+
+	t.Run("PROXY with TLVs", func(t *testing.T) {
+		tlvs := []byte{
+			0x01, 0x00, 0x02, 'h', '2', // ALPN
+			0x05, 0x00, 0x03, 0xAA, 0xBB, 0xCC, // UNIQUE_ID
+			0xE1, 0x00, 0x00, // custom, empty
+		}
+		data := append(header(0x21, 0x11, ipv4Block, tlvs), "GET / HTTP/1.1\r\n"...)
+
+		remaining, info, err := processProxyProtocolData(data)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(remaining) != "GET / HTTP/1.1\r\n" {
+			t.Errorf("Unexpected remaining data %q", remaining)
+		}
+		if info.HeaderLength != 16+12+len(tlvs) {
+			t.Errorf("Expected header length %d, got %d", 16+12+len(tlvs), info.HeaderLength)
+		}
+		if len(info.TLVs) != 3 {
+			t.Fatalf("Expected 3 TLVs, got %d", len(info.TLVs))
+		}
+		if info.TLVs[0].Name() != "ALPN" || string(info.TLVs[0].Value) != "h2" {
+			t.Errorf("Unexpected ALPN TLV %+v", info.TLVs[0])
+		}
+		if value, ok := info.TLV(PP2TypeUniqueID); !ok || !bytes.Equal(value, []byte{0xAA, 0xBB, 0xCC}) {
+			t.Errorf("Expected UNIQUE_ID TLV, got %x", value)
+		}
+		if info.TLVs[2].Name() != "0xE1" || len(info.TLVs[2].Value) != 0 {
+			t.Errorf("Unexpected custom TLV %+v", info.TLVs[2])
+		}
+	})
+
+	t.Run("LOCAL with TLVs", func(t *testing.T) {
+		data := header(0x20, 0x00, nil, []byte{0x04, 0x00, 0x01, 0x00})
+		_, info, err := processProxyProtocolData(data)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if info.Command != "LOCAL" || len(info.TLVs) != 1 || info.TLVs[0].Name() != "NOOP" {
+			t.Errorf("Unexpected LOCAL info %+v", info)
+		}
+	})
+
+	t.Run("v1 header length", func(t *testing.T) {
+		line := "PROXY TCP4 192.0.2.1 198.51.100.1 1000 443\r\n"
+		_, info, err := processProxyProtocolData([]byte(line + "GET / HTTP/1.1\r\n"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if info.HeaderLength != len(line) {
+			t.Errorf("Expected header length %d, got %d", len(line), info.HeaderLength)
+		}
+	})
+
+	malformed := []struct {
+		name string
+		tlvs []byte
+	}{
+		{"truncated TLV header", []byte{0x01, 0x00}},
+		{"TLV value past header end", []byte{0x01, 0x00, 0x05, 'h', '2'}},
+	}
+	for _, tc := range malformed {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := processProxyProtocolData(header(0x21, 0x11, ipv4Block, tc.tlvs))
+			if !errors.Is(err, errMalformedTLV) {
+				t.Errorf("Expected malformed TLV error, got %v", err)
+			}
+			if reason := parseErrorReason(err); reason != ParseErrorMalformedTLV {
+				t.Errorf("Expected reason %s, got %s", ParseErrorMalformedTLV, reason)
+			}
+		})
+	}
+}
+
+// Test headers built by the encoder parse back to the same info
+func TestEncodeProxyProtocolHeader(t *testing.T) {
+	roundTrip := []*ProxyProtocolInfo{
+		{Version: 1, Command: "PROXY", TransportProto: "TCP4", SourceAddr: "192.0.2.1", SourcePort: 1000, DestAddr: "198.51.100.1", DestPort: 443},
+		{Version: 1, Command: "PROXY", TransportProto: "TCP6", SourceAddr: "2001:db8::1", SourcePort: 1000, DestAddr: "2001:db8::2", DestPort: 443},
+		{Version: 2, Command: "PROXY", TransportProto: "TCP4", SourceAddr: "192.0.2.1", SourcePort: 1000, DestAddr: "198.51.100.1", DestPort: 443,
+			TLVs: []ProxyProtocolTLV{{Type: PP2TypeALPN, Value: []byte("h2")}}},
+		{Version: 2, Command: "PROXY", TransportProto: "TCP6", SourceAddr: "2001:db8::1", SourcePort: 65535, DestAddr: "2001:db8::2", DestPort: 0},
+	}
+	for _, expected := range roundTrip {
+		t.Run(fmt.Sprintf("v%d %s", expected.Version, expected.TransportProto), func(t *testing.T) {
+			header, err := encodeProxyProtocolHeader(expected)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			remaining, info, err := processProxyProtocolData(append(header, "payload"...))
+			if err != nil {
+				t.Fatalf("Failed to parse encoded header %q: %v", header, err)
+			}
+			if string(remaining) != "payload" {
+				t.Errorf("Unexpected remaining data %q", remaining)
+			}
+			if info.SourceAddr != expected.SourceAddr || info.SourcePort != expected.SourcePort ||
+				info.DestAddr != expected.DestAddr || info.DestPort != expected.DestPort ||
+				info.TransportProto != expected.TransportProto || len(info.TLVs) != len(expected.TLVs) {
+				t.Errorf("Expected %+v, got %+v", expected, info)
+			}
+			if info.HeaderLength != len(header) {
+				t.Errorf("Expected header length %d, got %d", len(header), info.HeaderLength)
+			}
+		})
+	}
+
+	t.Run("v2 LOCAL", func(t *testing.T) {
+		header, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 2, Command: "LOCAL"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(header) != 16 || header[12] != 0x20 || header[13] != 0x00 {
+			t.Errorf("Unexpected LOCAL header %x", header)
+		}
+	})
+
+	t.Run("v1 UNKNOWN", func(t *testing.T) {
+		header, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 1, TransportProto: "UNKNOWN"})
+		if err != nil || string(header) != "PROXY UNKNOWN\r\n" {
+			t.Errorf("Unexpected UNKNOWN header %q (%v)", header, err)
+		}
+	})
+
+	invalid := []struct {
+		name string
+		info *ProxyProtocolInfo
+	}{
+		{"unsupported version", &ProxyProtocolInfo{Version: 3}},
+		{"IPv6 address for TCP4", &ProxyProtocolInfo{Version: 1, TransportProto: "TCP4", SourceAddr: "2001:db8::1", DestAddr: "192.0.2.1"}},
+		{"IPv4 address for TCP6", &ProxyProtocolInfo{Version: 2, TransportProto: "TCP6", SourceAddr: "192.0.2.1", DestAddr: "2001:db8::1"}},
+		{"invalid address", &ProxyProtocolInfo{Version: 1, TransportProto: "TCP4", SourceAddr: "example.com", DestAddr: "192.0.2.1"}},
+		{"port out of range", &ProxyProtocolInfo{Version: 2, TransportProto: "TCP4", SourceAddr: "192.0.2.1", DestAddr: "192.0.2.2", SourcePort: 70000}},
+		{"unsupported transport", &ProxyProtocolInfo{Version: 2, TransportProto: "UDP4", SourceAddr: "192.0.2.1", DestAddr: "192.0.2.2"}},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := encodeProxyProtocolHeader(tc.info); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,27 +22,119 @@ const (
 	ProxyProtocolV2Prefix = "\x0D\x0A\x0D\x0A\x00\x0D\x0A\x51\x55\x49\x54\x0A"
 )
 
+// Parse errors, wrapped with details by the parsers
+var (
+	errInvalidV1Header    = errors.New("invalid Proxy Protocol v1 header")
+	errInvalidVersion     = errors.New("invalid Proxy Protocol version")
+	errAddressTooShort    = errors.New("address data too short")
+	errUnsupportedFamily  = errors.New("unsupported address family")
+	errIncompleteV2Header = errors.New("proxy protocol v2 header incomplete")
+	errMalformedTLV       = errors.New("malformed TLV")
+)
+
+// headerError is a parse error located at a byte offset of the header
+type headerError struct {
+	Offset int
+	Err    error
+}
+
+func (e *headerError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *headerError) Unwrap() error {
+	return e.Err
+}
+
+// errorAt records the header offset the error was found at
+func errorAt(offset int, err error) error {
+	return &headerError{Offset: offset, Err: err}
+}
+
+// errorOffset returns the header offset of a parse error, if known
+func errorOffset(err error) (int, bool) {
+	var located *headerError
+	if errors.As(err, &located) {
+		return located.Offset, true
+	}
+	return 0, false
+}
+
+// Proxy Protocol v2 TLV types (PP2_TYPE_*)
+const (
+	PP2TypeALPN      = 0x01
+	PP2TypeAuthority = 0x02
+	PP2TypeCRC32C    = 0x03
+	PP2TypeNoop      = 0x04
+	PP2TypeUniqueID  = 0x05
+	PP2TypeSSL       = 0x20
+	PP2TypeNetNS     = 0x30
+)
+
+// pp2ClientSSL is the PP2_CLIENT_SSL flag of the SSL TLV client field
+const pp2ClientSSL = 0x01
+
+var tlvTypeNames = map[byte]string{
+	PP2TypeALPN:      "ALPN",
+	PP2TypeAuthority: "AUTHORITY",
+	PP2TypeCRC32C:    "CRC32C",
+	PP2TypeNoop:      "NOOP",
+	PP2TypeUniqueID:  "UNIQUE_ID",
+	PP2TypeSSL:       "SSL",
+	PP2TypeNetNS:     "NETNS",
+	PP2TypeGCP:       "GCP",
+	PP2TypeAWS:       "AWS",
+	PP2TypeAzure:     "AZURE",
+}
+
+// ProxyProtocolTLV is a type-length-value extension of a v2 header
+type ProxyProtocolTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Name returns the PP2_TYPE name, or the hex type for custom TLVs
+func (t ProxyProtocolTLV) Name() string {
+	if name, ok := tlvTypeNames[t.Type]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", t.Type)
+}
+
 // ProxyProtocolInfo contains information from the Proxy Protocol header
 type ProxyProtocolInfo struct {
 	SourceAddr      string
 	DestAddr        string
 	SourcePort      int
 	DestPort        int
-	Version         int    // 1 or 2
-	TransportProto  string // "TCP4", "TCP6", or "UNKNOWN"
+	Version         int                // 1 or 2
+	Command         string             // "PROXY" or "LOCAL"
+	TransportProto  string             // "TCP4", "TCP6", or "UNKNOWN"
+	TLVs            []ProxyProtocolTLV // v2 only
+	HeaderLength    int                // bytes taken by the header
 	OriginalRequest *http.Request
+
+	// Decoded vendor TLVs, filled in by decodeCloudTLVs after parsing
+	AWSVPCEndpointID   string  // AWS PrivateLink VPC endpoint ID, e.g. vpce-0123456789abcdef0
+	AzureLinkID        *uint32 // Azure Private Link LINKID
+	GCPPSCConnectionID *uint64 // GCP Private Service Connect connection ID
 }
 
 // Listener implements the Proxy Protocol support
 type ProxyProtocolListener struct {
 	Listener        net.Listener
-	Logger          *log.Logger
+	Logger          *slog.Logger
 	ReadTimeout     time.Duration // Timeout for reading the Proxy Protocol header
 	OriginalHandler http.Handler  // Original HTTP Handler
+
+	// detached listeners only parse: no health, metrics, inspector, registry
+	// or ban side effects (used by the self-test)
+	detached bool
 }
 
 // NewProxyProtocolListener creates a new listener with Proxy Protocol support
-func NewProxyProtocolListener(listener net.Listener, handler http.Handler, logger *log.Logger) *ProxyProtocolListener {
+func NewProxyProtocolListener(listener net.Listener, handler http.Handler, logger *slog.Logger) *ProxyProtocolListener {
+	listeners.bound(listenerName(listener), listener.Addr().String())
 	return &ProxyProtocolListener{
 		Listener:        listener,
 		Logger:          logger,
@@ -47,13 +143,44 @@ func NewProxyProtocolListener(listener net.Listener, handler http.Handler, logge
 	}
 }
 
-// Accept accepts a connection and reads the Proxy Protocol header
+// newDetachedListener wraps a listener that parses headers without
+// touching the health checks, metrics, inspector, registry or bans
+func newDetachedListener(listener net.Listener) *ProxyProtocolListener {
+	return &ProxyProtocolListener{
+		Listener:    listener,
+		Logger:      listenerLog,
+		ReadTimeout: 5 * time.Second,
+		detached:    true,
+	}
+}
+
+// Accept accepts a connection and reads the Proxy Protocol header.
+// Connections of banned peers and clients are closed right away.
 func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
-	conn, err := l.Listener.Accept()
-	if err != nil {
-		return nil, err
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if !l.detached && isListenerFailure(err) {
+				listeners.failed(listenerName(l.Listener), err)
+			}
+			return nil, err
+		}
+		if !l.detached {
+			listeners.accepted(listenerName(l.Listener))
+		}
+		if !l.detached && peerBanned(conn.RemoteAddr().String(), ConnectionOriginListener) {
+			conn.Close()
+			continue
+		}
+		if accepted := l.readHeader(conn); accepted != nil {
+			return accepted, nil
+		}
 	}
+}
 
+// readHeader reads the Proxy Protocol header of a new connection. It returns
+// nil when the connection was closed because its client is banned.
+func (l *ProxyProtocolListener) readHeader(conn net.Conn) net.Conn {
 	// Set timeout
 	if l.ReadTimeout > 0 {
 		conn.SetReadDeadline(time.Now().Add(l.ReadTimeout))
@@ -64,12 +191,17 @@ func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
 
 	// Read first bytes (without consuming)
 	peek, err := br.Peek(14) // Enough to detect the signature (v1 or v2)
+	// Keep a copy of what arrived for the header inspector
+	raw, _ := br.Peek(min(br.Buffered(), maxInspectorRawBytes))
+	raw = append([]byte(nil), raw...)
 	if err != nil {
-		l.Logger.Printf("Error reading Proxy Protocol header: %v", err)
-		return conn, nil // Accept connection normally if header cannot be read
+		l.Logger.Warn("Error reading Proxy Protocol header", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		l.inspect(conn, raw, nil, err)
+		return conn // Accept connection normally if header cannot be read
 	}
 
 	var proxyInfo *ProxyProtocolInfo
+	parseStarted := time.Now()
 
 	// Check if it's a Proxy Protocol header
 	if bytes.HasPrefix(peek, []byte(ProxyProtocolV1Prefix)) {
@@ -80,16 +212,50 @@ func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
 		proxyInfo, err = parseProxyProtocolV2(br)
 	} else {
 		// No Proxy Protocol header
-		return conn, nil
+		l.inspect(conn, raw, nil, nil)
+		return conn
+	}
+	if !l.detached {
+		metrics.observeParse(parseStarted, proxyInfo, err)
 	}
+	l.inspect(conn, raw, proxyInfo, err)
 
 	if err != nil {
-		l.Logger.Printf("Error parsing Proxy Protocol header: %v", err)
-		return conn, nil
+		l.Logger.Warn("Error parsing Proxy Protocol header", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		if !l.detached {
+			recordFailure(conn.RemoteAddr().String(), BanReasonParse, "origin", ConnectionOriginListener)
+		}
+		return conn
 	}
 
 	// Reset timeout
 	conn.SetReadDeadline(time.Time{})
+	if l.detached {
+		return &proxyProtocolConn{
+			Conn:            conn,
+			ProxyInfo:       proxyInfo,
+			BufReader:       br,
+			proxyRemoteAddr: &proxyProtocolAddr{proxyInfo.SourceAddr, proxyInfo.SourcePort},
+			proxyLocalAddr:  &proxyProtocolAddr{proxyInfo.DestAddr, proxyInfo.DestPort},
+		}
+	}
+
+	// Clients banned for policy failures are rejected once their address is known
+	if peerBanned(proxyInfo.SourceAddr, ConnectionOriginListener) {
+		conn.Close()
+		return nil
+	}
+
+	// Map the vendor TLVs before the registry records the cloud endpoint
+	decodeCloudTLVs(proxyInfo, currentCustomTLVs())
+
+	// Track the connection until it is closed
+	upstreams.seen(conn.RemoteAddr().String())
+	registryID := connections.newID()
+	connections.register(registryID, ConnectionOriginListener, proxyInfo, "", 0)
+	l.Logger.Debug("Proxy Protocol header accepted", "remote_addr", conn.RemoteAddr().String(),
+		"source", net.JoinHostPort(proxyInfo.SourceAddr, strconv.Itoa(proxyInfo.SourcePort)),
+		"unique_id", proxyInfo.UniqueID(), "connection_id", registryID)
 
 	// Return connection with Proxy Protocol information
 	return &proxyProtocolConn{
@@ -98,14 +264,30 @@ func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
 		BufReader:       br,
 		proxyRemoteAddr: &proxyProtocolAddr{proxyInfo.SourceAddr, proxyInfo.SourcePort},
 		proxyLocalAddr:  &proxyProtocolAddr{proxyInfo.DestAddr, proxyInfo.DestPort},
-	}, nil
+		registryID:      registryID,
+	}
+}
+
+// inspect records what arrived on a connection in the header inspector
+func (l *ProxyProtocolListener) inspect(conn net.Conn, raw []byte, info *ProxyProtocolInfo, err error) {
+	if !l.detached {
+		inspector.record(ConnectionOriginListener, conn.RemoteAddr().String(), "", raw, info, err)
+	}
 }
 
 // Close closes the listener
 func (l *ProxyProtocolListener) Close() error {
+	if !l.detached {
+		listeners.closed(listenerName(l.Listener))
+	}
 	return l.Listener.Close()
 }
 
+// listenerName identifies a Proxy Protocol listener in the health checks
+func listenerName(listener net.Listener) string {
+	return "proxy-protocol " + listener.Addr().String()
+}
+
 // Addr returns the listener's address
 func (l *ProxyProtocolListener) Addr() net.Addr {
 	return l.Listener.Addr()
@@ -118,11 +300,24 @@ type proxyProtocolConn struct {
 	BufReader       *bufio.Reader
 	proxyRemoteAddr net.Addr
 	proxyLocalAddr  net.Addr
+	registryID      string // ID in the connection registry, empty if untracked
 }
 
 // Read reads data from the reader
 func (c *proxyProtocolConn) Read(b []byte) (int, error) {
-	return c.BufReader.Read(b)
+	n, err := c.BufReader.Read(b)
+	if c.registryID != "" && n > 0 {
+		connections.touch(c.registryID, n)
+	}
+	return n, err
+}
+
+// Close closes the connection and removes it from the registry
+func (c *proxyProtocolConn) Close() error {
+	if c.registryID != "" {
+		connections.remove(c.registryID)
+	}
+	return c.Conn.Close()
 }
 
 // LocalAddr returns the local address
@@ -157,18 +352,30 @@ func parseProxyProtocolV1(reader *bufio.Reader) (*ProxyProtocolInfo, error) {
 		return nil, err
 	}
 
+	headerLength := len(line)
+
 	// Remove \r\n at the end
 	line = strings.TrimSpace(line)
 
 	// Parse header
 	parts := strings.Split(line, " ")
+
+	// "PROXY UNKNOWN" may stand alone without the address placeholders
+	if len(parts) >= 2 && len(parts) < 6 && parts[0] == "PROXY" && parts[1] == "UNKNOWN" {
+		return &ProxyProtocolInfo{
+			Version:        1,
+			Command:        "PROXY",
+			TransportProto: "UNKNOWN",
+			HeaderLength:   headerLength,
+		}, nil
+	}
 	if len(parts) < 6 {
-		return nil, fmt.Errorf("invalid Proxy Protocol v1 header: %s", line)
+		return nil, errorAt(len(line), fmt.Errorf("%w: %s", errInvalidV1Header, line))
 	}
 
 	// Format: "PROXY TCP4/TCP6 SOURCE_IP DEST_IP SOURCE_PORT DEST_PORT"
 	if parts[0] != "PROXY" {
-		return nil, fmt.Errorf("invalid Proxy Protocol v1 prefix: %s", parts[0])
+		return nil, errorAt(0, fmt.Errorf("%w: invalid prefix %s", errInvalidV1Header, parts[0]))
 	}
 
 	proto := parts[1]
@@ -186,15 +393,17 @@ func parseProxyProtocolV1(reader *bufio.Reader) (*ProxyProtocolInfo, error) {
 		SourcePort:     sourcePort,
 		DestPort:       destPort,
 		Version:        1,
+		Command:        "PROXY",
 		TransportProto: proto,
+		HeaderLength:   headerLength,
 	}, nil
 }
 
 // Parser for Proxy Protocol v2 (binary header)
 func parseProxyProtocolV2(reader *bufio.Reader) (*ProxyProtocolInfo, error) {
-	// Read and discard signature (13 bytes)
+	// Read and discard signature (12 bytes)
 	signature := make([]byte, 12)
-	if _, err := reader.Read(signature); err != nil {
+	if _, err := io.ReadFull(reader, signature); err != nil {
 		return nil, err
 	}
 
@@ -207,18 +416,7 @@ func parseProxyProtocolV2(reader *bufio.Reader) (*ProxyProtocolInfo, error) {
 	// Check version
 	version := versionCmd >> 4
 	if version != 2 {
-		return nil, fmt.Errorf("invalid Proxy Protocol version: %d", version)
-	}
-
-	// Check command
-	command := versionCmd & 0xF
-	if command != 1 {
-		// Local commands (COMMAND_LOCAL) or other unsupported commands
-		// In this case, just skip the rest of the header
-		return &ProxyProtocolInfo{
-			Version:        2,
-			TransportProto: "UNKNOWN",
-		}, nil
+		return nil, errorAt(12, fmt.Errorf("%w: %d", errInvalidVersion, version))
 	}
 
 	// Read address family and protocol (1 byte)
@@ -232,16 +430,37 @@ func parseProxyProtocolV2(reader *bufio.Reader) (*ProxyProtocolInfo, error) {
 
 	// Read length (2 bytes)
 	lenBytes := make([]byte, 2)
-	if _, err := reader.Read(lenBytes); err != nil {
+	if _, err := io.ReadFull(reader, lenBytes); err != nil {
 		return nil, err
 	}
 	addrLen := int(lenBytes[0])<<8 | int(lenBytes[1])
 
-	// Read address data
+	// Read address data and TLVs
 	addrData := make([]byte, addrLen)
-	if _, err := reader.Read(addrData); err != nil {
+	if _, err := io.ReadFull(reader, addrData); err != nil {
 		return nil, err
 	}
+	headerLength := 16 + addrLen
+
+	// Check command
+	command := versionCmd & 0xF
+	if command != 1 {
+		// Local commands (COMMAND_LOCAL) or other unsupported commands.
+		// The address block is ignored, only the TLVs are kept.
+		tlvOffset := min(addressBlockLength(af), addrLen)
+		tlvs, err := parseTLVs(addrData[tlvOffset:], 16+tlvOffset)
+		if err != nil {
+			return nil, err
+		}
+		info := &ProxyProtocolInfo{
+			Version:        2,
+			Command:        "LOCAL",
+			TransportProto: "UNKNOWN",
+			TLVs:           tlvs,
+			HeaderLength:   headerLength,
+		}
+		return info, nil
+	}
 
 	// Parse address and ports based on address family
 	var sourceAddr, destAddr string
@@ -251,7 +470,7 @@ func parseProxyProtocolV2(reader *bufio.Reader) (*ProxyProtocolInfo, error) {
 	switch af {
 	case 1: // AF_INET (IPv4)
 		if addrLen < 12 {
-			return nil, fmt.Errorf("IPv4 address data too short: %d bytes", addrLen)
+			return nil, errorAt(14, fmt.Errorf("IPv4 %w: %d bytes", errAddressTooShort, addrLen))
 		}
 		sourceAddr = fmt.Sprintf("%d.%d.%d.%d", addrData[0], addrData[1], addrData[2], addrData[3])
 		destAddr = fmt.Sprintf("%d.%d.%d.%d", addrData[4], addrData[5], addrData[6], addrData[7])
@@ -261,7 +480,7 @@ func parseProxyProtocolV2(reader *bufio.Reader) (*ProxyProtocolInfo, error) {
 
 	case 2: // AF_INET6 (IPv6)
 		if addrLen < 36 {
-			return nil, fmt.Errorf("IPv6 address data too short: %d bytes", addrLen)
+			return nil, errorAt(14, fmt.Errorf("IPv6 %w: %d bytes", errAddressTooShort, addrLen))
 		}
 		// Format IPv6 addresses
 		srcIP := net.IP(addrData[0:16])
@@ -273,34 +492,221 @@ func parseProxyProtocolV2(reader *bufio.Reader) (*ProxyProtocolInfo, error) {
 		proto = "TCP6"
 
 	default:
-		return nil, fmt.Errorf("unsupported address family: %d", af)
+		return nil, errorAt(13, fmt.Errorf("%w: %d", errUnsupportedFamily, af))
 	}
 
-	return &ProxyProtocolInfo{
+	tlvs, err := parseTLVs(addrData[addressBlockLength(af):], 16+addressBlockLength(af))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ProxyProtocolInfo{
 		SourceAddr:     sourceAddr,
 		DestAddr:       destAddr,
 		SourcePort:     sourcePort,
 		DestPort:       destPort,
 		Version:        2,
+		Command:        "PROXY",
 		TransportProto: proto,
-	}, nil
+		TLVs:           tlvs,
+		HeaderLength:   headerLength,
+	}
+	return info, nil
+}
+
+// addressBlockLength returns the size of the v2 address block for the family
+func addressBlockLength(af byte) int {
+	switch af {
+	case 1: // AF_INET
+		return 12
+	case 2: // AF_INET6
+		return 36
+	case 3: // AF_UNIX
+		return 216
+	default:
+		return 0
+	}
+}
+
+// parseTLVs splits the data following the v2 address block into TLVs.
+// base is the header offset of data, used in error offsets.
+func parseTLVs(data []byte, base int) ([]ProxyProtocolTLV, error) {
+	var tlvs []ProxyProtocolTLV
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 3 {
+			return nil, errorAt(base+offset, fmt.Errorf("%w: truncated TLV header at offset %d",
+				errMalformedTLV, base+offset))
+		}
+		length := int(data[offset+1])<<8 | int(data[offset+2])
+		if len(data)-offset-3 < length {
+			return nil, errorAt(base+offset, fmt.Errorf("%w: TLV 0x%02X at offset %d needs %d bytes, %d left",
+				errMalformedTLV, data[offset], base+offset, length, len(data)-offset-3))
+		}
+		tlvs = append(tlvs, ProxyProtocolTLV{
+			Type:  data[offset],
+			Value: data[offset+3 : offset+3+length],
+		})
+		offset += 3 + length
+	}
+	return tlvs, nil
+}
+
+// TLV returns the value of the first TLV of the given type
+func (info *ProxyProtocolInfo) TLV(tlvType byte) ([]byte, bool) {
+	for _, tlv := range info.TLVs {
+		if tlv.Type == tlvType {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
 }
 
-// ProxyProtocolMiddleware is an HTTP middleware that inserts Proxy Protocol information into the request
+// encodeProxyProtocolHeader builds the v1 or v2 header for the info
+func encodeProxyProtocolHeader(info *ProxyProtocolInfo) ([]byte, error) {
+	switch info.Version {
+	case 1:
+		return encodeProxyProtocolV1(info)
+	case 2:
+		return encodeProxyProtocolV2(info)
+	default:
+		return nil, fmt.Errorf("%w: %d", errInvalidVersion, info.Version)
+	}
+}
+
+// Encoder for Proxy Protocol v1
+func encodeProxyProtocolV1(info *ProxyProtocolInfo) ([]byte, error) {
+	if info.TransportProto == "UNKNOWN" {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+
+	src, dst, err := encodeAddresses(info)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+		info.TransportProto, src, dst, info.SourcePort, info.DestPort)), nil
+}
+
+// Encoder for Proxy Protocol v2 (binary header)
+func encodeProxyProtocolV2(info *ProxyProtocolInfo) ([]byte, error) {
+	var body bytes.Buffer
+	versionCmd := byte(0x21) // version 2, PROXY
+	afProto := byte(0x00)    // AF_UNSPEC
+
+	if info.Command == "LOCAL" {
+		versionCmd = 0x20
+	} else {
+		src, dst, err := encodeAddresses(info)
+		if err != nil {
+			return nil, err
+		}
+		if info.TransportProto == "TCP4" {
+			afProto = 0x11 // AF_INET, STREAM
+			body.Write(src.To4())
+			body.Write(dst.To4())
+		} else {
+			afProto = 0x21 // AF_INET6, STREAM
+			body.Write(src.To16())
+			body.Write(dst.To16())
+		}
+		body.Write([]byte{byte(info.SourcePort >> 8), byte(info.SourcePort), byte(info.DestPort >> 8), byte(info.DestPort)})
+	}
+
+	for _, tlv := range info.TLVs {
+		if len(tlv.Value) > 0xFFFF {
+			return nil, fmt.Errorf("%w: TLV 0x%02X value too long", errMalformedTLV, tlv.Type)
+		}
+		body.Write([]byte{tlv.Type, byte(len(tlv.Value) >> 8), byte(len(tlv.Value))})
+		body.Write(tlv.Value)
+	}
+	if body.Len() > 0xFFFF {
+		return nil, fmt.Errorf("proxy protocol v2 header too long: %d bytes", body.Len())
+	}
+
+	header := make([]byte, 0, 16+body.Len())
+	header = append(header, ProxyProtocolV2Prefix...)
+	header = append(header, versionCmd, afProto, byte(body.Len()>>8), byte(body.Len()))
+	return append(header, body.Bytes()...), nil
+}
+
+// encodeAddresses validates the addresses and ports against the transport protocol
+func encodeAddresses(info *ProxyProtocolInfo) (net.IP, net.IP, error) {
+	src := net.ParseIP(info.SourceAddr)
+	dst := net.ParseIP(info.DestAddr)
+	if src == nil || dst == nil {
+		return nil, nil, fmt.Errorf("invalid address %q -> %q", info.SourceAddr, info.DestAddr)
+	}
+
+	switch info.TransportProto {
+	case "TCP4":
+		if src.To4() == nil || dst.To4() == nil {
+			return nil, nil, fmt.Errorf("TCP4 needs IPv4 addresses, got %s -> %s", info.SourceAddr, info.DestAddr)
+		}
+		src, dst = src.To4(), dst.To4()
+	case "TCP6":
+		if src.To4() != nil || dst.To4() != nil {
+			return nil, nil, fmt.Errorf("TCP6 needs IPv6 addresses, got %s -> %s", info.SourceAddr, info.DestAddr)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported transport protocol %q", info.TransportProto)
+	}
+
+	for _, port := range []int{info.SourcePort, info.DestPort} {
+		if port < 0 || port > 0xFFFF {
+			return nil, nil, fmt.Errorf("invalid port %d", port)
+		}
+	}
+	return src, dst, nil
+}
+
+// proxyProtocolConnKey is the request context key of the Proxy Protocol
+// connection, an unexported type cannot collide with keys of other packages
+type proxyProtocolConnKey struct{}
+
+// ProxyProtocolConnContext stores Proxy Protocol connections in the request
+// context for ProxyProtocolMiddleware, use it as http.Server.ConnContext
+func ProxyProtocolConnContext(ctx context.Context, c net.Conn) context.Context {
+	if pc, ok := c.(*proxyProtocolConn); ok {
+		return context.WithValue(ctx, proxyProtocolConnKey{}, pc)
+	}
+	return ctx
+}
+
+// ProxyProtocolMiddleware is an HTTP middleware that inserts Proxy Protocol information into the request.
+// It sets the same client headers as the capture ingress, including the X-Forwarded-For chain.
 func ProxyProtocolMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check if the connection comes via Proxy Protocol
-		if pc, ok := r.Context().Value("proxy_protocol_conn").(*proxyProtocolConn); ok {
+		if pc, ok := r.Context().Value(proxyProtocolConnKey{}).(*proxyProtocolConn); ok {
 			// Use remote address from Proxy Protocol
 			r.RemoteAddr = pc.RemoteAddr().String()
+			customTLVs := currentCustomTLVs()
 
-			// Set X-Forwarded-For header if not present
-			if r.Header.Get("X-Forwarded-For") == "" {
-				r.Header.Set("X-Forwarded-For", pc.ProxyInfo.SourceAddr)
+			if !checkEndpointAccess(pc.ProxyInfo) {
+				listenerLog.Warn("Request denied by endpoint access rules", "remote_addr", r.RemoteAddr,
+					"endpoint", endpointLabel(pc.ProxyInfo))
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			src := &headerSource{info: pc.ProxyInfo, hostname: r.Host, tls: r.TLS != nil}
+			if allowed, access := checkIPAccess(src, "origin", ConnectionOriginListener); !allowed {
+				writeIPAccessDenied(w, access)
+				return
+			}
+			if allowed, wait := checkRateLimit(pc.ProxyInfo, r.Host, "origin", ConnectionOriginListener); !allowed {
+				writeRateLimited(w, wait)
+				return
 			}
 
-			// Set X-Real-IP header
-			r.Header.Set("X-Real-IP", pc.ProxyInfo.SourceAddr)
+			// Remove client-identity headers of untrusted peers from the request
+			var stripped []string
+			src.sent, stripped = sanitizeClientHeaders(r.Header, pc.ProxyInfo)
+			for _, name := range stripped {
+				r.Header.Del(name)
+			}
+			countStripped(stripped)
+			applyClientHeaders(r.Header, src)
+			applyCustomTLVHeaders(r.Header, pc.ProxyInfo, customTLVs)
 
 			// Set X-Forwarded-Proto header if not present
 			if r.Header.Get("X-Forwarded-Proto") == "" {
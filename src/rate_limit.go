@@ -0,0 +1,451 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitSweepInterval is how often refilled buckets are dropped
+	rateLimitSweepInterval = 30 * time.Second
+	// maxRateLimitBuckets bounds the number of tracked clients
+	maxRateLimitBuckets = 16384
+	// rateLimitEvictionScan is how many of the least recently used buckets are
+	// searched for one that can be evicted without losing a limit
+	rateLimitEvictionScan = 64
+	// maxRateLimitRate bounds the configurable rate and burst
+	maxRateLimitRate = 1_000_000
+
+	defaultRateLimitIPv4Prefix = 32
+	defaultRateLimitIPv6Prefix = 64
+	defaultRateLimitTop        = 10
+	maxRateLimitTop            = 500
+)
+
+// RateLimit is a token bucket: Rate requests per second on average, up to
+// Burst at once. A rate of 0 disables the limit.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"` // default: the rate, at least 1
+}
+
+// HostRateLimit overrides the limit for the hostnames matched by a host pattern.
+// Its clients get buckets of their own.
+type HostRateLimit struct {
+	Pattern string `json:"pattern"`
+	Match   string `json:"match"` // "exact", "wildcard" or "regex"
+	RateLimit
+
+	rule HostRule
+}
+
+// RateLimitSettings limits requests per client address of the PROXY header.
+// Clients are grouped by subnet, e.g. /24 and /64 to treat a network as one client.
+type RateLimitSettings struct {
+	Enabled    bool            `json:"enabled"`
+	RateLimit                  // applies to hosts without an override
+	IPv4Prefix int             `json:"ipv4_prefix"` // default 32
+	IPv6Prefix int             `json:"ipv6_prefix"` // default 64
+	Hosts      []HostRateLimit `json:"hosts"`
+}
+
+// RateLimitBucket is the state of a client bucket
+type RateLimitBucket struct {
+	Client   string    `json:"client"` // subnet of the client
+	Scope    string    `json:"scope"`  // global or the pattern of the host override
+	Tokens   float64   `json:"tokens"`
+	Allowed  uint64    `json:"allowed"`
+	Limited  uint64    `json:"limited"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// RateLimitResponse is returned by the rate limit API
+type RateLimitResponse struct {
+	RateLimitSettings
+	Buckets int               `json:"buckets"` // clients currently tracked
+	Top     []RateLimitBucket `json:"top"`     // most limited clients first
+}
+
+func defaultRateLimitSettings() RateLimitSettings {
+	return RateLimitSettings{IPv4Prefix: defaultRateLimitIPv4Prefix, IPv6Prefix: defaultRateLimitIPv6Prefix}
+}
+
+// normalize validates the rate and fills in the default burst
+func (l *RateLimit) normalize() error {
+	if math.IsNaN(l.Rate) || l.Rate < 0 || l.Rate > maxRateLimitRate {
+		return fmt.Errorf("rate %v is outside 0-%d requests per second", l.Rate, maxRateLimitRate)
+	}
+	if l.Burst < 0 || l.Burst > maxRateLimitRate {
+		return fmt.Errorf("burst %d is outside 0-%d requests", l.Burst, maxRateLimitRate)
+	}
+	if l.Burst == 0 && l.Rate > 0 {
+		l.Burst = max(1, int(math.Ceil(l.Rate)))
+	}
+	return nil
+}
+
+// normalize validates the settings and host overrides
+func (s *RateLimitSettings) normalize() error {
+	if err := s.RateLimit.normalize(); err != nil {
+		return err
+	}
+	if s.IPv4Prefix == 0 {
+		s.IPv4Prefix = defaultRateLimitIPv4Prefix
+	}
+	if s.IPv6Prefix == 0 {
+		s.IPv6Prefix = defaultRateLimitIPv6Prefix
+	}
+	if s.IPv4Prefix < 8 || s.IPv4Prefix > 32 {
+		return fmt.Errorf("IPv4 prefix /%d is outside /8-/32", s.IPv4Prefix)
+	}
+	if s.IPv6Prefix < 16 || s.IPv6Prefix > 128 {
+		return fmt.Errorf("IPv6 prefix /%d is outside /16-/128", s.IPv6Prefix)
+	}
+
+	hosts := make([]HostRateLimit, 0, len(s.Hosts))
+	for i := range s.Hosts {
+		host := s.Hosts[i]
+		host.rule = HostRule{Pattern: host.Pattern, Match: host.Match, Enabled: true}
+		if err := host.rule.compile(); err != nil {
+			return fmt.Errorf("host override %d: %w", i+1, err)
+		}
+		host.Pattern, host.Match = host.rule.Pattern, host.rule.Match
+		if err := host.RateLimit.normalize(); err != nil {
+			return fmt.Errorf("host override %s: %w", host.Pattern, err)
+		}
+		hosts = append(hosts, host)
+	}
+	s.Hosts = hosts
+	return nil
+}
+
+// clone returns a copy that can be used outside the config lock
+func (s RateLimitSettings) clone() RateLimitSettings {
+	s.Hosts = append([]HostRateLimit{}, s.Hosts...)
+	return s
+}
+
+// limitFor returns the limit and bucket scope of a hostname
+func (s *RateLimitSettings) limitFor(hostname string) (RateLimit, string) {
+	if hostname != "" {
+		hostname = normalizeHostname(hostname)
+		for i := range s.Hosts {
+			if s.Hosts[i].rule.matches(hostname) {
+				return s.Hosts[i].RateLimit, s.Hosts[i].Pattern
+			}
+		}
+	}
+	return s.RateLimit, IPAccessScopeGlobal
+}
+
+// clientKey groups an address into its configured subnet
+func (s *RateLimitSettings) clientKey(addr netip.Addr) string {
+	addr = addr.Unmap()
+	bits := s.IPv6Prefix
+	if addr.Is4() {
+		bits = s.IPv4Prefix
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
+
+// tokenBucket tracks the tokens of a client in a scope
+type tokenBucket struct {
+	RateLimitBucket
+	key     string
+	limit   RateLimit
+	updated time.Time
+	blocked bool // the last request was limited
+}
+
+// refill adds the tokens earned since the last update
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.Tokens = math.Min(float64(b.limit.Burst), b.Tokens+elapsed*b.limit.Rate)
+		b.updated = now
+	}
+}
+
+// full reports whether the bucket has refilled, it then behaves like a new one
+func (b *tokenBucket) full() bool {
+	return b.Tokens >= float64(b.limit.Burst)
+}
+
+// rateLimiter holds the buckets of all clients, most recently used first
+type rateLimiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*list.Element // values are *tokenBucket
+	order      *list.List
+	maxBuckets int
+}
+
+var rateLimits = newRateLimiter(maxRateLimitBuckets)
+
+func newRateLimiter(maxBuckets int) *rateLimiter {
+	return &rateLimiter{
+		buckets:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxBuckets: maxBuckets,
+	}
+}
+
+// take removes a token from the bucket of a client. It returns false and the
+// time until the next token when the bucket is empty, and whether the bucket
+// just ran empty.
+func (l *rateLimiter) take(client, scope string, limit RateLimit, now time.Time) (bool, time.Duration, bool) {
+	key := scope + "|" + client
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var bucket *tokenBucket
+	if element, ok := l.buckets[key]; ok {
+		l.order.MoveToFront(element)
+		bucket = element.Value.(*tokenBucket)
+	} else {
+		if len(l.buckets) >= l.maxBuckets {
+			l.evictLocked(now)
+		}
+		bucket = &tokenBucket{key: key}
+		l.buckets[key] = l.order.PushFront(bucket)
+	}
+	if bucket.limit != limit {
+		bucket.RateLimitBucket = RateLimitBucket{Client: client, Scope: scope, Tokens: float64(limit.Burst)}
+		bucket.limit = limit
+		bucket.updated = now
+		bucket.blocked = false
+	}
+	bucket.refill(now)
+	bucket.LastSeen = now
+
+	if bucket.Tokens >= 1 {
+		bucket.Tokens--
+		bucket.Allowed++
+		bucket.blocked = false
+		return true, 0, false
+	}
+	bucket.Limited++
+	first := !bucket.blocked
+	bucket.blocked = true
+	return false, time.Duration((1 - bucket.Tokens) / limit.Rate * float64(time.Second)), first
+}
+
+// evictLocked makes room for a new bucket. Among the least recently used
+// buckets it prefers a full one, which is lost without a trace, then one that
+// is not limiting its client, so rotating addresses cannot push out limits.
+func (l *rateLimiter) evictLocked(now time.Time) {
+	victim := l.order.Back()
+	var unblocked *list.Element
+	for element, i := l.order.Back(), 0; element != nil && i < rateLimitEvictionScan; element, i = element.Prev(), i+1 {
+		bucket := element.Value.(*tokenBucket)
+		bucket.refill(now)
+		if bucket.full() {
+			l.removeLocked(element)
+			return
+		}
+		if unblocked == nil && !bucket.blocked {
+			unblocked = element
+		}
+	}
+	if unblocked != nil {
+		victim = unblocked
+	}
+	if victim != nil {
+		l.removeLocked(victim)
+	}
+}
+
+func (l *rateLimiter) removeLocked(element *list.Element) {
+	delete(l.buckets, element.Value.(*tokenBucket).key)
+	l.order.Remove(element)
+}
+
+// sweep drops buckets that are full again, they behave like new ones
+func (l *rateLimiter) sweep(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dropped := 0
+	for element := l.order.Front(); element != nil; {
+		next := element.Next()
+		bucket := element.Value.(*tokenBucket)
+		bucket.refill(now)
+		if bucket.full() {
+			l.removeLocked(element)
+			dropped++
+		}
+		element = next
+	}
+	return dropped
+}
+
+// reset drops all buckets, e.g. after the limits changed
+func (l *rateLimiter) reset() {
+	l.mu.Lock()
+	l.buckets = make(map[string]*list.Element)
+	l.order.Init()
+	l.mu.Unlock()
+}
+
+// startSweeper periodically drops refilled buckets until stop is closed
+func (l *rateLimiter) startSweeper(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if dropped := l.sweep(now); dropped > 0 {
+					accessLog.Debug("Dropped refilled rate limit buckets", "dropped", dropped)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// top returns the number of buckets and the n most limited ones, ties by most requests
+func (l *rateLimiter) top(n int, now time.Time) (int, []RateLimitBucket) {
+	l.mu.Lock()
+	buckets := make([]RateLimitBucket, 0, len(l.buckets))
+	for element := l.order.Front(); element != nil; element = element.Next() {
+		bucket := element.Value.(*tokenBucket)
+		bucket.refill(now)
+		buckets = append(buckets, bucket.RateLimitBucket)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(buckets, func(i, j int) bool {
+		a, b := buckets[i], buckets[j]
+		if a.Limited != b.Limited {
+			return a.Limited > b.Limited
+		}
+		if a.Allowed != b.Allowed {
+			return a.Allowed > b.Allowed
+		}
+		return a.Scope+a.Client < b.Scope+b.Client
+	})
+	total := len(buckets)
+	if len(buckets) > n {
+		buckets = buckets[:n]
+	}
+	return total, buckets
+}
+
+// checkRateLimit takes a token for the client of a connection. Connections
+// without a client address (LOCAL, UNKNOWN) are not limited. When the client
+// is limited it returns the time until the next request is allowed.
+func checkRateLimit(info *ProxyProtocolInfo, hostname string, attrs ...any) (bool, time.Duration) {
+	config.mu.RLock()
+	settings := config.RateLimit
+	config.mu.RUnlock()
+
+	if !settings.Enabled {
+		return true, 0
+	}
+	addr, err := netip.ParseAddr(info.SourceAddr)
+	if err != nil {
+		return true, 0
+	}
+	limit, scope := settings.limitFor(hostname)
+	if limit.Rate == 0 {
+		return true, 0
+	}
+
+	client := settings.clientKey(addr)
+	allowed, wait, first := rateLimits.take(client, scope, limit, time.Now())
+	if allowed {
+		return true, 0
+	}
+	metrics.rateLimited.inc(accessScopeLabel(scope))
+	log := accessLog.With(attrs...).With("source", info.SourceAddr, "host", hostname, "client", client, "scope", scope)
+	if first {
+		log.Warn("Rate limit exceeded", "rate", limit.Rate, "burst", limit.Burst)
+	} else {
+		log.Debug("Request rate limited", "retry_after", wait.String())
+	}
+	return false, wait
+}
+
+// writeRateLimited answers a limited request with 429 and a Retry-After header
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
+
+// currentRateLimitSettings returns a copy of the configured settings
+func currentRateLimitSettings() RateLimitSettings {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.RateLimit.clone()
+}
+
+func rateLimitResponse(top int) RateLimitResponse {
+	buckets, offenders := rateLimits.top(top, time.Now())
+	return RateLimitResponse{RateLimitSettings: currentRateLimitSettings(), Buckets: buckets, Top: offenders}
+}
+
+// handleAPIRateLimit returns (GET) or replaces (POST) the rate limit settings.
+// GET lists the bucket state of the most limited clients, ?top=<n> sets how many.
+func handleAPIRateLimit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		top := defaultRateLimitTop
+		if value := r.URL.Query().Get("top"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxRateLimitTop {
+				http.Error(w, fmt.Sprintf("top must be between 1 and %d", maxRateLimitTop), http.StatusBadRequest)
+				return
+			}
+			top = n
+		}
+		writeJSON(w, rateLimitResponse(top))
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var req RateLimitSettings
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := req.normalize(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config.mu.Lock()
+		config.RateLimit = req
+		config.mu.Unlock()
+		rateLimits.reset()
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		apiLog.Info("Rate limit updated", "enabled", req.Enabled, "rate", req.Rate, "burst", req.Burst,
+			"ipv4_prefix", req.IPv4Prefix, "ipv6_prefix", req.IPv6Prefix, "hosts", len(req.Hosts))
+		state := "disabled"
+		if req.Enabled {
+			state = fmt.Sprintf("%g/s, burst %d, %d host override(s)", req.Rate, req.Burst, len(req.Hosts))
+		}
+		events.record(EventSourcePlugin, "rateLimitUpdated", "Rate limit "+state)
+		writeJSON(w, rateLimitResponse(defaultRateLimitTop))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test validation and defaults of the settings
+func TestRateLimitNormalize(t *testing.T) {
+	settings := RateLimitSettings{RateLimit: RateLimit{Rate: 2.5}, Hosts: []HostRateLimit{
+		{Pattern: "API.example.com", RateLimit: RateLimit{Rate: 0.5}},
+	}}
+	if err := settings.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Burst != 3 || settings.IPv4Prefix != 32 || settings.IPv6Prefix != 64 {
+		t.Errorf("Expected the defaults, got %+v", settings)
+	}
+	if host := settings.Hosts[0]; host.Pattern != "api.example.com" || host.Match != "exact" || host.Burst != 1 {
+		t.Errorf("Expected the normalized host override, got %+v", host)
+	}
+
+	invalid := []RateLimitSettings{
+		{RateLimit: RateLimit{Rate: -1}},
+		{RateLimit: RateLimit{Rate: 1, Burst: -1}},
+		{IPv4Prefix: 4},
+		{IPv6Prefix: 129},
+		{Hosts: []HostRateLimit{{Pattern: "(", Match: "regex"}}},
+		{Hosts: []HostRateLimit{{Pattern: "a.example.com", RateLimit: RateLimit{Rate: 2e6}}}},
+	}
+	for _, settings := range invalid {
+		if err := settings.normalize(); err == nil {
+			t.Errorf("Expected %+v to be rejected", settings)
+		}
+	}
+}
+
+// Test subnet keys and host overrides
+func TestRateLimitClients(t *testing.T) {
+	settings := RateLimitSettings{RateLimit: RateLimit{Rate: 10}, IPv4Prefix: 24, Hosts: []HostRateLimit{
+		{Pattern: "*.api.example.com", Match: "wildcard", RateLimit: RateLimit{Rate: 1}},
+	}}
+	if err := settings.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]string{
+		"192.0.2.77":           "192.0.2.0/24",
+		"::ffff:192.0.2.77":    "192.0.2.0/24",
+		"2001:db8:1:2:3:4:5:6": "2001:db8:1:2::/64",
+		"2001:db8:1:3::1":      "2001:db8:1:3::/64",
+	}
+	for addr, expected := range keys {
+		if got := settings.clientKey(netip.MustParseAddr(addr)); got != expected {
+			t.Errorf("Expected %s to be grouped into %s, got %s", addr, expected, got)
+		}
+	}
+
+	if limit, scope := settings.limitFor("V1.API.example.com"); limit.Rate != 1 || scope != "*.api.example.com" {
+		t.Errorf("Expected the host override, got %+v in %s", limit, scope)
+	}
+	if limit, scope := settings.limitFor("www.example.com"); limit.Rate != 10 || scope != IPAccessScopeGlobal {
+		t.Errorf("Expected the global limit, got %+v in %s", limit, scope)
+	}
+}
+
+// Test taking and refilling tokens
+func TestRateLimiterTake(t *testing.T) {
+	limiter := newRateLimiter(maxRateLimitBuckets)
+	limit := RateLimit{Rate: 2, Burst: 3}
+	now := time.Unix(1760000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if allowed, _, _ := limiter.take("192.0.2.1/32", "global", limit, now); !allowed {
+			t.Fatalf("Expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	allowed, wait, first := limiter.take("192.0.2.1/32", "global", limit, now)
+	if allowed || wait != 500*time.Millisecond || !first {
+		t.Errorf("Expected the first rejection with a wait of 500ms, got %v %v %v", allowed, wait, first)
+	}
+	if _, _, first := limiter.take("192.0.2.1/32", "global", limit, now); first {
+		t.Error("Expected the second rejection not to be reported as first")
+	}
+	if allowed, _, _ := limiter.take("192.0.2.1/32", "example.com", limit, now); !allowed {
+		t.Error("Expected a host scope to have a bucket of its own")
+	}
+
+	// Half a second earns one token
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _, _ := limiter.take("192.0.2.1/32", "global", limit, now); !allowed {
+		t.Error("Expected the refilled token to be taken")
+	}
+	if _, _, first := limiter.take("192.0.2.1/32", "global", limit, now); !first {
+		t.Error("Expected a rejection after an allowed request to be reported as first")
+	}
+
+	total, top := limiter.top(1, now)
+	if total != 2 || len(top) != 1 || top[0].Client != "192.0.2.1/32" || top[0].Scope != "global" || top[0].Limited != 3 || top[0].Allowed != 4 {
+		t.Errorf("Expected the limited client on top, got %d %+v", total, top)
+	}
+
+	// Full buckets are dropped by the sweep
+	if dropped := limiter.sweep(now.Add(2 * time.Second)); dropped != 2 {
+		t.Errorf("Expected both refilled buckets to be dropped, got %d", dropped)
+	}
+}
+
+// Test that a full limiter evicts refilled and unlimited buckets first
+func TestRateLimiterEviction(t *testing.T) {
+	limiter := newRateLimiter(3)
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Unix(1760000000, 0)
+
+	// The least recently used client is limited
+	limiter.take("192.0.2.1/32", "global", limit, now)
+	if allowed, _, _ := limiter.take("192.0.2.1/32", "global", limit, now); allowed {
+		t.Fatal("Expected the second request to be limited")
+	}
+	limiter.take("192.0.2.2/32", "global", limit, now)
+	limiter.take("192.0.2.3/32", "global", limit, now)
+
+	// Rotating addresses push out the unlimited buckets, not the limit
+	for i := 4; i < 10; i++ {
+		limiter.take(fmt.Sprintf("192.0.2.%d/32", i), "global", limit, now)
+	}
+	total, top := limiter.top(3, now)
+	if total != 3 || top[0].Client != "192.0.2.1/32" || top[0].Limited != 1 {
+		t.Errorf("Expected the limited bucket to be kept, got %d %+v", total, top)
+	}
+
+	// Once refilled the bucket carries no limit and goes first
+	now = now.Add(2 * time.Second)
+	limiter.take("198.51.100.1/32", "global", limit, now)
+	if allowed, _, _ := limiter.take("198.51.100.1/32", "global", limit, now); allowed {
+		t.Fatal("Expected the second request to be limited")
+	}
+	limiter.take("198.51.100.2/32", "global", limit, now)
+	_, top = limiter.top(3, now)
+	if len(top) != 3 || top[0].Client != "198.51.100.1/32" {
+		t.Errorf("Expected the new limited bucket on top, got %+v", top)
+	}
+	for _, bucket := range top {
+		if bucket.Client == "192.0.2.1/32" {
+			t.Errorf("Expected the refilled bucket to be evicted, got %+v", top)
+		}
+	}
+}
+
+// Test 429 responses of the ingress and the host override
+func TestIngressRateLimit(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.RateLimit = RateLimitSettings{Enabled: true, RateLimit: RateLimit{Rate: 0.01, Burst: 2}, IPv4Prefix: 24, Hosts: []HostRateLimit{
+			{Pattern: "free.example.com"},
+		}}
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	ingress := func(id, hostname, source string, port int) *httptest.ResponseRecorder {
+		header, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+			SourceAddr: source, SourcePort: port, DestAddr: "198.51.100.49", DestPort: 443})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", INGRESS_PATH+"/", bytes.NewReader(append(header, "GET / HTTP/1.1\r\n\r\n"...)))
+		req.Header.Set("X-Zoraxy-RequestID", id)
+		rr := serveIngress(t, req, hostname)
+		connections.remove(ingressConnectionID(&ProxyProtocolInfo{SourceAddr: source, SourcePort: port, DestAddr: "198.51.100.49", DestPort: 443}))
+		return rr
+	}
+
+	limited := metrics.rateLimited.get(IPAccessScopeGlobal)
+	for i, source := range []string{"203.0.113.1", "203.0.113.2"} {
+		if rr := ingress("rate-limit-"+source, "", source, 43001+i); rr.Code != http.StatusOK {
+			t.Fatalf("Expected the burst to be allowed, got %d", rr.Code)
+		}
+	}
+	rr := ingress("rate-limit-3", "", "203.0.113.3", 43003)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the subnet to be limited, got %d", rr.Code)
+	}
+	if retry := rr.Header().Get("Retry-After"); retry != "100" {
+		t.Errorf("Expected Retry-After 100, got %q", retry)
+	}
+	if got := metrics.rateLimited.get(IPAccessScopeGlobal); got != limited+1 {
+		t.Errorf("Expected the limited counter to be %d, got %d", limited+1, got)
+	}
+
+	if rr := ingress("rate-limit-4", "", "192.0.2.3", 43004); rr.Code != http.StatusOK {
+		t.Errorf("Expected another subnet to be allowed, got %d", rr.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rr := ingress("rate-limit-free-"+string(rune('a'+i)), "free.example.com", "203.0.113.3", 43005+i); rr.Code != http.StatusOK {
+			t.Errorf("Expected the host without a limit to be allowed, got %d", rr.Code)
+		}
+	}
+}
+
+// Test the rate limit API
+func TestHandleAPIRateLimit(t *testing.T) {
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+	defer func() { configPath = oldPath }()
+	setConfig(t, func(c *PluginConfig) { c.RateLimit = RateLimitSettings{} })
+	token := issueTestCSRFToken(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/ui/api/ratelimit", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", token)
+		rr := httptest.NewRecorder()
+		handleAPIRateLimit(rr, req)
+		return rr
+	}
+
+	if rr := post(`{"enabled":true,"rate":1,"ipv6_prefix":8}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, got %d", rr.Code)
+	}
+	rr := post(`{"enabled":true,"rate":5,"ipv4_prefix":24,"hosts":[{"pattern":"Login.example.com","rate":0.2,"burst":3}]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response RateLimitResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Enabled || response.Burst != 5 || response.Hosts[0].Pattern != "login.example.com" || response.Hosts[0].Burst != 3 {
+		t.Errorf("Expected the normalized settings, got %+v", response.RateLimitSettings)
+	}
+
+	info := &ProxyProtocolInfo{SourceAddr: "192.0.2.200"}
+	for i := 0; i < 4; i++ {
+		checkRateLimit(info, "login.example.com")
+	}
+	checkRateLimit(&ProxyProtocolInfo{SourceAddr: "198.51.100.1"}, "www.example.com")
+	rr = httptest.NewRecorder()
+	handleAPIRateLimit(rr, httptest.NewRequest("GET", "/ui/api/ratelimit?top=1", nil))
+	response = RateLimitResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Buckets != 2 || len(response.Top) != 1 || response.Top[0].Client != "192.0.2.0/24" || response.Top[0].Limited != 1 {
+		t.Errorf("Expected the limited client on top, got %+v", response)
+	}
+	rr = httptest.NewRecorder()
+	handleAPIRateLimit(rr, httptest.NewRequest("GET", "/ui/api/ratelimit?top=0", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, got %d", rr.Code)
+	}
+
+	setConfig(t, func(c *PluginConfig) { c.RateLimit = RateLimitSettings{} })
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	restored := currentRateLimitSettings()
+	if !restored.Enabled || restored.IPv4Prefix != 24 || len(restored.Hosts) != 1 {
+		t.Fatalf("Expected the persisted settings to be restored, got %+v", restored)
+	}
+	if _, scope := restored.limitFor("login.example.com"); scope != "login.example.com" {
+		t.Error("Expected the restored host override to be compiled")
+	}
+}
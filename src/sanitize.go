@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// defaultStripHeaders are the client-identity headers removed from untrusted peers
+func defaultStripHeaders() []string {
+	return []string{
+		"X-Forwarded-For",
+		"X-Forwarded-Port",
+		"X-Forwarded-Proto",
+		"X-Real-IP",
+		"X-Client-IP",
+		"X-Cluster-Client-IP",
+		"X-Original-Remote-Addr",
+		"X-Original-Remote-Port",
+		"X-Proxy-Protocol-Source",
+		"Forwarded",
+		"True-Client-IP",
+		"CF-Connecting-IP",
+	}
+}
+
+// normalizeStripHeaders validates a strip list and drops duplicates. The
+// spelling of the first occurrence is kept.
+func normalizeStripHeaders(names []string) ([]string, error) {
+	normalized := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isHeaderToken(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		key := http.CanonicalHeaderKey(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, name)
+	}
+	return normalized, nil
+}
+
+// stripSet is every header removed from untrusted peers: the configured list
+// plus the names of the enabled client headers and custom TLV headers, so a
+// header the plugin skips (e.g. {client_addr} of a LOCAL connection) never
+// keeps the client's value. Headers in keep mode honour the client's value
+// and are left out.
+func stripSet(strip []string, headers []ClientHeader, tlvs []CustomTLV) map[string]bool {
+	set := make(map[string]bool, len(strip)+len(headers))
+	for _, name := range strip {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, header := range headers {
+		if header.Enabled && header.Mode != ClientHeaderKeep {
+			set[http.CanonicalHeaderKey(header.Name)] = true
+		}
+	}
+	for _, tlv := range tlvs {
+		if tlv.Header != "" {
+			set[http.CanonicalHeaderKey(tlv.Header)] = true
+		}
+	}
+	return set
+}
+
+// sanitizeClientHeaders returns a copy of the headers sent by the client
+// without client-identity headers, unless the Proxy Protocol source is a
+// trusted proxy. The names of the removed headers are returned for the
+// callers to remove or override them and count them (countStripped).
+func sanitizeClientHeaders(sent http.Header, info *ProxyProtocolInfo) (http.Header, []string) {
+	config.mu.RLock()
+	set := stripSet(config.StripHeaders, config.ClientHeaders, config.CustomTLVs)
+	trusted := config.trustedProxies
+	config.mu.RUnlock()
+
+	clean := sent.Clone()
+	if clean == nil {
+		clean = http.Header{}
+	}
+	if peer, err := netip.ParseAddr(info.SourceAddr); err == nil && prefixesContain(trusted, peer.Unmap()) {
+		return clean, nil
+	}
+
+	var stripped []string
+	for name := range clean {
+		key := http.CanonicalHeaderKey(name)
+		if !set[key] {
+			continue
+		}
+		delete(clean, name)
+		stripped = append(stripped, key)
+	}
+	sort.Strings(stripped)
+	return clean, stripped
+}
+
+// overrideStripped sets an empty value for every stripped header dst does not
+// set, so the client's value is replaced where headers can only be overridden
+func overrideStripped(dst http.Header, stripped []string) {
+	for _, name := range stripped {
+		if _, ok := dst[name]; !ok {
+			dst[name] = []string{""}
+		}
+	}
+}
+
+// countStripped counts headers removed from a forwarded request
+func countStripped(stripped []string) {
+	for _, name := range stripped {
+		metrics.strippedHeaders.inc(name)
+	}
+}
+
+// currentStripHeaders returns a copy of the configured strip list
+func currentStripHeaders() []string {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return append([]string{}, config.StripHeaders...)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that client-identity headers are only kept for trusted peers
+func TestSanitizeClientHeaders(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.ClientHeaders = []ClientHeader{{Name: "X-Client-Addr", Value: "{source_addr}", Enabled: true}}
+	})
+	info := &ProxyProtocolInfo{Version: 1, Command: "PROXY", TransportProto: "TCP4", SourceAddr: "192.0.2.10", SourcePort: 4000}
+	sent := http.Header{
+		"X-Real-Ip":       {"10.6.6.6"},
+		"X-Forwarded-For": {"10.6.6.6"},
+		"True-Client-Ip":  {"10.6.6.6"},
+		"X-Client-Addr":   {"10.6.6.6"},
+		"User-Agent":      {"curl/8.0"},
+	}
+
+	t.Run("untrusted peer", func(t *testing.T) {
+		clean, stripped := sanitizeClientHeaders(sent, info)
+
+		expected := []string{"True-Client-Ip", "X-Client-Addr", "X-Forwarded-For", "X-Real-Ip"}
+		if strings.Join(stripped, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected stripped %v, got %v", expected, stripped)
+		}
+		if len(clean) != 1 || clean.Get("User-Agent") != "curl/8.0" {
+			t.Errorf("Expected only User-Agent to be kept, got %v", clean)
+		}
+		if len(sent) != 5 {
+			t.Errorf("Expected the sent headers to be left alone, got %v", sent)
+		}
+	})
+
+	t.Run("trusted peer", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) { c.trustedProxies = testPrefixes(t, "192.0.2.0/24") })
+		clean, stripped := sanitizeClientHeaders(sent, info)
+		if len(stripped) != 0 || len(clean) != len(sent) {
+			t.Errorf("Expected all headers of a trusted peer to be kept, got %v (stripped %v)", clean, stripped)
+		}
+	})
+
+	t.Run("LOCAL connection", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) { c.trustedProxies = testPrefixes(t, "0.0.0.0/0") })
+		_, stripped := sanitizeClientHeaders(sent, &ProxyProtocolInfo{Version: 2, Command: "LOCAL", TransportProto: "UNKNOWN"})
+		if len(stripped) != 4 {
+			t.Errorf("Expected a connection without source to be untrusted, got %v", stripped)
+		}
+	})
+
+	t.Run("empty list keeps headers the plugin does not set", func(t *testing.T) {
+		setConfig(t, func(c *PluginConfig) { c.StripHeaders = nil })
+		_, stripped := sanitizeClientHeaders(sent, info)
+		if len(stripped) != 1 || stripped[0] != "X-Client-Addr" {
+			t.Errorf("Expected only the injected header to be stripped, got %v", stripped)
+		}
+	})
+}
+
+// Test that the ingress ignores a spoofed chain of an untrusted peer
+func TestIngressStripsSpoofedHeaders(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+	before := metrics.strippedHeaders.get("X-Forwarded-For")
+	beforeTrueClient := metrics.strippedHeaders.get("True-Client-Ip")
+
+	req := httptest.NewRequest("POST", INGRESS_PATH+"/", strings.NewReader("PROXY TCP4 192.0.2.100 198.51.100.50 45678 443\r\nGET / HTTP/1.1\r\n\r\n"))
+	req.Header.Set("X-Zoraxy-RequestID", "strip-1")
+	req.Header.Set("X-Forwarded-For", "10.6.6.6")
+	req.Header.Set("X-Real-IP", "10.6.6.6")
+	req.Header.Set("True-Client-IP", "10.6.6.6")
+	rr := serveIngress(t, req, "example.com")
+	connections.remove(ingressConnectionID(&ProxyProtocolInfo{SourceAddr: "192.0.2.100", SourcePort: 45678, DestAddr: "198.51.100.50", DestPort: 443}))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("X-Forwarded-For"); got != "192.0.2.100" {
+		t.Errorf("Expected X-Forwarded-For 192.0.2.100, got %q", got)
+	}
+	if got := rr.Header().Get("X-Real-IP"); got != "192.0.2.100" {
+		t.Errorf("Expected X-Real-IP 192.0.2.100, got %q", got)
+	}
+	if got, ok := rr.Header()["True-Client-Ip"]; !ok || len(got) != 1 || got[0] != "" {
+		t.Errorf("Expected an empty True-Client-IP override, got %q", got)
+	}
+
+	if got := metrics.strippedHeaders.get("X-Forwarded-For"); got != before+1 {
+		t.Errorf("Expected the X-Forwarded-For counter to be %d, got %d", before+1, got)
+	}
+	if got := metrics.strippedHeaders.get("True-Client-Ip"); got != beforeTrueClient+1 {
+		t.Errorf("Expected the True-Client-Ip counter to be %d, got %d", beforeTrueClient+1, got)
+	}
+}
+
+// Test that the middleware removes spoofed headers from the proxied request
+func TestProxyProtocolMiddlewareStripsHeaders(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(chan http.Header, 1)
+	server := &http.Server{
+		Handler: ProxyProtocolMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen <- r.Header.Clone()
+		})),
+		ConnContext: ProxyProtocolConnContext,
+	}
+	go server.Serve(NewProxyProtocolListener(ln, nil, listenerLog))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "PROXY TCP4 203.0.113.5 198.51.100.1 51000 80\r\n"+
+		"GET / HTTP/1.1\r\nHost: example.com\r\nTrue-Client-IP: 10.6.6.6\r\nX-Forwarded-Proto: https\r\n"+
+		"X-Proxy-Protocol-Source: 10.6.6.6:1\r\nConnection: close\r\n\r\n")
+	before := metrics.strippedHeaders.get("True-Client-Ip")
+	http.ReadResponse(bufio.NewReader(conn), nil)
+
+	select {
+	case header := <-seen:
+		if got := header.Get("True-Client-IP"); got != "" {
+			t.Errorf("Expected True-Client-IP to be removed, got %q", got)
+		}
+		if got := header.Get("X-Proxy-Protocol-Source"); got != "203.0.113.5:51000" {
+			t.Errorf("Expected X-Proxy-Protocol-Source 203.0.113.5:51000, got %q", got)
+		}
+		if got := header.Get("X-Forwarded-Proto"); got != "http" {
+			t.Errorf("Expected X-Forwarded-Proto http, got %q", got)
+		}
+		if got := metrics.strippedHeaders.get("True-Client-Ip"); got != before+1 {
+			t.Errorf("Expected the True-Client-Ip counter to be %d, got %d", before+1, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Request did not reach the handler")
+	}
+}
+
+// Test the strip list in the client headers API
+func TestHandleAPIStripHeaders(t *testing.T) {
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+	defer func() { configPath = oldPath }()
+	setConfig(t, func(c *PluginConfig) {
+		c.ClientHeaders = defaultClientHeaders()
+		c.StripHeaders = defaultStripHeaders()
+	})
+	token := issueTestCSRFToken(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/ui/api/headers", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", token)
+		rr := httptest.NewRecorder()
+		handleAPIClientHeaders(rr, req)
+		return rr
+	}
+
+	if rr := post(`{"headers":[],"strip_headers":["X-Bad Name"]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for an invalid name, got %d", rr.Code)
+	}
+
+	rr := post(`{"headers":[],"strip_headers":["x-real-ip","X-Real-IP"," Fastly-Client-IP "]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response ClientHeadersResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(response.StripHeaders, ",") != "x-real-ip,Fastly-Client-IP" {
+		t.Errorf("Expected the normalized list, got %v", response.StripHeaders)
+	}
+	if response.Stripped == nil {
+		t.Error("Expected the stripped counters in the response")
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Fastly-Client-IP"`) {
+		t.Errorf("Expected the list to be persisted, got %s", data)
+	}
+	setConfig(t, func(c *PluginConfig) { c.StripHeaders = nil })
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if strip := currentStripHeaders(); len(strip) != 2 {
+		t.Errorf("Expected the persisted list to be restored, got %v", strip)
+	}
+
+	if rr := post(`{"reset":true}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rr.Code)
+	}
+	if strip := currentStripHeaders(); len(strip) != len(defaultStripHeaders()) {
+		t.Errorf("Expected the default list after a reset, got %v", strip)
+	}
+}
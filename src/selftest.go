@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// selfTestTimeout bounds every self-test case
+const selfTestTimeout = 2 * time.Second
+
+// selfTestPayload is sent after every synthetic header
+const selfTestPayload = "GET /selftest HTTP/1.1\r\nHost: selftest.invalid\r\n\r\n"
+
+// SelfTestResult is the outcome of a single self-test case
+type SelfTestResult struct {
+	Name     string `json:"name"`
+	Passed   bool   `json:"passed"`
+	Message  string `json:"message"`
+	Duration string `json:"duration"`
+}
+
+// SelfTestResponse is returned by the self-test API
+type SelfTestResponse struct {
+	Passed  bool             `json:"passed"`
+	Results []SelfTestResult `json:"results"`
+}
+
+// selfTestCase sends header+payload and expects either the decoded info
+// or, when expected is nil, a connection passed on without Proxy Protocol info
+type selfTestCase struct {
+	name     string
+	header   func() ([]byte, error)
+	expected *ProxyProtocolInfo
+}
+
+func encodedHeader(info *ProxyProtocolInfo) func() ([]byte, error) {
+	return func() ([]byte, error) { return encodeProxyProtocolHeader(info) }
+}
+
+func rawHeader(header string) func() ([]byte, error) {
+	return func() ([]byte, error) { return []byte(header), nil }
+}
+
+// selfTestCases covers every header variant the listener understands
+func selfTestCases() []selfTestCase {
+	v1 := &ProxyProtocolInfo{Version: 1, Command: "PROXY", TransportProto: "TCP4",
+		SourceAddr: "192.0.2.10", SourcePort: 40000, DestAddr: "198.51.100.20", DestPort: 443}
+	v2IPv4 := &ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+		SourceAddr: "192.0.2.11", SourcePort: 40001, DestAddr: "198.51.100.21", DestPort: 443,
+		TLVs: []ProxyProtocolTLV{
+			{Type: PP2TypeALPN, Value: []byte("h2")},
+			{Type: PP2TypeAuthority, Value: []byte("selftest.invalid")},
+			{Type: PP2TypeUniqueID, Value: []byte{0x5e, 0x1f, 0x7e, 0x57}},
+		}}
+	v2IPv6 := &ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP6",
+		SourceAddr: "2001:db8::10", SourcePort: 40002, DestAddr: "2001:db8::20", DestPort: 8443}
+	local := &ProxyProtocolInfo{Version: 2, Command: "LOCAL", TransportProto: "UNKNOWN",
+		TLVs: []ProxyProtocolTLV{{Type: PP2TypeNoop, Value: []byte{0}}}}
+
+	return []selfTestCase{
+		{name: "v1 TCP4", header: encodedHeader(v1), expected: v1},
+		{name: "v2 IPv4 with TLVs", header: encodedHeader(v2IPv4), expected: v2IPv4},
+		{name: "v2 IPv6", header: encodedHeader(v2IPv6), expected: v2IPv6},
+		{name: "v2 LOCAL", header: encodedHeader(local), expected: local},
+		{name: "malformed v1", header: rawHeader("PROXY TCP4 192.0.2.12\r\n")},
+		{name: "malformed v2 TLV", header: func() ([]byte, error) {
+			header, err := encodeProxyProtocolHeader(&ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+				SourceAddr: "192.0.2.13", SourcePort: 40003, DestAddr: "198.51.100.23", DestPort: 443,
+				TLVs: []ProxyProtocolTLV{{Type: PP2TypeALPN, Value: []byte("h2")}}})
+			if err != nil {
+				return nil, err
+			}
+			// Claim a longer TLV value than the header holds
+			header[len(header)-3] = 0x40
+			return header, nil
+		}},
+	}
+}
+
+// runSelfTest sends every case through an ephemeral loopback listener,
+// detached from the health checks, metrics, registry and bans
+func runSelfTest() SelfTestResponse {
+	response := SelfTestResponse{Passed: true}
+	for _, tc := range selfTestCases() {
+		started := time.Now()
+		result := SelfTestResult{Name: tc.name, Passed: true, Message: "OK"}
+		if err := runSelfTestCase(tc); err != nil {
+			result.Passed = false
+			result.Message = err.Error()
+			response.Passed = false
+		}
+		result.Duration = time.Since(started).Round(time.Microsecond).String()
+		response.Results = append(response.Results, result)
+	}
+	return response
+}
+
+func runSelfTestCase(tc selfTestCase) error {
+	header, err := tc.header()
+	if err != nil {
+		return fmt.Errorf("encoding header: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("starting loopback listener: %w", err)
+	}
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(selfTestTimeout))
+	ppListener := newDetachedListener(ln)
+	ppListener.ReadTimeout = selfTestTimeout
+	defer ppListener.Close()
+
+	sent := make(chan error, 1)
+	go func() {
+		client, err := net.DialTimeout("tcp", ln.Addr().String(), selfTestTimeout)
+		if err != nil {
+			sent <- err
+			return
+		}
+		defer client.Close()
+		_, err = client.Write(append(header, selfTestPayload...))
+		sent <- err
+	}()
+
+	conn, err := ppListener.Accept()
+	if err != nil {
+		return fmt.Errorf("accepting connection: %w", err)
+	}
+	defer conn.Close()
+	if err := <-sent; err != nil {
+		return fmt.Errorf("sending header: %w", err)
+	}
+
+	ppConn, isProxyConn := conn.(*proxyProtocolConn)
+	if tc.expected == nil {
+		if isProxyConn {
+			return fmt.Errorf("malformed header was accepted as %s %s:%d", ppConn.ProxyInfo.Command,
+				ppConn.ProxyInfo.SourceAddr, ppConn.ProxyInfo.SourcePort)
+		}
+		return nil
+	}
+	if !isProxyConn {
+		return fmt.Errorf("header was not recognised")
+	}
+
+	if err := compareSelfTestInfo(tc.expected, ppConn.ProxyInfo); err != nil {
+		return err
+	}
+	if ppConn.ProxyInfo.HeaderLength != len(header) {
+		return fmt.Errorf("header length %d, expected %d", ppConn.ProxyInfo.HeaderLength, len(header))
+	}
+
+	conn.SetReadDeadline(time.Now().Add(selfTestTimeout))
+	payload, err := io.ReadAll(conn)
+	if err != nil {
+		return fmt.Errorf("reading passthrough bytes: %w", err)
+	}
+	if string(payload) != selfTestPayload {
+		return fmt.Errorf("passthrough bytes differ: got %q", payload)
+	}
+	return nil
+}
+
+// compareSelfTestInfo lists the fields that differ from the expected info
+func compareSelfTestInfo(expected, got *ProxyProtocolInfo) error {
+	var mismatches []string
+	check := func(field string, want, have interface{}) {
+		if want != have {
+			mismatches = append(mismatches, fmt.Sprintf("%s %v, expected %v", field, have, want))
+		}
+	}
+
+	check("version", expected.Version, got.Version)
+	check("command", expected.Command, got.Command)
+	check("transport", expected.TransportProto, got.TransportProto)
+	if expected.Command == "PROXY" {
+		check("source address", expected.SourceAddr, got.SourceAddr)
+		check("source port", expected.SourcePort, got.SourcePort)
+		check("destination address", expected.DestAddr, got.DestAddr)
+		check("destination port", expected.DestPort, got.DestPort)
+	}
+
+	check("TLV count", len(expected.TLVs), len(got.TLVs))
+	for i := 0; i < len(expected.TLVs) && i < len(got.TLVs); i++ {
+		if expected.TLVs[i].Type != got.TLVs[i].Type || !bytes.Equal(expected.TLVs[i].Value, got.TLVs[i].Value) {
+			mismatches = append(mismatches, fmt.Sprintf("TLV %d is %s=%x, expected %s=%x", i,
+				got.TLVs[i].Name(), got.TLVs[i].Value, expected.TLVs[i].Name(), expected.TLVs[i].Value))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%s", strings.Join(mismatches, "; "))
+	}
+	return nil
+}
+
+// handleAPISelfTest runs the loopback self-test
+func handleAPISelfTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireCSRFToken(w, r) {
+		return
+	}
+
+	response := runSelfTest()
+	failed := 0
+	for _, result := range response.Results {
+		if !result.Passed {
+			failed++
+			apiLog.Warn("Self-test case failed", "case", result.Name, "error", result.Message)
+		}
+	}
+
+	message := fmt.Sprintf("%d of %d case(s) passed", len(response.Results)-failed, len(response.Results))
+	apiLog.Info("Self-test finished", "passed", response.Passed, "failed", failed)
+	events.record(EventSourcePlugin, "selfTest", message)
+	writeJSON(w, response)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test every self-test case passes against the listener
+func TestRunSelfTest(t *testing.T) {
+	response := runSelfTest()
+	if len(response.Results) != len(selfTestCases()) {
+		t.Fatalf("Expected %d results, got %d", len(selfTestCases()), len(response.Results))
+	}
+	for _, result := range response.Results {
+		if !result.Passed {
+			t.Errorf("Self-test case %q failed: %s", result.Name, result.Message)
+		}
+	}
+	if !response.Passed {
+		t.Error("Expected self-test to pass")
+	}
+}
+
+// Test repeated self-tests leave no trace in the health checks, metrics,
+// inspector, registry or listeners
+func TestSelfTestSideEffects(t *testing.T) {
+	now := time.Now()
+	attempts, failures := metrics.recentParses.totals(now)
+	inspected := len(inspector.list(""))
+	tracked := connections.size()
+	bound := len(listeners.list())
+
+	for i := 0; i < 5; i++ {
+		if response := runSelfTest(); !response.Passed {
+			t.Fatalf("Expected self-test run %d to pass", i+1)
+		}
+	}
+
+	if a, f := metrics.recentParses.totals(time.Now()); a != attempts || f != failures {
+		t.Errorf("Expected %d/%d recent parses, got %d/%d", failures, attempts, f, a)
+	}
+	if check := checkParseErrors(time.Now()); attempts == 0 && !check.Healthy {
+		t.Errorf("Expected the parse error check to stay healthy, got %s", check.Message)
+	}
+	if got := len(inspector.list("")); got != inspected {
+		t.Errorf("Expected %d inspector entries, got %d", inspected, got)
+	}
+	if got := connections.size(); got != tracked {
+		t.Errorf("Expected %d tracked connections, got %d", tracked, got)
+	}
+	if got := len(listeners.list()); got != bound {
+		t.Errorf("Expected %d listeners, got %d", bound, got)
+	}
+}
+
+// Test the self-test API
+func TestSelfTestAPI(t *testing.T) {
+	t.Run("GET not allowed", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handleAPISelfTest(rr, httptest.NewRequest(http.MethodGet, UI_PATH+"/api/selftest", nil))
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status code %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+		}
+	})
+
+	t.Run("Missing CSRF Token", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handleAPISelfTest(rr, httptest.NewRequest(http.MethodPost, UI_PATH+"/api/selftest", nil))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("POST", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, UI_PATH+"/api/selftest", nil)
+		req.Header.Set("X-CSRF-Token", issueTestCSRFToken(t))
+		rr := httptest.NewRecorder()
+		handleAPISelfTest(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		var response SelfTestResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !response.Passed || len(response.Results) == 0 {
+			t.Errorf("Unexpected response %+v", response)
+		}
+	})
+}
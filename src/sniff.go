@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// sniffHandler decides on a sniffed request using the Zoraxy control status codes.
+// requestID is the X-Zoraxy-RequestID the ingress request will carry.
+type sniffHandler func(requestID string, req *plugin.DynamicSniffForwardRequest) plugin.ControlStatusCode
+
+// handleSniff answers Zoraxy's dynamic sniff requests with the control status codes
+// (280 CAPTURED, 284 UNHANDLED, 580 ERROR), the SDK's RegisterDynamicSniffHandler
+// only answers 200 or 501. Payloads that cannot be read or decoded are answered with ERROR.
+func handleSniff(handler sniffHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			sniffLog.Warn("Cannot read sniff request", "error", err)
+			writeControlStatus(w, plugin.ControlStatusCode_ERROR)
+			return
+		}
+		payload, err := plugin.DecodeForwardRequestPayload(body)
+		if err != nil {
+			sniffLog.Warn("Cannot decode sniff request", "error", err)
+			writeControlStatus(w, plugin.ControlStatusCode_ERROR)
+			return
+		}
+
+		writeControlStatus(w, handler(r.Header.Get("X-Zoraxy-RequestID"), &payload))
+	})
+}
+
+// controlStatusName returns the name of a control status code, for responses, logs and metrics
+func controlStatusName(code plugin.ControlStatusCode) string {
+	switch code {
+	case plugin.ControlStatusCode_CAPTURED:
+		return "CAPTURED"
+	case plugin.ControlStatusCode_UNHANDLED:
+		return "UNHANDLED"
+	case plugin.ControlStatusCode_ERROR:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("ControlStatusCode(%d)", int(code))
+	}
+}
+
+// writeControlStatus writes the control status code with its name as response body
+func writeControlStatus(w http.ResponseWriter, code plugin.ControlStatusCode) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(int(code))
+	w.Write([]byte(controlStatusName(code)))
+}
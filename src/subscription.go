@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// SUBSCRIPTION_PATH receives the events the plugin subscribed to
+const SUBSCRIPTION_PATH = "/notify"
+
+// Zoraxy events the plugin subscribes to. They are only recorded in the event
+// log. Proxy rule changes cannot be observed, Zoraxy sends no event for them;
+// the per-host settings are matched against each request's hostname instead.
+const (
+	EventAccessRuleCreated    = "accessRuleCreated"
+	EventBlacklistToggled     = "blacklistToggled"
+	EventBlacklistedIPBlocked = "blacklistedIpBlocked"
+)
+
+// subscriptionEvents lists the subscribed events with the reason for the IntroSpect
+var subscriptionEvents = map[string]string{
+	EventAccessRuleCreated:    "Record access rule changes in the plugin event log",
+	EventBlacklistToggled:     "Record blacklist changes in the plugin event log",
+	EventBlacklistedIPBlocked: "Record blocked clients in the plugin event log",
+}
+
+// handleSubscriptionEvent receives the events Zoraxy sends to SUBSCRIPTION_PATH
+func handleSubscriptionEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var event plugin.SubscriptionEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		logger.Warn("Error decoding subscription event", "error", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := processSubscriptionEvent(event); err != nil {
+		logger.Error("Error processing subscription event", "event", event.EventName, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// processSubscriptionEvent records a Zoraxy event in the event log
+func processSubscriptionEvent(event plugin.SubscriptionEvent) error {
+	if _, subscribed := subscriptionEvents[event.EventName]; !subscribed {
+		logger.Debug("Ignoring unsubscribed event", "event", event.EventName, "source", event.EventSource)
+		return nil
+	}
+
+	message := event.Payload
+	if message == "" {
+		message = "Received from " + event.EventSource
+	}
+	events.record(EventSourceZoraxy, event.EventName, message)
+
+	logger.Info("Processed subscription event", "event", event.EventName, "source", event.EventSource)
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	plugin "go.codexo.de/exoridus/zoraxy-proxy-protocol/mod/zoraxy_plugin"
+)
+
+// postSubscriptionEvent sends an event to the subscription endpoint
+func postSubscriptionEvent(t *testing.T, event plugin.SubscriptionEvent) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, SUBSCRIPTION_PATH, strings.NewReader(string(body)))
+	rr := httptest.NewRecorder()
+	handleSubscriptionEvent(rr, req)
+	return rr
+}
+
+// Test subscription endpoint
+func TestSubscriptionEvents(t *testing.T) {
+	t.Run("Events leave the config alone", func(t *testing.T) {
+		oldPath := configPath
+		configPath = filepath.Join(t.TempDir(), CONFIG_FILE)
+		defer func() { configPath = oldPath }()
+
+		setConfig(t, func(c *PluginConfig) {
+			c.Enabled, c.HostRules = true, []HostRule{{Pattern: "old.example.com", Enabled: true}}
+		})
+		stored := `{"enabled":true,"host_rules":[{"pattern":"new.example.com","match":"exact","enabled":true}]}`
+		if err := os.WriteFile(configPath, []byte(stored), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"proxyRuleUpdated", EventAccessRuleCreated} {
+			if rr := postSubscriptionEvent(t, plugin.SubscriptionEvent{EventName: name, EventSource: "zoraxy"}); rr.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+			}
+		}
+		if !isHostEnabled("old.example.com") || isHostEnabled("new.example.com") {
+			t.Error("Expected the in-memory host rules to be kept")
+		}
+		if _, subscribed := subscriptionEvents["proxyRuleUpdated"]; subscribed {
+			t.Error("Expected no subscription to proxy rule events")
+		}
+	})
+
+	t.Run("Relevant events are recorded", func(t *testing.T) {
+		rr := postSubscriptionEvent(t, plugin.SubscriptionEvent{
+			EventName:   EventBlacklistedIPBlocked,
+			EventSource: "zoraxy",
+			Payload:     "203.0.113.7 blocked",
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		latest := events.list()[0]
+		if latest.Source != EventSourceZoraxy || latest.Message != "203.0.113.7 blocked" {
+			t.Errorf("Unexpected event log entry: %+v", latest)
+		}
+	})
+
+	t.Run("Unsubscribed events are ignored", func(t *testing.T) {
+		before := len(events.list())
+		rr := postSubscriptionEvent(t, plugin.SubscriptionEvent{EventName: "somethingElse"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		if after := len(events.list()); after != before {
+			t.Errorf("Expected event log to stay at %d entries, got %d", before, after)
+		}
+	})
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, SUBSCRIPTION_PATH, strings.NewReader("{invalid"))
+		rr := httptest.NewRecorder()
+		handleSubscriptionEvent(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("GET not allowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, SUBSCRIPTION_PATH, nil)
+		rr := httptest.NewRecorder()
+		handleSubscriptionEvent(rr, req)
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status code %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+		}
+	})
+}
+
+// Test event log bounds and API
+func TestEventLog(t *testing.T) {
+	log := &eventLog{}
+	for i := 0; i < maxEventLogEntries+10; i++ {
+		log.record(EventSourcePlugin, "test", "entry")
+	}
+	if got := len(log.list()); got != maxEventLogEntries {
+		t.Errorf("Expected %d entries, got %d", maxEventLogEntries, got)
+	}
+
+	events.record(EventSourcePlugin, "apiTest", "visible through the API")
+	req := httptest.NewRequest(http.MethodGet, "/ui/api/events", nil)
+	rr := httptest.NewRecorder()
+	handleAPIEvents(rr, req)
+
+	var response EventsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if len(response.Events) == 0 || response.Events[0].Name != "apiTest" {
+		t.Errorf("Expected newest event first, got %+v", response.Events)
+	}
+}
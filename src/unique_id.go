@@ -0,0 +1,13 @@
+package main
+
+import "encoding/hex"
+
+// UniqueID returns the PP2_TYPE_UNIQUE_ID of the connection, as sent when it is
+// printable ASCII (HAProxy's unique-id-format) and hex encoded otherwise
+func (info *ProxyProtocolInfo) UniqueID() string {
+	value, _ := info.TLV(PP2TypeUniqueID)
+	if len(value) > 0 && !isPrintableASCII(value) {
+		return hex.EncodeToString(value)
+	}
+	return string(value)
+}
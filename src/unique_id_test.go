@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test decoding of PP2_TYPE_UNIQUE_ID
+func TestUniqueID(t *testing.T) {
+	tests := []struct {
+		name     string
+		tlvs     []ProxyProtocolTLV
+		expected string
+	}{
+		{"missing", nil, ""},
+		{"printable", []ProxyProtocolTLV{{Type: PP2TypeUniqueID, Value: []byte("7F000001:C2B8_0A000002:01BB_5E1F7E57_0001:1A2B")}},
+			"7F000001:C2B8_0A000002:01BB_5E1F7E57_0001:1A2B"},
+		{"binary", []ProxyProtocolTLV{{Type: PP2TypeUniqueID, Value: []byte{0x5e, 0x1f, 0x7e, 0x57}}}, "5e1f7e57"},
+		{"with spaces", []ProxyProtocolTLV{{Type: PP2TypeUniqueID, Value: []byte("a b")}}, "612062"},
+		{"first wins", []ProxyProtocolTLV{
+			{Type: PP2TypeALPN, Value: []byte("h2")},
+			{Type: PP2TypeUniqueID, Value: []byte("one")},
+			{Type: PP2TypeUniqueID, Value: []byte("two")},
+		}, "one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ProxyProtocolInfo{Version: 2, TLVs: tt.tlvs}
+			if got := info.UniqueID(); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// Test that the ingress propagates the unique ID as X-Request-ID and registers it
+func TestIngressUniqueID(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) {
+		c.Enabled, c.HostRules = true, nil
+		c.ClientHeaders = defaultClientHeaders()
+		c.trustedUpstreams = testPrefixes(t, testUpstream)
+	})
+
+	info := &ProxyProtocolInfo{Version: 2, Command: "PROXY", TransportProto: "TCP4",
+		SourceAddr: "192.0.2.60", SourcePort: 41000, DestAddr: "198.51.100.60", DestPort: 443,
+		TLVs: []ProxyProtocolTLV{{Type: PP2TypeUniqueID, Value: []byte("lb-4711")}}}
+	header, err := encodeProxyProtocolHeader(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := append(header, "GET / HTTP/1.1\r\n\r\n"...)
+
+	ingress := func(id, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", INGRESS_PATH+"/", bytes.NewReader(payload))
+		req.Header.Set("X-Zoraxy-RequestID", id)
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		rr := serveIngress(t, req, "example.com")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", rr.Code)
+		}
+		return rr
+	}
+
+	if got := ingress("unique-1", "").Header().Get("X-Request-ID"); got != "lb-4711" {
+		t.Errorf("Expected X-Request-ID lb-4711, got %q", got)
+	}
+	if got := ingress("unique-2", "client-42").Header().Get("X-Request-ID"); got != "" {
+		t.Errorf("Expected the client's X-Request-ID to be kept, got %q", got)
+	}
+
+	entries := connections.list(connectionFilter{UniqueID: "lb-4711"})
+	if len(entries) != 1 || entries[0].UniqueID != "lb-4711" || entries[0].Requests != 2 {
+		t.Errorf("Expected one registry entry with the unique ID, got %+v", entries)
+	}
+	connections.remove(ingressConnectionID(info))
+}
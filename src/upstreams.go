@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// peerIP extracts the IP of a host:port or bare host address
+func peerIP(peerAddr string) (netip.Addr, bool) {
+	host := peerAddr
+	if h, _, err := net.SplitHostPort(peerAddr); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// parsePrefixes accepts IP addresses and CIDR ranges
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", value)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// formatPrefix renders single addresses without the prefix length
+func formatPrefix(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+// trustedUpstreams returns the configured trusted upstream ranges
+func trustedUpstreams() []netip.Prefix {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+	return config.trustedUpstreams
+}
+
+// isTrustedUpstream reports whether the peer (host:port or host) is a trusted upstream
+func isTrustedUpstream(peerAddr string) bool {
+	addr, ok := peerIP(peerAddr)
+	return ok && prefixesContain(trustedUpstreams(), addr)
+}
+
+// handleAPIUpstreams returns (GET) or replaces (POST) the trusted upstreams
+func handleAPIUpstreams(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, upstreamsResponse())
+
+	case http.MethodPost:
+		if !requireCSRFToken(w, r) {
+			return
+		}
+
+		var req UpstreamsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		prefixes, err := parsePrefixes(req.TrustedUpstreams)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config.mu.Lock()
+		if err := checkBanUpstreams(config.Bans, prefixes); err != nil {
+			config.mu.Unlock()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config.trustedUpstreams = prefixes
+		config.mu.Unlock()
+
+		if err := saveConfig(); err != nil {
+			apiLog.Error("Error saving config", "error", err)
+		}
+
+		apiLog.Info("Trusted upstreams updated", "upstreams", len(prefixes))
+		events.record(EventSourcePlugin, "upstreamsUpdated", fmt.Sprintf("%d trusted upstream(s)", len(prefixes)))
+		writeJSON(w, upstreamsResponse())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func upstreamsResponse() UpstreamsResponse {
+	trusted := trustedUpstreams()
+	return UpstreamsResponse{
+		TrustedUpstreams: formatPrefixes(trusted),
+		Upstreams:        upstreams.status(trusted, time.Now()),
+	}
+}
+
+// formatPrefixes renders prefixes for the API and the config file
+func formatPrefixes(prefixes []netip.Prefix) []string {
+	result := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		result[i] = formatPrefix(prefix)
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test the prefix parser used for trusted upstreams
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := parsePrefixes([]string{"192.0.2.10", "10.1.2.3/8", "2001:db8::/32", "::ffff:198.51.100.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"192.0.2.10", "10.0.0.0/8", "2001:db8::/32", "198.51.100.1"}
+	for i, prefix := range formatPrefixes(prefixes) {
+		if prefix != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], prefix)
+		}
+	}
+
+	for _, invalid := range []string{"192.0.2.300", "10.0.0.0/33", "example.com"} {
+		if _, err := parsePrefixes([]string{invalid}); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+// Test the trusted upstreams API
+func TestUpstreamsAPI(t *testing.T) {
+	setConfig(t, func(c *PluginConfig) { c.trustedUpstreams = nil })
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, UI_PATH+"/api/upstreams", strings.NewReader(body))
+		req.Header.Set("X-CSRF-Token", issueTestCSRFToken(t))
+		rr := httptest.NewRecorder()
+		handleAPIUpstreams(rr, req)
+		return rr
+	}
+
+	rr := post(`{"trusted_upstreams": ["192.0.2.10", "10.0.0.0/8"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var response UpstreamsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.TrustedUpstreams) != 2 || len(response.Upstreams) != 2 {
+		t.Errorf("Unexpected response %+v", response)
+	}
+
+	if rr := post(`{"trusted_upstreams": ["not-an-ip"]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}